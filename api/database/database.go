@@ -4,33 +4,182 @@ import (
 	"booksapi/config"
 	"booksapi/logger"
 	"context"
+	"database/sql"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	_ "modernc.org/sqlite"
 )
 
-var Pool *pgxpool.Pool
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+// Storage is an opened database of one of supported drivers,
+// repositories pick their implementation by its concrete type
+type Storage interface {
+	Driver() string
+	Ping(ctx context.Context) error
+	Close()
+	// InTx runs a unit of work, repository calls made with ctx given to fn share one transaction
+	// which is committed only when fn returns nil, see RunPgxTx
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+	// migrator returns nil when driver has no schema to migrate
+	migrator() migrationRunner
+}
+
+type PostgresStorage struct {
+	Pool *pgxpool.Pool
+}
+
+func (s *PostgresStorage) Driver() string {
+	return DriverPostgres
+}
+
+func (s *PostgresStorage) Ping(ctx context.Context) error {
+	return s.Pool.Ping(ctx)
+}
+
+func (s *PostgresStorage) Close() {
+	s.Pool.Close()
+}
+
+func (s *PostgresStorage) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return RunPgxTx(ctx, s.Pool, func(ctx context.Context, _ pgx.Tx) error {
+		return fn(ctx)
+	})
+}
+
+func (s *PostgresStorage) migrator() migrationRunner {
+	return &postgresMigrator{pool: s.Pool}
+}
+
+type SQLiteStorage struct {
+	DB *sql.DB
+}
+
+func (s *SQLiteStorage) Driver() string {
+	return DriverSQLite
+}
+
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+func (s *SQLiteStorage) Close() {
+	s.DB.Close()
+}
+
+func (s *SQLiteStorage) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return RunSQLTx(ctx, s.DB, func(ctx context.Context, _ *sql.Tx) error {
+		return fn(ctx)
+	})
+}
+
+func (s *SQLiteStorage) migrator() migrationRunner {
+	return &sqliteMigrator{db: s.DB}
+}
+
+type MemoryStorage struct{}
+
+func (s *MemoryStorage) Driver() string {
+	return DriverMemory
+}
+
+func (s *MemoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemoryStorage) Close() {}
+
+// InTx of memory storage is RunMemoryTx
+func (s *MemoryStorage) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return RunMemoryTx(ctx, fn)
+}
+
+func (s *MemoryStorage) migrator() migrationRunner {
+	return nil
+}
+
+var storage Storage
+
+// Driver returns storage driver configured in appsettings, postgres is used when none is set
+func Driver() string {
+	driver := config.GetAppsettings().Database.Driver
+	if driver == "" {
+		return DriverPostgres
+	}
+	return driver
+}
+
+func openPostgres(db config.Database) (Storage, error) {
+	dbUrl := fmt.Sprintf("postgres://%s:%s@%s:%d/%s", db.User, db.Pass, db.Host, db.Port, db.Db)
+	pool, err := pgxpool.New(context.Background(), dbUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresStorage{Pool: pool}, nil
+}
+
+// OpenSQLite opens sqlite database file, file is created when it does not exist
+func OpenSQLite(path string) (*SQLiteStorage, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows single writer anyway, one connection avoids "database is locked" errors
+	db.SetMaxOpenConns(1)
+	return &SQLiteStorage{DB: db}, nil
+}
 
 func Init() {
 	db := config.GetAppsettings().Database
-	dbUrl := fmt.Sprintf("postgres://%s:%s@%s:%d/%s", db.User, db.Pass, db.Host, db.Port, db.Db)
 
 	var err error
-	Pool, err = pgxpool.New(context.Background(), dbUrl)
+	switch Driver() {
+	case DriverPostgres:
+		storage, err = openPostgres(db)
+	case DriverSQLite:
+		storage, err = OpenSQLite(db.Path)
+	case DriverMemory:
+		storage = &MemoryStorage{}
+		logger.Info("using in-memory storage, data won't survive restart")
+	default:
+		err = fmt.Errorf("unknown database driver %s", Driver())
+	}
 	if err != nil {
-		logger.Info(fmt.Sprintf("ERROR connecting pgx -> %s", err.Error()))
+		logger.Info(fmt.Sprintf("ERROR opening %s database -> %s", Driver(), err.Error()))
 		os.Exit(1)
 	}
 
-	logger.Info("database pool initialized")
+	logger.Info(fmt.Sprintf("%s database initialized", storage.Driver()))
+}
+
+// WithQueryTimeout limits ctx by queryTimeout from appsettings, ctx is left as is when no timeout is set
+func WithQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := config.GetAppsettings().Database.QueryTimeout
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout*int(time.Second)))
+}
+
+// Get returns storage opened by Init
+func Get() Storage {
+	return storage
 }
 
 func Ping() error {
-	err := Pool.Ping(context.Background())
+	err := storage.Ping(context.Background())
 	return err
 }
 
 func Close() {
-	Pool.Close()
+	storage.Close()
 }
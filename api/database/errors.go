@@ -0,0 +1,174 @@
+package database
+
+import (
+	"booksapi/logger"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// APIError is body of every error response
+type APIError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func (e APIError) Error() string {
+	json, _ := json.Marshal(e)
+	return string(json[:])
+}
+
+func WriteAPIErr(err APIError, w http.ResponseWriter) {
+	w.WriteHeader(err.Status)
+	fmt.Fprint(w, err.Error())
+}
+
+func WriteErr(err error, status int, w http.ResponseWriter) {
+	e := APIError{
+		Status:  status,
+		Message: err.Error(),
+	}
+
+	WriteAPIErr(e, w)
+}
+
+// StatusClientClosedRequest is nginx convention for requests abandoned by the client
+const StatusClientClosedRequest = 499
+
+// ErrStatus maps repository error to status code of the response
+func ErrStatus(err error) int {
+	var code int
+	switch e := err.(type) {
+	case NotFoundErr:
+		code = http.StatusNotFound
+	case PreconditionErr:
+		code = http.StatusPreconditionFailed
+	case BadRequestErr:
+		code = http.StatusBadRequest
+	case ConflictErr:
+		code = http.StatusConflict
+	case CanceledErr:
+		if e.Timeout {
+			code = http.StatusServiceUnavailable
+		} else {
+			code = StatusClientClosedRequest
+		}
+	default:
+		code = http.StatusInternalServerError
+	}
+	return code
+}
+
+// repository errors below are what repositories of every resource return,
+// handlers map them to status code of the response with ErrStatus
+
+type InternalErr struct {
+	Message string
+	// Cause is the database error, transaction helpers look at it to decide on retry
+	Cause error
+}
+
+func (e InternalErr) Error() string {
+	return e.Message
+}
+
+func (e InternalErr) Unwrap() error {
+	return e.Cause
+}
+
+type NotFoundErr struct {
+	Message string
+}
+
+func (e NotFoundErr) Error() string {
+	return e.Message
+}
+
+// CanceledErr is returned when query was interrupted by cancelled request context or query timeout
+type CanceledErr struct {
+	Message string
+	Timeout bool
+}
+
+func (e CanceledErr) Error() string {
+	return e.Message
+}
+
+// PreconditionErr is returned when version of a row differs from the one client expects
+type PreconditionErr struct {
+	Message string
+}
+
+func (e PreconditionErr) Error() string {
+	return e.Message
+}
+
+// ConflictErr is returned when a change breaks uniqueness or a row is still referenced
+type ConflictErr struct {
+	Message string
+}
+
+func (e ConflictErr) Error() string {
+	return e.Message
+}
+
+type BadRequestErr struct {
+	Message string
+}
+
+func (e BadRequestErr) Error() string {
+	return e.Message
+}
+
+// RepoErr wraps database error into a type handlers know how to map to status code,
+// errors caused by cancelled request or query timeout become CanceledErr
+func RepoErr(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return CanceledErr{Message: err.Error(), Timeout: true}
+	case errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		return CanceledErr{Message: err.Error()}
+	}
+	return InternalErr{Message: err.Error(), Cause: err}
+}
+
+// TxErr maps error of a transaction, repository errors made inside the transaction
+// pass as they are and the rest, e.g. failed commit, go through RepoErr
+func TxErr(ctx context.Context, err error) error {
+	switch err.(type) {
+	case nil:
+		return nil
+	case InternalErr, NotFoundErr, CanceledErr, PreconditionErr, ConflictErr, BadRequestErr:
+		return err
+	}
+	logger.Error(err.Error())
+	return RepoErr(ctx, err)
+}
+
+const (
+	// pgUniqueViolation is error code of postgres for duplicate key of an unique index
+	pgUniqueViolation = "23505"
+	// pgForeignKeyViolation is error code of postgres for a row still referenced by another table
+	pgForeignKeyViolation = "23503"
+)
+
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+func IsForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation
+}
+
+func IsSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
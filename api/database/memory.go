@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryMu is the storage-wide lock of memory repositories. A unit of work holds it for the whole of its fn
+// and a write made outside of one holds it while the write runs, so writes never interleave with a unit of work
+// and undoing one can't lose anyone else's change
+var memoryMu sync.Mutex
+
+type memoryTxKey struct{}
+
+// memoryTx is a unit of work of memory storage
+type memoryTx struct {
+	// undo puts repositories written in the unit of work back as they were before it, latest first
+	undo []func()
+	// saved holds repositories whose state is in undo already, it is nil for writes outside of a unit of work
+	// which are never undone
+	saved map[any]bool
+}
+
+func (tx *memoryTx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+}
+
+// RunMemoryTx runs fn as a unit of work of memory storage, writes of memory repositories made with ctx given to fn
+// are undone when fn returns an error. When ctx already carries a unit of work fn runs like in a savepoint of it
+func RunMemoryTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	outer, nested := ctx.Value(memoryTxKey{}).(*memoryTx)
+	if !nested {
+		memoryMu.Lock()
+		defer memoryMu.Unlock()
+	}
+
+	tx := &memoryTx{saved: map[any]bool{}}
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, tx)); err != nil {
+		tx.rollback()
+		return err
+	}
+
+	// released savepoint is undone with the unit of work it belongs to
+	if nested && outer.saved != nil {
+		outer.undo = append(outer.undo, tx.undo...)
+		for repo := range tx.saved {
+			outer.saved[repo] = true
+		}
+	}
+	return nil
+}
+
+// MemoryWrite is called by memory repositories before they write, ctx it returns is to be used for the rest of the write
+// and done when the write is over. Inside a unit of work save is called before the first write of repo and returns
+// the function putting repo back as it was. Outside of one the write holds the storage lock until done is called
+func MemoryWrite(ctx context.Context, repo any, save func() (restore func())) (context.Context, func()) {
+	if tx, ok := ctx.Value(memoryTxKey{}).(*memoryTx); ok {
+		if tx.saved != nil && !tx.saved[repo] {
+			tx.saved[repo] = true
+			tx.undo = append(tx.undo, save())
+		}
+		return ctx, func() {}
+	}
+
+	memoryMu.Lock()
+	return context.WithValue(ctx, memoryTxKey{}, &memoryTx{}), memoryMu.Unlock
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counters is a memory repository of named counters
+type counters struct {
+	mu     sync.Mutex
+	values map[string]int
+}
+
+func (c *counters) save() func() {
+	c.mu.Lock()
+	values := maps.Clone(c.values)
+	c.mu.Unlock()
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.values = values
+	}
+}
+
+func (c *counters) add(ctx context.Context, name string, n int) {
+	_, done := MemoryWrite(ctx, c, c.save)
+	defer done()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[name] += n
+}
+
+func (c *counters) get(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[name]
+}
+
+func TestRunMemoryTx(t *testing.T) {
+	ctx := context.Background()
+	c := &counters{values: map[string]int{}}
+	failed := errors.New("handler failed")
+
+	err := RunMemoryTx(ctx, func(ctx context.Context) error {
+		c.add(ctx, "a", 1)
+		c.add(ctx, "b", 1)
+		return failed
+	})
+	if !errors.Is(err, failed) || c.get("a") != 0 || c.get("b") != 0 {
+		t.Errorf("failed unit of work must roll back its writes\ngot %v, a=%d, b=%d", err, c.get("a"), c.get("b"))
+	}
+
+	err = RunMemoryTx(ctx, func(ctx context.Context) error {
+		c.add(ctx, "a", 1)
+		// failed savepoint rolls back only what was written inside it
+		inner := RunMemoryTx(ctx, func(ctx context.Context) error {
+			c.add(ctx, "a", 10)
+			c.add(ctx, "b", 10)
+			return failed
+		})
+		if !errors.Is(inner, failed) || c.get("a") != 1 || c.get("b") != 0 {
+			t.Errorf("failed savepoint must roll back its writes\ngot %v, a=%d, b=%d", inner, c.get("a"), c.get("b"))
+		}
+		return RunMemoryTx(ctx, func(ctx context.Context) error {
+			c.add(ctx, "b", 2)
+			return nil
+		})
+	})
+	if err != nil || c.get("a") != 1 || c.get("b") != 2 {
+		t.Errorf("unit of work must commit its writes\ngot %v, a=%d, b=%d", err, c.get("a"), c.get("b"))
+	}
+
+	err = RunMemoryTx(ctx, func(ctx context.Context) error {
+		c.add(ctx, "a", 1)
+		return RunMemoryTx(ctx, func(ctx context.Context) error {
+			c.add(ctx, "b", 1)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("RunMemoryTx failed\nunexpected error %s", err.Error())
+	}
+	err = RunMemoryTx(ctx, func(ctx context.Context) error {
+		RunMemoryTx(ctx, func(ctx context.Context) error {
+			c.add(ctx, "b", 1)
+			return nil
+		})
+		return failed
+	})
+	if !errors.Is(err, failed) || c.get("a") != 2 || c.get("b") != 3 {
+		t.Errorf("failed unit of work must roll back its released savepoints\ngot %v, a=%d, b=%d", err, c.get("a"), c.get("b"))
+	}
+}
+
+func TestRunMemoryTxKeepsOtherWrites(t *testing.T) {
+	ctx := context.Background()
+	c := &counters{values: map[string]int{}}
+
+	started, written := make(chan struct{}), make(chan struct{})
+	go func() {
+		<-started
+		// write outside of the unit of work waits for it
+		c.add(ctx, "b", 1)
+		close(written)
+	}()
+
+	err := RunMemoryTx(ctx, func(ctx context.Context) error {
+		close(started)
+		c.add(ctx, "a", 1)
+		select {
+		case <-written:
+			t.Errorf("write outside of the unit of work must wait for it")
+		case <-time.After(50 * time.Millisecond):
+		}
+		return errors.New("handler failed")
+	})
+	<-written
+
+	if err == nil || c.get("a") != 0 || c.get("b") != 1 {
+		t.Errorf("rollback must keep writes made outside of the unit of work\ngot %v, a=%d, b=%d", err, c.get("a"), c.get("b"))
+	}
+}
@@ -0,0 +1,372 @@
+package database
+
+import (
+	"booksapi/logger"
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationLockKey identifies advisory lock which keeps replicas from migrating at the same time
+const migrationLockKey = 7_318_211_001
+
+// migration file names look like 0001_create_books.up.sql
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// migrationRunner applies migrations of a single driver,
+// every method except lock is called only while lock holds
+type migrationRunner interface {
+	// dir is the directory inside migrations holding sql files of the driver
+	dir() string
+	// lock keeps other processes from migrating while action runs,
+	// schema_migrations table is created beforehand if it does not exist
+	lock(ctx context.Context, action func() error) error
+	appliedVersions(ctx context.Context) (map[int]time.Time, error)
+	// apply runs up migration and records it in one transaction
+	apply(ctx context.Context, m migration) error
+	// revert runs down migration and removes its record in one transaction
+	revert(ctx context.Context, m migration) error
+}
+
+func loadMigrations(files fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, e := range entries {
+		m := migrationName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file name %s", e.Name())
+		}
+
+		version, _ := strconv.Atoi(m[1])
+		content, err := fs.ReadFile(files, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{version: version, name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.name != m[2] {
+			return nil, fmt.Errorf("migration %d has files with different names", version)
+		}
+
+		if m[3] == "up" {
+			mig.up = string(content)
+		} else {
+			mig.down = string(content)
+		}
+	}
+
+	result := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.up == "" || mig.down == "" {
+			return nil, fmt.Errorf("migration %d must have both up and down files", mig.version)
+		}
+		result = append(result, *mig)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].version < result[j].version
+	})
+
+	return result, nil
+}
+
+func storageMigrator(s Storage) (migrationRunner, []migration, error) {
+	runner := s.migrator()
+	if runner == nil {
+		return nil, nil, fmt.Errorf("migrations are not supported by %s driver", s.Driver())
+	}
+
+	migrations, err := loadMigrations(migrationFiles, path.Join("migrations", runner.dir()))
+	return runner, migrations, err
+}
+
+// MigrateUp applies every migration which is not applied yet
+func MigrateUp(ctx context.Context, s Storage) error {
+	runner, migrations, err := storageMigrator(s)
+	if err != nil {
+		return err
+	}
+
+	return runner.lock(ctx, func() error {
+		applied, err := runner.appliedVersions(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+
+			if err := runner.apply(ctx, m); err != nil {
+				return fmt.Errorf("migration %d_%s failed: %w", m.version, m.name, err)
+			}
+			logger.Info(fmt.Sprintf("applied migration %d_%s", m.version, m.name))
+		}
+
+		return nil
+	})
+}
+
+// MigrateDown reverts given number of most recently applied migrations
+func MigrateDown(ctx context.Context, s Storage, steps int) error {
+	runner, migrations, err := storageMigrator(s)
+	if err != nil {
+		return err
+	}
+
+	return runner.lock(ctx, func() error {
+		applied, err := runner.appliedVersions(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+
+			if err := runner.revert(ctx, m); err != nil {
+				return fmt.Errorf("reverting migration %d_%s failed: %w", m.version, m.name, err)
+			}
+			logger.Info(fmt.Sprintf("reverted migration %d_%s", m.version, m.name))
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// GetMigrationStatus lists every known migration, AppliedAt is nil for pending ones
+func GetMigrationStatus(ctx context.Context, s Storage) ([]MigrationStatus, error) {
+	runner, migrations, err := storageMigrator(s)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationStatus, 0, len(migrations))
+	err = runner.lock(ctx, func() error {
+		applied, err := runner.appliedVersions(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			s := MigrationStatus{Version: m.version, Name: m.name}
+			if at, ok := applied[m.version]; ok {
+				s.AppliedAt = &at
+			}
+			result = append(result, s)
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+type postgresMigrator struct {
+	pool *pgxpool.Pool
+	// conn holds advisory lock, it is set only while lock runs
+	conn *pgxpool.Conn
+}
+
+func (r *postgresMigrator) dir() string {
+	return DriverPostgres
+}
+
+func (r *postgresMigrator) lock(ctx context.Context, action func() error) error {
+	conn, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+		if err != nil {
+			logger.Error(fmt.Sprintf("could not release migration lock -> %s", err.Error()))
+		}
+	}()
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS public.schema_migrations (
+                                 version    bigint PRIMARY KEY,
+                                 name       text NOT NULL,
+                                 applied_at timestamptz NOT NULL DEFAULT now()
+                             )`)
+	if err != nil {
+		return err
+	}
+
+	r.conn = conn
+	defer func() { r.conn = nil }()
+
+	return action()
+}
+
+func (r *postgresMigrator) appliedVersions(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := r.conn.Query(ctx, `SELECT version, applied_at FROM public.schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		result[version] = appliedAt
+	}
+
+	return result, rows.Err()
+}
+
+func (r *postgresMigrator) run(ctx context.Context, sql, record string, args pgx.NamedArgs) error {
+	tx, err := r.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, sql); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, record, args); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *postgresMigrator) apply(ctx context.Context, m migration) error {
+	return r.run(ctx, m.up,
+		`INSERT INTO public.schema_migrations (version, name) VALUES (@version, @name)`,
+		pgx.NamedArgs{"version": m.version, "name": m.name})
+}
+
+func (r *postgresMigrator) revert(ctx context.Context, m migration) error {
+	return r.run(ctx, m.down,
+		`DELETE FROM public.schema_migrations WHERE version = @version`,
+		pgx.NamedArgs{"version": m.version})
+}
+
+// sqliteMigrator relies on sqlite file lock, every transaction is opened
+// with BEGIN IMMEDIATE so concurrent migrators wait for each other
+type sqliteMigrator struct {
+	db *sql.DB
+}
+
+func (r *sqliteMigrator) dir() string {
+	return DriverSQLite
+}
+
+func (r *sqliteMigrator) lock(ctx context.Context, action func() error) error {
+	_, err := r.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+                                         version    INTEGER PRIMARY KEY,
+                                         name       TEXT NOT NULL,
+                                         applied_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+                                     )`)
+	if err != nil {
+		return err
+	}
+
+	return action()
+}
+
+func (r *sqliteMigrator) appliedVersions(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		at, err := time.Parse(time.RFC3339, appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		result[version] = at
+	}
+
+	return result, rows.Err()
+}
+
+func (r *sqliteMigrator) run(ctx context.Context, migrationSql, record string, args ...any) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, migrationSql); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return errors.New("migration was applied or reverted by another process")
+	}
+
+	return tx.Commit()
+}
+
+func (r *sqliteMigrator) apply(ctx context.Context, m migration) error {
+	return r.run(ctx, m.up,
+		`INSERT OR IGNORE INTO schema_migrations (version, name) VALUES (@version, @name)`,
+		sql.Named("version", m.version), sql.Named("name", m.name))
+}
+
+func (r *sqliteMigrator) revert(ctx context.Context, m migration) error {
+	return r.run(ctx, m.down,
+		`DELETE FROM schema_migrations WHERE version = @version`,
+		sql.Named("version", m.version))
+}
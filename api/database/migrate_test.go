@@ -0,0 +1,75 @@
+package database
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	tcases := []struct {
+		files    fstest.MapFS
+		versions []int
+		fails    bool
+	}{
+		{
+			files: fstest.MapFS{
+				"m/0002_second.up.sql":   {Data: []byte("up2")},
+				"m/0002_second.down.sql": {Data: []byte("down2")},
+				"m/0001_first.up.sql":    {Data: []byte("up1")},
+				"m/0001_first.down.sql":  {Data: []byte("down1")},
+			},
+			versions: []int{1, 2},
+		},
+		{
+			files: fstest.MapFS{
+				"m/0001_first.up.sql": {Data: []byte("up1")},
+			},
+			fails: true,
+		},
+		{
+			files: fstest.MapFS{
+				"m/0001_first.up.sql":   {Data: []byte("up1")},
+				"m/0001_other.down.sql": {Data: []byte("down1")},
+			},
+			fails: true,
+		},
+		{
+			files: fstest.MapFS{
+				"m/first.up.sql": {Data: []byte("up1")},
+			},
+			fails: true,
+		},
+	}
+
+	for _, tc := range tcases {
+		migrations, err := loadMigrations(tc.files, "m")
+		if tc.fails {
+			if err == nil {
+				t.Errorf("loadMigrations expected error for %v", tc.files)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("loadMigrations failed\nunexpected error %s", err.Error())
+			continue
+		}
+		if len(migrations) != len(tc.versions) {
+			t.Errorf("loadMigrations failed\nexpected %v migrations\ngot %v", len(tc.versions), len(migrations))
+			continue
+		}
+		for i, m := range migrations {
+			if m.version != tc.versions[i] || m.up == "" || m.down == "" {
+				t.Errorf("loadMigrations failed\nexpected version %v\ngot %+v", tc.versions[i], m)
+			}
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	for _, dir := range []string{DriverPostgres, DriverSQLite} {
+		_, err := loadMigrations(migrationFiles, "migrations/"+dir)
+		if err != nil {
+			t.Errorf("embedded %s migrations are invalid: %s", dir, err.Error())
+		}
+	}
+}
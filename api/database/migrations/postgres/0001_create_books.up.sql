@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS public.books (
+    id              serial PRIMARY KEY,
+    title           text NOT NULL,
+    author          text NOT NULL,
+    genre           text NOT NULL DEFAULT '',
+    number_of_pages integer,
+    price           integer,
+    release_year    integer
+);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS public.books_search_idx;
@@ -0,0 +1,6 @@
+-- expression has to stay in sync with searchDocument in books repository
+CREATE INDEX IF NOT EXISTS books_search_idx ON public.books USING GIN ((
+    setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(author, '')), 'B') ||
+    setweight(to_tsvector('simple', coalesce(genre, '')), 'C')
+));
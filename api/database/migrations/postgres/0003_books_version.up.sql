@@ -0,0 +1 @@
+ALTER TABLE public.books ADD COLUMN version integer NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.books_deleted_at_idx;
+
+ALTER TABLE public.books DROP COLUMN deleted_at;
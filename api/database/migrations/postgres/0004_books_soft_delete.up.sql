@@ -0,0 +1,3 @@
+ALTER TABLE public.books ADD COLUMN deleted_at timestamptz;
+
+CREATE INDEX books_deleted_at_idx ON public.books (deleted_at) WHERE deleted_at IS NOT NULL;
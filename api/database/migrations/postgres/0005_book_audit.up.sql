@@ -0,0 +1,12 @@
+-- no foreign key to books, history outlives purged books
+CREATE TABLE IF NOT EXISTS public.book_audit (
+    id         bigserial PRIMARY KEY,
+    book_id    integer NOT NULL,
+    action     text NOT NULL,
+    changes    jsonb NOT NULL,
+    actor      text NOT NULL,
+    request_id text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS book_audit_book_id_idx ON public.book_audit (book_id, id);
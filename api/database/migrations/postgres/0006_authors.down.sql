@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.book_authors;
+DROP TABLE IF EXISTS public.authors;
@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS public.authors (
+    id       serial PRIMARY KEY,
+    name     text NOT NULL,
+    -- name_key makes spellings like "JRR Tolkien" and "J.R.R. Tolkien" one author,
+    -- it is lowercased name without whitespace, dots, hyphens and apostrophes, see nameKey in authors package
+    name_key text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS public.book_authors (
+    book_id   integer NOT NULL REFERENCES public.books (id) ON DELETE CASCADE,
+    author_id integer NOT NULL REFERENCES public.authors (id) ON DELETE RESTRICT,
+    PRIMARY KEY (book_id, author_id)
+);
+
+CREATE INDEX IF NOT EXISTS book_authors_author_id_idx ON public.book_authors (author_id, book_id);
+
+-- author strings may name several authors separated by commas, semicolons, "&" or "and".
+-- Names which are left without a key are skipped like splitNames in authors package does
+CREATE TEMP TABLE author_names ON COMMIT DROP AS
+SELECT book_id, name, name_key
+FROM (
+    SELECT b.id AS book_id, trim(n.name) AS name,
+           lower(regexp_replace(translate(n.name, '.-''', ''), '[[:space:]]', '', 'g')) AS name_key
+    FROM public.books b,
+         regexp_split_to_table(b.author, '\s*(,|;|&|\mand\M)\s*', 'i') AS n (name)
+) n
+WHERE name_key <> '';
+
+-- spelling of the book with the lowest id names the author
+INSERT INTO public.authors (name, name_key)
+SELECT DISTINCT ON (name_key) name, name_key
+FROM author_names
+ORDER BY name_key, book_id;
+
+INSERT INTO public.book_authors (book_id, author_id)
+SELECT DISTINCT n.book_id, a.id
+FROM author_names n
+JOIN public.authors a ON a.name_key = n.name_key;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.books_genre_id_idx;
+ALTER TABLE public.books DROP COLUMN IF EXISTS genre_id;
+DROP TABLE IF EXISTS public.genres;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS public.genres (
+    id        serial PRIMARY KEY,
+    name      text NOT NULL,
+    -- top level genres have no parent, e.g. Fiction > Fantasy > High Fantasy
+    parent_id integer REFERENCES public.genres (id) ON DELETE RESTRICT
+);
+
+-- genre names are unique regardless of case so that a name is enough to find the genre
+CREATE UNIQUE INDEX IF NOT EXISTS genres_name_idx ON public.genres (lower(name));
+CREATE INDEX IF NOT EXISTS genres_parent_id_idx ON public.genres (parent_id);
+
+ALTER TABLE public.books ADD COLUMN IF NOT EXISTS genre_id integer REFERENCES public.genres (id) ON DELETE RESTRICT;
+CREATE INDEX IF NOT EXISTS books_genre_id_idx ON public.books (genre_id);
+
+-- every genre books already have becomes a top level genre, spelling of the book with the lowest id names it
+INSERT INTO public.genres (name)
+SELECT DISTINCT ON (lower(trim(genre))) trim(genre)
+FROM public.books
+WHERE trim(genre) <> ''
+ORDER BY lower(trim(genre)), id;
+
+UPDATE public.books b
+SET genre_id = g.id, genre = g.name
+FROM public.genres g
+WHERE lower(trim(b.genre)) = lower(g.name);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.editions;
+DROP TABLE IF EXISTS public.publishers;
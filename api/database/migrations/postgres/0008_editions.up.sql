@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS public.publishers (
+    id   serial PRIMARY KEY,
+    name text NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS publishers_name_idx ON public.publishers (lower(name));
+
+-- an edition is a published form of a work, the work is a books row
+CREATE TABLE IF NOT EXISTS public.editions (
+    id              serial PRIMARY KEY,
+    book_id         integer NOT NULL REFERENCES public.books (id) ON DELETE CASCADE,
+    publisher_id    integer REFERENCES public.publishers (id) ON DELETE RESTRICT,
+    format          text,
+    isbn            text,
+    number_of_pages integer,
+    price           integer,
+    release_date    date
+);
+
+CREATE INDEX IF NOT EXISTS editions_book_id_idx ON public.editions (book_id, id);
+CREATE INDEX IF NOT EXISTS editions_publisher_id_idx ON public.editions (publisher_id);
+
+-- pages and price of books become their first edition, books keep them as a copy of it from now on
+INSERT INTO public.editions (book_id, number_of_pages, price)
+SELECT id, number_of_pages, price
+FROM public.books
+WHERE number_of_pages IS NOT NULL OR price IS NOT NULL
+ORDER BY id;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.editions_isbn_idx;
+ALTER TABLE public.books DROP COLUMN IF EXISTS isbn;
@@ -0,0 +1,32 @@
+-- books keep isbn of their first edition as a copy, like pages and price
+ALTER TABLE public.books ADD COLUMN IF NOT EXISTS isbn text;
+
+-- isbns were saved as they came so far, hyphens and spaces are stripped
+UPDATE public.editions
+SET isbn = NULLIF(upper(regexp_replace(isbn, '[\s-]', '', 'g')), '')
+WHERE isbn IS NOT NULL;
+
+-- valid ISBN-10 becomes ISBN-13 with 978 prefix and check digit of its own, 38 is weighted sum of the prefix.
+-- Values which are no valid ISBN are kept, the api rejects them only when they are sent again.
+-- Postgres may evaluate conditions in any order, so case keeps casts away from values of other shape
+UPDATE public.editions
+SET isbn = '978' || left(isbn, 9) || ((10 - (38
+        + 3 * substr(isbn, 1, 1)::int + substr(isbn, 2, 1)::int
+        + 3 * substr(isbn, 3, 1)::int + substr(isbn, 4, 1)::int
+        + 3 * substr(isbn, 5, 1)::int + substr(isbn, 6, 1)::int
+        + 3 * substr(isbn, 7, 1)::int + substr(isbn, 8, 1)::int
+        + 3 * substr(isbn, 9, 1)::int) % 10) % 10)::text
+WHERE CASE WHEN isbn ~ '^[0-9]{9}[0-9X]$' THEN (10 * substr(isbn, 1, 1)::int + 9 * substr(isbn, 2, 1)::int + 8 * substr(isbn, 3, 1)::int
+       + 7 * substr(isbn, 4, 1)::int + 6 * substr(isbn, 5, 1)::int + 5 * substr(isbn, 6, 1)::int
+       + 4 * substr(isbn, 7, 1)::int + 3 * substr(isbn, 8, 1)::int + 2 * substr(isbn, 9, 1)::int
+       + CASE WHEN substr(isbn, 10, 1) = 'X' THEN 10 ELSE substr(isbn, 10, 1)::int END) % 11 = 0 END;
+
+-- an isbn names one edition, editions added later lose a shared one
+UPDATE public.editions
+SET isbn = NULL
+WHERE id NOT IN (SELECT min(id) FROM public.editions WHERE isbn IS NOT NULL GROUP BY isbn);
+
+CREATE UNIQUE INDEX IF NOT EXISTS editions_isbn_idx ON public.editions (isbn);
+
+UPDATE public.books b
+SET isbn = (SELECT e.isbn FROM public.editions e WHERE e.book_id = b.id ORDER BY e.id LIMIT 1);
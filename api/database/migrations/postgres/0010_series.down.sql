@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.books_series_id_idx;
+ALTER TABLE public.books DROP COLUMN IF EXISTS series_position, DROP COLUMN IF EXISTS series_id;
+DROP TABLE IF EXISTS public.series;
@@ -0,0 +1,11 @@
+-- a series orders books in reading order, e.g. The Lord of the Rings
+CREATE TABLE IF NOT EXISTS public.series (
+    id   serial PRIMARY KEY,
+    name text NOT NULL
+);
+
+-- books without position come after positioned ones of the same series
+ALTER TABLE public.books
+    ADD COLUMN IF NOT EXISTS series_id integer REFERENCES public.series (id) ON DELETE RESTRICT,
+    ADD COLUMN IF NOT EXISTS series_position integer CHECK (series_position > 0);
+CREATE INDEX IF NOT EXISTS books_series_id_idx ON public.books (series_id, series_position);
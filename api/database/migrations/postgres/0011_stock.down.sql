@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.stock_reservations;
+DROP TABLE IF EXISTS public.stock_movements;
+DROP TABLE IF EXISTS public.stock_levels;
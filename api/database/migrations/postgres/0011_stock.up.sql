@@ -0,0 +1,33 @@
+-- copies of a book on hand at a warehouse location
+CREATE TABLE IF NOT EXISTS public.stock_levels (
+    book_id  integer NOT NULL REFERENCES public.books (id) ON DELETE CASCADE,
+    location text NOT NULL,
+    quantity integer NOT NULL CHECK (quantity >= 0),
+    PRIMARY KEY (book_id, location)
+);
+
+-- ledger of every stock change, no foreign key to books so that it outlives purged books like book_audit
+CREATE TABLE IF NOT EXISTS public.stock_movements (
+    id             serial PRIMARY KEY,
+    book_id        integer NOT NULL,
+    location       text NOT NULL,
+    delta          integer NOT NULL,
+    reason         text NOT NULL CHECK (reason IN ('received', 'sold', 'damaged')),
+    reservation_id integer,
+    actor          text NOT NULL,
+    request_id     text NOT NULL DEFAULT '',
+    created_at     timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS stock_movements_book_id_idx ON public.stock_movements (book_id, id);
+
+-- reserved copies stay on hand but are not available until the reservation expires
+CREATE TABLE IF NOT EXISTS public.stock_reservations (
+    id         serial PRIMARY KEY,
+    book_id    integer NOT NULL REFERENCES public.books (id) ON DELETE CASCADE,
+    location   text NOT NULL,
+    quantity   integer NOT NULL CHECK (quantity > 0),
+    expires_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS stock_reservations_book_id_idx ON public.stock_reservations (book_id, expires_at);
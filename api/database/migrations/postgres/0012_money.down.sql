@@ -0,0 +1,10 @@
+-- amounts are rounded to whole units, currencies are lost
+ALTER TABLE public.editions DROP CONSTRAINT IF EXISTS editions_currency_check;
+ALTER TABLE public.editions DROP CONSTRAINT IF EXISTS editions_price_check;
+ALTER TABLE public.editions DROP COLUMN IF EXISTS currency;
+ALTER TABLE public.editions ALTER COLUMN price TYPE integer USING round(price);
+
+ALTER TABLE public.books DROP CONSTRAINT IF EXISTS books_currency_check;
+ALTER TABLE public.books DROP CONSTRAINT IF EXISTS books_price_check;
+ALTER TABLE public.books DROP COLUMN IF EXISTS currency;
+ALTER TABLE public.books ALTER COLUMN price TYPE integer USING round(price);
@@ -0,0 +1,14 @@
+-- prices become decimal amounts in a currency, integer prices saved so far were whole euros
+ALTER TABLE public.books ALTER COLUMN price TYPE numeric;
+ALTER TABLE public.books ADD COLUMN IF NOT EXISTS currency text;
+UPDATE public.books SET currency = 'EUR' WHERE price IS NOT NULL;
+ALTER TABLE public.books
+    ADD CONSTRAINT books_price_check CHECK (price >= 0),
+    ADD CONSTRAINT books_currency_check CHECK ((price IS NULL) = (currency IS NULL));
+
+ALTER TABLE public.editions ALTER COLUMN price TYPE numeric;
+ALTER TABLE public.editions ADD COLUMN IF NOT EXISTS currency text;
+UPDATE public.editions SET currency = 'EUR' WHERE price IS NOT NULL;
+ALTER TABLE public.editions
+    ADD CONSTRAINT editions_price_check CHECK (price >= 0),
+    ADD CONSTRAINT editions_currency_check CHECK ((price IS NULL) = (currency IS NULL));
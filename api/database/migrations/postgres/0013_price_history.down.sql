@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.scheduled_prices;
+DROP TABLE IF EXISTS public.price_history;
@@ -0,0 +1,39 @@
+-- price a book had from effective_from until effective_to, the current price has no effective_to.
+-- No foreign key to books, history outlives purged books like book_audit
+CREATE TABLE IF NOT EXISTS public.price_history (
+    id             serial PRIMARY KEY,
+    book_id        integer NOT NULL,
+    price          numeric NOT NULL CHECK (price >= 0),
+    currency       text NOT NULL,
+    effective_from timestamptz NOT NULL DEFAULT now(),
+    effective_to   timestamptz,
+    actor          text NOT NULL,
+    request_id     text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS price_history_book_id_idx ON public.price_history (book_id, id);
+CREATE UNIQUE INDEX IF NOT EXISTS price_history_current_idx ON public.price_history (book_id) WHERE effective_to IS NULL;
+
+-- prices the scheduler sets as price of their book at effective_from
+CREATE TABLE IF NOT EXISTS public.scheduled_prices (
+    id             serial PRIMARY KEY,
+    book_id        integer NOT NULL REFERENCES public.books (id) ON DELETE CASCADE,
+    price          numeric NOT NULL CHECK (price >= 0),
+    currency       text NOT NULL,
+    effective_from timestamptz NOT NULL,
+    actor          text NOT NULL,
+    request_id     text NOT NULL DEFAULT '',
+    created_at     timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_prices_effective_from_idx ON public.scheduled_prices (effective_from, id);
+CREATE INDEX IF NOT EXISTS scheduled_prices_book_id_idx ON public.scheduled_prices (book_id);
+
+-- history starts with current prices, effective since their last change recorded by audit
+INSERT INTO public.price_history (book_id, price, currency, effective_from, actor)
+SELECT b.id, b.price, b.currency,
+       COALESCE((SELECT max(a.created_at) FROM public.book_audit a WHERE a.book_id = b.id AND a.changes ? 'price'), now()),
+       'system'
+FROM public.books b
+WHERE b.price IS NOT NULL
+ORDER BY b.id;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS public.reviews;
+ALTER TABLE public.books
+    DROP COLUMN IF EXISTS rating_sum,
+    DROP COLUMN IF EXISTS rating_count;
@@ -0,0 +1,19 @@
+-- approved reviews only add up to rating of a book, kept on books to read the average without aggregating reviews
+ALTER TABLE public.books
+    ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0 CHECK (rating_count >= 0),
+    ADD COLUMN IF NOT EXISTS rating_sum integer NOT NULL DEFAULT 0 CHECK (rating_sum >= 0);
+
+-- reviews are pending until an admin approves or rejects them
+CREATE TABLE IF NOT EXISTS public.reviews (
+    id           serial PRIMARY KEY,
+    book_id      integer NOT NULL REFERENCES public.books (id) ON DELETE CASCADE,
+    rating       integer NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    text         text NOT NULL DEFAULT '',
+    author       text NOT NULL,
+    status       text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    moderated_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS reviews_book_id_idx ON public.reviews (book_id, status, id);
+CREATE INDEX IF NOT EXISTS reviews_status_idx ON public.reviews (status, id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.cart_lines;
+DROP TABLE IF EXISTS public.carts;
@@ -0,0 +1,16 @@
+-- carts are named by uuids and expire after a while without requests, expired carts are purged by the server
+CREATE TABLE IF NOT EXISTS public.carts (
+    id         text PRIMARY KEY,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS carts_expires_at_idx ON public.carts (expires_at);
+
+-- lines keep quantities only, price and stock are those the book has when the cart is read
+CREATE TABLE IF NOT EXISTS public.cart_lines (
+    cart_id  text NOT NULL REFERENCES public.carts (id) ON DELETE CASCADE,
+    book_id  integer NOT NULL REFERENCES public.books (id) ON DELETE CASCADE,
+    quantity integer NOT NULL CHECK (quantity > 0),
+    PRIMARY KEY (cart_id, book_id)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS books (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    title           TEXT NOT NULL,
+    author          TEXT NOT NULL,
+    genre           TEXT NOT NULL DEFAULT '',
+    number_of_pages INTEGER,
+    price           INTEGER,
+    release_year    INTEGER
+);
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS books_fts_update;
+DROP TRIGGER IF EXISTS books_fts_delete;
+DROP TRIGGER IF EXISTS books_fts_insert;
+DROP TABLE IF EXISTS books_fts;
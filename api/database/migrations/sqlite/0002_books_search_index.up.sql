@@ -0,0 +1,21 @@
+-- external content fts5 table, kept in sync with books by triggers
+CREATE VIRTUAL TABLE books_fts USING fts5(
+    title, author, genre,
+    content = 'books',
+    content_rowid = 'id'
+);
+
+CREATE TRIGGER books_fts_insert AFTER INSERT ON books BEGIN
+    INSERT INTO books_fts (rowid, title, author, genre) VALUES (new.id, new.title, new.author, new.genre);
+END;
+
+CREATE TRIGGER books_fts_delete AFTER DELETE ON books BEGIN
+    INSERT INTO books_fts (books_fts, rowid, title, author, genre) VALUES ('delete', old.id, old.title, old.author, old.genre);
+END;
+
+CREATE TRIGGER books_fts_update AFTER UPDATE ON books BEGIN
+    INSERT INTO books_fts (books_fts, rowid, title, author, genre) VALUES ('delete', old.id, old.title, old.author, old.genre);
+    INSERT INTO books_fts (rowid, title, author, genre) VALUES (new.id, new.title, new.author, new.genre);
+END;
+
+INSERT INTO books_fts (books_fts) VALUES ('rebuild');
@@ -0,0 +1,12 @@
+-- no foreign key to books, history outlives purged books
+CREATE TABLE IF NOT EXISTS book_audit (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id    INTEGER NOT NULL,
+    action     TEXT NOT NULL,
+    changes    TEXT NOT NULL,
+    actor      TEXT NOT NULL,
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS book_audit_book_id_idx ON book_audit (book_id, id);
@@ -0,0 +1,58 @@
+CREATE TABLE IF NOT EXISTS authors (
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    name     TEXT NOT NULL,
+    -- name_key makes spellings like "JRR Tolkien" and "J.R.R. Tolkien" one author,
+    -- it is lowercased name without whitespace, dots, hyphens and apostrophes, see nameKey in authors package
+    name_key TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS book_authors (
+    book_id   INTEGER NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    author_id INTEGER NOT NULL REFERENCES authors (id) ON DELETE RESTRICT,
+    PRIMARY KEY (book_id, author_id)
+);
+
+CREATE INDEX IF NOT EXISTS book_authors_author_id_idx ON book_authors (author_id, book_id);
+
+-- author strings may name several authors separated by commas, semicolons, "&" or "and".
+-- sqlite has no regexp functions, separators are turned into commas which split the string.
+-- keys drops whitespace characters of unicode.IsSpace one by one, names which are left without a key
+-- are skipped like splitNames in authors package does.
+-- sqlite lower only folds ascii letters, other names keep their case in name_key
+CREATE TEMP TABLE author_names AS
+WITH RECURSIVE parts (book_id, name, rest) AS (
+    SELECT id, '', replace(replace(replace(replace(author, ' and ', ','), ' AND ', ','), '&', ','), ';', ',') || ','
+    FROM books
+    UNION ALL
+    SELECT book_id, trim(substr(rest, 1, instr(rest, ',') - 1)), substr(rest, instr(rest, ',') + 1)
+    FROM parts
+    WHERE rest <> ''
+),
+keys (book_id, name, name_key, spaces) AS (
+    SELECT book_id, name, lower(replace(replace(replace(name, '.', ''), '-', ''), '''', '')),
+           char(9, 10, 11, 12, 13, 32, 133, 160, 5760, 8192, 8193, 8194, 8195, 8196, 8197, 8198, 8199, 8200, 8201,
+                8202, 8232, 8233, 8239, 8287, 12288)
+    FROM parts
+    WHERE name <> ''
+    UNION ALL
+    SELECT book_id, name, replace(name_key, substr(spaces, 1, 1), ''), substr(spaces, 2)
+    FROM keys
+    WHERE spaces <> ''
+)
+SELECT book_id, name, name_key
+FROM keys
+WHERE spaces = '' AND name_key <> '';
+
+-- spelling of the book with the lowest id names the author,
+-- sqlite takes bare columns of a min() aggregate from the row holding the minimum
+INSERT INTO authors (name, name_key)
+SELECT name, name_key
+FROM (SELECT name, name_key, min(book_id) FROM author_names GROUP BY name_key)
+ORDER BY name_key;
+
+INSERT INTO book_authors (book_id, author_id)
+SELECT DISTINCT n.book_id, a.id
+FROM author_names n
+JOIN authors a ON a.name_key = n.name_key;
+
+DROP TABLE author_names;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS books_genre_id_idx;
+ALTER TABLE books DROP COLUMN genre_id;
+DROP TABLE IF EXISTS genres;
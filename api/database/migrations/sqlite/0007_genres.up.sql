@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    name      TEXT NOT NULL,
+    -- top level genres have no parent, e.g. Fiction > Fantasy > High Fantasy
+    parent_id INTEGER REFERENCES genres (id) ON DELETE RESTRICT
+);
+
+-- genre names are unique regardless of case so that a name is enough to find the genre.
+-- sqlite lower only folds ascii letters, other names are compared as they are
+CREATE UNIQUE INDEX IF NOT EXISTS genres_name_idx ON genres (lower(name));
+CREATE INDEX IF NOT EXISTS genres_parent_id_idx ON genres (parent_id);
+
+ALTER TABLE books ADD COLUMN genre_id INTEGER REFERENCES genres (id) ON DELETE RESTRICT;
+CREATE INDEX IF NOT EXISTS books_genre_id_idx ON books (genre_id);
+
+-- every genre books already have becomes a top level genre, spelling of the book with the lowest id names it,
+-- sqlite takes bare columns of a min() aggregate from the row holding the minimum
+INSERT INTO genres (name)
+SELECT name
+FROM (SELECT trim(genre) AS name, min(id) FROM books WHERE trim(genre) <> '' GROUP BY lower(trim(genre)))
+ORDER BY lower(name);
+
+UPDATE books
+SET genre_id = g.id, genre = g.name
+FROM genres g
+WHERE lower(trim(books.genre)) = lower(g.name);
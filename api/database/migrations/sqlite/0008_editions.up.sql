@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS publishers (
+    id   INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL
+);
+
+-- sqlite lower only folds ascii letters, other names are compared as they are
+CREATE UNIQUE INDEX IF NOT EXISTS publishers_name_idx ON publishers (lower(name));
+
+-- an edition is a published form of a work, the work is a books row
+CREATE TABLE IF NOT EXISTS editions (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id         INTEGER NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    publisher_id    INTEGER REFERENCES publishers (id) ON DELETE RESTRICT,
+    format          TEXT,
+    isbn            TEXT,
+    number_of_pages INTEGER,
+    price           INTEGER,
+    release_date    DATE
+);
+
+CREATE INDEX IF NOT EXISTS editions_book_id_idx ON editions (book_id, id);
+CREATE INDEX IF NOT EXISTS editions_publisher_id_idx ON editions (publisher_id);
+
+-- pages and price of books become their first edition, books keep them as a copy of it from now on
+INSERT INTO editions (book_id, number_of_pages, price)
+SELECT id, number_of_pages, price
+FROM books
+WHERE number_of_pages IS NOT NULL OR price IS NOT NULL
+ORDER BY id;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS editions_isbn_idx;
+ALTER TABLE books DROP COLUMN isbn;
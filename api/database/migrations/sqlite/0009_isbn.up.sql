@@ -0,0 +1,32 @@
+-- books keep isbn of their first edition as a copy, like pages and price
+ALTER TABLE books ADD COLUMN isbn TEXT;
+
+-- isbns were saved as they came so far, hyphens and spaces are stripped
+UPDATE editions
+SET isbn = NULLIF(upper(replace(replace(trim(isbn), '-', ''), ' ', '')), '')
+WHERE isbn IS NOT NULL;
+
+-- valid ISBN-10 becomes ISBN-13 with 978 prefix and check digit of its own, 38 is weighted sum of the prefix.
+-- Values which are no valid ISBN are kept, the api rejects them only when they are sent again
+UPDATE editions
+SET isbn = '978' || substr(isbn, 1, 9) || ((10 - (38
+        + 3 * CAST(substr(isbn, 1, 1) AS INTEGER) + CAST(substr(isbn, 2, 1) AS INTEGER)
+        + 3 * CAST(substr(isbn, 3, 1) AS INTEGER) + CAST(substr(isbn, 4, 1) AS INTEGER)
+        + 3 * CAST(substr(isbn, 5, 1) AS INTEGER) + CAST(substr(isbn, 6, 1) AS INTEGER)
+        + 3 * CAST(substr(isbn, 7, 1) AS INTEGER) + CAST(substr(isbn, 8, 1) AS INTEGER)
+        + 3 * CAST(substr(isbn, 9, 1) AS INTEGER)) % 10) % 10)
+WHERE isbn GLOB '[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9X]'
+  AND (10 * CAST(substr(isbn, 1, 1) AS INTEGER) + 9 * CAST(substr(isbn, 2, 1) AS INTEGER) + 8 * CAST(substr(isbn, 3, 1) AS INTEGER)
+       + 7 * CAST(substr(isbn, 4, 1) AS INTEGER) + 6 * CAST(substr(isbn, 5, 1) AS INTEGER) + 5 * CAST(substr(isbn, 6, 1) AS INTEGER)
+       + 4 * CAST(substr(isbn, 7, 1) AS INTEGER) + 3 * CAST(substr(isbn, 8, 1) AS INTEGER) + 2 * CAST(substr(isbn, 9, 1) AS INTEGER)
+       + CASE WHEN substr(isbn, 10, 1) = 'X' THEN 10 ELSE CAST(substr(isbn, 10, 1) AS INTEGER) END) % 11 = 0;
+
+-- an isbn names one edition, editions added later lose a shared one
+UPDATE editions
+SET isbn = NULL
+WHERE id NOT IN (SELECT min(id) FROM editions WHERE isbn IS NOT NULL GROUP BY isbn);
+
+CREATE UNIQUE INDEX IF NOT EXISTS editions_isbn_idx ON editions (isbn);
+
+UPDATE books
+SET isbn = (SELECT e.isbn FROM editions e WHERE e.book_id = books.id ORDER BY e.id LIMIT 1);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS books_series_id_idx;
+ALTER TABLE books DROP COLUMN series_position;
+ALTER TABLE books DROP COLUMN series_id;
+DROP TABLE IF EXISTS series;
@@ -0,0 +1,10 @@
+-- a series orders books in reading order, e.g. The Lord of the Rings
+CREATE TABLE IF NOT EXISTS series (
+    id   INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL
+);
+
+-- books without position come after positioned ones of the same series
+ALTER TABLE books ADD COLUMN series_id INTEGER REFERENCES series (id) ON DELETE RESTRICT;
+ALTER TABLE books ADD COLUMN series_position INTEGER CHECK (series_position > 0);
+CREATE INDEX IF NOT EXISTS books_series_id_idx ON books (series_id, series_position);
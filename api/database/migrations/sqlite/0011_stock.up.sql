@@ -0,0 +1,34 @@
+-- copies of a book on hand at a warehouse location
+CREATE TABLE IF NOT EXISTS stock_levels (
+    book_id  INTEGER NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    location TEXT NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity >= 0),
+    PRIMARY KEY (book_id, location)
+);
+
+-- ledger of every stock change, no foreign key to books so that it outlives purged books like book_audit
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id        INTEGER NOT NULL,
+    location       TEXT NOT NULL,
+    delta          INTEGER NOT NULL,
+    reason         TEXT NOT NULL CHECK (reason IN ('received', 'sold', 'damaged')),
+    reservation_id INTEGER,
+    actor          TEXT NOT NULL,
+    request_id     TEXT NOT NULL DEFAULT '',
+    created_at     TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS stock_movements_book_id_idx ON stock_movements (book_id, id);
+
+-- reserved copies stay on hand but are not available until the reservation expires,
+-- expires_at is UTC text comparable with strftime of now
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id    INTEGER NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    location   TEXT NOT NULL,
+    quantity   INTEGER NOT NULL CHECK (quantity > 0),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS stock_reservations_book_id_idx ON stock_reservations (book_id, expires_at);
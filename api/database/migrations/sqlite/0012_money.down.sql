@@ -0,0 +1,6 @@
+-- amounts are rounded to whole units, currencies are lost
+UPDATE editions SET price = CAST(round(price) AS INTEGER) WHERE price IS NOT NULL;
+ALTER TABLE editions DROP COLUMN currency;
+
+UPDATE books SET price = CAST(round(price) AS INTEGER) WHERE price IS NOT NULL;
+ALTER TABLE books DROP COLUMN currency;
@@ -0,0 +1,7 @@
+-- prices become decimal amounts in a currency, integer prices saved so far were whole euros.
+-- INTEGER affinity of price keeps decimal amounts as REAL like NUMERIC would, so the column stays as it is
+ALTER TABLE books ADD COLUMN currency TEXT;
+UPDATE books SET currency = 'EUR' WHERE price IS NOT NULL;
+
+ALTER TABLE editions ADD COLUMN currency TEXT;
+UPDATE editions SET currency = 'EUR' WHERE price IS NOT NULL;
@@ -0,0 +1,41 @@
+-- price a book had from effective_from until effective_to, the current price has no effective_to.
+-- No foreign key to books, history outlives purged books like book_audit
+CREATE TABLE IF NOT EXISTS price_history (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id        INTEGER NOT NULL,
+    price          NUMERIC NOT NULL CHECK (price >= 0),
+    currency       TEXT NOT NULL,
+    effective_from TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    effective_to   TIMESTAMP,
+    actor          TEXT NOT NULL,
+    request_id     TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS price_history_book_id_idx ON price_history (book_id, id);
+CREATE UNIQUE INDEX IF NOT EXISTS price_history_current_idx ON price_history (book_id) WHERE effective_to IS NULL;
+
+-- prices the scheduler sets as price of their book at effective_from, which is UTC text comparable with strftime of now
+CREATE TABLE IF NOT EXISTS scheduled_prices (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id        INTEGER NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    price          NUMERIC NOT NULL CHECK (price >= 0),
+    currency       TEXT NOT NULL,
+    effective_from TIMESTAMP NOT NULL,
+    actor          TEXT NOT NULL,
+    request_id     TEXT NOT NULL DEFAULT '',
+    created_at     TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_prices_effective_from_idx ON scheduled_prices (effective_from, id);
+CREATE INDEX IF NOT EXISTS scheduled_prices_book_id_idx ON scheduled_prices (book_id);
+
+-- history starts with current prices, effective since their last change recorded by audit
+INSERT INTO price_history (book_id, price, currency, effective_from, actor)
+SELECT b.id, b.price, b.currency,
+       COALESCE((SELECT max(a.created_at) FROM book_audit a
+                 WHERE a.book_id = b.id AND json_extract(a.changes, '$.price') IS NOT NULL),
+                strftime('%Y-%m-%d %H:%M:%f', 'now')),
+       'system'
+FROM books b
+WHERE b.price IS NOT NULL
+ORDER BY b.id;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS reviews;
+ALTER TABLE books DROP COLUMN rating_sum;
+ALTER TABLE books DROP COLUMN rating_count;
@@ -0,0 +1,18 @@
+-- approved reviews only add up to rating of a book, kept on books to read the average without aggregating reviews
+ALTER TABLE books ADD COLUMN rating_count INTEGER NOT NULL DEFAULT 0 CHECK (rating_count >= 0);
+ALTER TABLE books ADD COLUMN rating_sum INTEGER NOT NULL DEFAULT 0 CHECK (rating_sum >= 0);
+
+-- reviews are pending until an admin approves or rejects them
+CREATE TABLE IF NOT EXISTS reviews (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id      INTEGER NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    rating       INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    text         TEXT NOT NULL DEFAULT '',
+    author       TEXT NOT NULL,
+    status       TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    created_at   TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    moderated_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS reviews_book_id_idx ON reviews (book_id, status, id);
+CREATE INDEX IF NOT EXISTS reviews_status_idx ON reviews (status, id);
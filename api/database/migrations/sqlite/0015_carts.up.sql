@@ -0,0 +1,17 @@
+-- carts are named by uuids and expire after a while without requests, expired carts are purged by the server.
+-- expires_at is UTC text comparable with strftime of now
+CREATE TABLE IF NOT EXISTS carts (
+    id         TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS carts_expires_at_idx ON carts (expires_at);
+
+-- lines keep quantities only, price and stock are those the book has when the cart is read
+CREATE TABLE IF NOT EXISTS cart_lines (
+    cart_id  TEXT NOT NULL REFERENCES carts (id) ON DELETE CASCADE,
+    book_id  INTEGER NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    PRIMARY KEY (cart_id, book_id)
+);
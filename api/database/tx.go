@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maxTxAttempts limits how many times a transaction is run when postgres aborts it
+// because of concurrent transactions
+const maxTxAttempts = 3
+
+const (
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+type pgxTxKey struct{}
+
+type sqlTxKey struct{}
+
+// sqlTx is a database/sql transaction with number of savepoints opened inside it
+type sqlTx struct {
+	tx    *sql.Tx
+	depth int
+}
+
+// PgxTx returns postgres transaction started by RunPgxTx which ctx belongs to
+func PgxTx(ctx context.Context) (pgx.Tx, bool) {
+	tx, ok := ctx.Value(pgxTxKey{}).(pgx.Tx)
+	return tx, ok
+}
+
+// SQLTx returns sqlite transaction started by RunSQLTx which ctx belongs to
+func SQLTx(ctx context.Context) (*sql.Tx, bool) {
+	t, ok := ctx.Value(sqlTxKey{}).(sqlTx)
+	return t.tx, ok
+}
+
+// PgxQuerier is implemented by both pool and transaction
+type PgxQuerier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// PgxConn returns transaction of the unit of work ctx belongs to, or the pool outside of one
+func PgxConn(ctx context.Context, pool *pgxpool.Pool) PgxQuerier {
+	if tx, ok := PgxTx(ctx); ok {
+		return tx
+	}
+	return pool
+}
+
+// SQLQuerier is implemented by both database and transaction
+type SQLQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// SQLConn returns transaction of the unit of work ctx belongs to, or the database outside of one.
+// Sqlite database has a single connection, so querying it while a transaction is open would wait forever
+func SQLConn(ctx context.Context, db *sql.DB) SQLQuerier {
+	if tx, ok := SQLTx(ctx); ok {
+		return tx
+	}
+	return db
+}
+
+func retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected
+}
+
+// RunPgxTx runs fn in a transaction, fn gets ctx which carries the transaction so that repository calls
+// made with it join the transaction. Transaction is committed when fn returns nil and rolled back otherwise.
+// It runs at repeatable read, so fn reads one snapshot and a row it writes after a concurrent transaction
+// changed it aborts the transaction with serialization failure instead of overwriting that change.
+// When ctx already carries a transaction fn runs in a savepoint of it, otherwise whole fn is run again
+// after serialization failure or deadlock, so fn must not have side effects outside of the database
+func RunPgxTx(ctx context.Context, pool *pgxpool.Pool, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	if outer, ok := PgxTx(ctx); ok {
+		// Begin of a transaction creates a savepoint
+		return runPgx(ctx, outer.Begin, fn)
+	}
+
+	var err error
+	for range maxTxAttempts {
+		err = runPgx(ctx, func(ctx context.Context) (pgx.Tx, error) {
+			return pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead})
+		}, fn)
+		if !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func runPgx(ctx context.Context, begin func(context.Context) (pgx.Tx, error),
+	fn func(ctx context.Context, tx pgx.Tx) error) error {
+	tx, err := begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	if err := fn(context.WithValue(ctx, pgxTxKey{}, tx), tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RunSQLTx runs fn in a transaction the same way RunPgxTx does. Sqlite transactions begin immediate
+// and take the database lock right away so they are never retried
+func RunSQLTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	if outer, ok := ctx.Value(sqlTxKey{}).(sqlTx); ok {
+		return runSavepoint(ctx, outer, fn)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, sqlTxKey{}, sqlTx{tx: tx}), tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func runSavepoint(ctx context.Context, outer sqlTx, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	inner := sqlTx{tx: outer.tx, depth: outer.depth + 1}
+	savepoint := fmt.Sprintf("sp_%d", inner.depth)
+
+	if _, err := inner.tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return err
+	}
+
+	if err := fn(context.WithValue(ctx, sqlTxKey{}, inner), inner.tx); err != nil {
+		// rolled back savepoint stays open until released
+		inner.tx.ExecContext(context.Background(), "ROLLBACK TO "+savepoint)
+		inner.tx.ExecContext(context.Background(), "RELEASE "+savepoint)
+		return err
+	}
+
+	_, err := inner.tx.ExecContext(ctx, "RELEASE "+savepoint)
+	return err
+}
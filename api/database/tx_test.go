@@ -0,0 +1,29 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestRetryable(t *testing.T) {
+	tcases := []struct {
+		err      error
+		expected bool
+	}{
+		{err: &pgconn.PgError{Code: pgSerializationFailure}, expected: true},
+		{err: &pgconn.PgError{Code: pgDeadlockDetected}, expected: true},
+		{err: fmt.Errorf("update failed: %w", &pgconn.PgError{Code: pgSerializationFailure}), expected: true},
+		{err: &pgconn.PgError{Code: "23505"}, expected: false},
+		{err: errors.New("connection refused"), expected: false},
+		{err: nil, expected: false},
+	}
+
+	for _, tc := range tcases {
+		if got := retryable(tc.err); got != tc.expected {
+			t.Errorf("retryable(%v) failed\nexpected %v\ngot %v", tc.err, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package money holds amounts of money in a currency, they are kept in minor units
+// so that no amount is ever rounded
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxAmount keeps amounts within 15 significant digits, sqlite keeps decimal amounts as REAL
+// and such amounts come back from it exactly as they were written
+const maxAmount = 999_999_999_999_999
+
+var errNegative = errors.New("amount must not be negative")
+
+var errTooLarge = errors.New("amount is too large")
+
+func unknownCurrency(code string) error {
+	return fmt.Errorf("currency %q is not an ISO 4217 code", code)
+}
+
+// Money is an amount in a currency, 19.99 EUR is Amount 1999 of Currency EUR
+type Money struct {
+	// Amount is in minor units of Currency
+	Amount int64
+	// Currency is an ISO 4217 code
+	Currency string
+}
+
+// New checks amount in minor units and currency code, lowercase codes are accepted
+func New(amount int64, currency string) (Money, error) {
+	code := strings.ToUpper(strings.TrimSpace(currency))
+	if _, ok := currencies[code]; !ok {
+		return Money{}, unknownCurrency(currency)
+	}
+	if amount < 0 {
+		return Money{}, errNegative
+	}
+	if amount > maxAmount {
+		return Money{}, errTooLarge
+	}
+	return Money{Amount: amount, Currency: code}, nil
+}
+
+// Parse reads a decimal amount like 19.99 in currency, it fails when the amount has
+// more decimal places than the currency has minor units
+func Parse(amount, currency string) (Money, error) {
+	code := strings.ToUpper(strings.TrimSpace(currency))
+	exp, ok := currencies[code]
+	if !ok {
+		return Money{}, unknownCurrency(currency)
+	}
+
+	s := strings.TrimSpace(amount)
+	if strings.HasPrefix(s, "-") {
+		return Money{}, errNegative
+	}
+	whole, frac, dot := strings.Cut(s, ".")
+	if !isDigits(whole) || dot && !isDigits(frac) {
+		return Money{}, fmt.Errorf("amount %q is not a decimal number", amount)
+	}
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("amount %s has more than %d decimal places of %s", s, exp, code)
+	}
+
+	digits := strings.TrimLeft(whole+frac+strings.Repeat("0", exp-len(frac)), "0")
+	if len(digits) > len(strconv.Itoa(maxAmount)) {
+		return Money{}, errTooLarge
+	}
+	var n int64
+	if digits != "" {
+		n, _ = strconv.ParseInt(digits, 10, 64)
+	}
+	return New(n, code)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Exponent returns number of minor unit digits of an ISO 4217 currency
+func Exponent(currency string) (int, bool) {
+	exp, ok := currencies[currency]
+	return exp, ok
+}
+
+// Decimal formats the amount with every minor unit digit of the currency, e.g. 20.00
+func (m Money) Decimal() string {
+	exp := currencies[m.Currency]
+	s := strconv.FormatInt(m.Amount, 10)
+	if exp == 0 {
+		return s
+	}
+	if len(s) <= exp {
+		s = strings.Repeat("0", exp-len(s)+1) + s
+	}
+	return s[:len(s)-exp] + "." + s[len(s)-exp:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Float64 is the amount in major units, it serves sorting and filtering which ignore currency
+func (m Money) Float64() float64 {
+	f, _ := strconv.ParseFloat(m.Decimal(), 64)
+	return f
+}
+
+// MarshalJSON writes the amount as a string, e.g. {"amount":"19.99","currency":"EUR"},
+// so that clients don't read it into a float
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{m.Decimal(), m.Currency})
+}
+
+// UnmarshalJSON takes the amount as a string or as a number
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var w struct {
+		Amount   json.Number `json:"amount"`
+		Currency string      `json:"currency"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&w); err != nil {
+		return err
+	}
+	parsed, err := Parse(w.Amount.String(), w.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
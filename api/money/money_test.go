@@ -0,0 +1,121 @@
+package money
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tcases := []struct {
+		amount   string
+		currency string
+		expected Money
+		decimal  string
+		fails    bool
+	}{
+		{amount: "19.99", currency: "EUR", expected: Money{Amount: 1999, Currency: "EUR"}, decimal: "19.99"},
+		{amount: " 20 ", currency: "eur", expected: Money{Amount: 2000, Currency: "EUR"}, decimal: "20.00"},
+		{amount: "0.5", currency: "USD", expected: Money{Amount: 50, Currency: "USD"}, decimal: "0.50"},
+		{amount: "0.05", currency: "USD", expected: Money{Amount: 5, Currency: "USD"}, decimal: "0.05"},
+		{amount: "1.500", currency: "JPY", fails: true},
+		{amount: "1500.000", currency: "JPY", expected: Money{Amount: 1500, Currency: "JPY"}, decimal: "1500"},
+		{amount: "1.234", currency: "KWD", expected: Money{Amount: 1234, Currency: "KWD"}, decimal: "1.234"},
+		{amount: "0", currency: "EUR", expected: Money{Currency: "EUR"}, decimal: "0.00"},
+		{amount: "19.999", currency: "EUR", fails: true},
+		{amount: "-1", currency: "EUR", fails: true},
+		{amount: "1e3", currency: "EUR", fails: true},
+		{amount: ".5", currency: "EUR", fails: true},
+		{amount: "5.", currency: "EUR", fails: true},
+		{amount: "", currency: "EUR", fails: true},
+		{amount: "19.99", currency: "EURO", fails: true},
+		{amount: "19.99", currency: "", fails: true},
+		{amount: "9999999999999.99", currency: "EUR", expected: Money{Amount: maxAmount, Currency: "EUR"}, decimal: "9999999999999.99"},
+		{amount: "10000000000000", currency: "EUR", fails: true},
+	}
+
+	for _, tc := range tcases {
+		m, err := Parse(tc.amount, tc.currency)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("Parse(%q, %q) expected error\ngot %+v", tc.amount, tc.currency, m)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q, %q) failed\nunexpected error %s", tc.amount, tc.currency, err.Error())
+			continue
+		}
+		if m != tc.expected || m.Decimal() != tc.decimal {
+			t.Errorf("Parse(%q, %q) failed\nexpected %+v %s\ngot %+v %s", tc.amount, tc.currency, tc.expected, tc.decimal, m, m.Decimal())
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	m := Money{Amount: 1999, Currency: "EUR"}
+	j, err := json.Marshal(m)
+	if err != nil || string(j) != `{"amount":"19.99","currency":"EUR"}` {
+		t.Errorf("Marshal failed\nexpected {\"amount\":\"19.99\",\"currency\":\"EUR\"}\ngot %s, %v", j, err)
+	}
+
+	tcases := []struct {
+		data  string
+		fails bool
+	}{
+		{data: `{"amount":"19.99","currency":"EUR"}`},
+		{data: `{"amount":19.99,"currency":"eur"}`},
+		{data: `{"amount":"19.99","currency":"XYZ"}`, fails: true},
+		{data: `{"amount":"abc","currency":"EUR"}`, fails: true},
+		{data: `{"amount":"19.99","currency":"EUR","cents":1999}`, fails: true},
+		{data: `1999`, fails: true},
+	}
+	for _, tc := range tcases {
+		var got Money
+		err := json.Unmarshal([]byte(tc.data), &got)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("Unmarshal %s expected error\ngot %+v", tc.data, got)
+			}
+			continue
+		}
+		if err != nil || got != m {
+			t.Errorf("Unmarshal %s failed\nexpected %+v\ngot %+v, %v", tc.data, m, got, err)
+		}
+	}
+}
+
+func TestScanFields(t *testing.T) {
+	tcases := []struct {
+		amount   any
+		currency any
+		expected *Money
+		fails    bool
+	}{
+		{amount: "19.99", currency: "EUR", expected: &Money{Amount: 1999, Currency: "EUR"}},
+		{amount: []byte("20"), currency: []byte("EUR"), expected: &Money{Amount: 2000, Currency: "EUR"}},
+		{amount: 19.9, currency: "EUR", expected: &Money{Amount: 1990, Currency: "EUR"}},
+		{amount: int64(1500), currency: "JPY", expected: &Money{Amount: 1500, Currency: "JPY"}},
+		{amount: nil, currency: nil},
+		{amount: "19.99", currency: nil, fails: true},
+	}
+
+	for _, tc := range tcases {
+		// a scanned row must not leak into the next one
+		m := &Money{Amount: 1, Currency: "USD"}
+		amount, currency := ScanFields(&m)
+		err := amount.(sql.Scanner).Scan(tc.amount)
+		if err == nil {
+			err = currency.(sql.Scanner).Scan(tc.currency)
+		}
+		if tc.fails {
+			if err == nil {
+				t.Errorf("ScanFields(%v, %v) expected error", tc.amount, tc.currency)
+			}
+			continue
+		}
+		if err != nil || (m == nil) != (tc.expected == nil) || m != nil && *m != *tc.expected {
+			t.Errorf("ScanFields(%v, %v) failed\nexpected %v\ngot %v, %v", tc.amount, tc.currency, tc.expected, m, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package money
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ScanFields returns scan destinations for an amount column followed by its currency column,
+// *m is set to nil when the amount is NULL. Postgres numeric comes as a string, sqlite keeps
+// decimal amounts as REAL and whole ones as INTEGER
+func ScanFields(m **Money) (amount, currency any) {
+	s := &scan{dst: m}
+	return (*amountScanner)(s), (*currencyScanner)(s)
+}
+
+// scan holds the amount until the currency it belongs to is scanned
+type scan struct {
+	dst    **Money
+	amount *string
+}
+
+type amountScanner scan
+
+func (s *amountScanner) Scan(src any) error {
+	s.amount, *s.dst = nil, nil
+	var amount string
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		amount = v
+	case []byte:
+		amount = string(v)
+	case int64:
+		amount = strconv.FormatInt(v, 10)
+	case float64:
+		amount = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Errorf("money: can't scan amount from %T", src)
+	}
+	s.amount = &amount
+	return nil
+}
+
+type currencyScanner scan
+
+func (s *currencyScanner) Scan(src any) error {
+	if s.amount == nil {
+		return nil
+	}
+	var code string
+	switch v := src.(type) {
+	case string:
+		code = v
+	case []byte:
+		code = string(v)
+	default:
+		return fmt.Errorf("money: can't scan currency from %T", src)
+	}
+	m, err := Parse(*s.amount, code)
+	if err != nil {
+		return err
+	}
+	*s.dst = &m
+	return nil
+}
@@ -0,0 +1,422 @@
+package authors
+
+import (
+	"booksapi/api/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+func invalidParamErr(name string) database.APIError {
+	return database.APIError{
+		Status:  http.StatusBadRequest,
+		Message: fmt.Sprintf("invalid value for %s query parameter", name),
+	}
+}
+
+// pathID parses integer path parameter of given name
+func pathID(r *http.Request, name string) (int, *database.APIError) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		return 0, &database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("only accept integer values as {%s} path parameter", name),
+		}
+	}
+	return id, nil
+}
+
+// parsePaging reads limit and offset query parameters, any other parameter than those and allowed is refused
+func parsePaging(values url.Values, allowed ...string) (pageQuery, *database.APIError) {
+	q := pageQuery{
+		Limit: defaultPageLimit,
+	}
+
+	for name := range values {
+		if name != "limit" && name != "offset" && !slices.Contains(allowed, name) {
+			return q, &database.APIError{
+				Status:  http.StatusBadRequest,
+				Message: fmt.Sprintf("unknown query parameter %s", name),
+			}
+		}
+	}
+
+	if values.Has("limit") {
+		limit, err := strconv.Atoi(values.Get("limit"))
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			e := invalidParamErr("limit")
+			return q, &e
+		}
+		q.Limit = limit
+	}
+
+	if values.Has("offset") {
+		offset, err := strconv.Atoi(values.Get("offset"))
+		if err != nil || offset < 0 {
+			e := invalidParamErr("offset")
+			return q, &e
+		}
+		q.Offset = offset
+	}
+
+	return q, nil
+}
+
+func decodeAuthor(r *http.Request) (authorRequestBody, *database.APIError) {
+	var req authorRequestBody
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, &database.APIError{
+			Message: "invalid request model",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if err := req.validate(); err != nil {
+		return req, &database.APIError{
+			Message: err.Error(),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	return req, nil
+}
+
+type API struct {
+	repo IAuthorsRepo
+}
+
+// New picks repository of the opened storage, books are needed by memory repo only
+func New(books BookFinder) API {
+	var repo IAuthorsRepo
+	switch s := database.Get().(type) {
+	case *database.PostgresStorage:
+		repo = &AuthorsRepo{pool: s.Pool}
+	case *database.SQLiteStorage:
+		repo = &SQLiteRepo{db: s.DB}
+	default:
+		repo = NewMemoryRepo(books)
+	}
+
+	return API{
+		repo: repo,
+	}
+}
+
+// LinkAuthors links books to authors their author strings name for books package, authors which don't
+// exist yet are added. Called with ctx of a book write, it joins the transaction of the write
+func (api API) LinkAuthors(ctx context.Context, books map[int]string) error {
+	return api.repo.LinkAuthors(ctx, books)
+}
+
+// GetAuthors returns a page of authors
+//
+//	@Summary		Get authors
+//	@Description	lists authors ordered by name
+//	@Tags			authors
+//	@Produce		json
+//	@Param			name	query		string	false	"any spelling of author name, case, spaces and dots are ignored"
+//	@Param			limit	query		int		false	"page size, 20 by default and 100 at most"
+//	@Param			offset	query		int		false	"number of authors to skip"
+//	@Success		200		{object}	authorsPageDTO
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Router			/api/authors [get]
+func (api API) GetAuthors(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	paging, apiErr := parsePaging(values, "name")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	q := authorsQuery{
+		Limit:  paging.Limit,
+		Offset: paging.Offset,
+	}
+	if values.Has("name") {
+		name := values.Get("name")
+		if nameKey(name) == "" {
+			database.WriteAPIErr(invalidParamErr("name"), w)
+			return
+		}
+		q.Name = &name
+	}
+
+	page, err := api.repo.GetAuthors(r.Context(), q)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto := authorsPageDTO{
+		Authors: make([]authorDTO, 0, len(page.Authors)),
+		Total:   page.Total,
+		Limit:   q.Limit,
+		Offset:  q.Offset,
+	}
+	for _, a := range page.Authors {
+		dto.Authors = append(dto.Authors, a.ToDto())
+	}
+
+	json, _ := json.Marshal(dto)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// GetAuthor returns an author by id
+//
+//	@Summary		Get author by id
+//	@Description	get author
+//	@Tags			authors
+//	@Produce		json
+//	@Param			id	path		int	true	"Author ID"
+//	@Success		200	{object}	authorDTO
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/authors/{id} [get]
+func (api API) GetAuthor(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	author, err := api.repo.GetAuthorById(r.Context(), id)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	json, _ := json.Marshal(author.ToDto())
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// AddAuthor adds new author
+//
+//	@Summary		Add new author
+//	@Description	adds author, another spelling of existing author name is refused
+//	@Tags			authors
+//	@Accept			json
+//	@Produce		json
+//	@Param			author	body		authorRequestBody	true	"request body"
+//	@Success		201		{object}	ActionResponse
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Failure		409		{object}	database.APIError
+//	@Router			/api/authors [post]
+func (api API) AddAuthor(w http.ResponseWriter, r *http.Request) {
+	req, apiErr := decodeAuthor(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	id, err := api.repo.AddAuthor(r.Context(), *req.Name)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	j, _ := json.Marshal(ActionResponse{ResourceId: id})
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, string(j[:]))
+}
+
+// UpdateAuthor renames an author
+//
+//	@Summary		Update author
+//	@Description	renames author, author of linked books follows the new name
+//	@Tags			authors
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path	int					true	"Author ID"
+//	@Param			author	body	authorRequestBody	true	"request body"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Failure		409	{object}	database.APIError
+//	@Router			/api/authors/{id} [patch]
+func (api API) UpdateAuthor(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	req, apiErr := decodeAuthor(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	if err := api.repo.UpdateAuthor(r.Context(), id, *req.Name); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveAuthor deletes an author without books
+//
+//	@Summary		Remove author
+//	@Description	removes author, books have to be unlinked first
+//	@Tags			authors
+//	@Produce		json
+//	@Param			id	path	int	true	"Author ID"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Failure		409	{object}	database.APIError
+//	@Router			/api/authors/{id} [delete]
+func (api API) RemoveAuthor(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	if err := api.repo.RemoveAuthor(r.Context(), id); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAuthorBooks returns a page of books of an author
+//
+//	@Summary		Get books of author
+//	@Description	lists books linked to the author in id order, trashed books are left out
+//	@Tags			authors
+//	@Produce		json
+//	@Param			id		path		int	true	"Author ID"
+//	@Param			limit	query		int	false	"page size, 20 by default and 100 at most"
+//	@Param			offset	query		int	false	"number of books to skip"
+//	@Success		200		{object}	booksPageDTO
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Failure		404		{object}	database.APIError
+//	@Router			/api/authors/{id}/books [get]
+func (api API) GetAuthorBooks(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	q, apiErr := parsePaging(r.URL.Query())
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	page, err := api.repo.GetAuthorBooks(r.Context(), id, q)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto := booksPageDTO{
+		Books:  make([]bookSummaryDTO, 0, len(page.Books)),
+		Total:  page.Total,
+		Limit:  q.Limit,
+		Offset: q.Offset,
+	}
+	for _, b := range page.Books {
+		dto.Books = append(dto.Books, b.ToDto())
+	}
+
+	json, _ := json.Marshal(dto)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// LinkBook makes the author one of authors of the book
+//
+//	@Summary		Link book to author
+//	@Description	links book to author, linking it again changes nothing
+//	@Tags			authors
+//	@Produce		json
+//	@Param			id		path	int	true	"Author ID"
+//	@Param			bookId	path	int	true	"Book ID"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/authors/{id}/books/{bookId} [put]
+func (api API) LinkBook(w http.ResponseWriter, r *http.Request) {
+	api.changeLink(w, r, api.repo.LinkBook)
+}
+
+// UnlinkBook removes the author from authors of the book
+//
+//	@Summary		Unlink book from author
+//	@Description	unlinks book from author
+//	@Tags			authors
+//	@Produce		json
+//	@Param			id		path	int	true	"Author ID"
+//	@Param			bookId	path	int	true	"Book ID"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/authors/{id}/books/{bookId} [delete]
+func (api API) UnlinkBook(w http.ResponseWriter, r *http.Request) {
+	api.changeLink(w, r, api.repo.UnlinkBook)
+}
+
+func (api API) changeLink(w http.ResponseWriter, r *http.Request,
+	change func(ctx context.Context, authorID, bookID int) error) {
+	authorID, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+	bookID, apiErr := pathID(r, "bookId")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	if err := change(r.Context(), authorID, bookID); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,164 @@
+package authors
+
+import (
+	"booksapi/api/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthorsAPI(t *testing.T) {
+	api := API{repo: NewMemoryRepo(fakeBooks{1: {title: "The Hobbit"}, 2: {title: "Good Omens"}})}
+
+	tcases := []struct {
+		method       string
+		url          string
+		pathValues   map[string]string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		data         string
+		headerStatus int
+	}{
+		{
+			method:       "POST",
+			url:          "/api/authors",
+			body:         `{"name":"  J.R.R.   Tolkien "}`,
+			handler:      api.AddAuthor,
+			data:         `{"resourceId":1}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "POST",
+			url:          "/api/authors",
+			body:         `{"name":"JRR Tolkien"}`,
+			handler:      api.AddAuthor,
+			data:         database.APIError{Status: http.StatusConflict, Message: `author named "JRR Tolkien" already exists`}.Error(),
+			headerStatus: http.StatusConflict,
+		},
+		{
+			method:       "POST",
+			url:          "/api/authors",
+			body:         `{"name":" "}`,
+			handler:      api.AddAuthor,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "name is required, won't save the data"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/authors",
+			body:         `{"name":"Neil Gaiman","born":1960}`,
+			handler:      api.AddAuthor,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "invalid request model"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "GET",
+			url:          "/api/authors/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.GetAuthor,
+			data:         `{"id":1,"name":"J.R.R. Tolkien"}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/authors/x",
+			pathValues:   map[string]string{"id": "x"},
+			handler:      api.GetAuthor,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "only accept integer values as {id} path parameter"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "PUT",
+			url:          "/api/authors/1/books/2",
+			pathValues:   map[string]string{"id": "1", "bookId": "2"},
+			handler:      api.LinkBook,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "PUT",
+			url:          "/api/authors/1/books/3",
+			pathValues:   map[string]string{"id": "1", "bookId": "3"},
+			handler:      api.LinkBook,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "book with id 3 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+		{
+			method:       "GET",
+			url:          "/api/authors/1/books?limit=5",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.GetAuthorBooks,
+			data:         `{"books":[{"id":2,"title":"Good Omens","releaseYear":null}],"total":1,"limit":5,"offset":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/authors?name=jrr%20tolkien",
+			handler:      api.GetAuthors,
+			data:         `{"authors":[{"id":1,"name":"J.R.R. Tolkien"}],"total":1,"limit":20,"offset":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/authors?sort=name",
+			handler:      api.GetAuthors,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "unknown query parameter sort"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "DELETE",
+			url:          "/api/authors/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.RemoveAuthor,
+			data:         database.APIError{Status: http.StatusConflict, Message: "author with id 1 still has books, unlink them first"}.Error(),
+			headerStatus: http.StatusConflict,
+		},
+		{
+			method:       "DELETE",
+			url:          "/api/authors/1/books/2",
+			pathValues:   map[string]string{"id": "1", "bookId": "2"},
+			handler:      api.UnlinkBook,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/authors/1",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"name":"John Ronald Reuel Tolkien"}`,
+			handler:      api.UpdateAuthor,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "DELETE",
+			url:          "/api/authors/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.RemoveAuthor,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/authors/1/books",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.GetAuthorBooks,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "author with id 1 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+	}
+
+	// cases run in order, each one sees changes of those before it
+	for _, tc := range tcases {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		for name, value := range tc.pathValues {
+			rq.SetPathValue(name, value)
+		}
+		tc.handler(w, rq)
+
+		if tc.data != w.Body.String() {
+			t.Errorf("%s %s failed\nexpected %v\ngot %v", tc.method, tc.url, tc.data, w.Body.String())
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("%s %s response header failed\nexpected %v\ngot  %v", tc.method, tc.url, tc.headerStatus, w.Code)
+		}
+	}
+}
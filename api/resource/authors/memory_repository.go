@@ -0,0 +1,324 @@
+package authors
+
+import (
+	"booksapi/api/database"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// BookFinder looks up live books kept by books package,
+// memory repo has no books table to join so it asks for them one by one
+type BookFinder interface {
+	FindBook(ctx context.Context, id int) (title string, releaseYear *int, ok bool)
+	// RenameAuthor changes author strings of books by ids, trashed books included, with rename
+	RenameAuthor(ctx context.Context, bookIDs []int, rename func(author string) string)
+}
+
+// MemoryRepo keeps authors in a map, it is meant for demos and local runs without postgres.
+// Trashed books are invisible to BookFinder, so unlike databases their links don't keep authors from removal
+type MemoryRepo struct {
+	mu      sync.RWMutex
+	authors map[int]authorEntity
+	lastID  int
+	// links holds ids of linked books by author id
+	links map[int]map[int]bool
+	books BookFinder
+}
+
+func NewMemoryRepo(books BookFinder) *MemoryRepo {
+	return &MemoryRepo{
+		authors: map[int]authorEntity{},
+		links:   map[int]map[int]bool{},
+		books:   books,
+	}
+}
+
+// save copies authors and their links for a unit of work, the copy is put back when the unit of work fails
+func (repo *MemoryRepo) save() func() {
+	repo.mu.RLock()
+	authors, lastID := maps.Clone(repo.authors), repo.lastID
+	links := make(map[int]map[int]bool, len(repo.links))
+	for id, linked := range repo.links {
+		links[id] = maps.Clone(linked)
+	}
+	repo.mu.RUnlock()
+
+	return func() {
+		repo.mu.Lock()
+		defer repo.mu.Unlock()
+		repo.authors, repo.lastID, repo.links = authors, lastID, links
+	}
+}
+
+// findByKey returns id of the author with the same nameKey, caller holds the lock
+func (repo *MemoryRepo) findByKey(name string) (int, bool) {
+	key := nameKey(name)
+	for id, a := range repo.authors {
+		if nameKey(a.Name) == key {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// linkedBooks returns live books out of linked ones in id order
+func (repo *MemoryRepo) linkedBooks(ctx context.Context, linked map[int]bool) []bookSummary {
+	bookIDs := make([]int, 0, len(linked))
+	for id := range linked {
+		bookIDs = append(bookIDs, id)
+	}
+	slices.Sort(bookIDs)
+
+	books := make([]bookSummary, 0, len(bookIDs))
+	for _, id := range bookIDs {
+		if title, releaseYear, ok := repo.books.FindBook(ctx, id); ok {
+			books = append(books, bookSummary{ID: id, Title: title, ReleaseYear: releaseYear})
+		}
+	}
+	return books
+}
+
+func (repo *MemoryRepo) GetAuthors(ctx context.Context, q authorsQuery) (authorsPage, error) {
+	if err := ctx.Err(); err != nil {
+		return authorsPage{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	page := authorsPage{
+		Authors: make([]authorEntity, 0),
+	}
+
+	matched := make([]authorEntity, 0)
+	for _, a := range repo.authors {
+		if q.Name == nil || nameKey(a.Name) == nameKey(*q.Name) {
+			matched = append(matched, a)
+		}
+	}
+	page.Total = len(matched)
+
+	sort.Slice(matched, func(i, j int) bool {
+		ki, kj := nameKey(matched[i].Name), nameKey(matched[j].Name)
+		if ki != kj {
+			return ki < kj
+		}
+		return matched[i].ID < matched[j].ID
+	})
+
+	start := min(q.Offset, len(matched))
+	end := min(start+q.Limit, len(matched))
+	page.Authors = append(page.Authors, matched[start:end]...)
+
+	return page, nil
+}
+
+func (repo *MemoryRepo) GetAuthorById(ctx context.Context, id int) (authorEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return authorEntity{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	a, ok := repo.authors[id]
+	if !ok {
+		return authorEntity{}, authorNotFound(id)
+	}
+
+	return a, nil
+}
+
+func (repo *MemoryRepo) AddAuthor(ctx context.Context, name string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, taken := repo.findByKey(name); taken {
+		return 0, nameTaken(name)
+	}
+
+	repo.lastID++
+	repo.authors[repo.lastID] = authorEntity{ID: repo.lastID, Name: name}
+
+	return repo.lastID, nil
+}
+
+func (repo *MemoryRepo) UpdateAuthor(ctx context.Context, id int, name string) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	oldName, bookIDs, err := repo.rename(id, name)
+	if err != nil {
+		return err
+	}
+
+	// books are renamed without the lock, they link their authors through the repo
+	repo.books.RenameAuthor(ctx, bookIDs, func(author string) string {
+		return renameIn(author, oldName, name)
+	})
+
+	return nil
+}
+
+// rename sets name of the author and returns its previous name with ids of linked books
+func (repo *MemoryRepo) rename(id int, name string) (string, []int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	a, ok := repo.authors[id]
+	if !ok {
+		return "", nil, authorNotFound(id)
+	}
+	if other, taken := repo.findByKey(name); taken && other != id {
+		return "", nil, nameTaken(name)
+	}
+
+	oldName := a.Name
+	a.Name = name
+	repo.authors[id] = a
+
+	bookIDs := make([]int, 0, len(repo.links[id]))
+	for bookID := range repo.links[id] {
+		bookIDs = append(bookIDs, bookID)
+	}
+	slices.Sort(bookIDs)
+
+	return oldName, bookIDs, nil
+}
+
+func (repo *MemoryRepo) RemoveAuthor(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.authors[id]; !ok {
+		return authorNotFound(id)
+	}
+	if len(repo.linkedBooks(ctx, repo.links[id])) > 0 {
+		return authorHasBooks(id)
+	}
+
+	delete(repo.authors, id)
+	delete(repo.links, id)
+
+	return nil
+}
+
+func (repo *MemoryRepo) GetAuthorBooks(ctx context.Context, id int, q pageQuery) (booksPage, error) {
+	if err := ctx.Err(); err != nil {
+		return booksPage{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	page := booksPage{
+		Books: make([]bookSummary, 0),
+	}
+
+	if _, ok := repo.authors[id]; !ok {
+		return page, authorNotFound(id)
+	}
+
+	books := repo.linkedBooks(ctx, repo.links[id])
+	page.Total = len(books)
+
+	start := min(q.Offset, len(books))
+	end := min(start+q.Limit, len(books))
+	page.Books = append(page.Books, books[start:end]...)
+
+	return page, nil
+}
+
+func (repo *MemoryRepo) LinkBook(ctx context.Context, authorID, bookID int) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.authors[authorID]; !ok {
+		return authorNotFound(authorID)
+	}
+	if _, _, ok := repo.books.FindBook(ctx, bookID); !ok {
+		return bookNotFound(bookID)
+	}
+
+	if repo.links[authorID] == nil {
+		repo.links[authorID] = map[int]bool{}
+	}
+	repo.links[authorID][bookID] = true
+
+	return nil
+}
+
+func (repo *MemoryRepo) UnlinkBook(ctx context.Context, authorID, bookID int) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if !repo.links[authorID][bookID] {
+		return database.NotFoundErr{Message: fmt.Sprintf("book with id %d is not linked to author with id %d", bookID, authorID)}
+	}
+	delete(repo.links[authorID], bookID)
+
+	return nil
+}
+
+func (repo *MemoryRepo) LinkAuthors(ctx context.Context, books map[int]string) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for bookID, author := range books {
+		for _, linked := range repo.links {
+			delete(linked, bookID)
+		}
+
+		for _, name := range splitNames(author) {
+			id, ok := repo.findByKey(name)
+			if !ok {
+				repo.lastID++
+				id = repo.lastID
+				repo.authors[id] = authorEntity{ID: id, Name: name}
+			}
+			if repo.links[id] == nil {
+				repo.links[id] = map[int]bool{}
+			}
+			repo.links[id][bookID] = true
+		}
+	}
+
+	return nil
+}
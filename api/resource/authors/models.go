@@ -0,0 +1,156 @@
+package authors
+
+import (
+	"booksapi/api/database"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+type authorRequestBody struct {
+	Name *string `json:"name"`
+}
+
+// validate trims the name and checks it is set
+func (b *authorRequestBody) validate() error {
+	if b.Name == nil || strings.TrimSpace(*b.Name) == "" {
+		return database.BadRequestErr{Message: "name is required, won't save the data"}
+	}
+	name := strings.Join(strings.Fields(*b.Name), " ")
+	b.Name = &name
+	return nil
+}
+
+// nameKey is what tells authors apart, spellings which differ only in case, spaces, dots, hyphens
+// or apostrophes are the same author. authors migration computes name_key column the same way
+func nameKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '.' || r == '-' || r == '\'' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+// nameSeparator separates authors of a book which names several of them by commas, semicolons, "&" or "and".
+// authors migration splits author strings of books the same way
+var nameSeparator = regexp.MustCompile(`(?i)\s*(,|;|&|\band\b)\s*`)
+
+// splitNames returns names of authors in author string of a book, every author is named once
+func splitNames(author string) []string {
+	names := make([]string, 0)
+	seen := map[string]bool{}
+	for _, name := range nameSeparator.Split(author, -1) {
+		name = strings.Join(strings.Fields(name), " ")
+		key := nameKey(name)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// renameIn replaces every spelling of oldName in author string of a book with newName, other authors
+// and separators stay as they are
+func renameIn(author, oldName, newName string) string {
+	key := nameKey(oldName)
+	var renamed strings.Builder
+	start := 0
+	separators := append(nameSeparator.FindAllStringIndex(author, -1), []int{len(author), len(author)})
+	for _, sep := range separators {
+		name := author[start:sep[0]]
+		if trimmed := strings.TrimSpace(name); trimmed != "" && nameKey(trimmed) == key {
+			name = strings.Replace(name, trimmed, newName, 1)
+		}
+		renamed.WriteString(name)
+		renamed.WriteString(author[sep[0]:sep[1]])
+		start = sep[1]
+	}
+	return renamed.String()
+}
+
+type authorEntity struct {
+	ID   int
+	Name string
+}
+
+func (a authorEntity) ToDto() authorDTO {
+	return authorDTO{
+		ID:   a.ID,
+		Name: a.Name,
+	}
+}
+
+type authorDTO struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type authorsQuery struct {
+	// Name matches authors by nameKey, so any spelling of the name finds the author
+	Name   *string
+	Limit  int
+	Offset int
+}
+
+type authorsPage struct {
+	Authors []authorEntity
+	Total   int
+}
+
+type authorsPageDTO struct {
+	Authors []authorDTO `json:"authors"`
+	Total   int         `json:"total"`
+	Limit   int         `json:"limit"`
+	Offset  int         `json:"offset"`
+}
+
+// bookAuthor is author string of a book linked to an author
+type bookAuthor struct {
+	ID     int
+	Author string
+}
+
+// bookSummary is a live book linked to an author
+type bookSummary struct {
+	ID          int
+	Title       string
+	ReleaseYear *int
+}
+
+func (b bookSummary) ToDto() bookSummaryDTO {
+	return bookSummaryDTO{
+		ID:          b.ID,
+		Title:       b.Title,
+		ReleaseYear: b.ReleaseYear,
+	}
+}
+
+type bookSummaryDTO struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	ReleaseYear *int   `json:"releaseYear"`
+}
+
+type pageQuery struct {
+	Limit  int
+	Offset int
+}
+
+type booksPage struct {
+	Books []bookSummary
+	Total int
+}
+
+type booksPageDTO struct {
+	Books  []bookSummaryDTO `json:"books"`
+	Total  int              `json:"total"`
+	Limit  int              `json:"limit"`
+	Offset int              `json:"offset"`
+}
+
+type ActionResponse struct {
+	ResourceId int `json:"resourceId"`
+}
@@ -0,0 +1,390 @@
+package authors
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/router/middlewares"
+	"booksapi/logger"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type IAuthorsRepo interface {
+	GetAuthors(context.Context, authorsQuery) (authorsPage, error)
+	GetAuthorById(context.Context, int) (authorEntity, error)
+	// AddAuthor and UpdateAuthor fail with ConflictErr when another author has the same nameKey
+	AddAuthor(ctx context.Context, name string) (int, error)
+	// UpdateAuthor renames the author in author strings of its books as well, trashed books included
+	UpdateAuthor(ctx context.Context, id int, name string) error
+	// RemoveAuthor fails with ConflictErr while books are linked to the author
+	RemoveAuthor(ctx context.Context, id int) error
+	// GetAuthorBooks lists live books of the author in id order
+	GetAuthorBooks(ctx context.Context, id int, q pageQuery) (booksPage, error)
+	// LinkBook adds the book to books of the author, linking it again changes nothing.
+	// Both author and live book must exist
+	LinkBook(ctx context.Context, authorID, bookID int) error
+	// UnlinkBook fails with NotFoundErr when the book is not linked to the author
+	UnlinkBook(ctx context.Context, authorID, bookID int) error
+	// LinkAuthors makes authors named in author strings of books by id the only authors of those books.
+	// Authors are found by nameKey and added when there is none yet, books call it in their transaction
+	LinkAuthors(ctx context.Context, books map[int]string) error
+}
+
+func authorNotFound(id int) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("author with id %d not found", id)}
+}
+
+func bookNotFound(id int) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", id)}
+}
+
+func nameTaken(name string) database.ConflictErr {
+	return database.ConflictErr{Message: fmt.Sprintf("author named %q already exists", name)}
+}
+
+func authorHasBooks(id int) database.ConflictErr {
+	return database.ConflictErr{Message: fmt.Sprintf("author with id %d still has books, unlink them first", id)}
+}
+
+// AuthorsRepo keeps authors in postgres
+type AuthorsRepo struct {
+	pool *pgxpool.Pool
+}
+
+func (repo *AuthorsRepo) GetAuthors(ctx context.Context, q authorsQuery) (authorsPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := authorsPage{
+		Authors: make([]authorEntity, 0),
+	}
+
+	where := ``
+	args := pgx.NamedArgs{
+		"limit":  q.Limit,
+		"offset": q.Offset,
+	}
+	if q.Name != nil {
+		where = `WHERE name_key = @name_key`
+		args["name_key"] = nameKey(*q.Name)
+	}
+
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT count(*) FROM public.authors `+where, args).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	query := `SELECT id, name FROM public.authors ` + where + ` ORDER BY name_key, id LIMIT @limit OFFSET @offset`
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	authors, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (authorEntity, error) {
+		var a authorEntity
+		err := row.Scan(&a.ID, &a.Name)
+		return a, err
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	page.Authors = authors
+
+	return page, nil
+}
+
+func (repo *AuthorsRepo) GetAuthorById(ctx context.Context, id int) (authorEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var a authorEntity
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT id, name FROM public.authors WHERE id = @id`,
+		pgx.NamedArgs{"id": id}).Scan(&a.ID, &a.Name)
+	if err != nil {
+		logger.Error(err.Error())
+		if errors.Is(err, pgx.ErrNoRows) {
+			return a, authorNotFound(id)
+		}
+		return a, database.RepoErr(ctx, err)
+	}
+
+	return a, nil
+}
+
+func (repo *AuthorsRepo) AddAuthor(ctx context.Context, name string) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO public.authors (name, name_key) VALUES (@name, @name_key) RETURNING id`
+	args := pgx.NamedArgs{
+		"name":     name,
+		"name_key": nameKey(name),
+	}
+
+	var id int
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, query, args).Scan(&id)
+	if err != nil {
+		logger.Error(err.Error())
+		if database.IsUniqueViolation(err) {
+			return 0, nameTaken(name)
+		}
+		return 0, database.RepoErr(ctx, err)
+	}
+
+	return id, nil
+}
+
+func (repo *AuthorsRepo) UpdateAuthor(ctx context.Context, id int, name string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	args := pgx.NamedArgs{
+		"id":       id,
+		"name":     name,
+		"name_key": nameKey(name),
+	}
+
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		var oldName string
+		err := tx.QueryRow(ctx, `SELECT name FROM public.authors WHERE id = @id FOR UPDATE`, args).Scan(&oldName)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return authorNotFound(id)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE public.authors SET name = @name, name_key = @name_key WHERE id = @id`, args)
+		if database.IsUniqueViolation(err) {
+			return nameTaken(name)
+		}
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(ctx, `SELECT b.id, b.author
+		                            FROM public.books b
+		                            JOIN public.book_authors ba ON ba.book_id = b.id
+		                            WHERE ba.author_id = @id
+		                            ORDER BY b.id
+		                            FOR UPDATE OF b`, args)
+		if err != nil {
+			return err
+		}
+		books, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (bookAuthor, error) {
+			var b bookAuthor
+			err := row.Scan(&b.ID, &b.Author)
+			return b, err
+		})
+		if err != nil {
+			return err
+		}
+
+		// every renamed book gets an audit entry like a book update
+		actor, requestID := middlewares.AuditActor(ctx), middlewares.RequestIDFromContext(ctx)
+		for _, b := range books {
+			author := renameIn(b.Author, oldName, name)
+			if author == b.Author {
+				continue
+			}
+			bookArgs := pgx.NamedArgs{"id": b.ID, "before": b.Author, "author": author, "actor": actor, "request_id": requestID}
+			_, err := tx.Exec(ctx, `UPDATE public.books SET author = @author, version = version + 1 WHERE id = @id`, bookArgs)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(ctx, `INSERT INTO public.book_audit (book_id, action, changes, actor, request_id)
+			                       VALUES (@id, 'update',
+			                               jsonb_build_object('author', jsonb_build_object('before', @before::text, 'after', @author::text)),
+			                               @actor, @request_id)`, bookArgs)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *AuthorsRepo) RemoveAuthor(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	// book_authors restricts deleting authors which still have books
+	tag, err := database.PgxConn(ctx, repo.pool).Exec(ctx, `DELETE FROM public.authors WHERE id = @id`, pgx.NamedArgs{"id": id})
+	if err != nil {
+		logger.Error(err.Error())
+		if database.IsForeignKeyViolation(err) {
+			return authorHasBooks(id)
+		}
+		return database.RepoErr(ctx, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return authorNotFound(id)
+	}
+
+	return nil
+}
+
+func (repo *AuthorsRepo) GetAuthorBooks(ctx context.Context, id int, q pageQuery) (booksPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := booksPage{
+		Books: make([]bookSummary, 0),
+	}
+
+	// author row is counted as well so that unknown author is told apart from author without books
+	countQuery := `SELECT count(*), count(b.id)
+	               FROM public.authors a
+	               LEFT JOIN public.book_authors ba ON ba.author_id = a.id
+	               LEFT JOIN public.books b ON b.id = ba.book_id AND b.deleted_at IS NULL
+	               WHERE a.id = @id`
+	var authors int
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, countQuery, pgx.NamedArgs{"id": id}).Scan(&authors, &page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	if authors == 0 {
+		return page, authorNotFound(id)
+	}
+
+	query := `SELECT b.id, b.title, b.release_year
+	          FROM public.book_authors ba
+	          JOIN public.books b ON b.id = ba.book_id
+	          WHERE ba.author_id = @id AND b.deleted_at IS NULL
+	          ORDER BY b.id
+	          LIMIT @limit OFFSET @offset`
+	args := pgx.NamedArgs{
+		"id":     id,
+		"limit":  q.Limit,
+		"offset": q.Offset,
+	}
+
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	books, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (bookSummary, error) {
+		var b bookSummary
+		err := row.Scan(&b.ID, &b.Title, &b.ReleaseYear)
+		return b, err
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	page.Books = books
+
+	return page, nil
+}
+
+func (repo *AuthorsRepo) LinkBook(ctx context.Context, authorID, bookID int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		// rows are locked so that neither of them is deleted before the link is made
+		err := tx.QueryRow(ctx, `SELECT id FROM public.authors WHERE id = @id FOR SHARE`,
+			pgx.NamedArgs{"id": authorID}).Scan(&authorID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return authorNotFound(authorID)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(ctx, `SELECT id FROM public.books WHERE id = @id AND deleted_at IS NULL FOR SHARE`,
+			pgx.NamedArgs{"id": bookID}).Scan(&bookID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return bookNotFound(bookID)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `INSERT INTO public.book_authors (book_id, author_id) VALUES (@book_id, @author_id)
+		                       ON CONFLICT DO NOTHING`,
+			pgx.NamedArgs{"book_id": bookID, "author_id": authorID})
+		return err
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *AuthorsRepo) UnlinkBook(ctx context.Context, authorID, bookID int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	tag, err := database.PgxConn(ctx, repo.pool).Exec(ctx,
+		`DELETE FROM public.book_authors WHERE author_id = @author_id AND book_id = @book_id`,
+		pgx.NamedArgs{"book_id": bookID, "author_id": authorID})
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.NotFoundErr{Message: fmt.Sprintf("book with id %d is not linked to author with id %d", bookID, authorID)}
+	}
+
+	return nil
+}
+
+func (repo *AuthorsRepo) LinkAuthors(ctx context.Context, books map[int]string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	// every name of every book is a row of the arrays
+	bookIDs := make([]int, 0, len(books))
+	names := make([]string, 0, len(books))
+	keys := make([]string, 0, len(books))
+	linked := make([]int, 0, len(books))
+	for bookID, author := range books {
+		linked = append(linked, bookID)
+		for _, name := range splitNames(author) {
+			bookIDs = append(bookIDs, bookID)
+			names = append(names, name)
+			keys = append(keys, nameKey(name))
+		}
+	}
+	args := pgx.NamedArgs{
+		"linked":   linked,
+		"book_ids": bookIDs,
+		"names":    names,
+		"keys":     keys,
+	}
+
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		// spelling of the book with the lowest id names a new author, as authors migration does
+		_, err := tx.Exec(ctx, `INSERT INTO public.authors (name, name_key)
+		                        SELECT DISTINCT ON (n.key) n.name, n.key
+		                        FROM unnest(@book_ids::integer[], @names::text[], @keys::text[]) AS n (book_id, name, key)
+		                        ORDER BY n.key, n.book_id
+		                        ON CONFLICT (name_key) DO NOTHING`, args)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM public.book_authors WHERE book_id = ANY(@linked)`, args)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `INSERT INTO public.book_authors (book_id, author_id)
+		                       SELECT DISTINCT n.book_id, a.id
+		                       FROM unnest(@book_ids::integer[], @keys::text[]) AS n (book_id, key)
+		                       JOIN public.authors a ON a.name_key = n.key`, args)
+		return err
+	})
+
+	return database.TxErr(ctx, err)
+}
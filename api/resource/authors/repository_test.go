@@ -0,0 +1,338 @@
+package authors
+
+import (
+	"booksapi/api/database"
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// repository tests run the same scenarios against every IAuthorsRepo implementation
+// which does not need an external server
+
+var ctx = context.Background()
+
+// testBooks are live books 1 and 2 and trashed book 3
+var testBooks = []struct {
+	title   string
+	author  string
+	trashed bool
+}{
+	{title: "The Hobbit", author: "JRR Tolkien"},
+	{title: "Good Omens", author: "Terry Pratchett & Neil Gaiman"},
+	{title: "Dune", author: "Frank Herbert", trashed: true},
+}
+
+type fakeBook struct {
+	title  string
+	author string
+}
+
+// fakeBooks is BookFinder of memory repo
+type fakeBooks map[int]*fakeBook
+
+func (b fakeBooks) FindBook(_ context.Context, id int) (string, *int, bool) {
+	book, ok := b[id]
+	if !ok {
+		return "", nil, false
+	}
+	return book.title, nil, true
+}
+
+func (b fakeBooks) RenameAuthor(_ context.Context, bookIDs []int, rename func(author string) string) {
+	for _, id := range bookIDs {
+		if book, ok := b[id]; ok {
+			book.author = rename(book.author)
+		}
+	}
+}
+
+func newSQLiteStorage(t *testing.T) *database.SQLiteStorage {
+	s, err := database.OpenSQLite(filepath.Join(t.TempDir(), "books.db"))
+	if err != nil {
+		t.Fatalf("could not open sqlite database %s", err.Error())
+	}
+	t.Cleanup(s.Close)
+
+	if err := database.MigrateUp(ctx, s); err != nil {
+		t.Fatalf("could not migrate sqlite database %s", err.Error())
+	}
+
+	return s
+}
+
+func insertBook(t *testing.T, s *database.SQLiteStorage, title, author string) {
+	if _, err := s.DB.Exec(`INSERT INTO books (title, author) VALUES (?, ?)`, title, author); err != nil {
+		t.Fatalf("could not insert book %s", err.Error())
+	}
+}
+
+func testRepos(t *testing.T) map[string]IAuthorsRepo {
+	s := newSQLiteStorage(t)
+	books := fakeBooks{}
+	for i, b := range testBooks {
+		insertBook(t, s, b.title, b.author)
+		if b.trashed {
+			s.DB.Exec(`UPDATE books SET deleted_at = '2024-01-01 00:00:00.000' WHERE id = ?`, i+1)
+		} else {
+			books[i+1] = &fakeBook{title: b.title, author: b.author}
+		}
+	}
+
+	return map[string]IAuthorsRepo{
+		"memory": NewMemoryRepo(books),
+		"sqlite": &SQLiteRepo{db: s.DB},
+	}
+}
+
+func authorNames(authors []authorEntity) []string {
+	names := make([]string, 0, len(authors))
+	for _, a := range authors {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+func TestRepoAuthors(t *testing.T) {
+	var conflict database.ConflictErr
+	var nf database.NotFoundErr
+
+	for name, repo := range testRepos(t) {
+		tolkien, err := repo.AddAuthor(ctx, "J.R.R. Tolkien")
+		if err != nil {
+			t.Fatalf("%s AddAuthor failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := repo.AddAuthor(ctx, "jrr tolkien"); !errors.As(err, &conflict) {
+			t.Errorf("%s AddAuthor expected ConflictErr for another spelling\ngot %v", name, err)
+		}
+		pratchett, _ := repo.AddAuthor(ctx, "Terry Pratchett")
+		repo.AddAuthor(ctx, "Neil Gaiman")
+
+		page, err := repo.GetAuthors(ctx, authorsQuery{Limit: 10})
+		if err != nil || page.Total != 3 ||
+			!slices.Equal(authorNames(page.Authors), []string{"J.R.R. Tolkien", "Neil Gaiman", "Terry Pratchett"}) {
+			t.Errorf("%s GetAuthors failed\ngot %+v, %v", name, page, err)
+		}
+		page, _ = repo.GetAuthors(ctx, authorsQuery{Name: strptr("JRR  Tolkien"), Limit: 10})
+		if page.Total != 1 || page.Authors[0].ID != tolkien {
+			t.Errorf("%s GetAuthors by name failed\ngot %+v", name, page)
+		}
+		page, _ = repo.GetAuthors(ctx, authorsQuery{Limit: 1, Offset: 2})
+		if page.Total != 3 || !slices.Equal(authorNames(page.Authors), []string{"Terry Pratchett"}) {
+			t.Errorf("%s GetAuthors paging failed\ngot %+v", name, page)
+		}
+
+		if err := repo.UpdateAuthor(ctx, pratchett, "J. R. R. Tolkien"); !errors.As(err, &conflict) {
+			t.Errorf("%s UpdateAuthor expected ConflictErr\ngot %v", name, err)
+		}
+		if err := repo.UpdateAuthor(ctx, tolkien, "John Ronald Reuel Tolkien"); err != nil {
+			t.Errorf("%s UpdateAuthor failed\nunexpected error %s", name, err.Error())
+		}
+		if a, err := repo.GetAuthorById(ctx, tolkien); err != nil || a.Name != "John Ronald Reuel Tolkien" {
+			t.Errorf("%s GetAuthorById failed\ngot %+v, %v", name, a, err)
+		}
+		if err := repo.UpdateAuthor(ctx, 42, "Nobody"); !errors.As(err, &nf) {
+			t.Errorf("%s UpdateAuthor expected NotFoundErr\ngot %v", name, err)
+		}
+		if _, err := repo.GetAuthorById(ctx, 42); !errors.As(err, &nf) {
+			t.Errorf("%s GetAuthorById expected NotFoundErr\ngot %v", name, err)
+		}
+	}
+}
+
+func TestRepoAuthorBooks(t *testing.T) {
+	var conflict database.ConflictErr
+	var nf database.NotFoundErr
+
+	for name, repo := range testRepos(t) {
+		id, _ := repo.AddAuthor(ctx, "Terry Pratchett")
+
+		for range 2 {
+			if err := repo.LinkBook(ctx, id, 2); err != nil {
+				t.Fatalf("%s LinkBook failed\nunexpected error %s", name, err.Error())
+			}
+		}
+		if err := repo.LinkBook(ctx, id, 1); err != nil {
+			t.Fatalf("%s LinkBook failed\nunexpected error %s", name, err.Error())
+		}
+		if err := repo.LinkBook(ctx, id, 3); !errors.As(err, &nf) {
+			t.Errorf("%s LinkBook expected NotFoundErr for trashed book\ngot %v", name, err)
+		}
+		if err := repo.LinkBook(ctx, 42, 1); !errors.As(err, &nf) {
+			t.Errorf("%s LinkBook expected NotFoundErr for unknown author\ngot %v", name, err)
+		}
+
+		page, err := repo.GetAuthorBooks(ctx, id, pageQuery{Limit: 10})
+		if err != nil || page.Total != 2 || page.Books[0].Title != "The Hobbit" || page.Books[1].Title != "Good Omens" {
+			t.Errorf("%s GetAuthorBooks failed\ngot %+v, %v", name, page, err)
+		}
+		page, _ = repo.GetAuthorBooks(ctx, id, pageQuery{Limit: 1, Offset: 1})
+		if page.Total != 2 || len(page.Books) != 1 || page.Books[0].ID != 2 {
+			t.Errorf("%s GetAuthorBooks paging failed\ngot %+v", name, page)
+		}
+		if _, err := repo.GetAuthorBooks(ctx, 42, pageQuery{Limit: 10}); !errors.As(err, &nf) {
+			t.Errorf("%s GetAuthorBooks expected NotFoundErr\ngot %v", name, err)
+		}
+
+		if err := repo.RemoveAuthor(ctx, id); !errors.As(err, &conflict) {
+			t.Errorf("%s RemoveAuthor expected ConflictErr while books are linked\ngot %v", name, err)
+		}
+		repo.UnlinkBook(ctx, id, 1)
+		if err := repo.UnlinkBook(ctx, id, 2); err != nil {
+			t.Errorf("%s UnlinkBook failed\nunexpected error %s", name, err.Error())
+		}
+		if err := repo.UnlinkBook(ctx, id, 2); !errors.As(err, &nf) {
+			t.Errorf("%s UnlinkBook expected NotFoundErr for unlinked book\ngot %v", name, err)
+		}
+		if page, _ := repo.GetAuthorBooks(ctx, id, pageQuery{Limit: 10}); page.Total != 0 {
+			t.Errorf("%s GetAuthorBooks expected no books\ngot %+v", name, page)
+		}
+		if err := repo.RemoveAuthor(ctx, id); err != nil {
+			t.Errorf("%s RemoveAuthor failed\nunexpected error %s", name, err.Error())
+		}
+		if err := repo.RemoveAuthor(ctx, id); !errors.As(err, &nf) {
+			t.Errorf("%s RemoveAuthor expected NotFoundErr\ngot %v", name, err)
+		}
+	}
+}
+
+func TestRepoLinkAuthors(t *testing.T) {
+	s := newSQLiteStorage(t)
+	books := fakeBooks{}
+	for i, b := range testBooks {
+		insertBook(t, s, b.title, b.author)
+		books[i+1] = &fakeBook{title: b.title, author: b.author}
+	}
+	repos := map[string]IAuthorsRepo{
+		"memory": NewMemoryRepo(books),
+		"sqlite": &SQLiteRepo{db: s.DB},
+	}
+	authorOf := map[string]func(id int) string{
+		"memory": func(id int) string { return books[id].author },
+		"sqlite": func(id int) string {
+			var author string
+			s.DB.QueryRow(`SELECT author FROM books WHERE id = ?`, id).Scan(&author)
+			return author
+		},
+	}
+
+	for name, repo := range repos {
+		var conflict database.ConflictErr
+
+		pratchett, _ := repo.AddAuthor(ctx, "Terry Pratchett")
+		err := repo.LinkAuthors(ctx, map[int]string{1: "J.R.R. Tolkien", 2: "Terry  Pratchett and Neil Gaiman; terry pratchett"})
+		if err != nil {
+			t.Fatalf("%s LinkAuthors failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := repo.AddAuthor(ctx, "JRR Tolkien"); !errors.As(err, &conflict) {
+			t.Errorf("%s LinkAuthors must add missing authors\ngot %v", name, err)
+		}
+		page, _ := repo.GetAuthors(ctx, authorsQuery{Name: strptr("Neil Gaiman"), Limit: 10})
+		if page.Total != 1 {
+			t.Fatalf("%s LinkAuthors must add Neil Gaiman\ngot %+v", name, page)
+		}
+		gaiman := page.Authors[0].ID
+		if books, _ := repo.GetAuthorBooks(ctx, pratchett, pageQuery{Limit: 10}); books.Total != 1 || books.Books[0].ID != 2 {
+			t.Errorf("%s LinkAuthors must link existing author\ngot %+v", name, books)
+		}
+
+		// links follow the author string
+		if err := repo.LinkAuthors(ctx, map[int]string{2: "Neil Gaiman & Terry Pratchett"}); err != nil {
+			t.Fatalf("%s LinkAuthors failed\nunexpected error %s", name, err.Error())
+		}
+		if err := repo.LinkAuthors(ctx, map[int]string{2: "Neil Gaiman"}); err != nil {
+			t.Fatalf("%s LinkAuthors failed\nunexpected error %s", name, err.Error())
+		}
+		if books, _ := repo.GetAuthorBooks(ctx, pratchett, pageQuery{Limit: 10}); books.Total != 0 {
+			t.Errorf("%s LinkAuthors must unlink authors no longer named\ngot %+v", name, books)
+		}
+
+		if err := repo.LinkAuthors(ctx, map[int]string{2: "Terry Pratchett & neil gaiman"}); err != nil {
+			t.Fatalf("%s LinkAuthors failed\nunexpected error %s", name, err.Error())
+		}
+		if err := repo.UpdateAuthor(ctx, gaiman, "Neil Richard Gaiman"); err != nil {
+			t.Fatalf("%s UpdateAuthor failed\nunexpected error %s", name, err.Error())
+		}
+		if got := authorOf[name](2); got != "Terry Pratchett & Neil Richard Gaiman" {
+			t.Errorf("%s UpdateAuthor must rename the author in books\ngot %q", name, got)
+		}
+		if got := authorOf[name](1); got != "JRR Tolkien" {
+			t.Errorf("%s UpdateAuthor must leave books of other authors\ngot %q", name, got)
+		}
+	}
+
+	// memory books write their own audit entries, see renameAuthor in books package
+	var changes, actor string
+	err := s.DB.QueryRow(`SELECT changes, actor FROM book_audit WHERE book_id = 2`).Scan(&changes, &actor)
+	expected := `{"author":{"before":"Terry Pratchett & Neil Gaiman","after":"Terry Pratchett & Neil Richard Gaiman"}}`
+	if err != nil || changes != expected || actor != "system" {
+		t.Errorf("sqlite UpdateAuthor must audit renamed books\nexpected %s\ngot %s by %q, %v", expected, changes, actor, err)
+	}
+}
+
+// migrateDownTo reverts every applied migration newer than version
+func migrateDownTo(t *testing.T, s *database.SQLiteStorage, version int) {
+	status, err := database.GetMigrationStatus(ctx, s)
+	if err != nil {
+		t.Fatalf("could not read migration status %s", err.Error())
+	}
+	steps := 0
+	for _, m := range status {
+		if m.Version > version && m.AppliedAt != nil {
+			steps++
+		}
+	}
+	if err := database.MigrateDown(ctx, s, steps); err != nil {
+		t.Fatalf("could not revert migrations %s", err.Error())
+	}
+}
+
+func TestAuthorsMigration(t *testing.T) {
+	s := newSQLiteStorage(t)
+	// 0005 is the last migration before authors
+	migrateDownTo(t, s, 5)
+	for _, author := range []string{"J.R.R. Tolkien", "JRR Tolkien", "Terry Pratchett & Neil Gaiman",
+		"Neil Gaiman and Terry Pratchett", "Stanislaw Lem", "Larry Niven; Jerry Pournelle",
+		"J.R.R.\u00a0Tolkien", "Stanislaw\tLem, -"} {
+		insertBook(t, s, "title", author)
+	}
+	if err := database.MigrateUp(ctx, s); err != nil {
+		t.Fatalf("could not migrate sqlite database %s", err.Error())
+	}
+
+	repo := &SQLiteRepo{db: s.DB}
+	page, err := repo.GetAuthors(ctx, authorsQuery{Limit: 10})
+	expected := []string{"Jerry Pournelle", "J.R.R. Tolkien", "Larry Niven", "Neil Gaiman", "Stanislaw Lem",
+		"Terry Pratchett"}
+	if err != nil || !slices.Equal(authorNames(page.Authors), expected) {
+		t.Fatalf("authors migration failed\nexpected %v\ngot %v, %v", expected, authorNames(page.Authors), err)
+	}
+
+	tcases := []struct {
+		author string
+		books  []int
+	}{
+		{"jrr tolkien", []int{1, 2, 7}},
+		{"Neil Gaiman", []int{3, 4}},
+		{"Terry Pratchett", []int{3, 4}},
+		{"Jerry Pournelle", []int{6}},
+		{"Stanislaw Lem", []int{5, 8}},
+	}
+	for _, tc := range tcases {
+		authors, _ := repo.GetAuthors(ctx, authorsQuery{Name: &tc.author, Limit: 1})
+		books, _ := repo.GetAuthorBooks(ctx, authors.Authors[0].ID, pageQuery{Limit: 10})
+		ids := make([]int, 0)
+		for _, b := range books.Books {
+			ids = append(ids, b.ID)
+		}
+		if !slices.Equal(ids, tc.books) {
+			t.Errorf("authors migration failed\nexpected %s to have books %v\ngot %v", tc.author, tc.books, ids)
+		}
+	}
+}
+
+func strptr(s string) *string {
+	return &s
+}
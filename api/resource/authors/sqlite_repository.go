@@ -0,0 +1,349 @@
+package authors
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/router/middlewares"
+	"booksapi/logger"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SQLiteRepo keeps authors in sqlite database
+type SQLiteRepo struct {
+	db *sql.DB
+}
+
+func (repo *SQLiteRepo) GetAuthors(ctx context.Context, q authorsQuery) (authorsPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := authorsPage{
+		Authors: make([]authorEntity, 0),
+	}
+
+	where := ``
+	args := []any{
+		sql.Named("limit", q.Limit),
+		sql.Named("offset", q.Offset),
+	}
+	if q.Name != nil {
+		where = `WHERE name_key = @name_key`
+		args = append(args, sql.Named("name_key", nameKey(*q.Name)))
+	}
+
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT count(*) FROM authors `+where, args...).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	query := `SELECT id, name FROM authors ` + where + ` ORDER BY name_key, id LIMIT @limit OFFSET @offset`
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a authorEntity
+		if err := rows.Scan(&a.ID, &a.Name); err != nil {
+			logger.Error(err.Error())
+			return page, database.RepoErr(ctx, err)
+		}
+		page.Authors = append(page.Authors, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *SQLiteRepo) GetAuthorById(ctx context.Context, id int) (authorEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var a authorEntity
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT id, name FROM authors WHERE id = @id`, sql.Named("id", id)).
+		Scan(&a.ID, &a.Name)
+	if err != nil {
+		logger.Error(err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return a, authorNotFound(id)
+		}
+		return a, database.RepoErr(ctx, err)
+	}
+
+	return a, nil
+}
+
+func (repo *SQLiteRepo) AddAuthor(ctx context.Context, name string) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO authors (name, name_key) VALUES (@name, @name_key) RETURNING id`
+
+	var id int
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, query, sql.Named("name", name), sql.Named("name_key", nameKey(name))).
+		Scan(&id)
+	if err != nil {
+		logger.Error(err.Error())
+		if database.IsSQLiteUniqueViolation(err) {
+			return 0, nameTaken(name)
+		}
+		return 0, database.RepoErr(ctx, err)
+	}
+
+	return id, nil
+}
+
+func (repo *SQLiteRepo) UpdateAuthor(ctx context.Context, id int, name string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		var oldName string
+		err := tx.QueryRowContext(ctx, `SELECT name FROM authors WHERE id = @id`, sql.Named("id", id)).Scan(&oldName)
+		if errors.Is(err, sql.ErrNoRows) {
+			return authorNotFound(id)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE authors SET name = @name, name_key = @name_key WHERE id = @id`,
+			sql.Named("id", id), sql.Named("name", name), sql.Named("name_key", nameKey(name)))
+		if database.IsSQLiteUniqueViolation(err) {
+			return nameTaken(name)
+		}
+		if err != nil {
+			return err
+		}
+
+		books, err := repo.authorBooks(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		// every renamed book gets an audit entry like a book update
+		actor, requestID := middlewares.AuditActor(ctx), middlewares.RequestIDFromContext(ctx)
+		for _, b := range books {
+			author := renameIn(b.Author, oldName, name)
+			if author == b.Author {
+				continue
+			}
+			_, err := tx.ExecContext(ctx, `UPDATE books SET author = @author, version = version + 1 WHERE id = @id`,
+				sql.Named("id", b.ID), sql.Named("author", author))
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO book_audit (book_id, action, changes, actor, request_id)
+			                              VALUES (@id, 'update', json_object('author', json_object('before', @before, 'after', @author)),
+			                                      @actor, @request_id)`,
+				sql.Named("id", b.ID), sql.Named("before", b.Author), sql.Named("author", author),
+				sql.Named("actor", actor), sql.Named("request_id", requestID))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+// authorBooks returns author strings of books linked to the author, trashed books included
+func (repo *SQLiteRepo) authorBooks(ctx context.Context, tx *sql.Tx, id int) ([]bookAuthor, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT b.id, b.author
+	                                   FROM books b
+	                                   JOIN book_authors ba ON ba.book_id = b.id
+	                                   WHERE ba.author_id = @id
+	                                   ORDER BY b.id`, sql.Named("id", id))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := make([]bookAuthor, 0)
+	for rows.Next() {
+		var b bookAuthor
+		if err := rows.Scan(&b.ID, &b.Author); err != nil {
+			return nil, err
+		}
+		books = append(books, b)
+	}
+	return books, rows.Err()
+}
+
+func (repo *SQLiteRepo) RemoveAuthor(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	// transaction holds the database lock, so no link can be made between the check and delete
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		var linked bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM book_authors WHERE author_id = @id)`,
+			sql.Named("id", id)).Scan(&linked)
+		if err != nil {
+			return err
+		}
+		if linked {
+			return authorHasBooks(id)
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM authors WHERE id = @id`, sql.Named("id", id))
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return authorNotFound(id)
+		}
+		return nil
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) GetAuthorBooks(ctx context.Context, id int, q pageQuery) (booksPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := booksPage{
+		Books: make([]bookSummary, 0),
+	}
+
+	// author row is counted as well so that unknown author is told apart from author without books
+	countQuery := `SELECT count(*), count(b.id)
+	               FROM authors a
+	               LEFT JOIN book_authors ba ON ba.author_id = a.id
+	               LEFT JOIN books b ON b.id = ba.book_id AND b.deleted_at IS NULL
+	               WHERE a.id = @id`
+	var authors int
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, countQuery, sql.Named("id", id)).Scan(&authors, &page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	if authors == 0 {
+		return page, authorNotFound(id)
+	}
+
+	query := `SELECT b.id, b.title, b.release_year
+	          FROM book_authors ba
+	          JOIN books b ON b.id = ba.book_id
+	          WHERE ba.author_id = @id AND b.deleted_at IS NULL
+	          ORDER BY b.id
+	          LIMIT @limit OFFSET @offset`
+
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query,
+		sql.Named("id", id), sql.Named("limit", q.Limit), sql.Named("offset", q.Offset))
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b bookSummary
+		if err := rows.Scan(&b.ID, &b.Title, &b.ReleaseYear); err != nil {
+			logger.Error(err.Error())
+			return page, database.RepoErr(ctx, err)
+		}
+		page.Books = append(page.Books, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *SQLiteRepo) LinkBook(ctx context.Context, authorID, bookID int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		var found bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM authors WHERE id = @id)`,
+			sql.Named("id", authorID)).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return authorNotFound(authorID)
+		}
+
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM books WHERE id = @id AND deleted_at IS NULL)`,
+			sql.Named("id", bookID)).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return bookNotFound(bookID)
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO book_authors (book_id, author_id) VALUES (@book_id, @author_id)
+		                              ON CONFLICT DO NOTHING`,
+			sql.Named("book_id", bookID), sql.Named("author_id", authorID))
+		return err
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) UnlinkBook(ctx context.Context, authorID, bookID int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := database.SQLConn(ctx, repo.db).ExecContext(ctx,
+		`DELETE FROM book_authors WHERE author_id = @author_id AND book_id = @book_id`,
+		sql.Named("book_id", bookID), sql.Named("author_id", authorID))
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return database.NotFoundErr{Message: fmt.Sprintf("book with id %d is not linked to author with id %d", bookID, authorID)}
+	}
+
+	return nil
+}
+
+func (repo *SQLiteRepo) LinkAuthors(ctx context.Context, books map[int]string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		for bookID, author := range books {
+			_, err := tx.ExecContext(ctx, `DELETE FROM book_authors WHERE book_id = @book_id`, sql.Named("book_id", bookID))
+			if err != nil {
+				return err
+			}
+
+			for _, name := range splitNames(author) {
+				key := sql.Named("name_key", nameKey(name))
+				_, err := tx.ExecContext(ctx, `INSERT INTO authors (name, name_key) VALUES (@name, @name_key)
+				                               ON CONFLICT (name_key) DO NOTHING`, sql.Named("name", name), key)
+				if err != nil {
+					return err
+				}
+
+				_, err = tx.ExecContext(ctx, `INSERT INTO book_authors (book_id, author_id)
+				                              SELECT @book_id, id FROM authors WHERE name_key = @name_key`,
+					sql.Named("book_id", bookID), key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+
+	return database.TxErr(ctx, err)
+}
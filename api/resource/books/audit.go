@@ -0,0 +1,81 @@
+package books
+
+import (
+	"booksapi/api/router/middlewares"
+	"context"
+	"encoding/json"
+)
+
+const (
+	auditCreate  = "create"
+	auditUpdate  = "update"
+	auditDelete  = "delete"
+	auditRestore = "restore"
+
+	// systemActor is recorded for changes made outside of a request
+	systemActor = middlewares.SystemActor
+)
+
+type auditChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// auditSnapshot holds JSON values of audited book fields, keys follow bookDTO
+func auditSnapshot(b *bookEntity) map[string]json.RawMessage {
+	result := map[string]json.RawMessage{}
+	if b == nil {
+		return result
+	}
+
+	fields := map[string]any{
+		"title":          b.Title,
+		"author":         b.Author,
+		"genre":          b.Genre,
+		"genreId":        b.GenreID,
+		"isbn":           b.ISBN,
+		"numberOfPages":  b.NumberOfPages,
+		"price":          b.Price,
+		"releaseYear":    b.ReleaseYear,
+		"seriesId":       b.SeriesID,
+		"seriesPosition": b.SeriesPosition,
+		"deletedAt":      b.DeletedAt,
+	}
+	for name, value := range fields {
+		result[name], _ = json.Marshal(value)
+	}
+	return result
+}
+
+// bookChanges diffs two states of a book, before is nil for created book
+func bookChanges(before, after *bookEntity) []byte {
+	was, now := auditSnapshot(before), auditSnapshot(after)
+
+	changes := map[string]auditChange{}
+	for name, value := range now {
+		prev, ok := was[name]
+		if !ok {
+			prev = json.RawMessage("null")
+		}
+		if string(prev) != string(value) {
+			changes[name] = auditChange{Before: prev, After: value}
+		}
+	}
+
+	j, _ := json.Marshal(changes)
+	return j
+}
+
+// newAudit describes change of a book made by request ctx belongs to
+func newAudit(ctx context.Context, action string, before, after *bookEntity) auditEntry {
+	e := auditEntry{
+		Action:    action,
+		Changes:   bookChanges(before, after),
+		Actor:     middlewares.AuditActor(ctx),
+		RequestID: middlewares.RequestIDFromContext(ctx),
+	}
+	if after != nil {
+		e.BookID = after.ID
+	}
+	return e
+}
@@ -0,0 +1,24 @@
+package books
+
+import "context"
+
+// AuthorLinker links books to authors kept by authors package. Authors named in author string of a book
+// are found by their name key and added when they don't exist yet
+type AuthorLinker interface {
+	// LinkAuthors makes authors named by author strings of books by id their only authors,
+	// called with ctx of a book write it joins the transaction of the write
+	LinkAuthors(ctx context.Context, books map[int]string) error
+}
+
+// linkAuthors links written books to authors their author strings name, books are not linked while authors is nil
+func linkAuthors(ctx context.Context, authors AuthorLinker, books ...bookEntity) error {
+	if authors == nil || len(books) == 0 {
+		return nil
+	}
+
+	names := make(map[int]string, len(books))
+	for _, b := range books {
+		names[b.ID] = b.Author
+	}
+	return authors.LinkAuthors(ctx, names)
+}
@@ -0,0 +1,121 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxBatchSize keeps a batch small enough to run as a single unit of work
+const maxBatchSize = 100
+
+// errBatchAborted rolls back atomic batch after one of its operations failed,
+// result of that operation tells why
+var errBatchAborted = errors.New("batch aborted")
+
+func batchFailure(status int, message string) batchResultDTO {
+	return batchResultDTO{
+		Status: status,
+		Error:  &database.APIError{Status: status, Message: message},
+	}
+}
+
+func batchRepoFailure(err error) batchResultDTO {
+	return batchFailure(database.ErrStatus(err), err.Error())
+}
+
+// runBatchOperation applies operation with the same checks and status codes
+// as the single book endpoint doing the same change
+func (api API) runBatchOperation(ctx context.Context, op batchOperation) batchResultDTO {
+	if op.Op == batchCreate {
+		if err := op.Book.validateNew(); err != nil {
+			return batchRepoFailure(err)
+		}
+		id, err := api.repo.AddBook(ctx, op.Book)
+		if err != nil {
+			return batchRepoFailure(err)
+		}
+		return batchResultDTO{Status: http.StatusCreated, Result: &ActionResponse{ResourceId: id}}
+	}
+
+	if op.Op != batchPatch && op.Op != batchDelete {
+		return batchFailure(http.StatusBadRequest,
+			fmt.Sprintf("unknown operation %q, expected %s, %s or %s", op.Op, batchCreate, batchPatch, batchDelete))
+	}
+	if op.ID < 1 {
+		return batchFailure(http.StatusBadRequest, fmt.Sprintf("%s operation needs positive id", op.Op))
+	}
+	ifMatch, err := ifMatchVersion(op.IfMatch)
+	if err != nil {
+		return batchFailure(http.StatusBadRequest, err.Error())
+	}
+
+	if op.Op == batchPatch {
+		if err := op.Book.validate(); err != nil {
+			return batchRepoFailure(err)
+		}
+		_, err = api.repo.UpdateBook(ctx, op.ID, op.Book, ifMatch)
+	} else {
+		err = api.repo.RemoveBook(ctx, op.ID, ifMatch)
+	}
+	if err != nil {
+		return batchRepoFailure(err)
+	}
+	return batchResultDTO{Status: http.StatusNoContent, Result: &ActionResponse{ResourceId: op.ID}}
+}
+
+// runOwnBatchOperation runs operation in a unit of work of its own which is rolled back when the operation fails
+func (api API) runOwnBatchOperation(ctx context.Context, op batchOperation) batchResultDTO {
+	var result batchResultDTO
+	err := api.inTx(ctx, func(ctx context.Context) error {
+		result = api.runBatchOperation(ctx, op)
+		if result.Error != nil {
+			return errBatchAborted
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchAborted) {
+		return batchRepoFailure(database.TxErr(ctx, err))
+	}
+	return result
+}
+
+// runAtomicBatch runs operations in one unit of work which is rolled back at the first failure,
+// results of the other operations then tell they were undone or skipped because of it
+func (api API) runAtomicBatch(ctx context.Context, ops []batchOperation) ([]batchResultDTO, error) {
+	results := make([]batchResultDTO, len(ops))
+	failed := -1
+
+	err := api.inTx(ctx, func(ctx context.Context) error {
+		// postgres may run the unit of work again after serialization failure
+		failed = -1
+		for i, op := range ops {
+			results[i] = api.runBatchOperation(ctx, op)
+			if results[i].Error != nil {
+				failed = i
+				return errBatchAborted
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchAborted) {
+		return nil, database.TxErr(ctx, err)
+	}
+
+	if failed >= 0 {
+		for i := range results {
+			switch {
+			case i < failed:
+				results[i] = batchFailure(http.StatusFailedDependency,
+					fmt.Sprintf("rolled back because operation %d failed", failed))
+			case i > failed:
+				results[i] = batchFailure(http.StatusFailedDependency,
+					fmt.Sprintf("not run because operation %d failed", failed))
+			}
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,126 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/money"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// editionFormats are formats an edition may be published in
+var editionFormats = []string{"hardcover", "paperback", "ebook", "audiobook"}
+
+// PublisherFinder looks up publishers kept by publishers package for memory repo, databases check publishers table instead
+type PublisherFinder interface {
+	FindPublisher(ctx context.Context, id int) (name string, ok bool)
+}
+
+func editionNotFound(id int) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("edition with id %d not found", id)}
+}
+
+func publisherNotFound(id int) database.BadRequestErr {
+	return database.BadRequestErr{Message: fmt.Sprintf("publisher with id %d not found", id)}
+}
+
+// validate checks format, isbn, price and release date of fields which are set,
+// format is lowercased and isbn brought to its ISBN-13 form
+func (b *editionRequestBody) validate() error {
+	if b.ISBN != nil {
+		isbn, err := normalizeISBN(*b.ISBN)
+		if err != nil {
+			return err
+		}
+		b.ISBN = &isbn
+	}
+	if err := validatePrice(b.Price); err != nil {
+		return err
+	}
+	if b.Format != nil {
+		format := strings.ToLower(strings.TrimSpace(*b.Format))
+		if !slices.Contains(editionFormats, format) {
+			return database.BadRequestErr{Message: fmt.Sprintf("format must be one of %s", strings.Join(editionFormats, ", "))}
+		}
+		b.Format = &format
+	}
+	if b.ReleaseDate != nil {
+		if _, err := time.Parse(time.DateOnly, *b.ReleaseDate); err != nil {
+			return database.BadRequestErr{Message: "releaseDate must be a date like 2006-01-02"}
+		}
+	}
+	return nil
+}
+
+// applyTo returns copy of e with every field set in validated request body replaced
+func (b editionRequestBody) applyTo(e editionEntity) editionEntity {
+	if b.PublisherID != nil {
+		e.PublisherID = b.PublisherID
+	}
+	if b.Format != nil {
+		e.Format = b.Format
+	}
+	if b.ISBN != nil {
+		e.ISBN = b.ISBN
+	}
+	if b.NumberOfPages != nil {
+		e.NumberOfPages = b.NumberOfPages
+	}
+	if b.Price != nil {
+		e.Price = parsedPrice(b.Price)
+	}
+	if b.ReleaseDate != nil {
+		date, _ := time.Parse(time.DateOnly, *b.ReleaseDate)
+		e.ReleaseDate = &date
+	}
+	return e
+}
+
+// touchesEdition tells whether saving the request body changes fields books copy from their first edition
+func (b bookRequestBody) touchesEdition() bool {
+	return b.ISBN != nil || b.NumberOfPages != nil || b.Price != nil
+}
+
+// copyFirstEdition returns copy of b with fields of its first edition, first is nil when the book has none
+func (b bookEntity) copyFirstEdition(first *editionEntity) bookEntity {
+	b.ISBN, b.NumberOfPages, b.Price = nil, nil, nil
+	if first != nil {
+		b.ISBN, b.NumberOfPages, b.Price = first.ISBN, first.NumberOfPages, first.Price
+	}
+	return b
+}
+
+// sameEditionFields tells whether books agree on fields they copy from their first edition
+func sameEditionFields(a, b bookEntity) bool {
+	equal := func(x, y *int) bool {
+		return x == nil && y == nil || x != nil && y != nil && *x == *y
+	}
+	sameISBN := a.ISBN == nil && b.ISBN == nil || a.ISBN != nil && b.ISBN != nil && *a.ISBN == *b.ISBN
+	return sameISBN && equal(a.NumberOfPages, b.NumberOfPages) && samePrice(a.Price, b.Price)
+}
+
+// editionColumns are selected in order of editionFields
+const editionColumns = `id, book_id, publisher_id, format, isbn, number_of_pages, price, currency, release_date`
+
+// editionFields returns scan destinations for editionColumns
+func editionFields(e *editionEntity) []any {
+	price, currency := money.ScanFields(&e.Price)
+	return []any{&e.ID, &e.BookID, &e.PublisherID, &e.Format, &e.ISBN, &e.NumberOfPages, price, currency, &e.ReleaseDate}
+}
+
+// editionArgs holds values of edition columns, release date is left to the repository
+// since sqlite keeps it as text
+func editionArgs(e editionEntity) map[string]any {
+	return map[string]any{
+		"id":              e.ID,
+		"book_id":         e.BookID,
+		"publisher_id":    e.PublisherID,
+		"format":          e.Format,
+		"isbn":            e.ISBN,
+		"number_of_pages": e.NumberOfPages,
+		"price":           priceAmount(e.Price),
+		"currency":        priceCurrency(e.Price),
+	}
+}
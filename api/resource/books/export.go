@@ -0,0 +1,134 @@
+package books
+
+import (
+	"booksapi/api/money"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// exportBufferSize is how much of an export is held in memory before it is written to the client
+const exportBufferSize = 32 << 10
+
+// exportColumns are written in the header row of delimited exports, they follow csv import columns
+var exportColumns = []string{"id", "title", "author", "genre", "numberOfPages", "price", "currency", "releaseYear"}
+
+// exportWriter writes books one by one, nothing reaches underlying writer before Flush
+// or before its buffer fills up
+type exportWriter interface {
+	WriteBook(b bookEntity) error
+	Flush() error
+}
+
+type exportFormat struct {
+	MediaType string
+	Extension string
+	newWriter func(w io.Writer) exportWriter
+}
+
+// exportFormats maps format query parameter values of GET /api/books/export to formats
+var exportFormats = map[string]exportFormat{
+	"csv": {
+		MediaType: "text/csv; charset=utf-8",
+		Extension: "csv",
+		newWriter: func(w io.Writer) exportWriter { return newDelimitedWriter(w, ',') },
+	},
+	"tsv": {
+		MediaType: "text/tab-separated-values; charset=utf-8",
+		Extension: "tsv",
+		newWriter: func(w io.Writer) exportWriter { return newDelimitedWriter(w, '\t') },
+	},
+	"ndjson": {
+		MediaType: "application/x-ndjson",
+		Extension: "ndjson",
+		newWriter: newNDJSONWriter,
+	},
+}
+
+func exportFormatNames() []string {
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// delimitedWriter writes header row before the first book
+type delimitedWriter struct {
+	csv    *csv.Writer
+	header bool
+}
+
+func newDelimitedWriter(w io.Writer, delimiter rune) *delimitedWriter {
+	writer := csv.NewWriter(bufio.NewWriterSize(w, exportBufferSize))
+	writer.Comma = delimiter
+	return &delimitedWriter{csv: writer}
+}
+
+func formatInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+// formatPrice returns price amount and currency columns
+func formatPrice(m *money.Money) (string, string) {
+	if m == nil {
+		return "", ""
+	}
+	return m.Decimal(), m.Currency
+}
+
+func (w *delimitedWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+	return w.csv.Write(exportColumns)
+}
+
+func (w *delimitedWriter) WriteBook(b bookEntity) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	price, currency := formatPrice(b.Price)
+	return w.csv.Write([]string{
+		strconv.Itoa(b.ID), b.Title, b.Author, b.Genre,
+		formatInt(b.NumberOfPages), price, currency, formatInt(b.ReleaseYear),
+	})
+}
+
+// Flush writes header of an empty export too, so clients always get the columns
+func (w *delimitedWriter) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+type ndjsonWriter struct {
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) exportWriter {
+	buf := bufio.NewWriterSize(w, exportBufferSize)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	return &ndjsonWriter{buf: buf, encoder: encoder}
+}
+
+// WriteBook writes the book as a line, Encode terminates every value with a newline
+func (w *ndjsonWriter) WriteBook(b bookEntity) error {
+	return w.encoder.Encode(b.ToDto())
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return w.buf.Flush()
+}
@@ -0,0 +1,88 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// GenreTree gives memory repo genres kept by genres package, databases join genres table instead
+type GenreTree interface {
+	// GenreNames maps id of every genre to its name
+	GenreNames(ctx context.Context) map[int]string
+	// Subgenres returns ids of the genre of given name, case insensitive, and of every genre below it
+	Subgenres(ctx context.Context, name string) []int
+}
+
+// genreIndex finds genres books link to by id and by case insensitive name
+type genreIndex struct {
+	names map[int]string
+	ids   map[string]int
+}
+
+func newGenreIndex(names map[int]string) genreIndex {
+	genres := genreIndex{
+		names: names,
+		ids:   make(map[string]int, len(names)),
+	}
+	for id, name := range names {
+		genres.ids[strings.ToLower(name)] = id
+	}
+	return genres
+}
+
+// touchesGenre tells whether saving the request body changes genre of the book
+func (b bookRequestBody) touchesGenre() bool {
+	return b.Genre != nil || b.GenreID != nil
+}
+
+// genreRefs returns ids and lower case names of genres books refer to, the genres to look up before linking them
+func genreRefs(books ...bookRequestBody) ([]int, []string) {
+	ids, names := make([]int, 0), make([]string, 0)
+	for _, b := range books {
+		if b.GenreID != nil {
+			ids = append(ids, *b.GenreID)
+		}
+		if b.Genre != nil && *b.Genre != "" {
+			names = append(names, strings.ToLower(*b.Genre))
+		}
+	}
+	return ids, names
+}
+
+// linkGenre makes genre and genreId of request body agree. GenreID picks the genre and its name
+// becomes the genre, genre alone links the book to the genre of that name and empty genre unlinks the book.
+// Request body which doesn't touch genre is returned as it is
+func (b bookRequestBody) linkGenre(genres genreIndex) (bookRequestBody, error) {
+	switch {
+	case b.GenreID != nil:
+		name, ok := genres.names[*b.GenreID]
+		if !ok {
+			return b, database.BadRequestErr{Message: fmt.Sprintf("genre with id %d not found", *b.GenreID)}
+		}
+		if b.Genre != nil && !strings.EqualFold(*b.Genre, name) {
+			return b, database.BadRequestErr{Message: fmt.Sprintf("genre %q is not the name of genre with id %d", *b.Genre, *b.GenreID)}
+		}
+		b.Genre = &name
+	case b.Genre != nil:
+		if *b.Genre == "" {
+			break
+		}
+		id, ok := genres.ids[strings.ToLower(*b.Genre)]
+		if !ok {
+			return b, database.BadRequestErr{Message: fmt.Sprintf("genre %q not found", *b.Genre)}
+		}
+		name := genres.names[id]
+		b.Genre, b.GenreID = &name, &id
+	}
+	return b, nil
+}
+
+// subgenresClause selects ids of the genre named @genre and of every genre below it
+const subgenresClause = `WITH RECURSIVE subgenres (id) AS (
+                             SELECT id FROM genres WHERE lower(name) = lower(@genre)
+                             UNION ALL
+                             SELECT g.id FROM genres g JOIN subgenres s ON g.parent_id = s.id
+                         )
+                         SELECT id FROM subgenres`
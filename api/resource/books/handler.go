@@ -1,70 +1,329 @@
 package books
 
 import (
+	"booksapi/api/database"
+	"booksapi/api/router"
+	"booksapi/logger"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
-func writeAPIErr(err APIError, w http.ResponseWriter) {
-	w.WriteHeader(err.Status)
-	fmt.Fprint(w, err.Error())
+// etag is a strong entity tag of given book version
+func etag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
 }
 
-func writeErr(err error, status int, w http.ResponseWriter) {
-	e := APIError{
-		Status:  status,
-		Message: err.Error(),
+// bodyETag is a strong entity tag of a book representation. Series links and available copies
+// change without a new book version, so the tag carries a digest of the body after the version
+func bodyETag(version int, body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`"%d-%x"`, version, sum[:8])
+}
+
+// parseETag returns version of a strong or weak entity tag issued by etag or bodyETag,
+// ok is false for other tags
+func parseETag(tag string) (version int, ok bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	v, _, _ := strings.Cut(tag[1:len(tag)-1], "-")
+	version, err := strconv.Atoi(v)
+	return version, err == nil
+}
+
+// parseIfMatch returns book version expected by If-Match header, nil means any version.
+// Tags which are not book versions never match so the update fails with 412
+func parseIfMatch(r *http.Request) (*int, error) {
+	return ifMatchVersion(r.Header.Get("If-Match"))
+}
+
+// ifMatchVersion parses If-Match header value, batch operations carry it in ifMatch field
+func ifMatchVersion(header string) (*int, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+	if strings.Contains(header, ",") {
+		return nil, errors.New("If-Match header must hold a single ETag")
 	}
 
-	writeAPIErr(e, w)
+	// If-Match uses strong comparison, weak tags never match
+	version, ok := parseETag(header)
+	if !ok || strings.HasPrefix(header, "W/") {
+		version = -1
+	}
+	return &version, nil
 }
 
-func getRepoErrcode(err error) int {
-	var code int
-	switch err.(type) {
-	case internalErr:
-		code = http.StatusInternalServerError
-	case notfoundErr:
-		code = http.StatusNotFound
+// noneMatch tells whether If-None-Match header allows sending the representation tagged etag,
+// weak comparison is used
+func noneMatch(r *http.Request, etag string) bool {
+	header := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	if header == "" {
+		return true
 	}
-	return code
+	if header == "*" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return false
+		}
+	}
+	return true
+}
+
+// storageRepo is implemented by every books repository, API hands out each part of it separately
+type storageRepo interface {
+	IBooksRepo
+	IStockRepo
+	IPricesRepo
+	IReviewsRepo
 }
 
 type API struct {
-	repo IBooksRepo
+	repo    IBooksRepo
+	stock   IStockRepo
+	prices  IPricesRepo
+	reviews IReviewsRepo
+	// inTx runs a unit of work of repo calls, see database.Storage
+	inTx func(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
-func New() API {
+// New picks repository of the opened storage, genres and publishers are needed by memory repo only
+func New(genres GenreTree, publishers PublisherFinder) API {
+	var repo storageRepo
+	storage := database.Get()
+	switch s := storage.(type) {
+	case *database.PostgresStorage:
+		repo = &BooksRepo{pool: s.Pool}
+	case *database.SQLiteStorage:
+		repo = &SQLiteRepo{db: s.DB}
+	default:
+		memory := NewMemoryRepo()
+		memory.genres = genres
+		memory.publishers = publishers
+		repo = memory
+	}
+
 	return API{
-		repo: &BooksRepo{},
+		repo:    repo,
+		stock:   repo,
+		prices:  repo,
+		reviews: repo,
+		inTx:    storage.InTx,
 	}
 }
 
-// GetBooks returns all books
+// Stock serves stock of books from the same storage as api
+func (api API) Stock() StockAPI {
+	return StockAPI{repo: api.stock, inTx: api.inTx}
+}
+
+// Prices serves prices of books from the same storage as api, scheduled prices are set in its units of work
+func (api API) Prices() PricesAPI {
+	return PricesAPI{repo: api.prices, books: api.repo, inTx: api.inTx}
+}
+
+// Reviews serves reviews of books from the same storage as api
+func (api API) Reviews() ReviewsAPI {
+	return ReviewsAPI{repo: api.reviews, inTx: api.inTx}
+}
+
+// UseSeries gives memory repo series kept by series package. Series package looks up books
+// of its series in turn, so series can't be passed to New
+func (api API) UseSeries(series SeriesFinder) {
+	if memory, ok := api.repo.(*MemoryRepo); ok {
+		memory.series = series
+	}
+}
+
+// UseAuthors links books written through api to authors of authors package. Authors package looks up
+// books of its authors in turn, so authors can't be passed to New
+func (api API) UseAuthors(authors AuthorLinker) {
+	switch repo := api.repo.(type) {
+	case *BooksRepo:
+		repo.authors = authors
+	case *SQLiteRepo:
+		repo.authors = authors
+	case *MemoryRepo:
+		repo.authors = authors
+	}
+}
+
+// FindBook looks up a live book for packages which link to books but can't join books table,
+// e.g. memory repository of authors
+func (api API) FindBook(ctx context.Context, id int) (title string, releaseYear *int, ok bool) {
+	b, err := api.repo.GetBookById(ctx, id)
+	if err != nil {
+		return "", nil, false
+	}
+	return b.Title, b.ReleaseYear, true
+}
+
+// SeriesBookIDs returns ids of live books of a series in reading order for memory repository of series,
+// inUse tells whether any book, trashed one included, belongs to the series
+func (api API) SeriesBookIDs(ctx context.Context, seriesID int) (ids []int, inUse bool) {
+	books, err := api.repo.GetSeriesBooks(ctx, seriesID)
+	if err != nil {
+		return nil, false
+	}
+	ids = make([]int, 0, len(books))
+	for _, b := range books {
+		if b.DeletedAt == nil {
+			ids = append(ids, b.ID)
+		}
+	}
+	return ids, len(books) > 0
+}
+
+// RenameAuthor rewrites author strings of books by ids with rename for memory repository of authors,
+// authors repositories of databases rename author strings of books in the transaction of the author
+func (api API) RenameAuthor(ctx context.Context, bookIDs []int, rename func(author string) string) {
+	if memory, ok := api.repo.(*MemoryRepo); ok {
+		memory.renameAuthor(ctx, bookIDs, rename)
+	}
+}
+
+// RenameGenre sets name of a renamed genre on its books for memory repository of genres,
+// genres repositories of databases rename books in the transaction of the genre
+func (api API) RenameGenre(ctx context.Context, genreID int, name string) {
+	if memory, ok := api.repo.(*MemoryRepo); ok {
+		memory.renameGenre(ctx, genreID, name)
+	}
+}
+
+// FindBookOffers looks up title, current price and copies available for sale of live books for carts in one go,
+// books which are not found are left out. Called in a unit of work it keeps their stock as it is until the work is done
+func (api API) FindBookOffers(ctx context.Context, ids []int) (map[int]Offer, error) {
+	return api.stock.GetOffers(ctx, ids)
+}
+
+// GetBooks returns a page of books
 //
-//	@Summary		Lists all books
-//	@Description	get books
+//	@Summary		Lists books page by page
+//	@Description	get books filtered by query parameters, use either offset or after cursor for paging
 //	@Tags			books
 //	@Accept			json
 //	@Produce		json
-//	@Success		200	{array}		bookDTO
-//	@Failure		500	{object}	APIError
+//	@Param			limit	query		int		false	"page size, 20 by default, 100 at most"
+//	@Param			offset	query		int		false	"number of books to skip"
+//	@Param			after			query		string	false	"nextCursor value from previous page"
+//	@Param			sort			query		string	false	"comma separated fields, prefix with - for descending order, e.g. -price,title, price needs currency"
+//	@Param			title			query		string	false	"exact title, case insensitive"
+//	@Param			author			query		string	false	"exact author, case insensitive"
+//	@Param			genre			query		string	false	"exact genre, case insensitive"
+//	@Param			includeSubgenres	query		bool	false	"match books of every genre below genre as well"
+//	@Param			minPrice		query		int		false	"lowest price, inclusive, needs currency"
+//	@Param			maxPrice		query		int		false	"highest price, inclusive, needs currency"
+//	@Param			currency		query		string	false	"ISO 4217 code, only books priced in it"
+//	@Param			pagesGt			query		int		false	"more pages than"
+//	@Param			pagesLt			query		int		false	"less pages than"
+//	@Param			releaseYearFrom	query		int		false	"released in or after year"
+//	@Param			releaseYearTo	query		int		false	"released in or before year"
+//	@Success		200		{object}	booksPageDTO
+//	@Header			200		{string}	Link	"first, next and prev page links"
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
 //	@Router			/api/books [get]
 func (api API) GetBooks(w http.ResponseWriter, r *http.Request) {
-	repoRes, err := api.repo.GetBooks()
+	q, apiErr := parseBooksQuery(r.URL.Query())
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	page, err := api.repo.GetBooks(r.Context(), q)
 	if err != nil {
-		writeErr(err, http.StatusInternalServerError, w)
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
 		return
 	}
 
-	dtos := make([]bookDTO, 0)
-	for _, b := range repoRes {
-		dtos = append(dtos, b.ToDto())
+	dto := booksPageDTO{
+		Items:      make([]bookDTO, 0),
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
+	}
+	for _, b := range page.Books {
+		dto.Items = append(dto.Items, b.ToDto())
+	}
+	if err := api.setAvailable(r.Context(), dto.Items); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
 	}
 
-	json, _ := json.Marshal(dtos)
+	json, _ := json.Marshal(dto)
+
+	for _, link := range pageLinks(r, q, page) {
+		w.Header().Add("Link", link)
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// SearchBooks performs full text search over books
+//
+//	@Summary		Search books
+//	@Description	full text search over title, author and genre, every word is matched as a prefix
+//	@Tags			books
+//	@Accept			json
+//	@Produce		json
+//	@Param			q		query		string	true	"search text"
+//	@Param			limit	query		int		false	"page size, 20 by default, 100 at most"
+//	@Param			offset	query		int		false	"number of results to skip"
+//	@Success		200		{object}	searchPageDTO
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Router			/api/books/search [get]
+func (api API) SearchBooks(w http.ResponseWriter, r *http.Request) {
+	q, apiErr := parseSearchQuery(r.URL.Query())
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	page, err := api.repo.SearchBooks(r.Context(), q)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto := searchPageDTO{
+		Items: make([]bookSearchResultDTO, 0),
+		Total: page.Total,
+	}
+	books := make([]bookDTO, 0, len(page.Results))
+	for _, res := range page.Results {
+		books = append(books, res.Book.ToDto())
+	}
+	if err := api.setAvailable(r.Context(), books); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+	for i, res := range page.Results {
+		dto.Items = append(dto.Items, bookSearchResultDTO{
+			Book:       books[i],
+			Rank:       res.Rank,
+			Highlights: res.Highlights,
+		})
+	}
+
+	json, _ := json.Marshal(dto)
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "%s", string(json[:]))
@@ -77,34 +336,139 @@ func (api API) GetBooks(w http.ResponseWriter, r *http.Request) {
 //	@Tags			books
 //	@Accept			json
 //	@Produce		json
-//	@Param			id	path		int	true	"Book ID"
-//	@Success		200	{object}	bookDTO
-//	@Failure		500	{object}	APIError
-//	@Failure		400	{object}	APIError
-//	@Failure		404	{object}	APIError
+//	@Param			id				path		int		true	"Book ID"
+//	@Param			If-None-Match	header		string	false	"ETag of a cached copy, 304 is sent while the book, its series links and available copies are unchanged"
+//	@Success		200				{object}	bookDTO
+//	@Header			200				{string}	ETag	"book version and digest of the body, accepted by If-Match"
+//	@Success		304
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
 //	@Router			/api/books/{id} [get]
 func (api API) GetBook(w http.ResponseWriter, r *http.Request) {
 	p := r.PathValue("id")
 	id, err := strconv.Atoi(p)
 	if err != nil {
-		e := APIError{
+		e := database.APIError{
 			Status:  http.StatusBadRequest,
 			Message: "only accept integer values as {id} path parameter",
 		}
-		writeAPIErr(e, w)
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	book, err := api.repo.GetBookById(r.Context(), id)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
 		return
 	}
 
-	book, err := api.repo.GetBookById(id)
+	dto, err := api.bookWithSeriesLinks(r.Context(), book)
 	if err != nil {
-		code := getRepoErrcode(err)
-		writeErr(err, code, w)
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
 		return
 	}
+	json, _ := json.Marshal(dto)
+
+	tag := bodyETag(book.Version, json)
+	w.Header().Set("ETag", tag)
+	if !noneMatch(r, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// setAvailable sets available copies of books in dtos
+func (api API) setAvailable(ctx context.Context, dtos []bookDTO) error {
+	if len(dtos) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(dtos))
+	for _, d := range dtos {
+		ids = append(ids, d.ID)
+	}
+	available, err := api.stock.GetAvailable(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range dtos {
+		if a, ok := available[dtos[i].ID]; ok {
+			dtos[i].Available = &a
+		}
+	}
+	return nil
+}
 
+// bookWithSeriesLinks adds books read before and after the book in its series and its available copies to its dto.
+// Neither changes the book version, which is why GET handlers tag the whole body with bodyETag
+func (api API) bookWithSeriesLinks(ctx context.Context, book bookEntity) (bookDTO, error) {
 	dto := book.ToDto()
+	if book.SeriesID != nil {
+		books, err := api.repo.GetSeriesBooks(ctx, *book.SeriesID)
+		if err != nil {
+			return dto, err
+		}
+		dto.PreviousInSeries, dto.NextInSeries = seriesLinks(books, book)
+	}
+
+	dtos := []bookDTO{dto}
+	err := api.setAvailable(ctx, dtos)
+	return dtos[0], err
+}
+
+// GetBookByISBN returns the book which has an edition of given isbn
+//
+//	@Summary		Get book by isbn
+//	@Description	get book by isbn of any of its editions, ISBN-10 and ISBN-13 are accepted with or without hyphens
+//	@Tags			books
+//	@Accept			json
+//	@Produce		json
+//	@Param			isbn			path		string	true	"ISBN-10 or ISBN-13"
+//	@Param			If-None-Match	header		string	false	"ETag of a cached copy, 304 is sent while the book, its series links and available copies are unchanged"
+//	@Success		200				{object}	bookDTO
+//	@Header			200				{string}	ETag	"book version and digest of the body, accepted by If-Match"
+//	@Success		304
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/books/isbn/{isbn} [get]
+func (api API) GetBookByISBN(w http.ResponseWriter, r *http.Request) {
+	isbn, err := normalizeISBN(r.PathValue("isbn"))
+	if err != nil {
+		database.WriteErr(err, http.StatusBadRequest, w)
+		return
+	}
+
+	book, err := api.repo.GetBookByISBN(r.Context(), isbn)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto, err := api.bookWithSeriesLinks(r.Context(), book)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
 	json, _ := json.Marshal(dto)
 
+	tag := bodyETag(book.Version, json)
+	w.Header().Set("ETag", tag)
+	if !noneMatch(r, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "%s", string(json[:]))
 }
@@ -118,8 +482,10 @@ func (api API) GetBook(w http.ResponseWriter, r *http.Request) {
 //	@Produce		json
 //	@Param			newbook	body		bookRequestBody	true	"request body"
 //	@Success		201		{object}	ActionResponse
-//	@Failure		500		{object}	APIError
-//	@Failure		400		{object}	APIError
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Failure		409		{object}	database.APIError
 //	@Router			/api/books [post]
 func (api API) AddBook(w http.ResponseWriter, r *http.Request) {
 	var req bookRequestBody
@@ -128,27 +494,27 @@ func (api API) AddBook(w http.ResponseWriter, r *http.Request) {
 	decoder.DisallowUnknownFields()
 	err := decoder.Decode(&req)
 	if err != nil {
-		e := APIError{
+		e := database.APIError{
 			Message: "invalid request model",
 			Status:  http.StatusBadRequest,
 		}
-		writeAPIErr(e, w)
+		database.WriteAPIErr(e, w)
 		return
 	}
 
-	if req.Title == nil || req.Author == nil {
-		e := APIError{
-			Message: "required fields are not set, won't save the data",
-			Status:  http.StatusBadRequest,
-		}
-		writeAPIErr(e, w)
+	if err := req.validateNew(); err != nil {
+		database.WriteErr(err, http.StatusBadRequest, w)
 		return
 	}
 
-	id, err := api.repo.AddBook(req)
-
+	var id int
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		id, err = api.repo.AddBook(ctx, req)
+		return err
+	})
 	if err != nil {
-		writeErr(err, http.StatusInternalServerError, w)
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
 		return
 	}
 
@@ -168,28 +534,39 @@ func (api API) AddBook(w http.ResponseWriter, r *http.Request) {
 //	@Tags			books
 //	@Accept			json
 //	@Produce		json
-//	@Param			id	path	int	true	"book record Id"
+//	@Param			id			path	int		true	"book record Id"
+//	@Param			If-Match	header	string	false	"ETag of the book, delete fails when the book has changed since"
 //	@Success		204
-//	@Failure		500	{object}	APIError
-//	@Failure		400	{object}	APIError
-//	@Failure		404	{object}	APIError
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Failure		412	{object}	database.APIError
 //	@Router			/api/books/{id} [delete]
 func (api API) RemoveBook(w http.ResponseWriter, r *http.Request) {
 	p := r.PathValue("id")
 	id, err := strconv.Atoi(p)
 	if err != nil {
-		e := APIError{
+		e := database.APIError{
 			Status:  http.StatusBadRequest,
 			Message: "only accept integer values as {id} path parameter",
 		}
-		writeAPIErr(e, w)
+		database.WriteAPIErr(e, w)
 		return
 	}
 
-	err = api.repo.RemoveBook(id)
+	ifMatch, err := parseIfMatch(r)
 	if err != nil {
-		code := getRepoErrcode(err)
-		writeErr(err, code, w)
+		database.WriteErr(err, http.StatusBadRequest, w)
+		return
+	}
+
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		return api.repo.RemoveBook(ctx, id, ifMatch)
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
 		return
 	}
 
@@ -205,22 +582,33 @@ func (api API) RemoveBook(w http.ResponseWriter, r *http.Request) {
 //	@Tags			books
 //	@Accept			json
 //	@Produce		json
-//	@Param			id		path	int		true	"book record Id"
-//	@Param			book	body	bookRequestBody	true	"request body"
-//	@Success		200
-//	@Failure		500	{object}	APIError
-//	@Failure		400	{object}	APIError
-//	@Failure		404	{object}	APIError
+//	@Param			id			path	int				true	"book record Id"
+//	@Param			If-Match	header	string			false	"ETag of the book, update fails when the book has changed since"
+//	@Param			book		body	bookRequestBody	true	"request body"
+//	@Success		204
+//	@Header			204	{string}	ETag	"new book version"
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Failure		412	{object}	database.APIError
+//	@Failure		409	{object}	database.APIError
 //	@Router			/api/books/{id} [patch]
 func (api API) UpdateBook(w http.ResponseWriter, r *http.Request) {
 	p := r.PathValue("id")
 	id, err := strconv.Atoi(p)
 	if err != nil {
-		e := APIError{
+		e := database.APIError{
 			Status:  http.StatusBadRequest,
 			Message: "only accept integer values as {id} path parameter",
 		}
-		writeAPIErr(e, w)
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	ifMatch, err := parseIfMatch(r)
+	if err != nil {
+		database.WriteErr(err, http.StatusBadRequest, w)
 		return
 	}
 
@@ -230,22 +618,670 @@ func (api API) UpdateBook(w http.ResponseWriter, r *http.Request) {
 	decoder.DisallowUnknownFields()
 	err = decoder.Decode(&req)
 	if err != nil {
-		e := APIError{
+		e := database.APIError{
 			Message: "invalid request model",
 			Status:  http.StatusBadRequest,
 		}
-		writeAPIErr(e, w)
+		database.WriteAPIErr(e, w)
 		return
 	}
 
-	err = api.repo.UpdateBook(id, req)
+	if err := req.validate(); err != nil {
+		database.WriteErr(err, http.StatusBadRequest, w)
+		return
+	}
+
+	var version int
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		version, err = api.repo.UpdateBook(ctx, id, req, ifMatch)
+		return err
+	})
 	if err != nil {
-		code := getRepoErrcode(err)
-		writeErr(err, code, w)
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
 		return
 	}
 
+	w.Header().Set("ETag", etag(version))
 	w.WriteHeader(http.StatusNoContent)
 	fmt.Print(w, "")
 
 }
+
+// GetTrash returns a page of books moved to trash
+//
+//	@Summary		Lists deleted books
+//	@Description	get books in trash, accepts the same query parameters as book listing
+//	@Tags			books
+//	@Accept			json
+//	@Produce		json
+//	@Param			limit	query		int		false	"page size, 20 by default, 100 at most"
+//	@Param			offset	query		int		false	"number of books to skip"
+//	@Param			after	query		string	false	"nextCursor value from previous page"
+//	@Param			sort	query		string	false	"comma separated fields, prefix with - for descending order, e.g. -price,title, price needs currency"
+//	@Success		200		{object}	trashPageDTO
+//	@Header			200		{string}	Link	"first, next and prev page links"
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Router			/api/books/trash [get]
+func (api API) GetTrash(w http.ResponseWriter, r *http.Request) {
+	q, apiErr := parseBooksQuery(r.URL.Query())
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+	q.Filter.Deleted = true
+
+	page, err := api.repo.GetBooks(r.Context(), q)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto := trashPageDTO{
+		Items:      make([]trashedBookDTO, 0),
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
+	}
+	for _, b := range page.Books {
+		dto.Items = append(dto.Items, b.ToTrashedDto())
+	}
+
+	json, _ := json.Marshal(dto)
+
+	for _, link := range pageLinks(r, q, page) {
+		w.Header().Add("Link", link)
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// RestoreBook takes book out of trash
+//
+//	@Summary		Restore deleted book
+//	@Description	moves book from trash back to the catalog
+//	@Tags			books
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path	int	true	"book record Id"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/books/{id}/restore [post]
+func (api API) RestoreBook(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		return api.repo.RestoreBook(ctx, id)
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	fmt.Fprint(w, "")
+}
+
+// PurgeTrash permanently deletes books from trash
+//
+//	@Summary		Purge trash
+//	@Description	permanently deletes books moved to trash before given time, whole trash by default. Admin only
+//	@Tags			books
+//	@Accept			json
+//	@Produce		json
+//	@Param			X-Admin-Key	header		string	true	"admin api key"
+//	@Param			before		query		string	false	"RFC 3339 time, e.g. 2024-01-31T00:00:00Z"
+//	@Success		200			{object}	PurgeResponse
+//	@Failure		500			{object}	database.APIError
+//	@Failure		503			{object}	database.APIError
+//	@Failure		400			{object}	database.APIError
+//	@Failure		403			{object}	database.APIError
+//	@Router			/api/books/trash [delete]
+func (api API) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	before := time.Now()
+	if v := r.URL.Query().Get("before"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			database.WriteAPIErr(invalidParamErr("before"), w)
+			return
+		}
+		before = t
+	}
+
+	var purged int
+	err := api.inTx(r.Context(), func(ctx context.Context) error {
+		n, err := api.repo.PurgeBooks(ctx, before)
+		purged = n
+		return err
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	j, _ := json.Marshal(PurgeResponse{Purged: purged})
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(j[:]))
+}
+
+// GetBookHistory returns changes made to a book
+//
+//	@Summary		Book change history
+//	@Description	lists every change of a book oldest first, with the actor and request id which made it
+//	@Tags			books
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int	true	"book record Id"
+//	@Param			limit	query		int	false	"page size, 20 by default, 100 at most"
+//	@Param			offset	query		int	false	"number of changes to skip"
+//	@Success		200		{object}	historyPageDTO
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Router			/api/books/{id}/history [get]
+func (api API) GetBookHistory(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	q, apiErr := parseHistoryQuery(r.URL.Query())
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	page, err := api.repo.GetBookHistory(r.Context(), id, q)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto := historyPageDTO{
+		Items: make([]auditEntryDTO, 0),
+		Total: page.Total,
+	}
+	for _, e := range page.Entries {
+		dto.Items = append(dto.Items, e.ToDto())
+	}
+
+	json, _ := json.Marshal(dto)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// ImportBooks adds books from a csv or ndjson file
+//
+//	@Summary		Import books
+//	@Description	adds every valid record of a csv file with a header row of book field names or of json lines.
+//	@Description	Records are validated as in adding a single book, invalid ones, those naming a series or genre which does not exist and those of a taken isbn are reported and skipped
+//	@Tags			books
+//	@Accept			text/csv,application/x-ndjson
+//	@Produce		json
+//	@Param			dryRun	query		bool	false	"only validate records, nothing is saved"
+//	@Param			file	body		string	true	"csv or ndjson records"
+//	@Success		200		{object}	importReportDTO
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Failure		413		{object}	database.APIError
+//	@Failure		415		{object}	database.APIError
+//	@Router			/api/books/import [post]
+func (api API) ImportBooks(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			database.WriteAPIErr(invalidParamErr("dryRun"), w)
+			return
+		}
+		dryRun = b
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	defer body.Close()
+
+	rows, apiErr := parseImport(mediaType, body)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	parsed := rows
+	err := api.inTx(r.Context(), func(ctx context.Context) error {
+		// import adds errors of rejected rows, a unit of work run again starts from rows as parsed
+		rows = cloneImportRows(parsed)
+		_, err := api.repo.ImportBooks(ctx, rows, dryRun)
+		return err
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	report := importReportDTO{
+		DryRun: dryRun,
+		Rows:   make([]importRowDTO, 0, len(rows)),
+	}
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			report.Rejected++
+			report.Rows = append(report.Rows, importRowDTO{Line: row.Line, Status: importRejected, Errors: row.Errors})
+			continue
+		}
+		report.Accepted++
+		report.Rows = append(report.Rows, importRowDTO{Line: row.Line, Status: importAccepted})
+	}
+
+	json, _ := json.Marshal(report)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// ExportBooks streams every book matching filters in requested format
+//
+//	@Summary		Export books
+//	@Description	streams books ordered by id as csv or tsv with a header row or as json lines.
+//	@Description	Filters work as in listing books. When export breaks after it started the connection is closed,
+//	@Description	so a cut off export never looks complete
+//	@Tags			books
+//	@Produce		text/csv,text/tab-separated-values,application/x-ndjson
+//	@Param			format			query		string	false	"export format, csv by default"	Enums(csv, ndjson, tsv)
+//	@Param			title			query		string	false	"exact title, case insensitive"
+//	@Param			author			query		string	false	"exact author, case insensitive"
+//	@Param			genre			query		string	false	"exact genre, case insensitive"
+//	@Param			includeSubgenres	query		bool	false	"match books of every genre below genre as well"
+//	@Param			minPrice		query		int		false	"minimal price, needs currency"
+//	@Param			maxPrice		query		int		false	"maximal price, needs currency"
+//	@Param			currency		query		string	false	"ISO 4217 code, only books priced in it"
+//	@Param			pagesGt			query		int		false	"number of pages greater than"
+//	@Param			pagesLt			query		int		false	"number of pages less than"
+//	@Param			releaseYearFrom	query		int		false	"released in or after year"
+//	@Param			releaseYearTo	query		int		false	"released in or before year"
+//	@Success		200				{string}	string	"exported books"
+//	@Failure		500				{object}	database.APIError
+//	@Failure		503				{object}	database.APIError
+//	@Failure		400				{object}	database.APIError
+//	@Router			/api/books/export [get]
+func (api API) ExportBooks(w http.ResponseWriter, r *http.Request) {
+	q, apiErr := parseExportQuery(r.URL.Query())
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	// export is sent as it is read, logging middleware would keep all of it in memory
+	router.SkipBodyCapture(w)
+	// big exports take longer than server write timeout allows
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	writer := q.Format.newWriter(w)
+	started := false
+	start := func() {
+		started = true
+		w.Header().Set("Content-Type", q.Format.MediaType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="books.%s"`, q.Format.Extension))
+		w.WriteHeader(http.StatusOK)
+	}
+
+	err := api.repo.ExportBooks(r.Context(), q.Filter, func(b bookEntity) error {
+		if !started {
+			start()
+		}
+		return writer.WriteBook(b)
+	})
+	if err == nil {
+		if !started {
+			start()
+		}
+		err = writer.Flush()
+	}
+	if err == nil {
+		return
+	}
+
+	if !started {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+	// status was already sent, closing connection is the only way left to tell client export failed
+	logger.Error(fmt.Sprintf("export aborted -> %s", err.Error()))
+	panic(http.ErrAbortHandler)
+}
+
+// BatchBooks creates, updates and removes books in one request
+//
+//	@Summary		Change books in batch
+//	@Description	runs operations in order and returns results at the same indexes with status codes of
+//	@Description	the single book endpoints. Operations are independent unless atomic is set, then the first failure
+//	@Description	rolls back the whole batch and the other operations fail with 424
+//	@Tags			books
+//	@Accept			json
+//	@Produce		json
+//	@Param			atomic		query		bool				false	"apply all operations or none"
+//	@Param			operations	body		[]batchOperation	true	"operations to run"
+//	@Success		207			{array}		batchResultDTO
+//	@Failure		500			{object}	database.APIError
+//	@Failure		503			{object}	database.APIError
+//	@Failure		400			{object}	database.APIError
+//	@Router			/api/books/batch [post]
+func (api API) BatchBooks(w http.ResponseWriter, r *http.Request) {
+	atomic := false
+	if v := r.URL.Query().Get("atomic"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			database.WriteAPIErr(invalidParamErr("atomic"), w)
+			return
+		}
+		atomic = b
+	}
+
+	var ops []batchOperation
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&ops); err != nil {
+		e := database.APIError{
+			Message: "invalid request model",
+			Status:  http.StatusBadRequest,
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+	if len(ops) == 0 || len(ops) > maxBatchSize {
+		e := database.APIError{
+			Message: fmt.Sprintf("batch must hold from 1 to %d operations", maxBatchSize),
+			Status:  http.StatusBadRequest,
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	var results []batchResultDTO
+	if atomic {
+		var err error
+		results, err = api.runAtomicBatch(r.Context(), ops)
+		if err != nil {
+			code := database.ErrStatus(err)
+			database.WriteErr(err, code, w)
+			return
+		}
+	} else {
+		results = make([]batchResultDTO, 0, len(ops))
+		for _, op := range ops {
+			results = append(results, api.runOwnBatchOperation(r.Context(), op))
+		}
+	}
+
+	json, _ := json.Marshal(results)
+
+	w.WriteHeader(http.StatusMultiStatus)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+func decodeEdition(r *http.Request) (editionRequestBody, *database.APIError) {
+	var req editionRequestBody
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, &database.APIError{
+			Message: "invalid request model",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if err := req.validate(); err != nil {
+		return req, &database.APIError{
+			Message: err.Error(),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	return req, nil
+}
+
+// GetEditions returns editions of a book
+//
+//	@Summary		Editions of book
+//	@Description	lists editions of a book in id order, isbn, numberOfPages and price of the book are those of the first one
+//	@Tags			editions
+//	@Produce		json
+//	@Param			id	path		int	true	"book record Id"
+//	@Success		200	{object}	editionsDTO
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/books/{id}/editions [get]
+func (api API) GetEditions(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	editions, err := api.repo.GetEditions(r.Context(), id)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto := editionsDTO{
+		Items: make([]editionDTO, 0, len(editions)),
+	}
+	for _, e := range editions {
+		dto.Items = append(dto.Items, e.ToDto())
+	}
+
+	json, _ := json.Marshal(dto)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// AddEdition adds new edition of a book
+//
+//	@Summary		Add edition
+//	@Description	adds edition of a book, first edition of a book gives it isbn, numberOfPages and price
+//	@Tags			editions
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int					true	"book record Id"
+//	@Param			edition	body		editionRequestBody	true	"request body"
+//	@Success		201		{object}	ActionResponse
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Failure		404		{object}	database.APIError
+//	@Failure		409		{object}	database.APIError
+//	@Router			/api/books/{id}/editions [post]
+func (api API) AddEdition(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	req, apiErr := decodeEdition(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	var editionID int
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		editionID, err = api.repo.AddEdition(ctx, id, req)
+		return err
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	j, _ := json.Marshal(ActionResponse{ResourceId: editionID})
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, string(j[:]))
+}
+
+// GetEdition returns an edition by id
+//
+//	@Summary		Get edition by id
+//	@Description	get edition, editions of books in trash are not found
+//	@Tags			editions
+//	@Produce		json
+//	@Param			id	path		int	true	"Edition ID"
+//	@Success		200	{object}	editionDTO
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/editions/{id} [get]
+func (api API) GetEdition(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	edition, err := api.repo.GetEditionById(r.Context(), id)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	json, _ := json.Marshal(edition.ToDto())
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// UpdateEdition updates an edition
+//
+//	@Summary		Update edition
+//	@Description	changes fields set in request body, change of the first edition changes the book as well
+//	@Tags			editions
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path	int					true	"Edition ID"
+//	@Param			edition	body	editionRequestBody	true	"request body"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Failure		409	{object}	database.APIError
+//	@Router			/api/editions/{id} [patch]
+func (api API) UpdateEdition(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	req, apiErr := decodeEdition(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		return api.repo.UpdateEdition(ctx, id, req)
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveEdition deletes an edition
+//
+//	@Summary		Remove edition
+//	@Description	removes edition, the next one becomes first edition of the book
+//	@Tags			editions
+//	@Produce		json
+//	@Param			id	path	int	true	"Edition ID"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/editions/{id} [delete]
+func (api API) RemoveEdition(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		return api.repo.RemoveEdition(ctx, id)
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
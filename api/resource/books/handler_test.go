@@ -1,13 +1,22 @@
 package books
 
 import (
+	"booksapi/api/database"
+	"booksapi/api/money"
+	"booksapi/api/resource/authors"
+	"booksapi/api/router"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 func intptr(x int) *int {
@@ -18,13 +27,31 @@ func strptr(s string) *string {
 	return &s
 }
 
+func floatptr(x float64) *float64 {
+	return &x
+}
+
+// eur is a price of a request body in euros
+func eur(amount string) *priceBody {
+	return &priceBody{Amount: json.Number(amount), Currency: "EUR"}
+}
+
+// euros is a saved price of whole euros
+func euros(units int64) *money.Money {
+	return &money.Money{Amount: units * 100, Currency: "EUR"}
+}
+
 type fakeWriter struct {
 	input        string
 	headerStatus int
+	header       http.Header
 }
 
-func (w fakeWriter) Header() http.Header {
-	panic("unimplemented")
+func (w *fakeWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = http.Header{}
+	}
+	return w.header
 }
 
 func (w *fakeWriter) Write(p []byte) (int, error) {
@@ -37,52 +64,135 @@ func (w *fakeWriter) WriteHeader(statusCode int) {
 }
 
 type fakeRepo struct {
-	pluralReturner   func() ([]bookEntity, error)
-	singleReturner   func(int) (bookEntity, error)
-	addbookAction    func(bookRequestBody) (int, error)
-	removeBookAction func(int) error
-	updateBookAction func(int, bookRequestBody) error
+	pluralReturner    func(context.Context, booksQuery) (booksPage, error)
+	searchReturner    func(context.Context, searchQuery) (searchPage, error)
+	singleReturner    func(context.Context, int) (bookEntity, error)
+	addbookAction     func(context.Context, bookRequestBody) (int, error)
+	removeBookAction  func(context.Context, int, *int) error
+	updateBookAction  func(context.Context, int, bookRequestBody, *int) (int, error)
+	restoreBookAction func(context.Context, int) error
+	purgeBooksAction  func(context.Context, time.Time) (int, error)
+	historyReturner   func(context.Context, int, historyQuery) (historyPage, error)
+	importAction      func(context.Context, []importRow, bool) (int, error)
+	exportAction      func(context.Context, booksFilter, func(bookEntity) error) error
+}
+
+// fakeStock is stock of books served by fakeRepo, their stock is tested against memory repo, see TestStock.
+// Books of fake repo have no available copies set
+type fakeStock struct {
+	IStockRepo
+}
+
+func (s fakeStock) GetAvailable(ctx context.Context, bookIDs []int) (map[int]int, error) {
+	return map[int]int{}, nil
+}
+
+// runTx runs fn as the unit of work of a storage without transactions
+func runTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (r fakeRepo) GetBookById(ctx context.Context, id int) (bookEntity, error) {
+	return r.singleReturner(ctx, id)
+}
+
+// isbn lookup is tested against memory repo, see TestGetBookByISBN
+func (r fakeRepo) GetBookByISBN(ctx context.Context, isbn string) (bookEntity, error) {
+	return bookEntity{}, errors.New("fake err")
+}
+
+// series are tested against memory repo, see TestSeries
+func (r fakeRepo) GetSeriesBooks(ctx context.Context, seriesID int) ([]bookEntity, error) {
+	return nil, errors.New("fake err")
+}
+
+func (r fakeRepo) GetBooks(ctx context.Context, q booksQuery) (booksPage, error) {
+	return r.pluralReturner(ctx, q)
+}
+
+func (r fakeRepo) SearchBooks(ctx context.Context, q searchQuery) (searchPage, error) {
+	return r.searchReturner(ctx, q)
+}
+
+func (r fakeRepo) AddBook(ctx context.Context, e bookRequestBody) (int, error) {
+	return r.addbookAction(ctx, e)
+}
+
+func (r fakeRepo) RemoveBook(ctx context.Context, id int, ifMatch *int) error {
+	return r.removeBookAction(ctx, id, ifMatch)
+}
+
+func (r fakeRepo) UpdateBook(ctx context.Context, id int, b bookRequestBody, ifMatch *int) (int, error) {
+	return r.updateBookAction(ctx, id, b, ifMatch)
+}
+
+func (r fakeRepo) RestoreBook(ctx context.Context, id int) error {
+	return r.restoreBookAction(ctx, id)
 }
 
-func (r fakeRepo) GetBookById(id int) (bookEntity, error) {
-	return r.singleReturner(id)
+func (r fakeRepo) PurgeBooks(ctx context.Context, before time.Time) (int, error) {
+	return r.purgeBooksAction(ctx, before)
 }
 
-func (r fakeRepo) GetBooks() ([]bookEntity, error) {
-	return r.pluralReturner()
+func (r fakeRepo) GetBookHistory(ctx context.Context, id int, q historyQuery) (historyPage, error) {
+	return r.historyReturner(ctx, id, q)
 }
 
-func (r fakeRepo) AddBook(e bookRequestBody) (int, error) {
-	return r.addbookAction(e)
+func (r fakeRepo) ImportBooks(ctx context.Context, rows []importRow, dryRun bool) (int, error) {
+	return r.importAction(ctx, rows, dryRun)
 }
 
-func (r fakeRepo) RemoveBook(id int) error {
-	return r.removeBookAction(id)
+func (r fakeRepo) ExportBooks(ctx context.Context, f booksFilter, fn func(bookEntity) error) error {
+	return r.exportAction(ctx, f, fn)
 }
 
-func (r fakeRepo) UpdateBook(id int, b bookRequestBody) error {
-	return r.updateBookAction(id, b)
+// editions are tested against memory repo, see TestEditions
+func (r fakeRepo) GetEditions(ctx context.Context, bookID int) ([]editionEntity, error) {
+	return nil, errors.New("fake err")
+}
+
+func (r fakeRepo) GetEditionById(ctx context.Context, id int) (editionEntity, error) {
+	return editionEntity{}, errors.New("fake err")
+}
+
+func (r fakeRepo) AddEdition(ctx context.Context, bookID int, b editionRequestBody) (int, error) {
+	return 0, errors.New("fake err")
+}
+
+func (r fakeRepo) UpdateEdition(ctx context.Context, id int, b editionRequestBody) error {
+	return errors.New("fake err")
+}
+
+func (r fakeRepo) RemoveEdition(ctx context.Context, id int) error {
+	return errors.New("fake err")
 }
 
 func TestGetBooks(t *testing.T) {
 	tcases := []struct {
 		repo     fakeRepo
 		w        *fakeWriter
+		req      *http.Request
 		expected struct {
 			data         string
 			headerStatus int
+			links        []string
 		}
 	}{
 		{
-			repo: fakeRepo{pluralReturner: func() ([]bookEntity, error) {
-				return nil, errors.New("fake err")
+			repo: fakeRepo{pluralReturner: func(context.Context, booksQuery) (booksPage, error) {
+				return booksPage{}, errors.New("fake err")
 			}},
 			w: &fakeWriter{},
+			req: func() *http.Request {
+				rq, _ := http.NewRequest("GET", "/books", nil)
+				return rq
+			}(),
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
-				data: APIError{
+				data: database.APIError{
 					Status:  http.StatusInternalServerError,
 					Message: "fake err",
 				}.Error(),
@@ -91,371 +201,529 @@ func TestGetBooks(t *testing.T) {
 		},
 		{
 			repo: fakeRepo{
-				pluralReturner: func() ([]bookEntity, error) {
-					return []bookEntity{
-						{
-							Title:         "The Fellowship of the Ring",
-							Author:        "JRR Tolkien",
-							Price:         intptr(20),
-							NumberOfPages: intptr(432),
-							Genre:         "fantasy",
-							ReleaseYear:   intptr(1954),
+				pluralReturner: func(_ context.Context, q booksQuery) (booksPage, error) {
+					if q.Limit != defaultPageLimit || q.Offset != 0 || q.After != nil {
+						return booksPage{}, errors.New("unexpected query")
+					}
+					return booksPage{
+						Books: []bookEntity{
+							{
+								Title:         "The Fellowship of the Ring",
+								Author:        "JRR Tolkien",
+								Price:         euros(20),
+								NumberOfPages: intptr(432),
+								Genre:         "fantasy",
+								ReleaseYear:   intptr(1954),
+							},
 						},
+						Total: 1,
 					}, nil
 				},
 			},
 			w: &fakeWriter{},
+			req: func() *http.Request {
+				rq, _ := http.NewRequest("GET", "/books", nil)
+				return rq
+			}(),
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
 				data: func() string {
-					dtos := []bookDTO{
-						{
-							Title:         "The Fellowship of the Ring",
-							Author:        "JRR Tolkien",
-							Price:         intptr(20),
-							NumberOfPages: intptr(432),
-							Genre:         "fantasy",
-							ReleaseYear:   intptr(1954),
+					dto := booksPageDTO{
+						Items: []bookDTO{
+							{
+								Title:         "The Fellowship of the Ring",
+								Author:        "JRR Tolkien",
+								Price:         newPriceDTO(euros(20)),
+								NumberOfPages: intptr(432),
+								Genre:         "fantasy",
+								ReleaseYear:   intptr(1954),
+							},
 						},
+						Total: 1,
 					}
-					json, _ := json.Marshal(dtos)
+					json, _ := json.Marshal(dto)
 					return string(json[:])
 				}(),
 				headerStatus: http.StatusOK,
+				links:        []string{`</books?limit=20>; rel="first"`},
 			},
 		},
 		{
 			repo: fakeRepo{
-				pluralReturner: func() ([]bookEntity, error) {
-					return []bookEntity{}, nil
+				pluralReturner: func(context.Context, booksQuery) (booksPage, error) {
+					return booksPage{Books: []bookEntity{}}, nil
 				},
 			},
 			w: &fakeWriter{},
+			req: func() *http.Request {
+				rq, _ := http.NewRequest("GET", "/books", nil)
+				return rq
+			}(),
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
-				data:         "[]",
+				data:         `{"items":[],"total":0,"nextCursor":null}`,
 				headerStatus: http.StatusOK,
+				links:        []string{`</books?limit=20>; rel="first"`},
 			},
 		},
 		{
 			repo: fakeRepo{
-				pluralReturner: func() ([]bookEntity, error) {
-					return nil, nil
+				pluralReturner: func(context.Context, booksQuery) (booksPage, error) {
+					return booksPage{}, nil
 				},
 			},
 			w: &fakeWriter{},
+			req: func() *http.Request {
+				rq, _ := http.NewRequest("GET", "/books", nil)
+				return rq
+			}(),
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
-				data:         "[]",
+				data:         `{"items":[],"total":0,"nextCursor":null}`,
 				headerStatus: http.StatusOK,
+				links:        []string{`</books?limit=20>; rel="first"`},
 			},
 		},
-	}
-
-	for _, tc := range tcases {
-		api := API{repo: tc.repo}
-		api.GetBooks(tc.w, nil)
-		if tc.expected.data != tc.w.input {
-			t.Errorf("GetBooks failed\nexpected %v\ngot %s", tc.expected.data, tc.w.input)
-		}
-		if tc.expected.headerStatus != tc.w.headerStatus {
-			t.Errorf("GetBooks response header failed\nexpected %v\ngot  %v",
-				tc.expected.headerStatus, tc.w.headerStatus)
-		}
-	}
-}
-
-func TestGetBookById(t *testing.T) {
-	tcases := []struct {
-		repo     fakeRepo
-		w        *fakeWriter
-		req      *http.Request
-		expected struct {
-			data         string
-			headerStatus int
-		}
-	}{
 		{
+			repo: fakeRepo{
+				pluralReturner: func(_ context.Context, q booksQuery) (booksPage, error) {
+					if q.Limit != 1 || q.Offset != 1 {
+						return booksPage{}, errors.New("unexpected query")
+					}
+					cursor := bookCursor{ID: 2}.encode()
+					return booksPage{
+						Books:      []bookEntity{{ID: 2, Title: "The Two Towers"}},
+						Total:      3,
+						NextCursor: &cursor,
+					}, nil
+				},
+			},
+			w: &fakeWriter{},
 			req: func() *http.Request {
-				rq := &http.Request{}
-				rq.SetPathValue("id", "wrongStr")
-
+				rq, _ := http.NewRequest("GET", "/books?limit=1&offset=1", nil)
 				return rq
 			}(),
-			repo: fakeRepo{},
-			w:    &fakeWriter{},
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
-				data: APIError{
-					Status:  http.StatusBadRequest,
-					Message: "only accept integer values as {id} path parameter",
-				}.Error(),
-				headerStatus: http.StatusBadRequest,
+				data: func() string {
+					cursor := bookCursor{ID: 2}.encode()
+					dto := booksPageDTO{
+						Items:      []bookDTO{{ID: 2, Title: "The Two Towers"}},
+						Total:      3,
+						NextCursor: &cursor,
+					}
+					json, _ := json.Marshal(dto)
+					return string(json[:])
+				}(),
+				headerStatus: http.StatusOK,
+				links: []string{
+					`</books?limit=1>; rel="first"`,
+					`</books?limit=1&offset=2>; rel="next"`,
+					`</books?limit=1&offset=0>; rel="prev"`,
+				},
 			},
 		},
 		{
-			repo: fakeRepo{singleReturner: func(i int) (bookEntity, error) {
-				return bookEntity{}, notfoundErr{fmt.Sprintf("resource not found err, at id -> %v", i)}
-			}},
+			repo: fakeRepo{
+				pluralReturner: func(_ context.Context, q booksQuery) (booksPage, error) {
+					if q.After == nil || q.After.ID != 1 {
+						return booksPage{}, errors.New("unexpected query")
+					}
+					cursor := bookCursor{ID: 2}.encode()
+					return booksPage{
+						Books:      []bookEntity{{ID: 2, Title: "The Two Towers"}},
+						Total:      3,
+						NextCursor: &cursor,
+					}, nil
+				},
+			},
 			w: &fakeWriter{},
 			req: func() *http.Request {
-				rq := &http.Request{}
-				rq.SetPathValue("id", "123")
-
+				rq, _ := http.NewRequest("GET", "/books?limit=1&after="+bookCursor{ID: 1}.encode(), nil)
 				return rq
 			}(),
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
-				data: APIError{
-					Status:  http.StatusNotFound,
-					Message: "resource not found err, at id -> 123",
-				}.Error(),
-				headerStatus: http.StatusNotFound,
+				data: func() string {
+					cursor := bookCursor{ID: 2}.encode()
+					dto := booksPageDTO{
+						Items:      []bookDTO{{ID: 2, Title: "The Two Towers"}},
+						Total:      3,
+						NextCursor: &cursor,
+					}
+					json, _ := json.Marshal(dto)
+					return string(json[:])
+				}(),
+				headerStatus: http.StatusOK,
+				links: []string{
+					`</books?limit=1>; rel="first"`,
+					`</books?after=` + bookCursor{ID: 2}.encode() + `&limit=1>; rel="next"`,
+				},
 			},
 		},
 		{
-			repo: fakeRepo{singleReturner: func(i int) (bookEntity, error) {
-				return bookEntity{}, internalErr{message: "internal err"}
-			}},
-			w: &fakeWriter{},
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
 			req: func() *http.Request {
-				rq := &http.Request{}
-				rq.SetPathValue("id", "10")
-
+				rq, _ := http.NewRequest("GET", "/books?limit=1000", nil)
 				return rq
 			}(),
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
-				data: APIError{
-					Status:  http.StatusInternalServerError,
-					Message: "internal err",
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "invalid value for limit query parameter",
 				}.Error(),
-				headerStatus: http.StatusInternalServerError,
+				headerStatus: http.StatusBadRequest,
 			},
 		},
 		{
-			repo: fakeRepo{singleReturner: func(i int) (bookEntity, error) {
-				return bookEntity{Title: "Superman Red Son"}, nil
-			}},
-			w: &fakeWriter{},
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
 			req: func() *http.Request {
-				rq := &http.Request{}
-				rq.SetPathValue("id", "10")
-
+				rq, _ := http.NewRequest("GET", "/books?after=notacursor", nil)
 				return rq
 			}(),
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
-				data: func() string {
-					dto := bookDTO{
-						Title: "Superman Red Son",
-					}
-					json, _ := json.Marshal(dto)
-					return string(json[:])
-				}(),
-				headerStatus: http.StatusOK,
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "invalid value for after query parameter",
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
 			},
 		},
-	}
-
-	for _, tc := range tcases {
-		api := API{repo: tc.repo}
-		api.GetBook(tc.w, tc.req)
-		if tc.expected.data != tc.w.input {
-			t.Errorf("GetBook failed\nexpected %v\ngot %s", tc.expected.data, tc.w.input)
-		}
-		if tc.expected.headerStatus != tc.w.headerStatus {
-			t.Errorf("GetBook response header failed\nexpected %v\ngot  %v",
-				tc.expected.headerStatus, tc.w.headerStatus)
-		}
-	}
-}
-
-func TestAddBook(t *testing.T) {
-	tcases := []struct {
-		repo     fakeRepo
-		w        *fakeWriter
-		req      *http.Request
-		expected struct {
-			data         string
-			headerStatus int
-		}
-	}{
 		{
 			repo: fakeRepo{
-				addbookAction: func(bookRequestBody) (int, error) {
-					return 0, badreqErr{message: "required fields are not set, won't save the data"}
+				pluralReturner: func(_ context.Context, q booksQuery) (booksPage, error) {
+					f := q.Filter
+					if f.Author == nil || *f.Author != "JRR Tolkien" || f.MaxPrice == nil || *f.MaxPrice != 25 ||
+						f.Currency == nil || *f.Currency != "EUR" || f.ReleaseYearFrom == nil || *f.ReleaseYearFrom != 1950 || f.Genre != nil || f.MinPrice != nil {
+						return booksPage{}, errors.New("unexpected filter")
+					}
+					return booksPage{Books: []bookEntity{}}, nil
 				},
 			},
 			w: &fakeWriter{},
 			req: func() *http.Request {
-				rq := &http.Request{}
-				j, _ := json.Marshal(bookRequestBody{})
-				rq.Body = io.NopCloser(strings.NewReader(string(j[:])))
-
+				rq, _ := http.NewRequest("GET", "/books?author=JRR+Tolkien&maxPrice=25&currency=eur&releaseYearFrom=1950", nil)
 				return rq
 			}(),
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
-				data: func() string {
-					e := APIError{
-						Message: "required fields are not set, won't save the data",
-						Status:  http.StatusBadRequest,
-					}
-					return e.Error()
-				}(),
-				headerStatus: http.StatusBadRequest,
+				data:         `{"items":[],"total":0,"nextCursor":null}`,
+				headerStatus: http.StatusOK,
+				links: []string{
+					`</books?author=JRR+Tolkien&currency=eur&limit=20&maxPrice=25&releaseYearFrom=1950>; rel="first"`,
+				},
 			},
 		},
 		{
 			repo: fakeRepo{},
 			w:    &fakeWriter{},
 			req: func() *http.Request {
-				msg := `{"tst":"value"}`
-				rq, _ := http.NewRequest("POST", "", strings.NewReader(msg))
-
+				rq, _ := http.NewRequest("GET", "/books?color=red", nil)
 				return rq
 			}(),
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
-				data: func() string {
-					e := APIError{
-						Message: "invalid request model",
-						Status:  http.StatusBadRequest,
-					}
-					return e.Error()
-				}(),
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "unknown query parameter color",
+				}.Error(),
 				headerStatus: http.StatusBadRequest,
 			},
 		},
 		{
-			repo: fakeRepo{addbookAction: func(be bookRequestBody) (int, error) {
-				return 1, nil
-			}},
-			w: &fakeWriter{},
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
 			req: func() *http.Request {
-				j := `{
-                        "author": "string",
-                        "genre": "string",
-                        "numberOfPages": 0,
-                        "price": 0,
-                        "releaseYear": 0,
-                        "title": "string"
-                      }`
-				// rq, _ := http.NewRequest("POST", "", strings.NewReader(msg))
-
-				rq := &http.Request{}
-				rq.Body = io.NopCloser(strings.NewReader(string(j[:])))
-
+				rq, _ := http.NewRequest("GET", "/books?minPrice=cheap", nil)
 				return rq
 			}(),
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
-				data: func() string {
-					a := ActionResponse{ResourceId: 1}
-					j, _ := json.Marshal(a)
-					return string(j[:])
-				}(),
-				headerStatus: http.StatusCreated,
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "invalid value for minPrice query parameter",
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
 			},
 		},
 		{
-			repo: fakeRepo{addbookAction: func(e bookRequestBody) (int, error) {
-				if *e.Title != "test" || *e.Author != "tst" || *e.Genre != "idk" ||
-					*e.NumberOfPages != 1 || *e.Price != 2 || *e.ReleaseYear != 3 {
-					return 0, errors.New("")
-				}
-				return 1, nil
-			}},
-			w: &fakeWriter{},
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
 			req: func() *http.Request {
-				d := bookRequestBody{
-					Title:         strptr("test"),
-					Author:        strptr("tst"),
-					Genre:         strptr("idk"),
-					NumberOfPages: intptr(1),
-					Price:         intptr(2),
-					ReleaseYear:   intptr(3),
-				}
-				j, _ := json.Marshal(d)
-				rq, _ := http.NewRequest("POST", "", strings.NewReader(string(j[:])))
-
+				rq, _ := http.NewRequest("GET", "/books?genre=", nil)
 				return rq
 			}(),
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
-				data: func() string {
-					a := ActionResponse{ResourceId: 1}
-					j, _ := json.Marshal(a)
-					return string(j[:])
-				}(),
-				headerStatus: http.StatusCreated,
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "invalid value for genre query parameter",
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
 			},
 		},
 		{
-			repo: fakeRepo{addbookAction: func(e bookRequestBody) (int, error) {
-				return 0, internalErr{message: "internal error"}
-			}},
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			req: func() *http.Request {
+				rq, _ := http.NewRequest("GET", "/books?includeSubgenres=true", nil)
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+				links        []string
+			}{
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "includeSubgenres query parameter requires genre",
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{
+				pluralReturner: func(_ context.Context, q booksQuery) (booksPage, error) {
+					if q.Filter.Genre == nil || *q.Filter.Genre != "fiction" || !q.Filter.IncludeSubgenres {
+						return booksPage{}, errors.New("unexpected filter")
+					}
+					return booksPage{Books: []bookEntity{}}, nil
+				},
+			},
 			w: &fakeWriter{},
 			req: func() *http.Request {
-				d := bookDTO{
-					Title:  "test",
-					Author: "tst",
-				}
-				j, _ := json.Marshal(d)
-				rq, _ := http.NewRequest("POST", "", strings.NewReader(string(j[:])))
-
+				rq, _ := http.NewRequest("GET", "/books?genre=fiction&includeSubgenres=true", nil)
 				return rq
 			}(),
 			expected: struct {
 				data         string
 				headerStatus int
+				links        []string
 			}{
-				data: func() string {
-					a := APIError{Message: "internal error", Status: http.StatusInternalServerError}
-					j, _ := json.Marshal(a)
-					return string(j[:])
-				}(),
-				headerStatus: http.StatusInternalServerError,
+				data:         `{"items":[],"total":0,"nextCursor":null}`,
+				headerStatus: http.StatusOK,
+				links:        []string{`</books?genre=fiction&includeSubgenres=true&limit=20>; rel="first"`},
 			},
-		}}
+		},
+		{
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			req: func() *http.Request {
+				rq, _ := http.NewRequest("GET", "/books?minPrice=30&maxPrice=20&currency=EUR", nil)
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+				links        []string
+			}{
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "minPrice query parameter can't be greater than maxPrice",
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{
+				pluralReturner: func(_ context.Context, q booksQuery) (booksPage, error) {
+					expected := []sortKey{{Field: "price", Desc: true}, {Field: "title"}}
+					if !slices.Equal(q.Sort, expected) {
+						return booksPage{}, errors.New("unexpected sort")
+					}
+					if q.After == nil || q.After.ID != 7 || !slices.Equal(q.After.Values, []any{20.0, "The Hobbit"}) {
+						return booksPage{}, errors.New("unexpected cursor")
+					}
+					return booksPage{Books: []bookEntity{}}, nil
+				},
+			},
+			w: &fakeWriter{},
+			req: func() *http.Request {
+				cursor := newCursor(bookEntity{ID: 7, Title: "The Hobbit", Price: euros(20)},
+					[]sortKey{{Field: "price", Desc: true}, {Field: "title"}})
+				rq, _ := http.NewRequest("GET", "/books?sort=-price,title&currency=EUR&after="+cursor.encode(), nil)
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+				links        []string
+			}{
+				data:         `{"items":[],"total":0,"nextCursor":null}`,
+				headerStatus: http.StatusOK,
+				links:        []string{`</books?currency=EUR&limit=20&sort=-price%2Ctitle>; rel="first"`},
+			},
+		},
+		{
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			req: func() *http.Request {
+				rq, _ := http.NewRequest("GET", "/books?maxPrice=20", nil)
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+				links        []string
+			}{
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "maxPrice query parameter requires currency",
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			req: func() *http.Request {
+				rq, _ := http.NewRequest("GET", "/books?sort=price", nil)
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+				links        []string
+			}{
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "sort by price requires currency query parameter",
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			req: func() *http.Request {
+				rq, _ := http.NewRequest("GET", "/books?currency=euro", nil)
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+				links        []string
+			}{
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "invalid value for currency query parameter",
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			req: func() *http.Request {
+				rq, _ := http.NewRequest("GET", "/books?sort=-price,color", nil)
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+				links        []string
+			}{
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: `invalid sort field "color" in sort query parameter`,
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			req: func() *http.Request {
+				rq, _ := http.NewRequest("GET", "/books?sort=title,-title", nil)
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+				links        []string
+			}{
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: `invalid sort field "-title" in sort query parameter`,
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			req: func() *http.Request {
+				rq, _ := http.NewRequest("GET", "/books?sort=title&after="+bookCursor{ID: 1}.encode(), nil)
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+				links        []string
+			}{
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "invalid value for after query parameter",
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+	}
 
 	for _, tc := range tcases {
-		api := API{repo: tc.repo}
-		api.AddBook(tc.w, tc.req)
+		api := API{repo: tc.repo, stock: fakeStock{}, inTx: runTx}
+		api.GetBooks(tc.w, tc.req)
 		if tc.expected.data != tc.w.input {
-			t.Errorf("AddBook failed\nexpected %v\ngot %s", tc.expected.data, tc.w.input)
+			t.Errorf("GetBooks failed\nexpected %v\ngot %s", tc.expected.data, tc.w.input)
 		}
 		if tc.expected.headerStatus != tc.w.headerStatus {
-			t.Errorf("AddBook response header failed\nexpected %v\ngot  %v",
+			t.Errorf("GetBooks response header failed\nexpected %v\ngot  %v",
 				tc.expected.headerStatus, tc.w.headerStatus)
 		}
+		if links := tc.w.Header().Values("Link"); strings.Join(links, ", ") != strings.Join(tc.expected.links, ", ") {
+			t.Errorf("GetBooks Link header failed\nexpected %v\ngot  %v", tc.expected.links, links)
+		}
 	}
 }
 
-func TestRemoveBookById(t *testing.T) {
+func TestSearchBooks(t *testing.T) {
 	tcases := []struct {
 		repo     fakeRepo
 		w        *fakeWriter
@@ -469,60 +737,52 @@ func TestRemoveBookById(t *testing.T) {
 			repo: fakeRepo{},
 			w:    &fakeWriter{},
 			req: func() *http.Request {
-				rq := &http.Request{}
-				rq.SetPathValue("id", "wrongStr")
-
+				rq, _ := http.NewRequest("GET", "/books/search?q=%20-%20", nil)
 				return rq
 			}(),
 			expected: struct {
 				data         string
 				headerStatus int
 			}{
-				data: APIError{
+				data: database.APIError{
 					Status:  http.StatusBadRequest,
-					Message: "only accept integer values as {id} path parameter",
+					Message: "q query parameter must contain at least one word",
 				}.Error(),
 				headerStatus: http.StatusBadRequest,
 			},
 		},
 		{
-			repo: fakeRepo{removeBookAction: func(i int) error {
-				return notfoundErr{fmt.Sprintf("resource not found err, at id -> %v", i)}
-			}},
-			w: &fakeWriter{},
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
 			req: func() *http.Request {
-				rq := &http.Request{}
-				rq.SetPathValue("id", "123")
-
+				rq, _ := http.NewRequest("GET", "/books/search?q=ring&author=tolkien", nil)
 				return rq
 			}(),
 			expected: struct {
 				data         string
 				headerStatus int
 			}{
-				data: APIError{
-					Status:  http.StatusNotFound,
-					Message: "resource not found err, at id -> 123",
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "unknown query parameter author",
 				}.Error(),
-				headerStatus: http.StatusNotFound,
+				headerStatus: http.StatusBadRequest,
 			},
 		},
 		{
-			repo: fakeRepo{removeBookAction: func(i int) error {
-				return internalErr{message: "internal err"}
+			repo: fakeRepo{searchReturner: func(context.Context, searchQuery) (searchPage, error) {
+				return searchPage{}, database.InternalErr{Message: "internal err"}
 			}},
 			w: &fakeWriter{},
 			req: func() *http.Request {
-				rq := &http.Request{}
-				rq.SetPathValue("id", "10")
-
+				rq, _ := http.NewRequest("GET", "/books/search?q=ring", nil)
 				return rq
 			}(),
 			expected: struct {
 				data         string
 				headerStatus int
 			}{
-				data: APIError{
+				data: database.APIError{
 					Status:  http.StatusInternalServerError,
 					Message: "internal err",
 				}.Error(),
@@ -530,40 +790,69 @@ func TestRemoveBookById(t *testing.T) {
 			},
 		},
 		{
-			repo: fakeRepo{removeBookAction: func(i int) error {
-				return nil
+			repo: fakeRepo{searchReturner: func(_ context.Context, q searchQuery) (searchPage, error) {
+				if !slices.Equal(q.Terms, []string{"fellow", "Ring"}) || q.Limit != 5 || q.Offset != 0 {
+					return searchPage{}, errors.New("unexpected query")
+				}
+				return searchPage{
+					Results: []bookSearchResult{
+						{
+							Book: bookEntity{ID: 1, Title: "The Fellowship of the Ring", Author: "JRR Tolkien"},
+							Rank: 0.5,
+							Highlights: bookHighlights{
+								Title:  "The <mark>Fellowship</mark> of the <mark>Ring</mark>",
+								Author: "JRR Tolkien",
+							},
+						},
+					},
+					Total: 1,
+				}, nil
 			}},
 			w: &fakeWriter{},
 			req: func() *http.Request {
-				rq := &http.Request{}
-				rq.SetPathValue("id", "10")
-
+				rq, _ := http.NewRequest("GET", "/books/search?q=fellow+Ring!&limit=5", nil)
 				return rq
 			}(),
 			expected: struct {
 				data         string
 				headerStatus int
 			}{
-				data:         "",
-				headerStatus: http.StatusNoContent,
+				data: func() string {
+					dto := searchPageDTO{
+						Items: []bookSearchResultDTO{
+							{
+								Book: bookDTO{ID: 1, Title: "The Fellowship of the Ring", Author: "JRR Tolkien"},
+								Rank: 0.5,
+								Highlights: bookHighlights{
+									Title:  "The <mark>Fellowship</mark> of the <mark>Ring</mark>",
+									Author: "JRR Tolkien",
+								},
+							},
+						},
+						Total: 1,
+					}
+					json, _ := json.Marshal(dto)
+					return string(json[:])
+				}(),
+				headerStatus: http.StatusOK,
 			},
 		},
 	}
 
 	for _, tc := range tcases {
-		api := API{repo: tc.repo}
-		api.RemoveBook(tc.w, tc.req)
+		api := API{repo: tc.repo, stock: fakeStock{}, inTx: runTx}
+		api.SearchBooks(tc.w, tc.req)
 		if tc.expected.data != tc.w.input {
-			t.Errorf("GetBook failed\nexpected %v\ngot %s", tc.expected.data, tc.w.input)
+			t.Errorf("SearchBooks failed\nexpected %v\ngot %s", tc.expected.data, tc.w.input)
 		}
 		if tc.expected.headerStatus != tc.w.headerStatus {
-			t.Errorf("GetBook response header failed\nexpected %v\ngot  %v",
+			t.Errorf("SearchBooks response header failed\nexpected %v\ngot  %v",
 				tc.expected.headerStatus, tc.w.headerStatus)
 		}
 	}
 }
 
-func TestUpdateBookById(t *testing.T) {
+func TestGetBookById(t *testing.T) {
 	tcases := []struct {
 		repo     fakeRepo
 		w        *fakeWriter
@@ -586,7 +875,7 @@ func TestUpdateBookById(t *testing.T) {
 				data         string
 				headerStatus int
 			}{
-				data: APIError{
+				data: database.APIError{
 					Status:  http.StatusBadRequest,
 					Message: "only accept integer values as {id} path parameter",
 				}.Error(),
@@ -594,13 +883,13 @@ func TestUpdateBookById(t *testing.T) {
 			},
 		},
 		{
-			repo: fakeRepo{},
-			w:    &fakeWriter{},
+			repo: fakeRepo{singleReturner: func(_ context.Context, i int) (bookEntity, error) {
+				return bookEntity{}, database.NotFoundErr{Message: fmt.Sprintf("resource not found err, at id -> %v", i)}
+			}},
+			w: &fakeWriter{},
 			req: func() *http.Request {
 				rq := &http.Request{}
-				j := `{"tst":"tst"}`
-				rq.Body = io.NopCloser(strings.NewReader(string(j[:])))
-				rq.SetPathValue("id", "10")
+				rq.SetPathValue("id", "123")
 
 				return rq
 			}(),
@@ -608,23 +897,20 @@ func TestUpdateBookById(t *testing.T) {
 				data         string
 				headerStatus int
 			}{
-				data: func() string {
-					e := APIError{
-						Message: "invalid request model",
-						Status:  http.StatusBadRequest,
-					}
-					return e.Error()
-				}(),
-				headerStatus: http.StatusBadRequest,
+				data: database.APIError{
+					Status:  http.StatusNotFound,
+					Message: "resource not found err, at id -> 123",
+				}.Error(),
+				headerStatus: http.StatusNotFound,
 			},
 		},
 		{
-			repo: fakeRepo{},
-			w:    &fakeWriter{},
+			repo: fakeRepo{singleReturner: func(_ context.Context, i int) (bookEntity, error) {
+				return bookEntity{}, database.InternalErr{Message: "internal err"}
+			}},
+			w: &fakeWriter{},
 			req: func() *http.Request {
 				rq := &http.Request{}
-				j := `random text`
-				rq.Body = io.NopCloser(strings.NewReader(string(j[:])))
 				rq.SetPathValue("id", "10")
 
 				return rq
@@ -633,36 +919,20 @@ func TestUpdateBookById(t *testing.T) {
 				data         string
 				headerStatus int
 			}{
-				data: func() string {
-					e := APIError{
-						Message: "invalid request model",
-						Status:  http.StatusBadRequest,
-					}
-					return e.Error()
-				}(),
-				headerStatus: http.StatusBadRequest,
+				data: database.APIError{
+					Status:  http.StatusInternalServerError,
+					Message: "internal err",
+				}.Error(),
+				headerStatus: http.StatusInternalServerError,
 			},
 		},
 		{
-			repo: fakeRepo{updateBookAction: func(i int, b bookRequestBody) error {
-				if *b.Title != "test" || *b.Author != "tst" || *b.Genre != "idk" ||
-					*b.NumberOfPages != 1 || *b.Price != 2 || *b.ReleaseYear != 3 {
-					return errors.New("")
-				}
-				return nil
+			repo: fakeRepo{singleReturner: func(_ context.Context, i int) (bookEntity, error) {
+				return bookEntity{Title: "Superman Red Son"}, nil
 			}},
 			w: &fakeWriter{},
 			req: func() *http.Request {
-				d := bookRequestBody{
-					Title:         strptr("test"),
-					Author:        strptr("tst"),
-					Genre:         strptr("idk"),
-					NumberOfPages: intptr(1),
-					Price:         intptr(2),
-					ReleaseYear:   intptr(3),
-				}
-				j, _ := json.Marshal(d)
-				rq, _ := http.NewRequest("POST", "", strings.NewReader(string(j[:])))
+				rq := &http.Request{}
 				rq.SetPathValue("id", "10")
 
 				return rq
@@ -671,19 +941,23 @@ func TestUpdateBookById(t *testing.T) {
 				data         string
 				headerStatus int
 			}{
-				data:         "",
-				headerStatus: http.StatusNoContent,
+				data: func() string {
+					dto := bookDTO{
+						Title: "Superman Red Son",
+					}
+					json, _ := json.Marshal(dto)
+					return string(json[:])
+				}(),
+				headerStatus: http.StatusOK,
 			},
 		},
 		{
-			repo: fakeRepo{updateBookAction: func(i int, b bookRequestBody) error {
-				return internalErr{"internal error"}
+			repo: fakeRepo{singleReturner: func(context.Context, int) (bookEntity, error) {
+				return bookEntity{}, database.CanceledErr{Message: "context canceled"}
 			}},
 			w: &fakeWriter{},
 			req: func() *http.Request {
-				d := bookRequestBody{}
-				j, _ := json.Marshal(d)
-				rq, _ := http.NewRequest("POST", "", strings.NewReader(string(j[:])))
+				rq := &http.Request{}
 				rq.SetPathValue("id", "10")
 
 				return rq
@@ -692,22 +966,20 @@ func TestUpdateBookById(t *testing.T) {
 				data         string
 				headerStatus int
 			}{
-				data: APIError{
-					Status:  http.StatusInternalServerError,
-					Message: "internal error",
+				data: database.APIError{
+					Status:  database.StatusClientClosedRequest,
+					Message: "context canceled",
 				}.Error(),
-				headerStatus: http.StatusInternalServerError,
+				headerStatus: database.StatusClientClosedRequest,
 			},
 		},
 		{
-			repo: fakeRepo{updateBookAction: func(i int, b bookRequestBody) error {
-				return notfoundErr{"not found error"}
+			repo: fakeRepo{singleReturner: func(context.Context, int) (bookEntity, error) {
+				return bookEntity{}, database.CanceledErr{Message: "context deadline exceeded", Timeout: true}
 			}},
 			w: &fakeWriter{},
 			req: func() *http.Request {
-				d := bookRequestBody{}
-				j, _ := json.Marshal(d)
-				rq, _ := http.NewRequest("POST", "", strings.NewReader(string(j[:])))
+				rq := &http.Request{}
 				rq.SetPathValue("id", "10")
 
 				return rq
@@ -716,18 +988,18 @@ func TestUpdateBookById(t *testing.T) {
 				data         string
 				headerStatus int
 			}{
-				data: APIError{
-					Status:  http.StatusNotFound,
-					Message: "not found error",
+				data: database.APIError{
+					Status:  http.StatusServiceUnavailable,
+					Message: "context deadline exceeded",
 				}.Error(),
-				headerStatus: http.StatusNotFound,
+				headerStatus: http.StatusServiceUnavailable,
 			},
 		},
 	}
 
 	for _, tc := range tcases {
-		api := API{repo: tc.repo}
-		api.UpdateBook(tc.w, tc.req)
+		api := API{repo: tc.repo, stock: fakeStock{}, inTx: runTx}
+		api.GetBook(tc.w, tc.req)
 		if tc.expected.data != tc.w.input {
 			t.Errorf("GetBook failed\nexpected %v\ngot %s", tc.expected.data, tc.w.input)
 		}
@@ -737,3 +1009,2276 @@ func TestUpdateBookById(t *testing.T) {
 		}
 	}
 }
+
+func TestAddBook(t *testing.T) {
+	tcases := []struct {
+		repo     fakeRepo
+		w        *fakeWriter
+		req      *http.Request
+		expected struct {
+			data         string
+			headerStatus int
+		}
+	}{
+		{
+			repo: fakeRepo{
+				addbookAction: func(context.Context, bookRequestBody) (int, error) {
+					return 0, database.BadRequestErr{Message: "required fields are not set, won't save the data"}
+				},
+			},
+			w: &fakeWriter{},
+			req: func() *http.Request {
+				rq := &http.Request{}
+				j, _ := json.Marshal(bookRequestBody{})
+				rq.Body = io.NopCloser(strings.NewReader(string(j[:])))
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: func() string {
+					e := database.APIError{
+						Message: "required fields are not set, won't save the data",
+						Status:  http.StatusBadRequest,
+					}
+					return e.Error()
+				}(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			req: func() *http.Request {
+				msg := `{"tst":"value"}`
+				rq, _ := http.NewRequest("POST", "", strings.NewReader(msg))
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: func() string {
+					e := database.APIError{
+						Message: "invalid request model",
+						Status:  http.StatusBadRequest,
+					}
+					return e.Error()
+				}(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{addbookAction: func(_ context.Context, be bookRequestBody) (int, error) {
+				return 1, nil
+			}},
+			w: &fakeWriter{},
+			req: func() *http.Request {
+				j := `{
+                        "author": "string",
+                        "genre": "string",
+                        "numberOfPages": 0,
+                        "price": {"amount": "0", "currency": "EUR"},
+                        "releaseYear": 0,
+                        "title": "string"
+                      }`
+				// rq, _ := http.NewRequest("POST", "", strings.NewReader(msg))
+
+				rq := &http.Request{}
+				rq.Body = io.NopCloser(strings.NewReader(string(j[:])))
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: func() string {
+					a := ActionResponse{ResourceId: 1}
+					j, _ := json.Marshal(a)
+					return string(j[:])
+				}(),
+				headerStatus: http.StatusCreated,
+			},
+		},
+		{
+			repo: fakeRepo{addbookAction: func(_ context.Context, e bookRequestBody) (int, error) {
+				if *e.Title != "test" || *e.Author != "tst" || *e.Genre != "idk" ||
+					*e.NumberOfPages != 1 || *e.Price != *eur("2") || *e.ReleaseYear != 3 {
+					return 0, errors.New("")
+				}
+				return 1, nil
+			}},
+			w: &fakeWriter{},
+			req: func() *http.Request {
+				d := bookRequestBody{
+					Title:         strptr("test"),
+					Author:        strptr("tst"),
+					Genre:         strptr("idk"),
+					NumberOfPages: intptr(1),
+					Price:         eur("2"),
+					ReleaseYear:   intptr(3),
+				}
+				j, _ := json.Marshal(d)
+				rq, _ := http.NewRequest("POST", "", strings.NewReader(string(j[:])))
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: func() string {
+					a := ActionResponse{ResourceId: 1}
+					j, _ := json.Marshal(a)
+					return string(j[:])
+				}(),
+				headerStatus: http.StatusCreated,
+			},
+		},
+		{
+			repo: fakeRepo{addbookAction: func(_ context.Context, e bookRequestBody) (int, error) {
+				return 0, database.InternalErr{Message: "internal error"}
+			}},
+			w: &fakeWriter{},
+			req: func() *http.Request {
+				d := bookDTO{
+					Title:  "test",
+					Author: "tst",
+				}
+				j, _ := json.Marshal(d)
+				rq, _ := http.NewRequest("POST", "", strings.NewReader(string(j[:])))
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: func() string {
+					a := database.APIError{Message: "internal error", Status: http.StatusInternalServerError}
+					j, _ := json.Marshal(a)
+					return string(j[:])
+				}(),
+				headerStatus: http.StatusInternalServerError,
+			},
+		}}
+
+	for _, tc := range tcases {
+		api := API{repo: tc.repo, stock: fakeStock{}, inTx: runTx}
+		api.AddBook(tc.w, tc.req)
+		if tc.expected.data != tc.w.input {
+			t.Errorf("AddBook failed\nexpected %v\ngot %s", tc.expected.data, tc.w.input)
+		}
+		if tc.expected.headerStatus != tc.w.headerStatus {
+			t.Errorf("AddBook response header failed\nexpected %v\ngot  %v",
+				tc.expected.headerStatus, tc.w.headerStatus)
+		}
+	}
+}
+
+func TestRemoveBookById(t *testing.T) {
+	tcases := []struct {
+		repo     fakeRepo
+		w        *fakeWriter
+		req      *http.Request
+		expected struct {
+			data         string
+			headerStatus int
+		}
+	}{
+		{
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			req: func() *http.Request {
+				rq := &http.Request{}
+				rq.SetPathValue("id", "wrongStr")
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "only accept integer values as {id} path parameter",
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{removeBookAction: func(_ context.Context, i int, _ *int) error {
+				return database.NotFoundErr{Message: fmt.Sprintf("resource not found err, at id -> %v", i)}
+			}},
+			w: &fakeWriter{},
+			req: func() *http.Request {
+				rq := &http.Request{}
+				rq.SetPathValue("id", "123")
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: database.APIError{
+					Status:  http.StatusNotFound,
+					Message: "resource not found err, at id -> 123",
+				}.Error(),
+				headerStatus: http.StatusNotFound,
+			},
+		},
+		{
+			repo: fakeRepo{removeBookAction: func(_ context.Context, i int, _ *int) error {
+				return database.InternalErr{Message: "internal err"}
+			}},
+			w: &fakeWriter{},
+			req: func() *http.Request {
+				rq := &http.Request{}
+				rq.SetPathValue("id", "10")
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: database.APIError{
+					Status:  http.StatusInternalServerError,
+					Message: "internal err",
+				}.Error(),
+				headerStatus: http.StatusInternalServerError,
+			},
+		},
+		{
+			repo: fakeRepo{removeBookAction: func(_ context.Context, i int, _ *int) error {
+				return nil
+			}},
+			w: &fakeWriter{},
+			req: func() *http.Request {
+				rq := &http.Request{}
+				rq.SetPathValue("id", "10")
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data:         "",
+				headerStatus: http.StatusNoContent,
+			},
+		},
+	}
+
+	for _, tc := range tcases {
+		api := API{repo: tc.repo, stock: fakeStock{}, inTx: runTx}
+		api.RemoveBook(tc.w, tc.req)
+		if tc.expected.data != tc.w.input {
+			t.Errorf("GetBook failed\nexpected %v\ngot %s", tc.expected.data, tc.w.input)
+		}
+		if tc.expected.headerStatus != tc.w.headerStatus {
+			t.Errorf("GetBook response header failed\nexpected %v\ngot  %v",
+				tc.expected.headerStatus, tc.w.headerStatus)
+		}
+	}
+}
+
+func TestUpdateBookById(t *testing.T) {
+	tcases := []struct {
+		repo     fakeRepo
+		w        *fakeWriter
+		req      *http.Request
+		expected struct {
+			data         string
+			headerStatus int
+		}
+	}{
+		{
+			req: func() *http.Request {
+				rq := &http.Request{}
+				rq.SetPathValue("id", "wrongStr")
+
+				return rq
+			}(),
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: database.APIError{
+					Status:  http.StatusBadRequest,
+					Message: "only accept integer values as {id} path parameter",
+				}.Error(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			req: func() *http.Request {
+				rq := &http.Request{}
+				j := `{"tst":"tst"}`
+				rq.Body = io.NopCloser(strings.NewReader(string(j[:])))
+				rq.SetPathValue("id", "10")
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: func() string {
+					e := database.APIError{
+						Message: "invalid request model",
+						Status:  http.StatusBadRequest,
+					}
+					return e.Error()
+				}(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{},
+			w:    &fakeWriter{},
+			req: func() *http.Request {
+				rq := &http.Request{}
+				j := `random text`
+				rq.Body = io.NopCloser(strings.NewReader(string(j[:])))
+				rq.SetPathValue("id", "10")
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: func() string {
+					e := database.APIError{
+						Message: "invalid request model",
+						Status:  http.StatusBadRequest,
+					}
+					return e.Error()
+				}(),
+				headerStatus: http.StatusBadRequest,
+			},
+		},
+		{
+			repo: fakeRepo{updateBookAction: func(_ context.Context, i int, b bookRequestBody, _ *int) (int, error) {
+				if *b.Title != "test" || *b.Author != "tst" || *b.Genre != "idk" ||
+					*b.NumberOfPages != 1 || *b.Price != *eur("2") || *b.ReleaseYear != 3 {
+					return 0, errors.New("")
+				}
+				return 2, nil
+			}},
+			w: &fakeWriter{},
+			req: func() *http.Request {
+				d := bookRequestBody{
+					Title:         strptr("test"),
+					Author:        strptr("tst"),
+					Genre:         strptr("idk"),
+					NumberOfPages: intptr(1),
+					Price:         eur("2"),
+					ReleaseYear:   intptr(3),
+				}
+				j, _ := json.Marshal(d)
+				rq, _ := http.NewRequest("POST", "", strings.NewReader(string(j[:])))
+				rq.SetPathValue("id", "10")
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data:         "",
+				headerStatus: http.StatusNoContent,
+			},
+		},
+		{
+			repo: fakeRepo{updateBookAction: func(_ context.Context, i int, b bookRequestBody, _ *int) (int, error) {
+				return 0, database.InternalErr{Message: "internal error"}
+			}},
+			w: &fakeWriter{},
+			req: func() *http.Request {
+				d := bookRequestBody{}
+				j, _ := json.Marshal(d)
+				rq, _ := http.NewRequest("POST", "", strings.NewReader(string(j[:])))
+				rq.SetPathValue("id", "10")
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: database.APIError{
+					Status:  http.StatusInternalServerError,
+					Message: "internal error",
+				}.Error(),
+				headerStatus: http.StatusInternalServerError,
+			},
+		},
+		{
+			repo: fakeRepo{updateBookAction: func(_ context.Context, i int, b bookRequestBody, _ *int) (int, error) {
+				return 0, database.NotFoundErr{Message: "not found error"}
+			}},
+			w: &fakeWriter{},
+			req: func() *http.Request {
+				d := bookRequestBody{}
+				j, _ := json.Marshal(d)
+				rq, _ := http.NewRequest("POST", "", strings.NewReader(string(j[:])))
+				rq.SetPathValue("id", "10")
+
+				return rq
+			}(),
+			expected: struct {
+				data         string
+				headerStatus int
+			}{
+				data: database.APIError{
+					Status:  http.StatusNotFound,
+					Message: "not found error",
+				}.Error(),
+				headerStatus: http.StatusNotFound,
+			},
+		},
+	}
+
+	for _, tc := range tcases {
+		api := API{repo: tc.repo, stock: fakeStock{}, inTx: runTx}
+		api.UpdateBook(tc.w, tc.req)
+		if tc.expected.data != tc.w.input {
+			t.Errorf("GetBook failed\nexpected %v\ngot %s", tc.expected.data, tc.w.input)
+		}
+		if tc.expected.headerStatus != tc.w.headerStatus {
+			t.Errorf("GetBook response header failed\nexpected %v\ngot  %v",
+				tc.expected.headerStatus, tc.w.headerStatus)
+		}
+	}
+}
+
+func TestBookETags(t *testing.T) {
+	book := func(context.Context, int) (bookEntity, error) {
+		return bookEntity{ID: 10, Title: "Solaris", Version: 3}, nil
+	}
+	update := func(_ context.Context, _ int, _ bookRequestBody, ifMatch *int) (int, error) {
+		if ifMatch != nil && *ifMatch != 3 {
+			return 0, versionMismatch(10)
+		}
+		return 4, nil
+	}
+	remove := func(ctx context.Context, id int, ifMatch *int) error {
+		_, err := update(ctx, id, bookRequestBody{}, ifMatch)
+		return err
+	}
+	repo := fakeRepo{singleReturner: book, updateBookAction: update, removeBookAction: remove}
+	b, _ := book(ctx, 10)
+	body, _ := json.Marshal(b.ToDto())
+	current := bodyETag(3, body)
+
+	tcases := []struct {
+		method       string
+		header       string
+		value        string
+		headerStatus int
+		etag         string
+	}{
+		{method: "GET", headerStatus: http.StatusOK, etag: current},
+		{method: "GET", header: "If-None-Match", value: current, headerStatus: http.StatusNotModified, etag: current},
+		{method: "GET", header: "If-None-Match", value: `"1", W/` + current, headerStatus: http.StatusNotModified, etag: current},
+		{method: "GET", header: "If-None-Match", value: "*", headerStatus: http.StatusNotModified, etag: current},
+		{method: "GET", header: "If-None-Match", value: `"3"`, headerStatus: http.StatusOK, etag: current},
+		{method: "GET", header: "If-None-Match", value: `"2"`, headerStatus: http.StatusOK, etag: current},
+		{method: "PATCH", headerStatus: http.StatusNoContent, etag: `"4"`},
+		{method: "PATCH", header: "If-Match", value: `"3"`, headerStatus: http.StatusNoContent, etag: `"4"`},
+		{method: "PATCH", header: "If-Match", value: current, headerStatus: http.StatusNoContent, etag: `"4"`},
+		{method: "PATCH", header: "If-Match", value: "*", headerStatus: http.StatusNoContent, etag: `"4"`},
+		{method: "PATCH", header: "If-Match", value: `"2"`, headerStatus: http.StatusPreconditionFailed},
+		{method: "PATCH", header: "If-Match", value: `W/"3"`, headerStatus: http.StatusPreconditionFailed},
+		{method: "PATCH", header: "If-Match", value: "garbage", headerStatus: http.StatusPreconditionFailed},
+		{method: "PATCH", header: "If-Match", value: `"2", "3"`, headerStatus: http.StatusBadRequest},
+		{method: "DELETE", header: "If-Match", value: `"3"`, headerStatus: http.StatusNoContent},
+		{method: "DELETE", header: "If-Match", value: `"2"`, headerStatus: http.StatusPreconditionFailed},
+	}
+
+	for _, tc := range tcases {
+		api := API{repo: repo, stock: fakeStock{}, inTx: runTx}
+		w := &fakeWriter{}
+		rq, _ := http.NewRequest(tc.method, "", strings.NewReader("{}"))
+		rq.SetPathValue("id", "10")
+		if tc.header != "" {
+			rq.Header.Set(tc.header, tc.value)
+		}
+
+		switch tc.method {
+		case "GET":
+			api.GetBook(w, rq)
+		case "PATCH":
+			api.UpdateBook(w, rq)
+		case "DELETE":
+			api.RemoveBook(w, rq)
+		}
+
+		if tc.headerStatus != w.headerStatus {
+			t.Errorf("%s with %s %s response header failed\nexpected %v\ngot  %v",
+				tc.method, tc.header, tc.value, tc.headerStatus, w.headerStatus)
+		}
+		if etag := w.Header().Get("ETag"); etag != tc.etag {
+			t.Errorf("%s with %s %s ETag failed\nexpected %v\ngot  %v",
+				tc.method, tc.header, tc.value, tc.etag, etag)
+		}
+		if w.headerStatus == http.StatusNotModified && w.input != "" {
+			t.Errorf("GET with %s %s expected empty body\ngot %s", tc.header, tc.value, w.input)
+		}
+	}
+}
+
+func TestGetTrash(t *testing.T) {
+	deletedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	repo := fakeRepo{pluralReturner: func(_ context.Context, q booksQuery) (booksPage, error) {
+		if !q.Filter.Deleted {
+			return booksPage{}, errors.New("trash must list deleted books only")
+		}
+		return booksPage{Books: []bookEntity{{ID: 3, Title: "Dune", DeletedAt: &deletedAt}}, Total: 1}, nil
+	}}
+
+	w := &fakeWriter{}
+	rq, _ := http.NewRequest("GET", "/api/books/trash?limit=10", nil)
+	API{repo: repo, stock: fakeStock{}, inTx: runTx}.GetTrash(w, rq)
+
+	expected := `{"items":[{"id":3,"title":"Dune","author":"","genre":"","numberOfPages":null,"price":null,` +
+		`"releaseYear":null,"deletedAt":"2024-05-01T10:00:00Z"}],"total":1,"nextCursor":null}`
+	if w.input != expected {
+		t.Errorf("GetTrash failed\nexpected %v\ngot %s", expected, w.input)
+	}
+	if w.headerStatus != http.StatusOK {
+		t.Errorf("GetTrash response header failed\nexpected %v\ngot  %v", http.StatusOK, w.headerStatus)
+	}
+}
+
+func TestRestoreBook(t *testing.T) {
+	repo := fakeRepo{restoreBookAction: func(_ context.Context, id int) error {
+		if id != 10 {
+			return notInTrash(id)
+		}
+		return nil
+	}}
+
+	tcases := []struct {
+		id           string
+		data         string
+		headerStatus int
+	}{
+		{
+			id: "wrongStr",
+			data: database.APIError{
+				Status:  http.StatusBadRequest,
+				Message: "only accept integer values as {id} path parameter",
+			}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			id: "11",
+			data: database.APIError{
+				Status:  http.StatusNotFound,
+				Message: "book with id 11 is not in trash",
+			}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+		{
+			id:           "10",
+			data:         "",
+			headerStatus: http.StatusNoContent,
+		},
+	}
+
+	for _, tc := range tcases {
+		w := &fakeWriter{}
+		rq := &http.Request{}
+		rq.SetPathValue("id", tc.id)
+		API{repo: repo, stock: fakeStock{}, inTx: runTx}.RestoreBook(w, rq)
+
+		if tc.data != w.input {
+			t.Errorf("RestoreBook failed\nexpected %v\ngot %s", tc.data, w.input)
+		}
+		if tc.headerStatus != w.headerStatus {
+			t.Errorf("RestoreBook response header failed\nexpected %v\ngot  %v", tc.headerStatus, w.headerStatus)
+		}
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	var purgedBefore time.Time
+	repo := fakeRepo{purgeBooksAction: func(_ context.Context, before time.Time) (int, error) {
+		purgedBefore = before
+		return 2, nil
+	}}
+
+	tcases := []struct {
+		url          string
+		data         string
+		headerStatus int
+		before       time.Time
+	}{
+		{
+			url:          "/api/books/trash?before=2024-01-31T00:00:00Z",
+			data:         `{"purged":2}`,
+			headerStatus: http.StatusOK,
+			before:       time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			url:          "/api/books/trash?before=yesterday",
+			data:         invalidParamErr("before").Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tcases {
+		purgedBefore = time.Time{}
+		w := &fakeWriter{}
+		rq, _ := http.NewRequest("DELETE", tc.url, nil)
+		API{repo: repo, stock: fakeStock{}, inTx: runTx}.PurgeTrash(w, rq)
+
+		if tc.data != w.input {
+			t.Errorf("PurgeTrash failed\nexpected %v\ngot %s", tc.data, w.input)
+		}
+		if tc.headerStatus != w.headerStatus {
+			t.Errorf("PurgeTrash response header failed\nexpected %v\ngot  %v", tc.headerStatus, w.headerStatus)
+		}
+		if !purgedBefore.Equal(tc.before) {
+			t.Errorf("PurgeTrash failed\nexpected purge before %v\ngot %v", tc.before, purgedBefore)
+		}
+	}
+}
+
+func TestGetBookHistory(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	repo := fakeRepo{historyReturner: func(_ context.Context, id int, q historyQuery) (historyPage, error) {
+		if q.Limit != 1 || q.Offset != 1 {
+			return historyPage{}, database.InternalErr{Message: fmt.Sprintf("unexpected query %+v", q)}
+		}
+		return historyPage{
+			Entries: []auditEntry{{ID: 7, BookID: id, Action: auditUpdate, Actor: "alice", RequestID: "req-1",
+				Changes: []byte(`{"price":{"before":20,"after":12}}`), CreatedAt: createdAt}},
+			Total: 2,
+		}, nil
+	}}
+
+	tcases := []struct {
+		id           string
+		url          string
+		data         string
+		headerStatus int
+	}{
+		{
+			id:           "wrongStr",
+			url:          "/api/books/wrongStr/history",
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "only accept integer values as {id} path parameter"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			id:           "3",
+			url:          "/api/books/3/history?limit=1&page=2",
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "unknown query parameter page"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			id:  "3",
+			url: "/api/books/3/history?limit=1&offset=1",
+			data: `{"items":[{"id":7,"bookId":3,"action":"update","changes":{"price":{"before":20,"after":12}},` +
+				`"actor":"alice","requestId":"req-1","createdAt":"2024-05-01T10:00:00Z"}],"total":2}`,
+			headerStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range tcases {
+		w := &fakeWriter{}
+		rq, _ := http.NewRequest("GET", tc.url, nil)
+		rq.SetPathValue("id", tc.id)
+		API{repo: repo, stock: fakeStock{}, inTx: runTx}.GetBookHistory(w, rq)
+
+		if tc.data != w.input {
+			t.Errorf("GetBookHistory failed\nexpected %v\ngot %s", tc.data, w.input)
+		}
+		if tc.headerStatus != w.headerStatus {
+			t.Errorf("GetBookHistory response header failed\nexpected %v\ngot  %v", tc.headerStatus, w.headerStatus)
+		}
+	}
+}
+
+func TestImportBooks(t *testing.T) {
+	var imported []bookRequestBody
+	repo := fakeRepo{importAction: func(_ context.Context, rows []importRow, dryRun bool) (int, error) {
+		if dryRun {
+			return 0, nil
+		}
+		imported = importRejects{}.accepted(rows)
+		return len(imported), nil
+	}}
+
+	tcases := []struct {
+		url          string
+		contentType  string
+		body         string
+		data         string
+		headerStatus int
+		imported     []string
+	}{
+		{
+			url:         "/api/books/import",
+			contentType: "text/csv; charset=utf-8",
+			body: "\uFEFFTitle,author,numberOfPages,price,currency\n" +
+				"Dune,Frank Herbert,412,15,EUR\n" +
+				",Stanislaw Lem,,,\n" +
+				"Solaris,Stanislaw Lem,many,,\n" +
+				"\"Eden, 1959\",Stanislaw Lem,,,\n" +
+				"Ubik,Philip K. Dick\n",
+			data: `{"dryRun":false,"accepted":2,"rejected":3,"rows":[` +
+				`{"line":2,"status":"accepted"},` +
+				`{"line":3,"status":"rejected","errors":["required fields are not set, won't save the data"]},` +
+				`{"line":4,"status":"rejected","errors":["numberOfPages must be an integer"]},` +
+				`{"line":5,"status":"accepted"},` +
+				`{"line":6,"status":"rejected","errors":["row has 2 fields, header has 5"]}]}`,
+			headerStatus: http.StatusOK,
+			imported:     []string{"Dune", "Eden, 1959"},
+		},
+		{
+			url:         "/api/books/import?dryRun=true",
+			contentType: "application/x-ndjson",
+			body: `{"title":"Dune","author":"Frank Herbert","price":{"amount":"15","currency":"EUR"}}` + "\n\n" +
+				`{"title":"Solaris","subtitle":"x"}` + "\n" +
+				`{"title":"Solaris"}`,
+			data: `{"dryRun":true,"accepted":1,"rejected":2,"rows":[` +
+				`{"line":1,"status":"accepted"},` +
+				`{"line":3,"status":"rejected","errors":["invalid json -\u003e json: unknown field \"subtitle\""]},` +
+				`{"line":4,"status":"rejected","errors":["required fields are not set, won't save the data"]}]}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			url:          "/api/books/import",
+			contentType:  "text/csv",
+			body:         "title,subtitle\nDune,123\n",
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "unknown csv column subtitle"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			url:         "/api/books/import",
+			contentType: "text/csv",
+			body: "title,author,isbn\n" +
+				"Dune,Frank Herbert,0-306-40615-2\n" +
+				"Eden,Stanislaw Lem,9780306406157\n" +
+				"Ubik,Philip K. Dick,123\n",
+			data: `{"dryRun":false,"accepted":1,"rejected":2,"rows":[` +
+				`{"line":2,"status":"accepted"},` +
+				`{"line":3,"status":"rejected","errors":["isbn 9780306406157 is already used on line 2"]},` +
+				`{"line":4,"status":"rejected","errors":["isbn \"123\" is not a valid ISBN-10 or ISBN-13"]}]}`,
+			headerStatus: http.StatusOK,
+			imported:     []string{"Dune"},
+		},
+		{
+			url:          "/api/books/import",
+			contentType:  "application/json",
+			body:         "[]",
+			data:         database.APIError{Status: http.StatusUnsupportedMediaType, Message: "import accepts text/csv or application/x-ndjson content only"}.Error(),
+			headerStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			url:          "/api/books/import?dryRun=maybe",
+			contentType:  "text/csv",
+			body:         "title,author\n",
+			data:         invalidParamErr("dryRun").Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tcases {
+		imported = nil
+		w := &fakeWriter{}
+		rq, _ := http.NewRequest("POST", tc.url, strings.NewReader(tc.body))
+		rq.Header.Set("Content-Type", tc.contentType)
+		API{repo: repo, stock: fakeStock{}, inTx: runTx}.ImportBooks(w, rq)
+
+		if tc.data != w.input {
+			t.Errorf("ImportBooks failed\nexpected %v\ngot %s", tc.data, w.input)
+		}
+		if tc.headerStatus != w.headerStatus {
+			t.Errorf("ImportBooks response header failed\nexpected %v\ngot  %v", tc.headerStatus, w.headerStatus)
+		}
+
+		titles := make([]string, 0)
+		for _, b := range imported {
+			titles = append(titles, *b.Title)
+		}
+		if len(titles) != len(tc.imported) || !slices.Equal(titles, tc.imported) && len(tc.imported) > 0 {
+			t.Errorf("ImportBooks failed\nexpected to import %v\ngot %v", tc.imported, titles)
+		}
+	}
+}
+
+func TestExportBooks(t *testing.T) {
+	books := []bookEntity{
+		{ID: 1, Title: "Eden, 1959", Author: "Stanislaw Lem", Price: euros(12), ReleaseYear: intptr(1959)},
+		{ID: 4, Title: "Dune", Author: "Frank Herbert", Genre: "science fiction", NumberOfPages: intptr(412)},
+	}
+	export := func(_ context.Context, _ booksFilter, fn func(bookEntity) error) error {
+		for _, b := range books {
+			if err := fn(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tcases := []struct {
+		url          string
+		repo         fakeRepo
+		data         string
+		headerStatus int
+		contentType  string
+		filter       booksFilter
+	}{
+		{
+			url:  "/api/books/export",
+			repo: fakeRepo{exportAction: export},
+			data: "id,title,author,genre,numberOfPages,price,currency,releaseYear\n" +
+				"1,\"Eden, 1959\",Stanislaw Lem,,,12.00,EUR,1959\n" +
+				"4,Dune,Frank Herbert,science fiction,412,,,\n",
+			headerStatus: http.StatusOK,
+			contentType:  "text/csv; charset=utf-8",
+		},
+		{
+			url:          "/api/books/export?format=tsv&author=Frank%20Herbert&minPrice=10&maxPrice=20&currency=EUR",
+			repo:         fakeRepo{exportAction: func(context.Context, booksFilter, func(bookEntity) error) error { return nil }},
+			data:         "id\ttitle\tauthor\tgenre\tnumberOfPages\tprice\tcurrency\treleaseYear\n",
+			headerStatus: http.StatusOK,
+			contentType:  "text/tab-separated-values; charset=utf-8",
+			filter:       booksFilter{Author: strptr("Frank Herbert"), MinPrice: floatptr(10), MaxPrice: floatptr(20), Currency: strptr("EUR")},
+		},
+		{
+			url:  "/api/books/export?format=ndjson&genre=fantasy",
+			repo: fakeRepo{exportAction: export},
+			data: `{"id":1,"title":"Eden, 1959","author":"Stanislaw Lem","genre":"","numberOfPages":null,"price":{"amount":"12.00","currency":"EUR"},"releaseYear":1959}` + "\n" +
+				`{"id":4,"title":"Dune","author":"Frank Herbert","genre":"science fiction","numberOfPages":412,"price":null,"releaseYear":null}` + "\n",
+			headerStatus: http.StatusOK,
+			contentType:  "application/x-ndjson",
+			filter:       booksFilter{Genre: strptr("fantasy")},
+		},
+		{
+			url:          "/api/books/export?format=xlsx",
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "format query parameter must be one of csv, ndjson, tsv"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			url:          "/api/books/export?limit=10",
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "unknown query parameter limit"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			url:          "/api/books/export?minPrice=20&maxPrice=10&currency=EUR",
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "minPrice query parameter can't be greater than maxPrice"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			url: "/api/books/export",
+			repo: fakeRepo{exportAction: func(context.Context, booksFilter, func(bookEntity) error) error {
+				return database.InternalErr{Message: "internal error"}
+			}},
+			data:         database.APIError{Status: http.StatusInternalServerError, Message: "internal error"}.Error(),
+			headerStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tcases {
+		var filter booksFilter
+		repo := tc.repo
+		if repo.exportAction != nil {
+			repo.exportAction = func(ctx context.Context, f booksFilter, fn func(bookEntity) error) error {
+				filter = f
+				return tc.repo.exportAction(ctx, f, fn)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest("GET", tc.url, nil)
+		API{repo: repo, stock: fakeStock{}, inTx: runTx}.ExportBooks(w, rq)
+
+		if tc.data != w.Body.String() {
+			t.Errorf("ExportBooks failed\nexpected %q\ngot %q", tc.data, w.Body.String())
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("ExportBooks response header failed\nexpected %v\ngot  %v", tc.headerStatus, w.Code)
+		}
+		if tc.headerStatus == http.StatusOK {
+			if ct := w.Header().Get("Content-Type"); ct != tc.contentType {
+				t.Errorf("ExportBooks content type failed\nexpected %v\ngot  %v", tc.contentType, ct)
+			}
+			if !reflect.DeepEqual(filter, tc.filter) {
+				t.Errorf("ExportBooks filter failed\nexpected %+v\ngot  %+v", tc.filter, filter)
+			}
+		}
+	}
+}
+
+func TestExportBooksAbort(t *testing.T) {
+	repo := fakeRepo{exportAction: func(_ context.Context, _ booksFilter, fn func(bookEntity) error) error {
+		if err := fn(bookEntity{ID: 1, Title: "Dune", Author: "Frank Herbert"}); err != nil {
+			return err
+		}
+		return database.CanceledErr{Message: "timeout", Timeout: true}
+	}}
+
+	rec := httptest.NewRecorder()
+	w := router.NewResponseWriterWrapper(rec)
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("ExportBooks must abort response broken after it started\ngot %v", r)
+		}
+		if rec.Code != http.StatusOK {
+			t.Errorf("ExportBooks response header failed\nexpected %v\ngot  %v", http.StatusOK, rec.Code)
+		}
+		if !*w.SkipBody || w.Body.Len() != 0 {
+			t.Errorf("ExportBooks must not be captured by logging\ngot %q", w.Body.String())
+		}
+	}()
+
+	API{repo: repo, stock: fakeStock{}, inTx: runTx}.ExportBooks(w, httptest.NewRequest("GET", "/api/books/export", nil))
+}
+
+func TestBatchBooks(t *testing.T) {
+	tcases := []struct {
+		url          string
+		body         string
+		data         string
+		headerStatus int
+		titles       map[int]string
+	}{
+		{
+			url: "/api/books/batch",
+			body: `[{"op":"create","book":{"title":"Ubik","author":"Philip K. Dick"}},
+				{"op":"create","book":{"title":"Eden"}},
+				{"op":"patch","id":1,"ifMatch":"\"7\"","book":{"title":"Fellowship"}},
+				{"op":"patch","id":2,"ifMatch":"\"1\"","book":{"title":"Towers"}},
+				{"op":"delete","id":42},
+				{"op":"delete","id":3},
+				{"op":"rename","id":4}]`,
+			data: `[{"status":201,"result":{"resourceId":6}},` +
+				`{"status":400,"error":{"status":400,"message":"required fields are not set, won't save the data"}},` +
+				`{"status":412,"error":{"status":412,"message":"book with id 1 does not match expected version"}},` +
+				`{"status":204,"result":{"resourceId":2}},` +
+				`{"status":404,"error":{"status":404,"message":"book with id 42 not found"}},` +
+				`{"status":204,"result":{"resourceId":3}},` +
+				`{"status":400,"error":{"status":400,"message":"unknown operation \"rename\", expected create, patch or delete"}}]`,
+			headerStatus: http.StatusMultiStatus,
+			titles:       map[int]string{1: "The Fellowship of the Ring", 2: "Towers", 6: "Ubik"},
+		},
+		{
+			url: "/api/books/batch?atomic=true",
+			body: `[{"op":"create","book":{"title":"Ubik","author":"Philip K. Dick"}},
+				{"op":"patch","id":2,"book":{"title":"Towers"}},
+				{"op":"delete","id":42},
+				{"op":"delete","id":3}]`,
+			data: `[{"status":424,"error":{"status":424,"message":"rolled back because operation 2 failed"}},` +
+				`{"status":424,"error":{"status":424,"message":"rolled back because operation 2 failed"}},` +
+				`{"status":404,"error":{"status":404,"message":"book with id 42 not found"}},` +
+				`{"status":424,"error":{"status":424,"message":"not run because operation 2 failed"}}]`,
+			headerStatus: http.StatusMultiStatus,
+			titles:       map[int]string{2: "The Two Towers", 3: "The Return of the King"},
+		},
+		{
+			url: "/api/books/batch?atomic=1",
+			body: `[{"op":"create","book":{"title":"Ubik","author":"Philip K. Dick"}},
+				{"op":"delete","id":6}]`,
+			data:         `[{"status":201,"result":{"resourceId":6}},{"status":204,"result":{"resourceId":6}}]`,
+			headerStatus: http.StatusMultiStatus,
+		},
+		{
+			url:          "/api/books/batch",
+			body:         `[{"op":"create","isbn":"123"}]`,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "invalid request model"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			url:          "/api/books/batch",
+			body:         `[]`,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "batch must hold from 1 to 100 operations"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			url:          "/api/books/batch?atomic=maybe",
+			body:         `[{"op":"delete","id":1}]`,
+			data:         invalidParamErr("atomic").Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tcases {
+		repo := seedRepo(t, NewMemoryRepo()).(*MemoryRepo)
+		audit := len(repo.audit)
+
+		w := &fakeWriter{}
+		rq, _ := http.NewRequest("POST", tc.url, strings.NewReader(tc.body))
+		API{repo: repo, stock: repo, inTx: database.RunMemoryTx}.BatchBooks(w, rq)
+
+		if tc.data != w.input {
+			t.Errorf("BatchBooks failed\nexpected %v\ngot %s", tc.data, w.input)
+		}
+		if tc.headerStatus != w.headerStatus {
+			t.Errorf("BatchBooks response header failed\nexpected %v\ngot  %v", tc.headerStatus, w.headerStatus)
+		}
+
+		for id, title := range tc.titles {
+			if b, err := repo.GetBookById(ctx, id); err != nil || b.Title != title {
+				t.Errorf("BatchBooks failed\nexpected book %d titled %q\ngot %+v, %v", id, title, b, err)
+			}
+		}
+		if tc.url == "/api/books/batch?atomic=true" && (len(repo.books) != 5 || len(repo.audit) != audit) {
+			t.Errorf("BatchBooks must roll back atomic batch\ngot %d books, %d audit entries", len(repo.books),
+				len(repo.audit))
+		}
+	}
+}
+
+func TestEditions(t *testing.T) {
+	repo := seedRepo(t, NewMemoryRepo()).(*MemoryRepo)
+	repo.publishers = fakePublishers{1: "Allen & Unwin"}
+	api := API{repo: repo, stock: repo, inTx: database.RunMemoryTx}
+
+	tcases := []struct {
+		method       string
+		url          string
+		id           string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		data         string
+		headerStatus int
+	}{
+		{
+			method:       "GET",
+			url:          "/api/books/1/editions",
+			id:           "1",
+			handler:      api.GetEditions,
+			data:         `{"items":[{"id":1,"bookId":1,"publisherId":null,"format":null,"isbn":null,"numberOfPages":432,"price":{"amount":"20.00","currency":"EUR"},"releaseDate":null}]}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/5/editions",
+			id:           "5",
+			body:         `{"publisherId":1,"format":" Paperback","price":{"amount":9,"currency":"eur"},"releaseDate":"1970-05-01"}`,
+			handler:      api.AddEdition,
+			data:         `{"resourceId":5}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "GET",
+			url:          "/api/editions/5",
+			id:           "5",
+			handler:      api.GetEdition,
+			data:         `{"id":5,"bookId":5,"publisherId":1,"format":"paperback","isbn":null,"numberOfPages":null,"price":{"amount":"9.00","currency":"EUR"},"releaseDate":"1970-05-01"}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/5",
+			id:           "5",
+			handler:      api.GetBook,
+			data:         `{"id":5,"title":"Solaris","author":"Stanislaw Lem","genre":"","numberOfPages":null,"price":{"amount":"9.00","currency":"EUR"},"releaseYear":null,"available":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/5/editions",
+			id:           "5",
+			body:         `{"format":"scroll"}`,
+			handler:      api.AddEdition,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "format must be one of hardcover, paperback, ebook, audiobook"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/5/editions",
+			id:           "5",
+			body:         `{"releaseDate":"1970"}`,
+			handler:      api.AddEdition,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "releaseDate must be a date like 2006-01-02"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/5/editions",
+			id:           "5",
+			body:         `{"publisherId":2}`,
+			handler:      api.AddEdition,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "publisher with id 2 not found"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/42/editions",
+			id:           "42",
+			body:         `{}`,
+			handler:      api.AddEdition,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "book with id 42 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/editions/5",
+			id:           "5",
+			body:         `{"numberOfPages":204}`,
+			handler:      api.UpdateEdition,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/5",
+			id:           "5",
+			handler:      api.GetBook,
+			data:         `{"id":5,"title":"Solaris","author":"Stanislaw Lem","genre":"","numberOfPages":204,"price":{"amount":"9.00","currency":"EUR"},"releaseYear":null,"available":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "DELETE",
+			url:          "/api/editions/5",
+			id:           "5",
+			handler:      api.RemoveEdition,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/editions/5",
+			id:           "5",
+			handler:      api.GetEdition,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "edition with id 5 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/5/editions",
+			id:           "5",
+			handler:      api.GetEditions,
+			data:         `{"items":[]}`,
+			headerStatus: http.StatusOK,
+		},
+	}
+
+	// cases run in order, each one sees changes of those before it
+	for _, tc := range tcases {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		rq.SetPathValue("id", tc.id)
+		tc.handler(w, rq)
+
+		if tc.data != w.Body.String() {
+			t.Errorf("%s %s failed\nexpected %v\ngot %v", tc.method, tc.url, tc.data, w.Body.String())
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("%s %s response header failed\nexpected %v\ngot  %v", tc.method, tc.url, tc.headerStatus, w.Code)
+		}
+	}
+}
+
+func TestNormalizeISBN(t *testing.T) {
+	tcases := []struct {
+		isbn     string
+		expected string
+		valid    bool
+	}{
+		{"0-306-40615-2", "9780306406157", true},
+		{"080442957x", "9780804429573", true},
+		{" 978 0 306 40615 7 ", "9780306406157", true},
+		{"9791000000008", "9791000000008", true},
+		{"0-306-40615-3", "", false},
+		{"9780306406158", "", false},
+		{"9770306406151", "", false},
+		{"03064X6152", "", false},
+		{"97803064061570", "", false},
+		{"", "", false},
+	}
+
+	for _, tc := range tcases {
+		isbn, err := normalizeISBN(tc.isbn)
+		if isbn != tc.expected || (err == nil) != tc.valid {
+			t.Errorf("normalizeISBN(%q) failed\nexpected %q, valid %v\ngot %q, %v", tc.isbn, tc.expected, tc.valid, isbn, err)
+		}
+	}
+}
+
+func TestGetBookByISBN(t *testing.T) {
+	repo := seedRepo(t, NewMemoryRepo()).(*MemoryRepo)
+	api := API{repo: repo, stock: repo, inTx: database.RunMemoryTx}
+
+	tcases := []struct {
+		method       string
+		url          string
+		pathValues   map[string]string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		data         string
+		headerStatus int
+	}{
+		{
+			method:       "PATCH",
+			url:          "/api/books/5",
+			pathValues:   map[string]string{"id": "5"},
+			body:         `{"isbn":"0-306-40615-2"}`,
+			handler:      api.UpdateBook,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/isbn/978-0-306-40615-7",
+			pathValues:   map[string]string{"isbn": "978-0-306-40615-7"},
+			handler:      api.GetBookByISBN,
+			data:         `{"id":5,"title":"Solaris","author":"Stanislaw Lem","genre":"","isbn":"9780306406157","numberOfPages":null,"price":null,"releaseYear":null,"available":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/isbn/0306406152",
+			pathValues:   map[string]string{"isbn": "0306406152"},
+			handler:      api.GetBookByISBN,
+			data:         `{"id":5,"title":"Solaris","author":"Stanislaw Lem","genre":"","isbn":"9780306406157","numberOfPages":null,"price":null,"releaseYear":null,"available":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/isbn/0306406153",
+			pathValues:   map[string]string{"isbn": "0306406153"},
+			handler:      api.GetBookByISBN,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: `isbn "0306406153" is not a valid ISBN-10 or ISBN-13`}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/isbn/9791000000008",
+			pathValues:   map[string]string{"isbn": "9791000000008"},
+			handler:      api.GetBookByISBN,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "book with isbn 9791000000008 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books",
+			body:         `{"title":"Eden","author":"Stanislaw Lem","isbn":"978-0-306-40615-7"}`,
+			handler:      api.AddBook,
+			data:         database.APIError{Status: http.StatusConflict, Message: "isbn 9780306406157 already belongs to another edition, trashed books included"}.Error(),
+			headerStatus: http.StatusConflict,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books",
+			body:         `{"title":"Eden","author":"Stanislaw Lem","isbn":"978-0-306"}`,
+			handler:      api.AddBook,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: `isbn "978-0-306" is not a valid ISBN-10 or ISBN-13`}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/4/editions",
+			pathValues:   map[string]string{"id": "4"},
+			body:         `{"isbn":"0306406152"}`,
+			handler:      api.AddEdition,
+			data:         database.APIError{Status: http.StatusConflict, Message: "isbn 9780306406157 already belongs to another edition, trashed books included"}.Error(),
+			headerStatus: http.StatusConflict,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/4/editions",
+			pathValues:   map[string]string{"id": "4"},
+			body:         `{"format":"paperback","isbn":"979-10-00000-00-8"}`,
+			handler:      api.AddEdition,
+			data:         `{"resourceId":6}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/isbn/9791000000008",
+			pathValues:   map[string]string{"isbn": "9791000000008"},
+			handler:      api.GetBookByISBN,
+			data:         `{"id":4,"title":"Dune","author":"Frank Herbert","genre":"science fiction","numberOfPages":412,"price":{"amount":"15.00","currency":"EUR"},"releaseYear":1965,"available":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "DELETE",
+			url:          "/api/books/5",
+			pathValues:   map[string]string{"id": "5"},
+			handler:      api.RemoveBook,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/isbn/9780306406157",
+			pathValues:   map[string]string{"isbn": "9780306406157"},
+			handler:      api.GetBookByISBN,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "book with isbn 9780306406157 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+	}
+
+	// cases run in order, each one sees changes of those before it
+	for _, tc := range tcases {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		for name, value := range tc.pathValues {
+			rq.SetPathValue(name, value)
+		}
+		tc.handler(w, rq)
+
+		if tc.data != w.Body.String() {
+			t.Errorf("%s %s failed\nexpected %v\ngot %v", tc.method, tc.url, tc.data, w.Body.String())
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("%s %s response header failed\nexpected %v\ngot  %v", tc.method, tc.url, tc.headerStatus, w.Code)
+		}
+	}
+}
+
+func TestSeries(t *testing.T) {
+	memory := NewMemoryRepo()
+	memory.series = fakeSeries{1: "The Lord of the Rings"}
+	repo := seedRepo(t, memory).(*MemoryRepo)
+	api := API{repo: repo, stock: repo, inTx: database.RunMemoryTx}
+
+	// books are left open, links and available copies follow
+	fellowship := `{"id":1,"title":"The Fellowship of the Ring","author":"JRR Tolkien","genre":"fantasy","numberOfPages":432,"price":{"amount":"20.00","currency":"EUR"},"releaseYear":1954,"seriesId":1,"seriesPosition":1`
+	towers := `{"id":2,"title":"The Two Towers","author":"JRR Tolkien","genre":"fantasy","numberOfPages":352,"price":{"amount":"20.00","currency":"EUR"},"releaseYear":1954,"seriesId":1,"seriesPosition":2`
+	king := `{"id":3,"title":"The Return of the King","author":"JRR Tolkien","genre":"fantasy","numberOfPages":null,"price":{"amount":"25.00","currency":"EUR"},"releaseYear":1955,"seriesId":1,"seriesPosition":3`
+
+	tcases := []struct {
+		method       string
+		url          string
+		pathValues   map[string]string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		data         string
+		headerStatus int
+	}{
+		{
+			method:       "PATCH",
+			url:          "/api/books/1",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"seriesId":1,"seriesPosition":1}`,
+			handler:      api.UpdateBook,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/books/3",
+			pathValues:   map[string]string{"id": "3"},
+			body:         `{"seriesId":1,"seriesPosition":3}`,
+			handler:      api.UpdateBook,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/books/2",
+			pathValues:   map[string]string{"id": "2"},
+			body:         `{"seriesId":1,"seriesPosition":2}`,
+			handler:      api.UpdateBook,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/2",
+			pathValues:   map[string]string{"id": "2"},
+			handler:      api.GetBook,
+			data:         towers + `,"previousInSeries":{"id":1,"title":"The Fellowship of the Ring","href":"/api/books/1"},"nextInSeries":{"id":3,"title":"The Return of the King","href":"/api/books/3"},"available":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/3",
+			pathValues:   map[string]string{"id": "3"},
+			handler:      api.GetBook,
+			data:         king + `,"previousInSeries":{"id":2,"title":"The Two Towers","href":"/api/books/2"},"available":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.GetBook,
+			data:         fellowship + `,"nextInSeries":{"id":2,"title":"The Two Towers","href":"/api/books/2"},"available":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/books/4",
+			pathValues:   map[string]string{"id": "4"},
+			body:         `{"seriesPosition":1}`,
+			handler:      api.UpdateBook,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "seriesPosition needs seriesId"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/books/4",
+			pathValues:   map[string]string{"id": "4"},
+			body:         `{"seriesId":1,"seriesPosition":0}`,
+			handler:      api.UpdateBook,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "seriesPosition must be greater than 0"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/books/4",
+			pathValues:   map[string]string{"id": "4"},
+			body:         `{"seriesId":2}`,
+			handler:      api.UpdateBook,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "series with id 2 not found"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/books/2",
+			pathValues:   map[string]string{"id": "2"},
+			body:         `{"seriesId":null}`,
+			handler:      api.UpdateBook,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.GetBook,
+			data:         fellowship + `,"nextInSeries":{"id":3,"title":"The Return of the King","href":"/api/books/3"},"available":0}`,
+			headerStatus: http.StatusOK,
+		},
+	}
+
+	// cases run in order, each one sees changes of those before it
+	for _, tc := range tcases {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		for name, value := range tc.pathValues {
+			rq.SetPathValue(name, value)
+		}
+		tc.handler(w, rq)
+
+		if tc.data != w.Body.String() {
+			t.Errorf("%s %s failed\nexpected %v\ngot %v", tc.method, tc.url, tc.data, w.Body.String())
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("%s %s response header failed\nexpected %v\ngot  %v", tc.method, tc.url, tc.headerStatus, w.Code)
+		}
+	}
+}
+
+func TestStock(t *testing.T) {
+	repo := seedRepo(t, NewMemoryRepo()).(*MemoryRepo)
+	api := API{repo: repo, stock: repo, inTx: database.RunMemoryTx}
+	stock := api.Stock()
+
+	// the book is left open, available copies follow
+	fellowship := `{"id":1,"title":"The Fellowship of the Ring","author":"JRR Tolkien","genre":"fantasy","numberOfPages":432,"price":{"amount":"20.00","currency":"EUR"},"releaseYear":1954`
+
+	tcases := []struct {
+		method       string
+		url          string
+		pathValues   map[string]string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		data         string
+		prefix       bool
+		headerStatus int
+	}{
+		{
+			method:       "POST",
+			url:          "/api/books/1/stock/adjust",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"location":" berlin ","quantity":5,"reason":"Received"}`,
+			handler:      stock.AdjustStock,
+			data:         `{"resourceId":1}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/stock/adjust",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"location":"paris","quantity":2,"reason":"received"}`,
+			handler:      stock.AdjustStock,
+			data:         `{"resourceId":2}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/stock/adjust",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"location":"berlin","quantity":1,"reason":"stolen"}`,
+			handler:      stock.AdjustStock,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "reason must be one of received, sold, damaged"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/stock/adjust",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"location":"berlin","quantity":0,"reason":"sold"}`,
+			handler:      stock.AdjustStock,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "quantity must be greater than 0"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/42/stock/adjust",
+			pathValues:   map[string]string{"id": "42"},
+			body:         `{"location":"berlin","quantity":1,"reason":"received"}`,
+			handler:      stock.AdjustStock,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "book with id 42 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/stock/reservations",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"location":"berlin","quantity":3}`,
+			handler:      stock.ReserveStock,
+			data:         `{"id":1,"bookId":1,"location":"berlin","quantity":3,"expiresAt":`,
+			prefix:       true,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.GetBook,
+			data:         fellowship + `,"available":4}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/stock/adjust",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"location":"berlin","quantity":3,"reason":"sold"}`,
+			handler:      stock.AdjustStock,
+			data:         database.APIError{Status: http.StatusConflict, Message: "book with id 1 has 2 copies available at berlin, can't take 3"}.Error(),
+			headerStatus: http.StatusConflict,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/stock/adjust",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"location":"berlin","quantity":4,"reason":"sold","reservationId":1}`,
+			handler:      stock.AdjustStock,
+			data:         `{"resourceId":3}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/1/stock",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      stock.GetStock,
+			data:         `{"bookId":1,"available":3,"locations":[{"location":"berlin","quantity":1,"reserved":0},{"location":"paris","quantity":2,"reserved":0}]}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "DELETE",
+			url:          "/api/reservations/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      stock.ReleaseReservation,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "reservation with id 1 not found or expired"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/stock/reservations",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"location":"paris","quantity":3}`,
+			handler:      stock.ReserveStock,
+			data:         database.APIError{Status: http.StatusConflict, Message: "book with id 1 has 2 copies available at paris, can't take 3"}.Error(),
+			headerStatus: http.StatusConflict,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/stock/reservations",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"location":"paris","quantity":2}`,
+			handler:      stock.ReserveStock,
+			data:         `{"id":2,"bookId":1,"location":"paris","quantity":2,"expiresAt":`,
+			prefix:       true,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "DELETE",
+			url:          "/api/reservations/2",
+			pathValues:   map[string]string{"id": "2"},
+			handler:      stock.ReleaseReservation,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/stock/adjust",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"location":"paris","quantity":1,"reason":"damaged","reservationId":2}`,
+			handler:      stock.AdjustStock,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "reservationId needs reason sold"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/1/stock/movements?limit=1&offset=2",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      stock.GetStockMovements,
+			data:         `{"items":[{"id":3,"bookId":1,"location":"berlin","delta":-4,"reason":"sold","reservationId":1,"actor":"system","requestId":"","createdAt":`,
+			prefix:       true,
+			headerStatus: http.StatusOK,
+		},
+	}
+
+	// cases run in order, each one sees changes of those before it
+	for _, tc := range tcases {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		for name, value := range tc.pathValues {
+			rq.SetPathValue(name, value)
+		}
+		tc.handler(w, rq)
+
+		// reservations and movements carry timestamps, only their start is compared
+		if got := w.Body.String(); tc.data != got && !(tc.prefix && strings.HasPrefix(got, tc.data)) {
+			t.Errorf("%s %s failed\nexpected %v\ngot %v", tc.method, tc.url, tc.data, got)
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("%s %s response header failed\nexpected %v\ngot  %v", tc.method, tc.url, tc.headerStatus, w.Code)
+		}
+	}
+}
+
+func TestPrices(t *testing.T) {
+	tcases := []struct {
+		body     string
+		expected *money.Money
+		err      string
+	}{
+		{body: `{"price":{"amount":"19.99","currency":"eur"}}`, expected: &money.Money{Amount: 1999, Currency: "EUR"}},
+		{body: `{"price":{"amount":19.9,"currency":"USD"}}`, expected: &money.Money{Amount: 1990, Currency: "USD"}},
+		{body: `{"price":{"amount":"1500","currency":"JPY"}}`, expected: &money.Money{Amount: 1500, Currency: "JPY"}},
+		{body: `{"price":null}`},
+		{body: `{"price":{"amount":"19.99","currency":"XYZ"}}`, err: `price currency "XYZ" is not an ISO 4217 code`},
+		{body: `{"price":{"amount":"-1","currency":"EUR"}}`, err: "price amount must not be negative"},
+		{body: `{"price":{"amount":"19.999","currency":"EUR"}}`, err: "price amount 19.999 has more than 2 decimal places of EUR"},
+		{body: `{"price":{"amount":"19.99"}}`, err: `price must have amount and currency, e.g. {"amount":"19.99","currency":"EUR"}`},
+		{body: `{"price":20}`, err: `price must have amount and currency, e.g. {"amount":"19.99","currency":"EUR"}`},
+	}
+
+	for _, tc := range tcases {
+		var b bookRequestBody
+		if err := json.Unmarshal([]byte(tc.body), &b); err != nil {
+			t.Errorf("%s failed\nunexpected error %s", tc.body, err.Error())
+			continue
+		}
+		err := b.validate()
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%s expected error %q\ngot %v", tc.body, tc.err, err)
+			}
+			continue
+		}
+		if got := b.price(); err != nil || !samePrice(got, tc.expected) {
+			t.Errorf("%s failed\nexpected %v\ngot %v, %v", tc.body, tc.expected, got, err)
+		}
+	}
+
+	var b bookRequestBody
+	if err := json.Unmarshal([]byte(`{"price":{"amount":"1","currency":"EUR","cents":100}}`), &b); err == nil {
+		t.Errorf("price with unknown field expected error")
+	}
+
+	j, _ := json.Marshal(newPriceDTO(euros(20)))
+	if string(j) != `{"amount":"20.00","currency":"EUR"}` {
+		t.Errorf("price failed\nexpected {\"amount\":\"20.00\",\"currency\":\"EUR\"}\ngot %s", j)
+	}
+	// integer clients get whole units of the default currency, other prices keep amount and currency
+	plainCases := []struct {
+		price    money.Money
+		expected string
+	}{
+		{money.Money{Amount: 2000, Currency: "EUR"}, `20`},
+		{money.Money{Amount: 1999, Currency: "EUR"}, `{"amount":"19.99","currency":"EUR"}`},
+		{money.Money{Amount: 2000, Currency: "USD"}, `{"amount":"20.00","currency":"USD"}`},
+	}
+	for _, tc := range plainCases {
+		j, _ := json.Marshal(&priceDTO{Amount: tc.price.Decimal(), Currency: tc.price.Currency, plain: plainAmount(tc.price)})
+		if string(j) != tc.expected {
+			t.Errorf("plain price of %s failed\nexpected %s\ngot %s", tc.price, tc.expected, j)
+		}
+	}
+	dto := bookDTO{ID: 1, Price: &priceDTO{Amount: "20.00", Currency: "EUR", plain: plainAmount(*euros(20))}}
+	j, _ = json.Marshal(dto)
+	var client struct {
+		Price *int `json:"price"`
+	}
+	if err := json.Unmarshal(j, &client); err != nil || client.Price == nil || *client.Price != 20 {
+		t.Errorf("integer client must read plain price\ngot %s, %v", j, err)
+	}
+}
+
+func TestPriceSchedule(t *testing.T) {
+	repo := seedRepo(t, NewMemoryRepo()).(*MemoryRepo)
+	api := API{repo: repo, stock: repo, prices: repo, inTx: database.RunMemoryTx}
+	pricesApi := api.Prices()
+
+	future := time.Now().Add(time.Hour).UTC().Truncate(time.Second).Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	tcases := []struct {
+		method       string
+		url          string
+		pathValues   map[string]string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		data         string
+		prefix       bool
+		headerStatus int
+	}{
+		{
+			method:       "POST",
+			url:          "/api/books/1/prices",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"price":{"amount":"14.99","currency":"EUR"},"effectiveFrom":"` + future + `"}`,
+			handler:      pricesApi.SchedulePrice,
+			data:         `{"resourceId":1}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/prices",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"price":{"amount":"14.99","currency":"EUR"},"effectiveFrom":"` + past + `"}`,
+			handler:      pricesApi.SchedulePrice,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "effectiveFrom must be a time in the future"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/prices",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"effectiveFrom":"` + future + `"}`,
+			handler:      pricesApi.SchedulePrice,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "price is required"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/prices",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"price":{"amount":"-1","currency":"EUR"},"effectiveFrom":"` + future + `"}`,
+			handler:      pricesApi.SchedulePrice,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "price amount must not be negative"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/42/prices",
+			pathValues:   map[string]string{"id": "42"},
+			body:         `{"price":{"amount":"1","currency":"EUR"},"effectiveFrom":"` + future + `"}`,
+			handler:      pricesApi.SchedulePrice,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "book with id 42 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/books/1",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"price":{"amount":"18","currency":"EUR"}}`,
+			handler:      api.UpdateBook,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/1/prices",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      pricesApi.GetPrices,
+			data:         `{"bookId":1,"history":[{"id":1,"price":{"amount":"20.00","currency":"EUR"},"effectiveFrom":"`,
+			prefix:       true,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/5/prices",
+			pathValues:   map[string]string{"id": "5"},
+			handler:      pricesApi.GetPrices,
+			data:         `{"bookId":5,"history":[],"scheduled":[]}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/42/prices",
+			pathValues:   map[string]string{"id": "42"},
+			handler:      pricesApi.GetPrices,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "book with id 42 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range tcases {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		for name, value := range tc.pathValues {
+			rq.SetPathValue(name, value)
+		}
+		tc.handler(w, rq)
+
+		// price history carries timestamps, only its start is compared
+		if got := w.Body.String(); tc.data != got && !(tc.prefix && strings.HasPrefix(got, tc.data)) {
+			t.Errorf("%s %s failed\nexpected %v\ngot %v", tc.method, tc.url, tc.data, got)
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("%s %s response header failed\nexpected %v\ngot  %v", tc.method, tc.url, tc.headerStatus, w.Code)
+		}
+	}
+
+	var prices pricesDTO
+	w := httptest.NewRecorder()
+	rq := httptest.NewRequest("GET", "/api/books/1/prices", nil)
+	rq.SetPathValue("id", "1")
+	pricesApi.GetPrices(w, rq)
+	if err := json.Unmarshal(w.Body.Bytes(), &prices); err != nil || len(prices.History) != 2 || prices.History[0].EffectiveTo == nil ||
+		prices.History[1].EffectiveTo != nil || prices.History[1].Price.Amount != "18.00" || len(prices.Scheduled) != 1 ||
+		prices.Scheduled[0].EffectiveFrom.Format(time.RFC3339) != future {
+		t.Errorf("GET /api/books/1/prices failed\ngot %s", w.Body.String())
+	}
+}
+
+func TestReviews(t *testing.T) {
+	repo := seedRepo(t, NewMemoryRepo()).(*MemoryRepo)
+	api := API{repo: repo, stock: repo, reviews: repo, inTx: database.RunMemoryTx}
+	reviews := api.Reviews()
+
+	tcases := []struct {
+		method       string
+		url          string
+		pathValues   map[string]string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		data         string
+		prefix       bool
+		headerStatus int
+	}{
+		{
+			method:       "POST",
+			url:          "/api/books/1/reviews",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"rating":5,"text":" Even the smallest person can change the course of the future. ","author":"Sam"}`,
+			handler:      reviews.AddReview,
+			data:         `{"resourceId":1}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/reviews",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"rating":2,"author":"Gollum"}`,
+			handler:      reviews.AddReview,
+			data:         `{"resourceId":2}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/reviews",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"rating":6,"author":"Sam"}`,
+			handler:      reviews.AddReview,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "rating must be between 1 and 5"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/reviews",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"rating":4,"author":"  "}`,
+			handler:      reviews.AddReview,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "author is required"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/1/reviews",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"rating":4,"author":"Sam","status":"approved"}`,
+			handler:      reviews.AddReview,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "invalid request model"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/books/42/reviews",
+			pathValues:   map[string]string{"id": "42"},
+			body:         `{"rating":4,"author":"Sam"}`,
+			handler:      reviews.AddReview,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "book with id 42 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/1/reviews",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      reviews.GetBookReviews,
+			data:         `{"items":[],"total":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/reviews",
+			handler:      reviews.GetReviews,
+			data:         `{"items":[{"id":1,"bookId":1,"rating":5,"text":"Even the smallest person can change the course of the future.","author":"Sam","status":"pending","createdAt":"`,
+			prefix:       true,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/reviews?status=spam",
+			handler:      reviews.GetReviews,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "invalid value for status query parameter"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/reviews/1",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"status":"approved"}`,
+			handler:      reviews.ModerateReview,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/reviews/2",
+			pathValues:   map[string]string{"id": "2"},
+			body:         `{"status":"Rejected"}`,
+			handler:      reviews.ModerateReview,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/reviews/1",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"status":"spam"}`,
+			handler:      reviews.ModerateReview,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "status must be one of pending, approved, rejected"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/reviews/42",
+			pathValues:   map[string]string{"id": "42"},
+			body:         `{"status":"approved"}`,
+			handler:      reviews.ModerateReview,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "review with id 42 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/1/reviews?limit=1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      reviews.GetBookReviews,
+			data:         `{"items":[{"id":1,"bookId":1,"rating":5,"text":"Even the smallest person can change the course of the future.","author":"Sam","status":"approved","createdAt":"`,
+			prefix:       true,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/1/reviews?status=pending",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      reviews.GetBookReviews,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "unknown query parameter status"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "GET",
+			url:          "/api/reviews?status=rejected",
+			handler:      reviews.GetReviews,
+			data:         `{"items":[{"id":2,"bookId":1,"rating":2,"text":"","author":"Gollum","status":"rejected","createdAt":"`,
+			prefix:       true,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/42/reviews",
+			pathValues:   map[string]string{"id": "42"},
+			handler:      reviews.GetBookReviews,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "book with id 42 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range tcases {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		for name, value := range tc.pathValues {
+			rq.SetPathValue(name, value)
+		}
+		tc.handler(w, rq)
+
+		// reviews carry timestamps, only their start is compared
+		if got := w.Body.String(); tc.data != got && !(tc.prefix && strings.HasPrefix(got, tc.data)) {
+			t.Errorf("%s %s failed\nexpected %v\ngot %v", tc.method, tc.url, tc.data, got)
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("%s %s response header failed\nexpected %v\ngot  %v", tc.method, tc.url, tc.headerStatus, w.Code)
+		}
+	}
+
+	var book bookDTO
+	w := httptest.NewRecorder()
+	rq := httptest.NewRequest("GET", "/api/books/1", nil)
+	rq.SetPathValue("id", "1")
+	api.GetBook(w, rq)
+	if err := json.Unmarshal(w.Body.Bytes(), &book); err != nil || book.AverageRating == nil || *book.AverageRating != 5 ||
+		book.RatingCount != 1 {
+		t.Errorf("GET /api/books/1 must rate the book with approved reviews\ngot %s", w.Body.String())
+	}
+}
+
+func TestStockETag(t *testing.T) {
+	repo := seedRepo(t, NewMemoryRepo()).(*MemoryRepo)
+	api := API{repo: repo, stock: repo, inTx: database.RunMemoryTx}
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest("GET", "/api/books/1", nil)
+		rq.SetPathValue("id", "1")
+		if ifNoneMatch != "" {
+			rq.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		api.GetBook(w, rq)
+		return w
+	}
+
+	etag := get("").Header().Get("ETag")
+	if w := get(etag); w.Code != http.StatusNotModified {
+		t.Fatalf("GET /api/books/1 with If-None-Match %s failed\nexpected %v\ngot  %v", etag, http.StatusNotModified, w.Code)
+	}
+
+	location, quantity, reason := "berlin", 3, stockReceived
+	if _, err := repo.AdjustStock(ctx, 1, stockAdjustRequestBody{Location: &location, Quantity: &quantity, Reason: &reason}); err != nil {
+		t.Fatalf("AdjustStock failed\nunexpected error %s", err.Error())
+	}
+
+	var book bookDTO
+	w := get(etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("GET /api/books/1 after stock change must not match the old ETag %s\ngot %v %s", etag, w.Code, w.Header().Get("ETag"))
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &book); err != nil || book.Available == nil || *book.Available != 3 {
+		t.Errorf("GET /api/books/1 after stock change must send available copies\ngot %s", w.Body.String())
+	}
+}
+
+type txKey struct{}
+
+func TestWritesInUnitOfWork(t *testing.T) {
+	var outcomes []error
+	inTx := func(ctx context.Context, fn func(ctx context.Context) error) error {
+		err := fn(context.WithValue(ctx, txKey{}, true))
+		outcomes = append(outcomes, err)
+		return err
+	}
+	inUnit := func(ctx context.Context) error {
+		if ctx.Value(txKey{}) == nil {
+			return errors.New("write outside of unit of work")
+		}
+		return nil
+	}
+	repo := fakeRepo{
+		addbookAction: func(ctx context.Context, _ bookRequestBody) (int, error) {
+			return 1, inUnit(ctx)
+		},
+		updateBookAction: func(ctx context.Context, _ int, _ bookRequestBody, _ *int) (int, error) {
+			return 2, inUnit(ctx)
+		},
+		removeBookAction: func(ctx context.Context, id int, _ *int) error {
+			if err := inUnit(ctx); err != nil {
+				return err
+			}
+			return database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", id)}
+		},
+	}
+	api := API{repo: repo, stock: fakeStock{}, inTx: inTx}
+
+	tcases := []struct {
+		method       string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		headerStatus int
+		committed    bool
+	}{
+		{method: "POST", body: `{"title":"Solaris","author":"Stanislaw Lem","genre":"fantasy"}`, handler: api.AddBook,
+			headerStatus: http.StatusCreated, committed: true},
+		{method: "PATCH", body: `{"title":"Solaris"}`, handler: api.UpdateBook, headerStatus: http.StatusNoContent, committed: true},
+		{method: "DELETE", handler: api.RemoveBook, headerStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tcases {
+		outcomes = nil
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, "/api/books/1", strings.NewReader(tc.body))
+		rq.SetPathValue("id", "1")
+		tc.handler(w, rq)
+
+		if w.Code != tc.headerStatus {
+			t.Errorf("%s /api/books/1 failed\nexpected %v\ngot  %v %s", tc.method, tc.headerStatus, w.Code, w.Body.String())
+		}
+		if len(outcomes) != 1 || (outcomes[0] == nil) != tc.committed {
+			t.Errorf("%s /api/books/1 must run one unit of work which commits only when the handler succeeds\ngot %v",
+				tc.method, outcomes)
+		}
+	}
+}
+
+func TestModerateReviewETag(t *testing.T) {
+	repo := seedRepo(t, NewMemoryRepo()).(*MemoryRepo)
+	api := API{repo: repo, stock: repo, reviews: repo, inTx: database.RunMemoryTx}
+	reviews := api.Reviews()
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest("GET", "/api/books/1", nil)
+		rq.SetPathValue("id", "1")
+		if ifNoneMatch != "" {
+			rq.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		api.GetBook(w, rq)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	rq := httptest.NewRequest("POST", "/api/books/1/reviews", strings.NewReader(`{"rating":4,"author":"Sam"}`))
+	rq.SetPathValue("id", "1")
+	reviews.AddReview(w, rq)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /api/books/1/reviews failed\ngot %v %s", w.Code, w.Body.String())
+	}
+
+	etag := get("").Header().Get("ETag")
+	if w := get(etag); w.Code != http.StatusNotModified {
+		t.Fatalf("GET /api/books/1 with If-None-Match %s failed\nexpected %v\ngot  %v", etag, http.StatusNotModified, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	rq = httptest.NewRequest("PATCH", "/api/reviews/1", strings.NewReader(`{"status":"approved"}`))
+	rq.SetPathValue("id", "1")
+	reviews.ModerateReview(w, rq)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("PATCH /api/reviews/1 failed\ngot %v %s", w.Code, w.Body.String())
+	}
+
+	var book bookDTO
+	w = get(etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("GET /api/books/1 after approval must not match the old ETag %s\ngot %v %s", etag, w.Code, w.Header().Get("ETag"))
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &book); err != nil || book.RatingCount != 1 {
+		t.Errorf("GET /api/books/1 after approval must count the review\ngot %s", w.Body.String())
+	}
+}
+
+func TestFindBookOffers(t *testing.T) {
+	repo := seedRepo(t, NewMemoryRepo()).(*MemoryRepo)
+	api := API{repo: repo, stock: repo, inTx: database.RunMemoryTx}
+
+	location, quantity, reason := "berlin", 3, stockReceived
+	if _, err := repo.AdjustStock(ctx, 1, stockAdjustRequestBody{Location: &location, Quantity: &quantity, Reason: &reason}); err != nil {
+		t.Fatalf("AdjustStock failed\nunexpected error %s", err.Error())
+	}
+
+	offers, err := api.FindBookOffers(ctx, []int{1, 5, 42})
+	if err != nil || len(offers) != 2 {
+		t.Fatalf("FindBookOffers failed\nexpected offers of books 1 and 5\ngot %v, %v", offers, err)
+	}
+	if o := offers[1]; o.Title != "The Fellowship of the Ring" || o.Price == nil || o.Price.String() != "20.00 EUR" || o.Available != 3 {
+		t.Errorf("FindBookOffers failed\ngot %+v", o)
+	}
+	if o := offers[5]; o.Price != nil || o.Available != 0 {
+		t.Errorf("FindBookOffers of book without price and stock failed\ngot %+v", o)
+	}
+}
+
+func TestBookAuthors(t *testing.T) {
+	repo := NewMemoryRepo()
+	api := API{repo: repo, stock: repo, inTx: database.RunMemoryTx}
+	authorsApi := authors.New(api)
+	api.UseAuthors(authorsApi)
+
+	tcases := []struct {
+		method       string
+		url          string
+		pathValues   map[string]string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		data         string
+		headerStatus int
+	}{
+		{
+			method:       "POST",
+			url:          "/api/books",
+			body:         `{"title":"Good Omens","author":"Terry Pratchett & Neil Gaiman"}`,
+			handler:      api.AddBook,
+			data:         `{"resourceId":1}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "GET",
+			url:          "/api/authors/2/books",
+			pathValues:   map[string]string{"id": "2"},
+			handler:      authorsApi.GetAuthorBooks,
+			data:         `{"books":[{"id":1,"title":"Good Omens","releaseYear":null}],"total":1,"limit":20,"offset":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/books/1",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"author":"Neil Gaiman"}`,
+			handler:      api.UpdateBook,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/authors/1/books",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      authorsApi.GetAuthorBooks,
+			data:         `{"books":[],"total":0,"limit":20,"offset":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/authors/2",
+			pathValues:   map[string]string{"id": "2"},
+			body:         `{"name":"Neil Richard Gaiman"}`,
+			handler:      authorsApi.UpdateAuthor,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/books/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.GetBook,
+			data:         `{"id":1,"title":"Good Omens","author":"Neil Richard Gaiman","genre":"","numberOfPages":null,"price":null,"releaseYear":null,"available":0}`,
+			headerStatus: http.StatusOK,
+		},
+	}
+
+	// cases run in order, each one sees changes of those before it
+	for _, tc := range tcases {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		for name, value := range tc.pathValues {
+			rq.SetPathValue(name, value)
+		}
+		tc.handler(w, rq)
+
+		if tc.data != w.Body.String() {
+			t.Errorf("%s %s failed\nexpected %v\ngot %v", tc.method, tc.url, tc.data, w.Body.String())
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("%s %s response header failed\nexpected %v\ngot  %v", tc.method, tc.url, tc.headerStatus, w.Code)
+		}
+	}
+}
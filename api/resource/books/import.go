@@ -0,0 +1,284 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxImportRows keeps a single import small enough to fit in one transaction
+	maxImportRows = 10_000
+	// maxImportSize limits request body of an import in bytes
+	maxImportSize = 16 << 20
+	// maxImportLine limits length of a single ndjson line in bytes
+	maxImportLine = 1 << 20
+)
+
+const (
+	mediaTypeCSV    = "text/csv"
+	mediaTypeNDJSON = "application/x-ndjson"
+)
+
+// csvColumns maps csv header names, which follow json field names of bookRequestBody, to field setters
+var csvColumns = map[string]func(b *bookRequestBody, value string) error{
+	"title":  func(b *bookRequestBody, v string) error { b.Title = &v; return nil },
+	"author": func(b *bookRequestBody, v string) error { b.Author = &v; return nil },
+	"genre":  func(b *bookRequestBody, v string) error { b.Genre = &v; return nil },
+	"isbn":   func(b *bookRequestBody, v string) error { b.ISBN = &v; return nil },
+	"numberofpages": func(b *bookRequestBody, v string) error {
+		return setIntColumn(&b.NumberOfPages, "numberOfPages", v)
+	},
+	// price and currency may come in any order, they are checked together by validate
+	"price": func(b *bookRequestBody, v string) error {
+		if b.Price == nil {
+			b.Price = &priceBody{}
+		}
+		b.Price.Amount = json.Number(v)
+		return nil
+	},
+	"currency": func(b *bookRequestBody, v string) error {
+		if b.Price == nil {
+			b.Price = &priceBody{}
+		}
+		b.Price.Currency = v
+		return nil
+	},
+	"releaseyear": func(b *bookRequestBody, v string) error {
+		return setIntColumn(&b.ReleaseYear, "releaseYear", v)
+	},
+	"seriesid": func(b *bookRequestBody, v string) error {
+		b.SeriesID.Set = true
+		return setIntColumn(&b.SeriesID.Value, "seriesId", v)
+	},
+	"seriesposition": func(b *bookRequestBody, v string) error {
+		return setIntColumn(&b.SeriesPosition, "seriesPosition", v)
+	},
+}
+
+func setIntColumn(field **int, name, value string) error {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer", name)
+	}
+	*field = &v
+	return nil
+}
+
+func importErr(format string, args ...any) *database.APIError {
+	return &database.APIError{
+		Status:  http.StatusBadRequest,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// importReadErr explains why import body could not be read
+func importReadErr(prefix string, err error) *database.APIError {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &database.APIError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("import is limited to %d bytes", tooLarge.Limit),
+		}
+	}
+	return importErr("%s -> %s", prefix, err.Error())
+}
+
+// parseImport reads records of an import file of given media type, records which can't be
+// read into a book or break AddBook rules are returned with Errors set.
+// Errors which make the rest of the file unreadable are returned as database.APIError
+func parseImport(mediaType string, body io.Reader) ([]importRow, *database.APIError) {
+	var rows []importRow
+	var e *database.APIError
+
+	switch mediaType {
+	case mediaTypeCSV:
+		rows, e = parseCSVImport(body)
+	case mediaTypeNDJSON:
+		rows, e = parseNDJSONImport(body)
+	default:
+		return nil, &database.APIError{
+			Status:  http.StatusUnsupportedMediaType,
+			Message: fmt.Sprintf("import accepts %s or %s content only", mediaTypeCSV, mediaTypeNDJSON),
+		}
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	for i := range rows {
+		if len(rows[i].Errors) > 0 {
+			continue
+		}
+		if err := rows[i].Book.validateNew(); err != nil {
+			rows[i].Errors = append(rows[i].Errors, err.Error())
+		}
+	}
+
+	// isbn of an earlier row would make the whole import fail on saving, so the later row is rejected here
+	isbnLines := map[string]int{}
+	for i := range rows {
+		isbn := rows[i].Book.ISBN
+		if len(rows[i].Errors) > 0 || isbn == nil {
+			continue
+		}
+		if line, ok := isbnLines[*isbn]; ok {
+			rows[i].Errors = append(rows[i].Errors, fmt.Sprintf("isbn %s is already used on line %d", *isbn, line))
+			continue
+		}
+		isbnLines[*isbn] = rows[i].Line
+	}
+
+	return rows, nil
+}
+
+func parseCSVImport(body io.Reader) ([]importRow, *database.APIError) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, importErr("csv must start with a header row")
+	}
+	if err != nil {
+		return nil, importReadErr("invalid csv", err)
+	}
+
+	setters := make([]func(*bookRequestBody, string) error, len(header))
+	seen := map[string]bool{}
+	for i, name := range header {
+		// spreadsheets often save csv with byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF")))
+		setter, ok := csvColumns[name]
+		if !ok {
+			return nil, importErr("unknown csv column %s", header[i])
+		}
+		if seen[name] {
+			return nil, importErr("duplicate csv column %s", header[i])
+		}
+		seen[name] = true
+		setters[i] = setter
+	}
+
+	rows := make([]importRow, 0)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, importReadErr("invalid csv", err)
+		}
+		if len(rows) == maxImportRows {
+			return nil, importErr("import is limited to %d rows", maxImportRows)
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := importRow{Line: line}
+		if len(record) != len(header) {
+			row.Errors = append(row.Errors,
+				fmt.Sprintf("row has %d fields, header has %d", len(record), len(header)))
+			rows = append(rows, row)
+			continue
+		}
+
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			if err := setters[i](&row.Book, value); err != nil {
+				row.Errors = append(row.Errors, err.Error())
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func parseNDJSONImport(body io.Reader) ([]importRow, *database.APIError) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxImportLine)
+
+	rows := make([]importRow, 0)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, importErr("import is limited to %d rows", maxImportRows)
+		}
+
+		row := importRow{Line: line}
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&row.Book); err != nil {
+			row.Errors = append(row.Errors, "invalid json -> "+err.Error())
+		} else if decoder.More() {
+			row.Errors = append(row.Errors, "line must hold a single json object")
+		}
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, importErr("line %d is longer than %d bytes", line+1, maxImportLine)
+		}
+		return nil, importReadErr("could not read import", err)
+	}
+
+	return rows, nil
+}
+
+// importRejects holds errors repositories find in import rows while saving them, by index of the row.
+// They are put on the rows once the import is done, so that a retried transaction starts over without them
+type importRejects map[int][]string
+
+func (r importRejects) add(i int, err error) {
+	r[i] = append(r[i], err.Error())
+}
+
+// isAccepted tells whether row i is still going to be imported
+func (r importRejects) isAccepted(rows []importRow, i int) bool {
+	return len(rows[i].Errors) == 0 && len(r[i]) == 0
+}
+
+// accepted returns books of rows which are still going to be imported
+func (r importRejects) accepted(rows []importRow) []bookRequestBody {
+	books := make([]bookRequestBody, 0, len(rows))
+	for i, row := range rows {
+		if r.isAccepted(rows, i) {
+			books = append(books, row.Book)
+		}
+	}
+	return books
+}
+
+// apply adds the errors to their rows
+func (r importRejects) apply(rows []importRow) {
+	for i, errs := range r {
+		rows[i].Errors = append(rows[i].Errors, errs...)
+	}
+}
+
+// cloneImportRows copies rows so that errors added to the copy don't reach rows
+func cloneImportRows(rows []importRow) []importRow {
+	cloned := slices.Clone(rows)
+	for i := range cloned {
+		cloned[i].Errors = slices.Clip(cloned[i].Errors)
+	}
+	return cloned
+}
@@ -0,0 +1,80 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"fmt"
+	"strings"
+)
+
+func invalidISBN(isbn string) database.BadRequestErr {
+	return database.BadRequestErr{Message: fmt.Sprintf("isbn %q is not a valid ISBN-10 or ISBN-13", isbn)}
+}
+
+func isbnTaken(isbn string) database.ConflictErr {
+	return database.ConflictErr{Message: fmt.Sprintf("isbn %s already belongs to another edition, trashed books included", isbn)}
+}
+
+func bookByISBNNotFound(isbn string) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("book with isbn %s not found", isbn)}
+}
+
+// normalizeISBN checks check digit of ISBN-10 or ISBN-13 written with or without hyphens and spaces
+// and returns it as ISBN-13 of digits only, which is the form editions keep
+func normalizeISBN(isbn string) (string, error) {
+	s := strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(strings.TrimSpace(isbn)))
+
+	switch {
+	case len(s) == 10 && validISBN10(s):
+		return "978" + s[:9] + isbn13CheckDigit("978"+s[:9]), nil
+	case len(s) == 13 && validISBN13(s):
+		return s, nil
+	}
+	return "", invalidISBN(isbn)
+}
+
+func allDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validISBN10 checks ISBN-10 of digits whose last one may be X, weighted sum of its digits divides by 11
+func validISBN10(s string) bool {
+	if !allDigits(s[:9]) || !allDigits(s[9:]) && s[9] != 'X' {
+		return false
+	}
+
+	sum := 0
+	for i, c := range s {
+		digit := int(c - '0')
+		if c == 'X' {
+			digit = 10
+		}
+		sum += (10 - i) * digit
+	}
+	return sum%11 == 0
+}
+
+// validISBN13 checks ISBN-13 of digits with one of bookland prefixes
+func validISBN13(s string) bool {
+	if !allDigits(s) || !strings.HasPrefix(s, "978") && !strings.HasPrefix(s, "979") {
+		return false
+	}
+	return isbn13CheckDigit(s[:12]) == s[12:]
+}
+
+// isbn13CheckDigit computes the last digit of ISBN-13 out of the first twelve
+func isbn13CheckDigit(s string) string {
+	sum := 0
+	for i, c := range s[:12] {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(c-'0')
+	}
+	return fmt.Sprint((10 - sum%10) % 10)
+}
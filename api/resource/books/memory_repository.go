@@ -0,0 +1,1447 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/money"
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemoryRepo keeps books in a map, it is meant for demos and local runs without postgres
+type MemoryRepo struct {
+	mu     sync.RWMutex
+	books  map[int]bookEntity
+	lastID int
+	// audit is kept in order of changes, entry ids are its indexes plus one
+	audit []auditEntry
+	// genres are those of genres package, books are not linked to any genre while it is nil
+	genres GenreTree
+	// editions of every book, lastEditionID numbers them across books
+	editions      map[int]editionEntity
+	lastEditionID int
+	// publishers are those of publishers package, editions can't name a publisher while it is nil
+	publishers PublisherFinder
+	// series are those of series package, books can't join any series while it is nil
+	series SeriesFinder
+	// authors are those of authors package, books are not linked to any author while it is nil
+	authors AuthorLinker
+	// stock maps book and location to copies on hand, movements are kept in order and numbered like audit
+	stock             map[stockKey]int
+	movements         []stockMovement
+	reservations      map[int]reservationEntity
+	lastReservationID int
+	// prices is price history in order it was written and numbered like audit, entries are ended in place
+	prices               []priceEntry
+	scheduledPrices      map[int]scheduledPrice
+	lastScheduledPriceID int
+	// reviews of every book, rating of approved ones is added up on their books
+	reviews      map[int]reviewEntity
+	lastReviewID int
+}
+
+type stockKey struct {
+	BookID   int
+	Location string
+}
+
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		books:        map[int]bookEntity{},
+		editions:     map[int]editionEntity{},
+		stock:        map[stockKey]int{},
+		reservations: map[int]reservationEntity{},
+
+		scheduledPrices: map[int]scheduledPrice{},
+		reviews:         map[int]reviewEntity{},
+	}
+}
+
+// save copies books and everything kept with them for a unit of work, the copy is put back when the unit of work fails.
+// Audit and movements are only appended to, their length is enough
+func (repo *MemoryRepo) save() func() {
+	repo.mu.RLock()
+	books, lastID, audit := maps.Clone(repo.books), repo.lastID, len(repo.audit)
+	editions, lastEditionID := maps.Clone(repo.editions), repo.lastEditionID
+	stock, movements := maps.Clone(repo.stock), len(repo.movements)
+	reservations, lastReservationID := maps.Clone(repo.reservations), repo.lastReservationID
+	prices, scheduledPrices, lastScheduledPriceID := slices.Clone(repo.prices), maps.Clone(repo.scheduledPrices), repo.lastScheduledPriceID
+	reviews, lastReviewID := maps.Clone(repo.reviews), repo.lastReviewID
+	repo.mu.RUnlock()
+
+	return func() {
+		repo.mu.Lock()
+		defer repo.mu.Unlock()
+
+		repo.books, repo.lastID, repo.audit = books, lastID, repo.audit[:audit]
+		repo.editions, repo.lastEditionID = editions, lastEditionID
+		repo.stock, repo.movements = stock, repo.movements[:movements]
+		repo.reservations, repo.lastReservationID = reservations, lastReservationID
+		repo.prices, repo.scheduledPrices, repo.lastScheduledPriceID = prices, scheduledPrices, lastScheduledPriceID
+		repo.reviews, repo.lastReviewID = reviews, lastReviewID
+	}
+}
+
+// writeAudit records change of a book, caller holds write lock
+func (repo *MemoryRepo) writeAudit(e auditEntry) {
+	e.ID = len(repo.audit) + 1
+	e.CreatedAt = time.Now().UTC()
+	repo.audit = append(repo.audit, e)
+}
+
+// writePriceHistory ends the current price history entry of a book and starts one of its new price
+// when the change of the book sets another price, caller holds write lock
+func (repo *MemoryRepo) writePriceHistory(ctx context.Context, before, after *bookEntity) {
+	if !priceChanged(before, after) {
+		return
+	}
+
+	now := time.Now().UTC()
+	for i, e := range repo.prices {
+		if e.BookID == after.ID && e.EffectiveTo == nil {
+			repo.prices[i].EffectiveTo = &now
+		}
+	}
+	if after.Price == nil {
+		return
+	}
+
+	e := newPriceEntry(ctx, after)
+	e.ID = len(repo.prices) + 1
+	e.EffectiveFrom = now
+	repo.prices = append(repo.prices, e)
+}
+
+// linkGenre links request body to a genre of the taxonomy, see bookRequestBody.linkGenre.
+// Genre names are left as they are while genres is nil, there is no taxonomy to find them in
+func (repo *MemoryRepo) linkGenre(ctx context.Context, b bookRequestBody) (bookRequestBody, error) {
+	if repo.genres == nil {
+		if b.GenreID != nil {
+			return b.linkGenre(newGenreIndex(map[int]string{}))
+		}
+		return b, nil
+	}
+	return b.linkGenre(newGenreIndex(repo.genres.GenreNames(ctx)))
+}
+
+// withSubgenres returns filter with ids of genres it matches filled in, see booksFilter.subgenres
+func (repo *MemoryRepo) withSubgenres(ctx context.Context, f booksFilter) booksFilter {
+	if f.Genre == nil || !f.IncludeSubgenres {
+		return f
+	}
+	f.subgenres = map[int]bool{}
+	if repo.genres != nil {
+		for _, id := range repo.genres.Subgenres(ctx, *f.Genre) {
+			f.subgenres[id] = true
+		}
+	}
+	return f
+}
+
+func equalFold(filter *string, value string) bool {
+	return filter == nil || strings.EqualFold(*filter, value)
+}
+
+// compareInt behaves like sql comparison, missing value never satisfies the predicate
+func compareInt(filter *int, value *int, satisfies func(v, f int) bool) bool {
+	if filter == nil {
+		return true
+	}
+	return value != nil && satisfies(*value, *filter)
+}
+
+// comparePrice compares amount of the price like sql does, filter on currency keeps prices in one currency
+func comparePrice(filter *float64, value *money.Money, satisfies func(v, f float64) bool) bool {
+	if filter == nil {
+		return true
+	}
+	return value != nil && satisfies(value.Float64(), *filter)
+}
+
+func (f booksFilter) matches(b bookEntity) bool {
+	ge := func(v, f int) bool { return v >= f }
+	le := func(v, f int) bool { return v <= f }
+	gt := func(v, f int) bool { return v > f }
+	lt := func(v, f int) bool { return v < f }
+
+	genre := equalFold(f.Genre, b.Genre)
+	if f.Genre != nil && f.IncludeSubgenres {
+		genre = b.GenreID != nil && f.subgenres[*b.GenreID]
+	}
+
+	return f.Deleted == (b.DeletedAt != nil) &&
+		equalFold(f.Title, b.Title) &&
+		equalFold(f.Author, b.Author) &&
+		genre &&
+		(f.Currency == nil || b.Price != nil && b.Price.Currency == *f.Currency) &&
+		comparePrice(f.MinPrice, b.Price, func(v, f float64) bool { return v >= f }) &&
+		comparePrice(f.MaxPrice, b.Price, func(v, f float64) bool { return v <= f }) &&
+		compareInt(f.PagesGt, b.NumberOfPages, gt) &&
+		compareInt(f.PagesLt, b.NumberOfPages, lt) &&
+		compareInt(f.ReleaseYearFrom, b.ReleaseYear, ge) &&
+		compareInt(f.ReleaseYearTo, b.ReleaseYear, le)
+}
+
+func compareValues(a, b any) int {
+	switch x := a.(type) {
+	case int:
+		y, _ := b.(int)
+		return x - y
+	case float64:
+		y, _ := b.(float64)
+		return cmp.Compare(x, y)
+	case string:
+		y, _ := b.(string)
+		return strings.Compare(x, y)
+	}
+	return 0
+}
+
+// compareBooks orders books in the same way as ORDER BY of BooksRepo
+func compareBooks(a, b bookEntity, keys []sortKey) int {
+	for _, k := range keys {
+		c := compareValues(sortValue(a, k.Field), sortValue(b, k.Field))
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return a.ID - b.ID
+}
+
+// compareToKey orders book against keyset position of a cursor
+func compareToKey(b bookEntity, keys []sortKey, values []any, id int) int {
+	for i, k := range keys {
+		c := compareValues(sortValue(b, k.Field), values[i])
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return b.ID - id
+}
+
+func (repo *MemoryRepo) GetBooks(ctx context.Context, q booksQuery) (booksPage, error) {
+	if err := ctx.Err(); err != nil {
+		return booksPage{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	page := booksPage{
+		Books: make([]bookEntity, 0),
+	}
+
+	filter := repo.withSubgenres(ctx, q.Filter)
+	matched := make([]bookEntity, 0)
+	for _, b := range repo.books {
+		if filter.matches(b) {
+			matched = append(matched, b)
+		}
+	}
+	page.Total = len(matched)
+
+	sort.Slice(matched, func(i, j int) bool {
+		return compareBooks(matched[i], matched[j], q.Sort) < 0
+	})
+
+	start := min(q.Offset, len(matched))
+	if q.After != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			return compareToKey(matched[i], q.Sort, q.After.Values, q.After.ID) > 0
+		})
+	}
+	end := min(start+q.Limit, len(matched))
+
+	page.Books = append(page.Books, matched[start:end]...)
+	if end < len(matched) && len(page.Books) > 0 {
+		cursor := newCursor(page.Books[len(page.Books)-1], q.Sort).encode()
+		page.NextCursor = &cursor
+	}
+
+	return page, nil
+}
+
+func splitWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func prefixOfAny(word string, terms []string) bool {
+	for _, t := range terms {
+		if strings.HasPrefix(word, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlight wraps every word of text which starts with one of terms in <mark></mark>
+func highlight(text string, terms []string) string {
+	var sb strings.Builder
+	word := make([]rune, 0)
+
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		w := string(word)
+		if prefixOfAny(strings.ToLower(w), terms) {
+			sb.WriteString("<mark>" + w + "</mark>")
+		} else {
+			sb.WriteString(w)
+		}
+		word = word[:0]
+	}
+
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		sb.WriteRune(r)
+	}
+	flush()
+
+	return sb.String()
+}
+
+func (repo *MemoryRepo) SearchBooks(ctx context.Context, q searchQuery) (searchPage, error) {
+	if err := ctx.Err(); err != nil {
+		return searchPage{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	page := searchPage{
+		Results: make([]bookSearchResult, 0),
+	}
+
+	terms := make([]string, 0, len(q.Terms))
+	for _, t := range q.Terms {
+		terms = append(terms, strings.ToLower(t))
+	}
+
+	// weights follow searchDocument of BooksRepo, title matches rank highest
+	fields := []struct {
+		weight float32
+		value  func(bookEntity) string
+	}{
+		{1, func(b bookEntity) string { return b.Title }},
+		{0.4, func(b bookEntity) string { return b.Author }},
+		{0.2, func(b bookEntity) string { return b.Genre }},
+	}
+
+	matched := make([]bookSearchResult, 0)
+	for _, b := range repo.books {
+		if b.DeletedAt != nil {
+			continue
+		}
+
+		var rank float32
+		found := true
+		for _, t := range terms {
+			var best float32
+			for _, f := range fields {
+				for _, w := range splitWords(f.value(b)) {
+					if strings.HasPrefix(w, t) {
+						best = max(best, f.weight)
+					}
+				}
+			}
+			if best == 0 {
+				found = false
+				break
+			}
+			rank += best
+		}
+		if !found {
+			continue
+		}
+
+		matched = append(matched, bookSearchResult{
+			Book: b,
+			Rank: rank / float32(len(terms)),
+			Highlights: bookHighlights{
+				Title:  highlight(b.Title, terms),
+				Author: highlight(b.Author, terms),
+				Genre:  highlight(b.Genre, terms),
+			},
+		})
+	}
+	page.Total = len(matched)
+
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].Rank != matched[j].Rank {
+			return matched[i].Rank > matched[j].Rank
+		}
+		return matched[i].Book.ID < matched[j].Book.ID
+	})
+
+	start := min(q.Offset, len(matched))
+	end := min(start+q.Limit, len(matched))
+	page.Results = append(page.Results, matched[start:end]...)
+
+	return page, nil
+}
+
+func (repo *MemoryRepo) GetBookById(ctx context.Context, id int) (bookEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return bookEntity{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	b, ok := repo.books[id]
+	if !ok || b.DeletedAt != nil {
+		return bookEntity{}, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", id)}
+	}
+
+	return b, nil
+}
+
+func (repo *MemoryRepo) GetBookByISBN(ctx context.Context, isbn string) (bookEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return bookEntity{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, e := range repo.editions {
+		if e.ISBN != nil && *e.ISBN == isbn && repo.books[e.BookID].DeletedAt == nil {
+			return repo.books[e.BookID], nil
+		}
+	}
+
+	return bookEntity{}, bookByISBNNotFound(isbn)
+}
+
+// ExportBooks copies matching books so that fn runs without the lock held
+func (repo *MemoryRepo) ExportBooks(ctx context.Context, f booksFilter, fn func(bookEntity) error) error {
+	f = repo.withSubgenres(ctx, f)
+
+	repo.mu.RLock()
+	matched := make([]bookEntity, 0)
+	for _, b := range repo.books {
+		if f.matches(b) {
+			matched = append(matched, b)
+		}
+	}
+	repo.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].ID < matched[j].ID
+	})
+
+	for _, b := range matched {
+		if err := ctx.Err(); err != nil {
+			return database.RepoErr(ctx, err)
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (repo *MemoryRepo) AddBook(ctx context.Context, b bookRequestBody) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	b, err := repo.linkGenre(ctx, b)
+	if err != nil {
+		return 0, err
+	}
+	if err := repo.checkSeries(ctx, b.seriesID()); err != nil {
+		return 0, err
+	}
+
+	repo.mu.Lock()
+	if err := repo.checkISBN(b.ISBN, 0); err != nil {
+		repo.mu.Unlock()
+		return 0, err
+	}
+	created := repo.insert(ctx, b)
+	repo.mu.Unlock()
+
+	// authors are linked without the lock, authors repo looks up books while holding its own
+	return created.ID, linkAuthors(ctx, repo.authors, created)
+}
+
+// insert adds a valid book linked to its genre, caller holds write lock
+func (repo *MemoryRepo) insert(ctx context.Context, b bookRequestBody) bookEntity {
+	repo.lastID++
+	e := bookEntity{
+		ID:             repo.lastID,
+		Version:        1,
+		Title:          *b.Title,
+		Author:         *b.Author,
+		GenreID:        b.GenreID,
+		ISBN:           b.ISBN,
+		NumberOfPages:  b.NumberOfPages,
+		Price:          parsedPrice(b.Price),
+		ReleaseYear:    b.ReleaseYear,
+		SeriesID:       b.SeriesID.Value,
+		SeriesPosition: b.SeriesPosition,
+	}
+	if b.Genre != nil {
+		e.Genre = *b.Genre
+	}
+	repo.books[e.ID] = e
+	if b.touchesEdition() {
+		repo.saveFirstEdition(e)
+	}
+	repo.writePriceHistory(ctx, nil, &e)
+	repo.writeAudit(newAudit(ctx, auditCreate, nil, &e))
+
+	return e
+}
+
+func (repo *MemoryRepo) ImportBooks(ctx context.Context, rows []importRow, dryRun bool) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	rejects := importRejects{}
+	linked := make(map[int]bookRequestBody, len(rows))
+	for i, row := range rows {
+		if !rejects.isAccepted(rows, i) {
+			continue
+		}
+		if err := repo.checkSeries(ctx, row.Book.seriesID()); err != nil {
+			rejects.add(i, err)
+			continue
+		}
+		b, err := repo.linkGenre(ctx, row.Book)
+		if err != nil {
+			rejects.add(i, err)
+			continue
+		}
+		linked[i] = b
+	}
+
+	created := repo.insertAll(ctx, rows, linked, rejects, dryRun)
+	if dryRun {
+		rejects.apply(rows)
+		return 0, nil
+	}
+	// authors are linked without the lock, authors repo looks up books while holding its own
+	if err := linkAuthors(ctx, repo.authors, created...); err != nil {
+		return 0, err
+	}
+	rejects.apply(rows)
+
+	return len(created), nil
+}
+
+// insertAll adds linked books of rows by index in order of the rows, rows whose isbn is taken are rejected.
+// Dry run only rejects them
+func (repo *MemoryRepo) insertAll(ctx context.Context, rows []importRow, linked map[int]bookRequestBody,
+	rejects importRejects, dryRun bool) []bookEntity {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	created := make([]bookEntity, 0, len(linked))
+	for i := range rows {
+		b, ok := linked[i]
+		if !ok {
+			continue
+		}
+		if err := repo.checkISBN(b.ISBN, 0); err != nil {
+			rejects.add(i, err)
+			continue
+		}
+		if !dryRun {
+			created = append(created, repo.insert(ctx, b))
+		}
+	}
+	return created
+}
+
+func (repo *MemoryRepo) RemoveBook(ctx context.Context, id int, ifMatch *int) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing, ok := repo.books[id]
+	if !ok || existing.DeletedAt != nil {
+		return database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", id)}
+	}
+	if ifMatch != nil && *ifMatch != existing.Version {
+		return versionMismatch(id)
+	}
+
+	removed := existing
+	now := time.Now().UTC()
+	removed.DeletedAt = &now
+	removed.Version++
+	repo.books[id] = removed
+	repo.writeAudit(newAudit(ctx, auditDelete, &existing, &removed))
+
+	return nil
+}
+
+func (repo *MemoryRepo) UpdateBook(ctx context.Context, id int, b bookRequestBody, ifMatch *int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+	if err := repo.checkSeries(ctx, b.seriesID()); err != nil {
+		return 0, err
+	}
+
+	updated, err := repo.update(ctx, id, b, ifMatch)
+	if err != nil {
+		return 0, err
+	}
+	// authors are linked without the lock, authors repo looks up books while holding its own
+	if b.Author != nil {
+		if err := linkAuthors(ctx, repo.authors, updated); err != nil {
+			return 0, err
+		}
+	}
+
+	return updated.Version, nil
+}
+
+// update applies b to a live book
+func (repo *MemoryRepo) update(ctx context.Context, id int, b bookRequestBody, ifMatch *int) (bookEntity, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing, ok := repo.books[id]
+	if !ok || existing.DeletedAt != nil {
+		return existing, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", id)}
+	}
+	if ifMatch != nil && *ifMatch != existing.Version {
+		return existing, versionMismatch(id)
+	}
+
+	b, err := repo.linkGenre(ctx, b)
+	if err != nil {
+		return existing, err
+	}
+	// the book passes its isbn to its first edition, so that one may have it already
+	firstID := 0
+	if first := repo.firstEdition(id); first != nil {
+		firstID = first.ID
+	}
+	if err := repo.checkISBN(b.ISBN, firstID); err != nil {
+		return existing, err
+	}
+	updated := b.applyTo(existing)
+	updated.Version++
+	repo.books[id] = updated
+	if b.touchesEdition() {
+		repo.saveFirstEdition(updated)
+	}
+	repo.writePriceHistory(ctx, &existing, &updated)
+	repo.writeAudit(newAudit(ctx, auditUpdate, &existing, &updated))
+
+	return updated, nil
+}
+
+// renameAuthor rewrites author strings of books by ids, trashed books included, with rename.
+// The book gets a new version and an audit entry, authors repositories of databases rename the same way
+func (repo *MemoryRepo) renameAuthor(ctx context.Context, bookIDs []int, rename func(author string) string) {
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, id := range bookIDs {
+		b, ok := repo.books[id]
+		if !ok {
+			continue
+		}
+		if author := rename(b.Author); author != b.Author {
+			renamed := b
+			renamed.Author = author
+			renamed.Version++
+			repo.books[id] = renamed
+			repo.writeAudit(newAudit(ctx, auditUpdate, &b, &renamed))
+		}
+	}
+}
+
+// renameGenre sets name of genre genreID on its books, trashed books included.
+// Every renamed book gets a new version and an audit entry like genres repositories of databases write
+func (repo *MemoryRepo) renameGenre(ctx context.Context, genreID int, name string) {
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	ids := make([]int, 0, len(repo.books))
+	for id := range repo.books {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		b := repo.books[id]
+		if b.GenreID == nil || *b.GenreID != genreID || b.Genre == name {
+			continue
+		}
+		renamed := b
+		renamed.Genre = name
+		renamed.Version++
+		repo.books[id] = renamed
+		repo.writeAudit(newAudit(ctx, auditUpdate, &b, &renamed))
+	}
+}
+
+func (repo *MemoryRepo) RestoreBook(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing, ok := repo.books[id]
+	if !ok || existing.DeletedAt == nil {
+		return notInTrash(id)
+	}
+
+	restored := existing
+	restored.DeletedAt = nil
+	restored.Version++
+	repo.books[id] = restored
+	repo.writeAudit(newAudit(ctx, auditRestore, &existing, &restored))
+
+	return nil
+}
+
+func (repo *MemoryRepo) PurgeBooks(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	purged := 0
+	for id, b := range repo.books {
+		if b.DeletedAt != nil && b.DeletedAt.Before(before) {
+			delete(repo.books, id)
+			purged++
+		}
+	}
+	for id, e := range repo.editions {
+		if _, ok := repo.books[e.BookID]; !ok {
+			delete(repo.editions, id)
+		}
+	}
+	// stock, scheduled prices and reviews go with the book like in databases, movements and price history are kept
+	for key := range repo.stock {
+		if _, ok := repo.books[key.BookID]; !ok {
+			delete(repo.stock, key)
+		}
+	}
+	for id, r := range repo.reservations {
+		if _, ok := repo.books[r.BookID]; !ok {
+			delete(repo.reservations, id)
+		}
+	}
+	for id, s := range repo.scheduledPrices {
+		if _, ok := repo.books[s.BookID]; !ok {
+			delete(repo.scheduledPrices, id)
+		}
+	}
+	for id, r := range repo.reviews {
+		if _, ok := repo.books[r.BookID]; !ok {
+			delete(repo.reviews, id)
+		}
+	}
+
+	return purged, nil
+}
+
+func (repo *MemoryRepo) GetBookHistory(ctx context.Context, id int, q historyQuery) (historyPage, error) {
+	if err := ctx.Err(); err != nil {
+		return historyPage{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	page := historyPage{
+		Entries: make([]auditEntry, 0),
+	}
+
+	matched := make([]auditEntry, 0)
+	for _, e := range repo.audit {
+		if e.BookID == id {
+			matched = append(matched, e)
+		}
+	}
+	page.Total = len(matched)
+
+	start := min(q.Offset, len(matched))
+	end := min(start+q.Limit, len(matched))
+	page.Entries = append(page.Entries, matched[start:end]...)
+
+	return page, nil
+}
+
+// firstEdition returns edition of the book with the lowest id, caller holds the lock
+func (repo *MemoryRepo) firstEdition(bookID int) *editionEntity {
+	var first *editionEntity
+	for _, e := range repo.editions {
+		if e.BookID == bookID && (first == nil || e.ID < first.ID) {
+			first = &e
+		}
+	}
+	return first
+}
+
+// saveFirstEdition copies ISBN, NumberOfPages and Price of the book to its first edition,
+// the edition is added when the book has none. Caller holds write lock
+func (repo *MemoryRepo) saveFirstEdition(b bookEntity) {
+	first := repo.firstEdition(b.ID)
+	if first == nil {
+		repo.lastEditionID++
+		first = &editionEntity{ID: repo.lastEditionID, BookID: b.ID}
+	}
+	first.ISBN, first.NumberOfPages, first.Price = b.ISBN, b.NumberOfPages, b.Price
+	repo.editions[first.ID] = *first
+}
+
+// syncFirstEdition updates the book with fields of its first edition after editions of the book change,
+// the update is recorded unless the book already has them. Caller holds write lock
+func (repo *MemoryRepo) syncFirstEdition(ctx context.Context, bookID int) {
+	existing := repo.books[bookID]
+	updated := existing.copyFirstEdition(repo.firstEdition(bookID))
+	if sameEditionFields(existing, updated) {
+		return
+	}
+	updated.Version++
+	repo.books[bookID] = updated
+	repo.writePriceHistory(ctx, &existing, &updated)
+	repo.writeAudit(newAudit(ctx, auditUpdate, &existing, &updated))
+}
+
+// checkISBN fails with isbnTaken when isbn belongs to an edition other than the one of editionID,
+// trashed books included like with the unique index of databases. Caller holds the lock
+func (repo *MemoryRepo) checkISBN(isbn *string, editionID int) error {
+	if isbn == nil {
+		return nil
+	}
+	for _, e := range repo.editions {
+		if e.ID != editionID && e.ISBN != nil && *e.ISBN == *isbn {
+			return isbnTaken(*isbn)
+		}
+	}
+	return nil
+}
+
+// checkPublisher checks that publisher exists
+func (repo *MemoryRepo) checkPublisher(ctx context.Context, id *int) error {
+	if id == nil {
+		return nil
+	}
+	if repo.publishers == nil {
+		return publisherNotFound(*id)
+	}
+	if _, ok := repo.publishers.FindPublisher(ctx, *id); !ok {
+		return publisherNotFound(*id)
+	}
+	return nil
+}
+
+// liveEdition returns edition of a live book, caller holds the lock
+func (repo *MemoryRepo) liveEdition(id int) (editionEntity, error) {
+	e, ok := repo.editions[id]
+	if !ok || repo.books[e.BookID].DeletedAt != nil {
+		return e, editionNotFound(id)
+	}
+	return e, nil
+}
+
+func (repo *MemoryRepo) GetEditions(ctx context.Context, bookID int) ([]editionEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	b, ok := repo.books[bookID]
+	if !ok || b.DeletedAt != nil {
+		return nil, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	editions := make([]editionEntity, 0)
+	for _, e := range repo.editions {
+		if e.BookID == bookID {
+			editions = append(editions, e)
+		}
+	}
+	sort.Slice(editions, func(i, j int) bool {
+		return editions[i].ID < editions[j].ID
+	})
+
+	return editions, nil
+}
+
+func (repo *MemoryRepo) GetEditionById(ctx context.Context, id int) (editionEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return editionEntity{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return repo.liveEdition(id)
+}
+
+func (repo *MemoryRepo) AddEdition(ctx context.Context, bookID int, b editionRequestBody) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+	if err := repo.checkPublisher(ctx, b.PublisherID); err != nil {
+		return 0, err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	book, ok := repo.books[bookID]
+	if !ok || book.DeletedAt != nil {
+		return 0, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+	if err := repo.checkISBN(b.ISBN, 0); err != nil {
+		return 0, err
+	}
+
+	repo.lastEditionID++
+	e := b.applyTo(editionEntity{ID: repo.lastEditionID, BookID: bookID})
+	repo.editions[e.ID] = e
+	repo.syncFirstEdition(ctx, bookID)
+
+	return e.ID, nil
+}
+
+func (repo *MemoryRepo) UpdateEdition(ctx context.Context, id int, b editionRequestBody) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+	if err := repo.checkPublisher(ctx, b.PublisherID); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing, err := repo.liveEdition(id)
+	if err != nil {
+		return err
+	}
+	if err := repo.checkISBN(b.ISBN, id); err != nil {
+		return err
+	}
+	repo.editions[id] = b.applyTo(existing)
+	repo.syncFirstEdition(ctx, existing.BookID)
+
+	return nil
+}
+
+func (repo *MemoryRepo) RemoveEdition(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing, err := repo.liveEdition(id)
+	if err != nil {
+		return err
+	}
+	delete(repo.editions, id)
+	repo.syncFirstEdition(ctx, existing.BookID)
+
+	return nil
+}
+
+// checkSeries checks that series exists, caller must not hold the lock as series package
+// looks up books of its series while holding a lock of its own
+func (repo *MemoryRepo) checkSeries(ctx context.Context, id *int) error {
+	if id == nil {
+		return nil
+	}
+	if repo.series == nil {
+		return unknownSeries(*id)
+	}
+	if _, ok := repo.series.FindSeries(ctx, *id); !ok {
+		return unknownSeries(*id)
+	}
+	return nil
+}
+
+func (repo *MemoryRepo) GetSeriesBooks(ctx context.Context, seriesID int) ([]bookEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	books := make([]bookEntity, 0)
+	for _, b := range repo.books {
+		if b.SeriesID != nil && *b.SeriesID == seriesID {
+			books = append(books, b)
+		}
+	}
+	// books without position come last like NULLS LAST of sql
+	sort.Slice(books, func(i, j int) bool {
+		a, b := books[i], books[j]
+		switch {
+		case a.SeriesPosition == nil && b.SeriesPosition == nil:
+			return a.ID < b.ID
+		case a.SeriesPosition == nil || b.SeriesPosition == nil:
+			return b.SeriesPosition == nil
+		case *a.SeriesPosition != *b.SeriesPosition:
+			return *a.SeriesPosition < *b.SeriesPosition
+		}
+		return a.ID < b.ID
+	})
+
+	return books, nil
+}
+
+// levelsOf returns stock of a book in location order counting reservations unexpired at now, caller holds the lock
+func (repo *MemoryRepo) levelsOf(bookID int, now time.Time) stockEntity {
+	s := stockEntity{BookID: bookID, Levels: make([]stockLevelEntity, 0)}
+	for key, quantity := range repo.stock {
+		if key.BookID == bookID {
+			s.Levels = append(s.Levels, stockLevelEntity{
+				Location: key.Location,
+				Quantity: quantity,
+				Reserved: repo.reservedAt(key, now),
+			})
+		}
+	}
+	sort.Slice(s.Levels, func(i, j int) bool { return s.Levels[i].Location < s.Levels[j].Location })
+	return s
+}
+
+// reservedAt sums up reservations of a location unexpired at now, caller holds the lock
+func (repo *MemoryRepo) reservedAt(key stockKey, now time.Time) int {
+	reserved := 0
+	for _, r := range repo.reservations {
+		if r.BookID == key.BookID && r.Location == key.Location && r.ExpiresAt.After(now) {
+			reserved += r.Quantity
+		}
+	}
+	return reserved
+}
+
+func (repo *MemoryRepo) GetStock(ctx context.Context, bookID int) (stockEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return stockEntity{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	if b, ok := repo.books[bookID]; !ok || b.DeletedAt != nil {
+		return stockEntity{}, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+	return repo.levelsOf(bookID, time.Now()), nil
+}
+
+func (repo *MemoryRepo) GetAvailable(ctx context.Context, bookIDs []int) (map[int]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	now := time.Now()
+	available := make(map[int]int, len(bookIDs))
+	for _, id := range bookIDs {
+		available[id] = repo.levelsOf(id, now).available()
+	}
+	return available, nil
+}
+
+func (repo *MemoryRepo) GetOffers(ctx context.Context, bookIDs []int) (map[int]Offer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	now := time.Now()
+	offers := make(map[int]Offer, len(bookIDs))
+	for _, id := range bookIDs {
+		if b, ok := repo.books[id]; ok && b.DeletedAt == nil {
+			offers[id] = Offer{Title: b.Title, Price: b.Price, Available: repo.levelsOf(id, now).available()}
+		}
+	}
+	return offers, nil
+}
+
+func (repo *MemoryRepo) AdjustStock(ctx context.Context, bookID int, b stockAdjustRequestBody) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if book, ok := repo.books[bookID]; !ok || book.DeletedAt != nil {
+		return 0, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	now := time.Now()
+	key := stockKey{BookID: bookID, Location: *b.Location}
+	held := 0
+	if b.ReservationID != nil {
+		r, ok := repo.reservations[*b.ReservationID]
+		if !ok || r.BookID != bookID || r.Location != key.Location || !r.ExpiresAt.After(now) {
+			return 0, unknownReservation(*b.ReservationID, key.Location)
+		}
+		held = r.Quantity
+	}
+	if err := b.check(bookID, repo.stock[key], repo.reservedAt(key, now), held); err != nil {
+		return 0, err
+	}
+
+	if b.ReservationID != nil {
+		delete(repo.reservations, *b.ReservationID)
+	}
+	m := newMovement(ctx, bookID, b)
+	repo.stock[key] += m.Delta
+	m.ID = len(repo.movements) + 1
+	m.CreatedAt = now.UTC()
+	repo.movements = append(repo.movements, m)
+
+	return m.ID, nil
+}
+
+func (repo *MemoryRepo) GetStockMovements(ctx context.Context, bookID int, q movementsQuery) (movementsPage, error) {
+	if err := ctx.Err(); err != nil {
+		return movementsPage{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	all := make([]stockMovement, 0)
+	for _, m := range repo.movements {
+		if m.BookID == bookID {
+			all = append(all, m)
+		}
+	}
+
+	start := min(q.Offset, len(all))
+	end := min(start+q.Limit, len(all))
+	return movementsPage{Movements: all[start:end], Total: len(all)}, nil
+}
+
+func (repo *MemoryRepo) ReserveStock(ctx context.Context, bookID int, b reservationRequestBody, expiresAt time.Time) (reservationEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return reservationEntity{}, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if book, ok := repo.books[bookID]; !ok || book.DeletedAt != nil {
+		return reservationEntity{}, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	now := time.Now()
+	for id, r := range repo.reservations {
+		if r.BookID == bookID && !r.ExpiresAt.After(now) {
+			delete(repo.reservations, id)
+		}
+	}
+
+	key := stockKey{BookID: bookID, Location: *b.Location}
+	if err := b.check(bookID, repo.stock[key], repo.reservedAt(key, now)); err != nil {
+		return reservationEntity{}, err
+	}
+
+	repo.lastReservationID++
+	r := reservationEntity{
+		ID:        repo.lastReservationID,
+		BookID:    bookID,
+		Location:  key.Location,
+		Quantity:  *b.Quantity,
+		ExpiresAt: expiresAt.UTC(),
+	}
+	repo.reservations[r.ID] = r
+
+	return r, nil
+}
+
+func (repo *MemoryRepo) ReleaseReservation(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	r, ok := repo.reservations[id]
+	if !ok || !r.ExpiresAt.After(time.Now()) {
+		return reservationNotFound(id)
+	}
+	delete(repo.reservations, id)
+
+	return nil
+}
+
+func (repo *MemoryRepo) GetPrices(ctx context.Context, bookID int) (pricesEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return pricesEntity{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	if b, ok := repo.books[bookID]; !ok || b.DeletedAt != nil {
+		return pricesEntity{}, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	prices := pricesEntity{BookID: bookID, History: make([]priceEntry, 0), Scheduled: make([]scheduledPrice, 0)}
+	for _, e := range repo.prices {
+		if e.BookID == bookID {
+			prices.History = append(prices.History, e)
+		}
+	}
+	for _, s := range repo.scheduledPrices {
+		if s.BookID == bookID {
+			prices.Scheduled = append(prices.Scheduled, s)
+		}
+	}
+	sortScheduledPrices(prices.Scheduled)
+
+	return prices, nil
+}
+
+// sortScheduledPrices puts scheduled prices in the order they apply
+func sortScheduledPrices(prices []scheduledPrice) {
+	slices.SortFunc(prices, func(a, b scheduledPrice) int {
+		return cmp.Or(a.EffectiveFrom.Compare(b.EffectiveFrom), cmp.Compare(a.ID, b.ID))
+	})
+}
+
+func (repo *MemoryRepo) SchedulePrice(ctx context.Context, bookID int, b priceScheduleRequestBody) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if book, ok := repo.books[bookID]; !ok || book.DeletedAt != nil {
+		return 0, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	s := newScheduledPrice(ctx, bookID, b)
+	repo.lastScheduledPriceID++
+	s.ID = repo.lastScheduledPriceID
+	s.CreatedAt = time.Now().UTC()
+	repo.scheduledPrices[s.ID] = s
+
+	return s.ID, nil
+}
+
+func (repo *MemoryRepo) GetDuePrices(ctx context.Context, now time.Time) ([]scheduledPrice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	due := make([]scheduledPrice, 0)
+	for _, s := range repo.scheduledPrices {
+		if b, ok := repo.books[s.BookID]; ok && b.DeletedAt == nil && !s.EffectiveFrom.After(now) {
+			due = append(due, s)
+		}
+	}
+	sortScheduledPrices(due)
+
+	return due, nil
+}
+
+func (repo *MemoryRepo) RemoveScheduledPrice(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.scheduledPrices[id]; !ok {
+		return scheduledPriceNotFound(id)
+	}
+	delete(repo.scheduledPrices, id)
+
+	return nil
+}
+
+func (repo *MemoryRepo) AddReview(ctx context.Context, bookID int, b reviewRequestBody) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if book, ok := repo.books[bookID]; !ok || book.DeletedAt != nil {
+		return 0, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	repo.lastReviewID++
+	r := reviewEntity{
+		ID:        repo.lastReviewID,
+		BookID:    bookID,
+		Rating:    *b.Rating,
+		Text:      *b.Text,
+		Author:    *b.Author,
+		Status:    reviewPending,
+		CreatedAt: time.Now().UTC(),
+	}
+	repo.reviews[r.ID] = r
+
+	return r.ID, nil
+}
+
+// reviewsPage returns page of reviews in status of q which match, sorted by compare
+func (repo *MemoryRepo) reviewsPage(q reviewsQuery, match func(reviewEntity) bool, compare func(a, b reviewEntity) int) reviewsPage {
+	all := make([]reviewEntity, 0)
+	for _, r := range repo.reviews {
+		if r.Status == q.Status && match(r) {
+			all = append(all, r)
+		}
+	}
+	slices.SortFunc(all, compare)
+
+	start := min(q.Offset, len(all))
+	end := min(start+q.Limit, len(all))
+	return reviewsPage{Reviews: all[start:end], Total: len(all)}
+}
+
+func (repo *MemoryRepo) GetBookReviews(ctx context.Context, bookID int, q reviewsQuery) (reviewsPage, error) {
+	if err := ctx.Err(); err != nil {
+		return reviewsPage{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	if b, ok := repo.books[bookID]; !ok || b.DeletedAt != nil {
+		return reviewsPage{}, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	page := repo.reviewsPage(q, func(r reviewEntity) bool { return r.BookID == bookID }, func(a, b reviewEntity) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return page, nil
+}
+
+func (repo *MemoryRepo) GetReviews(ctx context.Context, q reviewsQuery) (reviewsPage, error) {
+	if err := ctx.Err(); err != nil {
+		return reviewsPage{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	page := repo.reviewsPage(q, func(reviewEntity) bool { return true }, func(a, b reviewEntity) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return page, nil
+}
+
+func (repo *MemoryRepo) ModerateReview(ctx context.Context, id int, status string) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	r, ok := repo.reviews[id]
+	if !ok {
+		return reviewNotFound(id)
+	}
+
+	// rating is part of the book, a new version makes cached copies of it stale
+	count, sum := ratingDelta(r.Status, status, r.Rating)
+	if b, ok := repo.books[r.BookID]; ok && (count != 0 || sum != 0) {
+		b.RatingCount += count
+		b.RatingSum += sum
+		b.Version++
+		repo.books[r.BookID] = b
+	}
+
+	now := time.Now().UTC()
+	r.Status, r.ModeratedAt = status, &now
+	repo.reviews[id] = r
+
+	return nil
+}
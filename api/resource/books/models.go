@@ -1,76 +1,759 @@
 package books
 
 import (
+	"booksapi/api/database"
+	"booksapi/api/money"
 	"encoding/json"
+	"time"
 )
 
+// nullableID tells a field set to null apart from a field left out of request body
+type nullableID struct {
+	Set   bool
+	Value *int
+}
+
+// UnmarshalJSON is called for null as well, absent field leaves Set false
+func (n *nullableID) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	return json.Unmarshal(data, &n.Value)
+}
+
+func (n nullableID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.Value)
+}
+
+// bookRequestBody holds fields of a book to save, GenreID links the book to a genre of the taxonomy
+// and makes its name the genre of the book. ISBN, NumberOfPages and Price are saved to the first edition of the book.
+// SeriesID null takes the book out of its series together with its position
 type bookRequestBody struct {
-	Title         *string `json:"title"`
-	Author        *string `json:"author"`
-	Genre         *string `json:"genre"`
-	NumberOfPages *int    `json:"numberOfPages"`
-	Price         *int    `json:"price"`
-	ReleaseYear   *int    `json:"releaseYear"`
+	Title          *string    `json:"title"`
+	Author         *string    `json:"author"`
+	Genre          *string    `json:"genre"`
+	GenreID        *int       `json:"genreId"`
+	ISBN           *string    `json:"isbn" example:"978-0-261-10221-7"`
+	NumberOfPages  *int       `json:"numberOfPages"`
+	Price          *priceBody `json:"price"`
+	ReleaseYear    *int       `json:"releaseYear"`
+	SeriesID       nullableID `json:"seriesId" swaggertype:"integer"`
+	SeriesPosition *int       `json:"seriesPosition"`
+}
+
+// validate checks fields which are set and brings isbn to its ISBN-13 form, every book write runs it
+func (b *bookRequestBody) validate() error {
+	if b.ISBN != nil {
+		isbn, err := normalizeISBN(*b.ISBN)
+		if err != nil {
+			return err
+		}
+		b.ISBN = &isbn
+	}
+	if err := validatePrice(b.Price); err != nil {
+		return err
+	}
+	return validateSeriesFields(b.SeriesID, b.SeriesPosition)
+}
+
+// validateNew checks rules every new book must satisfy on top of validate, AddBook and import share them
+func (b *bookRequestBody) validateNew() error {
+	if b.Title == nil || b.Author == nil {
+		return database.BadRequestErr{Message: "required fields are not set, won't save the data"}
+	}
+	return b.validate()
 }
 
+// applyTo returns copy of e with every field set in request body replaced
+func (b bookRequestBody) applyTo(e bookEntity) bookEntity {
+	if b.Author != nil {
+		e.Author = *b.Author
+	}
+	if b.Title != nil {
+		e.Title = *b.Title
+	}
+	// genre and its link change together, see linkGenre
+	if b.Genre != nil {
+		e.Genre = *b.Genre
+		e.GenreID = b.GenreID
+	}
+	if b.ISBN != nil {
+		e.ISBN = b.ISBN
+	}
+	if b.NumberOfPages != nil {
+		e.NumberOfPages = b.NumberOfPages
+	}
+	if b.Price != nil {
+		e.Price = parsedPrice(b.Price)
+	}
+	if b.ReleaseYear != nil {
+		e.ReleaseYear = b.ReleaseYear
+	}
+	if b.SeriesID.Set {
+		e.SeriesID = b.SeriesID.Value
+		if e.SeriesID == nil {
+			e.SeriesPosition = nil
+		}
+	}
+	if b.SeriesPosition != nil {
+		e.SeriesPosition = b.SeriesPosition
+	}
+	return e
+}
+
+// bookEntity is a books row, Version is incremented on every change
+// and is exposed to clients as ETag only. GenreID is set when Genre is a genre of the taxonomy.
+// ISBN, NumberOfPages and Price are copies of the first edition, they are nil while the book has no editions.
+// SeriesPosition orders books of the series SeriesID points to
 type bookEntity struct {
-	ID            int
-	Title         string
-	Author        string
-	Genre         string
-	NumberOfPages *int
-	Price         *int
-	ReleaseYear   *int
+	ID             int
+	Title          string
+	Author         string
+	Genre          string
+	GenreID        *int
+	ISBN           *string
+	NumberOfPages  *int
+	Price          *money.Money
+	ReleaseYear    *int
+	SeriesID       *int
+	SeriesPosition *int
+	// RatingCount and RatingSum add up ratings of approved reviews
+	RatingCount int
+	RatingSum   int
+	Version     int
+	// DeletedAt is set while the book is in trash
+	DeletedAt *time.Time
 }
 
 func (b bookEntity) ToDto() bookDTO {
-	return bookDTO(b)
+	return bookDTO{
+		ID:             b.ID,
+		Title:          b.Title,
+		Author:         b.Author,
+		Genre:          b.Genre,
+		GenreID:        b.GenreID,
+		ISBN:           b.ISBN,
+		NumberOfPages:  b.NumberOfPages,
+		Price:          newPriceDTO(b.Price),
+		ReleaseYear:    b.ReleaseYear,
+		SeriesID:       b.SeriesID,
+		SeriesPosition: b.SeriesPosition,
+		AverageRating:  b.averageRating(),
+		RatingCount:    b.RatingCount,
+	}
 }
 
 type bookDTO struct {
-	ID            int    `json:"id"`
-	Title         string `json:"title"`
-	Author        string `json:"author"`
-	Genre         string `json:"genre"`
-	NumberOfPages *int   `json:"numberOfPages"`
-	Price         *int   `json:"price"`
-	ReleaseYear   *int   `json:"releaseYear"`
+	ID             int       `json:"id"`
+	Title          string    `json:"title"`
+	Author         string    `json:"author"`
+	Genre          string    `json:"genre"`
+	GenreID        *int      `json:"genreId,omitempty"`
+	ISBN           *string   `json:"isbn,omitempty" example:"9780261102217"`
+	NumberOfPages  *int      `json:"numberOfPages"`
+	Price          *priceDTO `json:"price"`
+	ReleaseYear    *int      `json:"releaseYear"`
+	SeriesID       *int      `json:"seriesId,omitempty"`
+	SeriesPosition *int      `json:"seriesPosition,omitempty"`
+	// PreviousInSeries and NextInSeries are set for a single book only, lists leave them out
+	PreviousInSeries *bookLinkDTO `json:"previousInSeries,omitempty"`
+	NextInSeries     *bookLinkDTO `json:"nextInSeries,omitempty"`
+	// Available is number of copies which can be sold, it is set by reads of live books only
+	Available *int `json:"available,omitempty"`
+	// AverageRating and RatingCount are left out until a review of the book is approved
+	AverageRating *float64 `json:"averageRating,omitempty" example:"4.5"`
+	RatingCount   int      `json:"ratingCount,omitempty"`
+}
+
+// bookLinkDTO points to another book
+type bookLinkDTO struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Href  string `json:"href" example:"/api/books/2"`
+}
+
+type trashedBookDTO struct {
+	bookDTO
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+func (b bookEntity) ToTrashedDto() trashedBookDTO {
+	dto := trashedBookDTO{bookDTO: b.ToDto()}
+	if b.DeletedAt != nil {
+		dto.DeletedAt = *b.DeletedAt
+	}
+	return dto
+}
+
+// editionRequestBody adds or changes an edition, fields left out of PATCH request keep their values
+type editionRequestBody struct {
+	PublisherID   *int       `json:"publisherId"`
+	Format        *string    `json:"format" enums:"hardcover,paperback,ebook,audiobook"`
+	ISBN          *string    `json:"isbn" example:"978-0-261-10221-7"`
+	NumberOfPages *int       `json:"numberOfPages"`
+	Price         *priceBody `json:"price"`
+	ReleaseDate   *string    `json:"releaseDate" example:"2006-01-02"`
+}
+
+// editionEntity is a published form of a book, e.g. a paperback of one publisher. ISBN is kept in ISBN-13 form
+type editionEntity struct {
+	ID            int
+	BookID        int
+	PublisherID   *int
+	Format        *string
+	ISBN          *string
+	NumberOfPages *int
+	Price         *money.Money
+	ReleaseDate   *time.Time
+}
+
+func (e editionEntity) ToDto() editionDTO {
+	dto := editionDTO{
+		ID:            e.ID,
+		BookID:        e.BookID,
+		PublisherID:   e.PublisherID,
+		Format:        e.Format,
+		ISBN:          e.ISBN,
+		NumberOfPages: e.NumberOfPages,
+		Price:         newPriceDTO(e.Price),
+	}
+	if e.ReleaseDate != nil {
+		date := e.ReleaseDate.Format(time.DateOnly)
+		dto.ReleaseDate = &date
+	}
+	return dto
+}
+
+type editionDTO struct {
+	ID            int       `json:"id"`
+	BookID        int       `json:"bookId"`
+	PublisherID   *int      `json:"publisherId"`
+	Format        *string   `json:"format"`
+	ISBN          *string   `json:"isbn"`
+	NumberOfPages *int      `json:"numberOfPages"`
+	Price         *priceDTO `json:"price"`
+	ReleaseDate   *string   `json:"releaseDate" example:"2006-01-02"`
+}
+
+type editionsDTO struct {
+	Items []editionDTO `json:"items"`
+}
+
+// booksFilter holds optional predicates for book listing, nil fields are not applied
+type booksFilter struct {
+	Title           *string
+	Author          *string
+	Genre           *string
+	MinPrice        *float64
+	MaxPrice        *float64
+	PagesGt         *int
+	PagesLt         *int
+	ReleaseYearFrom *int
+	ReleaseYearTo   *int
+	// Currency leaves out books priced in other currencies, prices of MinPrice, MaxPrice and price sort are in it
+	Currency *string
+	// IncludeSubgenres makes Genre match books of every genre below the named one as well
+	IncludeSubgenres bool
+	// Deleted selects books in trash instead of live ones, it never comes from query string
+	Deleted bool
+	// subgenres holds ids of genres IncludeSubgenres matches, memory repo fills it in before matching
+	subgenres map[int]bool
+}
+
+// exportQuery selects books ExportBooks streams and the format they are written in
+type exportQuery struct {
+	Format exportFormat
+	Filter booksFilter
+}
+
+type sortKey struct {
+	Field string
+	Desc  bool
+}
+
+// booksQuery describes which page of books to return,
+// After is set for keyset pagination and takes place of Offset.
+// Books are always ordered by id after Sort keys
+type booksQuery struct {
+	Limit  int
+	Offset int
+	After  *bookCursor
+	Filter booksFilter
+	Sort   []sortKey
+}
+
+type booksPage struct {
+	Books      []bookEntity
+	Total      int
+	NextCursor *string
+}
+
+type booksPageDTO struct {
+	Items      []bookDTO `json:"items"`
+	Total      int       `json:"total"`
+	NextCursor *string   `json:"nextCursor"`
+}
+
+type trashPageDTO struct {
+	Items      []trashedBookDTO `json:"items"`
+	Total      int              `json:"total"`
+	NextCursor *string          `json:"nextCursor"`
+}
+
+type PurgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+// searchQuery is a full text search request, Terms are matched as prefixes
+type searchQuery struct {
+	Terms  []string
+	Limit  int
+	Offset int
+}
+
+type bookSearchResult struct {
+	Book       bookEntity
+	Rank       float32
+	Highlights bookHighlights
+}
+
+type searchPage struct {
+	Results []bookSearchResult
+	Total   int
+}
+
+// bookHighlights contains field values with matched terms wrapped in <mark></mark>
+type bookHighlights struct {
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Genre  string `json:"genre"`
+}
+
+type bookSearchResultDTO struct {
+	Book       bookDTO        `json:"book"`
+	Rank       float32        `json:"rank"`
+	Highlights bookHighlights `json:"highlights"`
+}
+
+type searchPageDTO struct {
+	Items []bookSearchResultDTO `json:"items"`
+	Total int                   `json:"total"`
+}
+
+// auditEntry is a single change of a book, Changes is a JSON object which maps
+// every changed field to {"before": ..., "after": ...}
+type auditEntry struct {
+	ID        int
+	BookID    int
+	Action    string
+	Changes   []byte
+	Actor     string
+	RequestID string
+	CreatedAt time.Time
+}
+
+func (e auditEntry) ToDto() auditEntryDTO {
+	return auditEntryDTO{
+		ID:        e.ID,
+		BookID:    e.BookID,
+		Action:    e.Action,
+		Changes:   json.RawMessage(e.Changes),
+		Actor:     e.Actor,
+		RequestID: e.RequestID,
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+type auditEntryDTO struct {
+	ID        int             `json:"id"`
+	BookID    int             `json:"bookId"`
+	Action    string          `json:"action"`
+	Changes   json.RawMessage `json:"changes" swaggertype:"object"`
+	Actor     string          `json:"actor"`
+	RequestID string          `json:"requestId"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// historyQuery selects a page of book changes, oldest change comes first
+type historyQuery struct {
+	Limit  int
+	Offset int
+}
+
+type historyPage struct {
+	Entries []auditEntry
+	Total   int
+}
+
+type historyPageDTO struct {
+	Items []auditEntryDTO `json:"items"`
+	Total int             `json:"total"`
+}
+
+const (
+	importAccepted = "accepted"
+	importRejected = "rejected"
+)
+
+// importRow is a record of imported file, Errors are empty for valid records
+type importRow struct {
+	Line   int
+	Book   bookRequestBody
+	Errors []string
+}
+
+type importRowDTO struct {
+	Line   int      `json:"line"`
+	Status string   `json:"status" enums:"accepted,rejected"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+type importReportDTO struct {
+	DryRun   bool           `json:"dryRun"`
+	Accepted int            `json:"accepted"`
+	Rejected int            `json:"rejected"`
+	Rows     []importRowDTO `json:"rows"`
 }
 
 type ActionResponse struct {
 	ResourceId int `json:"resourceId"`
 }
 
-type APIError struct {
-	Status  int    `json:"status"`
-	Message string `json:"message"`
+const (
+	batchCreate = "create"
+	batchPatch  = "patch"
+	batchDelete = "delete"
+)
+
+// batchOperation is one change of POST /api/books/batch, ID and IfMatch are not used by create
+// and Book is not used by delete
+type batchOperation struct {
+	Op      string          `json:"op" enums:"create,patch,delete"`
+	ID      int             `json:"id,omitempty"`
+	IfMatch string          `json:"ifMatch,omitempty" example:"\"3\""`
+	Book    bookRequestBody `json:"book"`
+}
+
+// batchResultDTO holds outcome of the operation at the same index of the batch,
+// Result is set for successful operations and Error for the rest
+type batchResultDTO struct {
+	Status int                `json:"status"`
+	Result *ActionResponse    `json:"result,omitempty"`
+	Error  *database.APIError `json:"error,omitempty"`
+}
+
+// stockAdjustRequestBody changes copies of a book at a warehouse location. Quantity is always positive,
+// Reason tells whether copies come in or go out. ReservationID sells copies the reservation holds and ends it
+type stockAdjustRequestBody struct {
+	Location      *string `json:"location" example:"main"`
+	Quantity      *int    `json:"quantity" example:"5"`
+	Reason        *string `json:"reason" enums:"received,sold,damaged"`
+	ReservationID *int    `json:"reservationId"`
+}
+
+// reservationRequestBody holds copies of a book at a location until the reservation expires
+type reservationRequestBody struct {
+	Location *string `json:"location" example:"main"`
+	Quantity *int    `json:"quantity" example:"1"`
+}
+
+// stockLevelEntity is stock of a book at one location, Reserved counts copies of unexpired reservations
+type stockLevelEntity struct {
+	Location string
+	Quantity int
+	Reserved int
+}
+
+type stockEntity struct {
+	BookID int
+	Levels []stockLevelEntity
+}
+
+// available counts copies which are neither sold nor reserved
+func (s stockEntity) available() int {
+	available := 0
+	for _, l := range s.Levels {
+		available += max(l.Quantity-l.Reserved, 0)
+	}
+	return available
+}
+
+func (s stockEntity) ToDto() stockDTO {
+	dto := stockDTO{
+		BookID:    s.BookID,
+		Available: s.available(),
+		Locations: make([]stockLevelDTO, 0, len(s.Levels)),
+	}
+	for _, l := range s.Levels {
+		dto.Locations = append(dto.Locations, stockLevelDTO{
+			Location: l.Location,
+			Quantity: l.Quantity,
+			Reserved: l.Reserved,
+		})
+	}
+	return dto
+}
+
+type stockDTO struct {
+	BookID    int             `json:"bookId"`
+	Available int             `json:"available"`
+	Locations []stockLevelDTO `json:"locations"`
+}
+
+type stockLevelDTO struct {
+	Location string `json:"location"`
+	Quantity int    `json:"quantity"`
+	Reserved int    `json:"reserved"`
+}
+
+// stockMovement is a ledger record of a stock change, Delta is negative for copies going out
+type stockMovement struct {
+	ID            int
+	BookID        int
+	Location      string
+	Delta         int
+	Reason        string
+	ReservationID *int
+	Actor         string
+	RequestID     string
+	CreatedAt     time.Time
+}
+
+func (m stockMovement) ToDto() stockMovementDTO {
+	return stockMovementDTO{
+		ID:            m.ID,
+		BookID:        m.BookID,
+		Location:      m.Location,
+		Delta:         m.Delta,
+		Reason:        m.Reason,
+		ReservationID: m.ReservationID,
+		Actor:         m.Actor,
+		RequestID:     m.RequestID,
+		CreatedAt:     m.CreatedAt,
+	}
+}
+
+type stockMovementDTO struct {
+	ID            int       `json:"id"`
+	BookID        int       `json:"bookId"`
+	Location      string    `json:"location"`
+	Delta         int       `json:"delta"`
+	Reason        string    `json:"reason"`
+	ReservationID *int      `json:"reservationId,omitempty"`
+	Actor         string    `json:"actor"`
+	RequestID     string    `json:"requestId"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// movementsQuery selects a page of stock movements, oldest movement comes first
+type movementsQuery struct {
+	Limit  int
+	Offset int
+}
+
+type movementsPage struct {
+	Movements []stockMovement
+	Total     int
+}
+
+type movementsPageDTO struct {
+	Items []stockMovementDTO `json:"items"`
+	Total int                `json:"total"`
+}
+
+type reservationEntity struct {
+	ID        int
+	BookID    int
+	Location  string
+	Quantity  int
+	ExpiresAt time.Time
+}
+
+func (r reservationEntity) ToDto() reservationDTO {
+	return reservationDTO{
+		ID:        r.ID,
+		BookID:    r.BookID,
+		Location:  r.Location,
+		Quantity:  r.Quantity,
+		ExpiresAt: r.ExpiresAt,
+	}
+}
+
+type reservationDTO struct {
+	ID        int       `json:"id"`
+	BookID    int       `json:"bookId"`
+	Location  string    `json:"location"`
+	Quantity  int       `json:"quantity"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// priceScheduleRequestBody sets price of a book at EffectiveFrom, which must be in the future
+type priceScheduleRequestBody struct {
+	Price         *priceBody `json:"price"`
+	EffectiveFrom *time.Time `json:"effectiveFrom" example:"2026-12-01T00:00:00Z"`
+}
+
+// priceEntry is a price a book had from EffectiveFrom until EffectiveTo, which is nil for the current price
+type priceEntry struct {
+	ID            int
+	BookID        int
+	Price         *money.Money
+	EffectiveFrom time.Time
+	EffectiveTo   *time.Time
+	Actor         string
+	RequestID     string
+}
+
+func (e priceEntry) ToDto() priceEntryDTO {
+	return priceEntryDTO{
+		ID:            e.ID,
+		Price:         newPriceDTO(e.Price),
+		EffectiveFrom: e.EffectiveFrom,
+		EffectiveTo:   e.EffectiveTo,
+		Actor:         e.Actor,
+		RequestID:     e.RequestID,
+	}
+}
+
+type priceEntryDTO struct {
+	ID            int        `json:"id"`
+	Price         *priceDTO  `json:"price"`
+	EffectiveFrom time.Time  `json:"effectiveFrom"`
+	EffectiveTo   *time.Time `json:"effectiveTo"`
+	Actor         string     `json:"actor"`
+	RequestID     string     `json:"requestId"`
+}
+
+// scheduledPrice becomes price of its book at EffectiveFrom, the scheduler removes it once it is applied
+type scheduledPrice struct {
+	ID            int
+	BookID        int
+	Price         *money.Money
+	EffectiveFrom time.Time
+	Actor         string
+	RequestID     string
+	CreatedAt     time.Time
+}
+
+func (s scheduledPrice) ToDto() scheduledPriceDTO {
+	return scheduledPriceDTO{
+		ID:            s.ID,
+		Price:         newPriceDTO(s.Price),
+		EffectiveFrom: s.EffectiveFrom,
+		Actor:         s.Actor,
+		RequestID:     s.RequestID,
+		CreatedAt:     s.CreatedAt,
+	}
+}
+
+type scheduledPriceDTO struct {
+	ID            int       `json:"id"`
+	Price         *priceDTO `json:"price"`
+	EffectiveFrom time.Time `json:"effectiveFrom"`
+	Actor         string    `json:"actor"`
+	RequestID     string    `json:"requestId"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// pricesEntity holds price history of a book oldest first and its scheduled prices in the order they apply
+type pricesEntity struct {
+	BookID    int
+	History   []priceEntry
+	Scheduled []scheduledPrice
+}
+
+func (p pricesEntity) ToDto() pricesDTO {
+	dto := pricesDTO{
+		BookID:    p.BookID,
+		History:   make([]priceEntryDTO, 0, len(p.History)),
+		Scheduled: make([]scheduledPriceDTO, 0, len(p.Scheduled)),
+	}
+	for _, e := range p.History {
+		dto.History = append(dto.History, e.ToDto())
+	}
+	for _, s := range p.Scheduled {
+		dto.Scheduled = append(dto.Scheduled, s.ToDto())
+	}
+	return dto
+}
+
+type pricesDTO struct {
+	BookID    int                 `json:"bookId"`
+	History   []priceEntryDTO     `json:"history"`
+	Scheduled []scheduledPriceDTO `json:"scheduled"`
+}
+
+// reviewRequestBody is a review of a book by a customer, Text may be left out
+type reviewRequestBody struct {
+	Rating *int    `json:"rating" example:"5"`
+	Text   *string `json:"text"`
+	Author *string `json:"author" example:"Jane"`
+}
+
+// reviewModerationRequestBody sets moderation status of a review
+type reviewModerationRequestBody struct {
+	Status *string `json:"status" enums:"pending,approved,rejected"`
 }
 
-func (e APIError) Error() string {
-	json, _ := json.Marshal(e)
-	return string(json[:])
+// reviewEntity is a review of a book, only approved reviews are shown with the book and counted in its rating
+type reviewEntity struct {
+	ID          int
+	BookID      int
+	Rating      int
+	Text        string
+	Author      string
+	Status      string
+	CreatedAt   time.Time
+	ModeratedAt *time.Time
 }
 
-type internalErr struct {
-	message string
+func (r reviewEntity) ToDto() reviewDTO {
+	return reviewDTO{
+		ID:          r.ID,
+		BookID:      r.BookID,
+		Rating:      r.Rating,
+		Text:        r.Text,
+		Author:      r.Author,
+		Status:      r.Status,
+		CreatedAt:   r.CreatedAt,
+		ModeratedAt: r.ModeratedAt,
+	}
 }
 
-func (e internalErr) Error() string {
-	return e.message
+type reviewDTO struct {
+	ID          int        `json:"id"`
+	BookID      int        `json:"bookId"`
+	Rating      int        `json:"rating"`
+	Text        string     `json:"text"`
+	Author      string     `json:"author"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ModeratedAt *time.Time `json:"moderatedAt,omitempty"`
 }
 
-type notfoundErr struct {
-	message string
+// reviewsQuery selects a page of reviews in Status. Reviews of a book come newest first,
+// the moderation queue of every book oldest first
+type reviewsQuery struct {
+	Status string
+	Limit  int
+	Offset int
 }
 
-func (e notfoundErr) Error() string {
-	return e.message
+type reviewsPage struct {
+	Reviews []reviewEntity
+	Total   int
 }
 
-type badreqErr struct {
-	message string
+type reviewsPageDTO struct {
+	Items []reviewDTO `json:"items"`
+	Total int         `json:"total"`
 }
 
-func (e badreqErr) Error() string {
-	return e.message
+func (p reviewsPage) ToDto() reviewsPageDTO {
+	dto := reviewsPageDTO{
+		Items: make([]reviewDTO, 0, len(p.Reviews)),
+		Total: p.Total,
+	}
+	for _, r := range p.Reviews {
+		dto.Items = append(dto.Items, r.ToDto())
+	}
+	return dto
 }
@@ -0,0 +1,240 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/money"
+	"booksapi/api/router/middlewares"
+	"booksapi/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// defaultCurrency is the currency prices sent without one are taken in, see config.Money
+func defaultCurrency() string {
+	if currency := config.GetAppsettings().Money.DefaultCurrency; currency != "" {
+		return currency
+	}
+	return "EUR"
+}
+
+// integerPrices tells whether compatibility mode for integer clients is on, see config.Money
+func integerPrices() bool {
+	return config.GetAppsettings().Money.IntegerPrices
+}
+
+// priceBody is a price as clients send it, {"amount":"19.99","currency":"EUR"}. Integer clients send
+// a plain number, which is read into Amount. Price is checked by validate of the request body it belongs to
+type priceBody struct {
+	Amount   json.Number `json:"amount" swaggertype:"string" example:"19.99"`
+	Currency string      `json:"currency" example:"EUR"`
+}
+
+func (p *priceBody) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return json.Unmarshal(data, &p.Amount)
+	}
+	// fields are decoded like the request body they belong to
+	type fields priceBody
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*fields)(p))
+}
+
+// money reads the price, a price without currency is accepted in compatibility mode only
+func (p priceBody) money() (money.Money, error) {
+	currency := p.Currency
+	if currency == "" {
+		if !integerPrices() {
+			return money.Money{}, database.BadRequestErr{Message: `price must have amount and currency, e.g. {"amount":"19.99","currency":"EUR"}`}
+		}
+		currency = defaultCurrency()
+	}
+	m, err := money.Parse(p.Amount.String(), currency)
+	if err != nil {
+		return m, database.BadRequestErr{Message: "price " + err.Error()}
+	}
+	return m, nil
+}
+
+// validatePrice checks price of a request body when it is set
+func validatePrice(p *priceBody) error {
+	if p == nil {
+		return nil
+	}
+	_, err := p.money()
+	return err
+}
+
+// parsedPrice returns validated price of a request body, nil when it is not set
+func parsedPrice(p *priceBody) *money.Money {
+	if p == nil {
+		return nil
+	}
+	m, _ := p.money()
+	return &m
+}
+
+// newPriceBody is request body form of a saved price
+func newPriceBody(m *money.Money) *priceBody {
+	return &priceBody{Amount: json.Number(m.Decimal()), Currency: m.Currency}
+}
+
+// price returns validated price of the request body, nil when it leaves price alone
+func (b bookRequestBody) price() *money.Money {
+	return parsedPrice(b.Price)
+}
+
+// priceDTO writes a price as amount and currency, or as a plain integer in compatibility mode
+type priceDTO struct {
+	Amount   string `json:"amount" example:"19.99"`
+	Currency string `json:"currency" example:"EUR"`
+	// plain is the price integer clients get, nil when they get amount and currency
+	plain *int64
+}
+
+func newPriceDTO(m *money.Money) *priceDTO {
+	if m == nil {
+		return nil
+	}
+	dto := &priceDTO{Amount: m.Decimal(), Currency: m.Currency}
+	if integerPrices() {
+		dto.plain = plainAmount(*m)
+	}
+	return dto
+}
+
+// plainAmount returns price in whole units of the default currency, the only prices integer clients read.
+// Prices with a fraction or in another currency are nil, they are written with amount and currency
+// so that nothing is lost and integer clients fail on them rather than read a wrong price
+func plainAmount(m money.Money) *int64 {
+	exp, _ := money.Exponent(m.Currency)
+	scale := int64(math.Pow10(exp))
+	if m.Currency != defaultCurrency() || m.Amount%scale != 0 {
+		return nil
+	}
+	units := m.Amount / scale
+	return &units
+}
+
+func (p priceDTO) MarshalJSON() ([]byte, error) {
+	if p.plain != nil {
+		return json.Marshal(*p.plain)
+	}
+	type fields priceDTO
+	return json.Marshal(fields(p))
+}
+
+// priceAmount is value of a price column, both drivers take numeric: pgx natively and sqlite as decimal text
+func priceAmount(m *money.Money) pgtype.Numeric {
+	if m == nil {
+		return pgtype.Numeric{}
+	}
+	exp, _ := money.Exponent(m.Currency)
+	return pgtype.Numeric{Int: big.NewInt(m.Amount), Exp: int32(-exp), Valid: true}
+}
+
+// priceCurrency is value of the currency column next to a price column
+func priceCurrency(m *money.Money) *string {
+	if m == nil {
+		return nil
+	}
+	return &m.Currency
+}
+
+// samePrice tells whether prices are equal, nil ones included
+func samePrice(a, b *money.Money) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+// validate checks price and makes sure it is scheduled for the future
+func (b *priceScheduleRequestBody) validate() error {
+	if b.Price == nil {
+		return database.BadRequestErr{Message: "price is required"}
+	}
+	if err := validatePrice(b.Price); err != nil {
+		return err
+	}
+	if b.EffectiveFrom == nil || !b.EffectiveFrom.After(time.Now()) {
+		return database.BadRequestErr{Message: "effectiveFrom must be a time in the future"}
+	}
+	return nil
+}
+
+// priceChanged tells whether a change of a book sets another price, before is nil for created book
+func priceChanged(before, after *bookEntity) bool {
+	var was *money.Money
+	if before != nil {
+		was = before.Price
+	}
+	return !samePrice(was, after.Price)
+}
+
+// newPriceEntry starts price history entry of the current price of b, actor and request id come from ctx like for audit
+func newPriceEntry(ctx context.Context, b *bookEntity) priceEntry {
+	e := priceEntry{
+		BookID:    b.ID,
+		Price:     b.Price,
+		Actor:     middlewares.ActorFromContext(ctx),
+		RequestID: middlewares.RequestIDFromContext(ctx),
+	}
+	if e.Actor == "" {
+		e.Actor = systemActor
+	}
+	return e
+}
+
+// newScheduledPrice is validated price schedule of a book made by request ctx belongs to
+func newScheduledPrice(ctx context.Context, bookID int, b priceScheduleRequestBody) scheduledPrice {
+	s := scheduledPrice{
+		BookID:        bookID,
+		Price:         parsedPrice(b.Price),
+		EffectiveFrom: b.EffectiveFrom.UTC(),
+		Actor:         middlewares.ActorFromContext(ctx),
+		RequestID:     middlewares.RequestIDFromContext(ctx),
+	}
+	if s.Actor == "" {
+		s.Actor = systemActor
+	}
+	return s
+}
+
+// priceEntryColumns are selected in order of priceEntryFields
+const priceEntryColumns = `id, book_id, price, currency, effective_from, effective_to, actor, request_id`
+
+func priceEntryFields(e *priceEntry) []any {
+	price, currency := money.ScanFields(&e.Price)
+	return []any{&e.ID, &e.BookID, price, currency, &e.EffectiveFrom, &e.EffectiveTo, &e.Actor, &e.RequestID}
+}
+
+// scheduledPriceColumns are selected in order of scheduledPriceFields
+const scheduledPriceColumns = `id, book_id, price, currency, effective_from, actor, request_id, created_at`
+
+func scheduledPriceFields(s *scheduledPrice) []any {
+	price, currency := money.ScanFields(&s.Price)
+	return []any{&s.ID, &s.BookID, price, currency, &s.EffectiveFrom, &s.Actor, &s.RequestID, &s.CreatedAt}
+}
+
+// IPricesRepo keeps price history and scheduled prices of books. Prices belong to books, so every books
+// repository implements it
+type IPricesRepo interface {
+	// GetPrices lists price history of a live book with its scheduled prices. Every write of IBooksRepo which
+	// changes price of a book ends its current history entry and starts one of the new price in the same transaction
+	GetPrices(ctx context.Context, bookID int) (pricesEntity, error)
+	// SchedulePrice adds price the scheduler sets for a live book at effectiveFrom of b and returns its id
+	SchedulePrice(ctx context.Context, bookID int, b priceScheduleRequestBody) (int, error)
+	// GetDuePrices lists scheduled prices of live books due at now in the order they apply
+	GetDuePrices(ctx context.Context, now time.Time) ([]scheduledPrice, error)
+	// RemoveScheduledPrice removes scheduled price, it fails with NotFoundErr when the price is gone already
+	RemoveScheduledPrice(ctx context.Context, id int) error
+}
+
+func scheduledPriceNotFound(id int) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("scheduled price with id %d not found", id)}
+}
@@ -0,0 +1,130 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// PricesAPI serves price history and scheduled prices of books kept by books repository, see API.Prices
+type PricesAPI struct {
+	repo IPricesRepo
+	// books sets scheduled prices as prices of their books
+	books IBooksRepo
+	// inTx runs a unit of work of repo calls, see database.Storage
+	inTx func(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// GetPrices returns price history of a book
+//
+//	@Summary		Prices of book
+//	@Description	lists prices a book had oldest first, the current one has no effectiveTo. Scheduled prices follow
+//	@Description	in the order they apply
+//	@Tags			prices
+//	@Produce		json
+//	@Param			id	path		int	true	"book record Id"
+//	@Success		200	{object}	pricesDTO
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/books/{id}/prices [get]
+func (api PricesAPI) GetPrices(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	prices, err := api.repo.GetPrices(r.Context(), id)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	json, _ := json.Marshal(prices.ToDto())
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// SchedulePrice schedules a price of a book
+//
+//	@Summary		Schedule price
+//	@Description	the price becomes price of the book at effectiveFrom, it is set by a background scheduler
+//	@Description	within prices.scheduleInterval and recorded in price history like any other price change
+//	@Tags			prices
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"book record Id"
+//	@Param			price	body		priceScheduleRequestBody	true	"request body"
+//	@Success		201		{object}	ActionResponse
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Failure		404		{object}	database.APIError
+//	@Router			/api/books/{id}/prices [post]
+func (api PricesAPI) SchedulePrice(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	req, apiErr := decodePriceSchedule(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	var scheduledID int
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		scheduledID, err = api.repo.SchedulePrice(ctx, id, req)
+		return err
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	j, _ := json.Marshal(ActionResponse{ResourceId: scheduledID})
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, string(j[:]))
+}
+
+func decodePriceSchedule(r *http.Request) (priceScheduleRequestBody, *database.APIError) {
+	var req priceScheduleRequestBody
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, &database.APIError{
+			Message: "invalid request model",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if err := req.validate(); err != nil {
+		return req, &database.APIError{
+			Message: err.Error(),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	return req, nil
+}
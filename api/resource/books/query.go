@@ -0,0 +1,642 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/money"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+
+	// nullSortValue takes place of missing numeric values while sorting,
+	// so books without price or pages come first in ascending order
+	nullSortValue = -1
+)
+
+// sortableFields maps bookDTO fields which can be used in sort query parameter
+// to whether their values are numbers
+var sortableFields = map[string]bool{
+	"id":            true,
+	"title":         false,
+	"author":        false,
+	"genre":         false,
+	"numberOfPages": true,
+	"price":         true,
+	"releaseYear":   true,
+}
+
+// decimalSortFields are numeric sortableFields which are not integers, cursors hold their values as floats
+var decimalSortFields = map[string]bool{
+	"price": true,
+}
+
+// bookCursor is the keyset position of the last book on a page,
+// it is handed out to clients as an opaque base64 string.
+// Values holds sort key values of that book and Sort the sort they belong to
+type bookCursor struct {
+	ID     int    `json:"id"`
+	Sort   string `json:"sort,omitempty"`
+	Values []any  `json:"values,omitempty"`
+}
+
+func newCursor(b bookEntity, keys []sortKey) bookCursor {
+	c := bookCursor{
+		ID:   b.ID,
+		Sort: sortSpec(keys),
+	}
+	for _, k := range keys {
+		c.Values = append(c.Values, sortValue(b, k.Field))
+	}
+	return c
+}
+
+func (c bookCursor) encode() string {
+	j, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(j)
+}
+
+func decodeCursor(s string) (bookCursor, error) {
+	var c bookCursor
+	j, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(j))
+	decoder.UseNumber()
+	err = decoder.Decode(&c)
+	return c, err
+}
+
+// matchSort checks that cursor was issued for given sort and converts its values to field types
+func (c *bookCursor) matchSort(keys []sortKey) error {
+	if c.Sort != sortSpec(keys) || len(c.Values) != len(keys) {
+		return errors.New("cursor does not match sort")
+	}
+
+	for i, k := range keys {
+		switch v := c.Values[i].(type) {
+		case json.Number:
+			if decimalSortFields[k.Field] {
+				f, err := v.Float64()
+				if err != nil {
+					return errors.New("cursor does not match sort")
+				}
+				c.Values[i] = f
+				continue
+			}
+			n, err := v.Int64()
+			if err != nil || !sortableFields[k.Field] {
+				return errors.New("cursor does not match sort")
+			}
+			c.Values[i] = int(n)
+		case string:
+			if sortableFields[k.Field] {
+				return errors.New("cursor does not match sort")
+			}
+		default:
+			return errors.New("cursor does not match sort")
+		}
+	}
+
+	return nil
+}
+
+func sortValue(b bookEntity, field string) any {
+	orNull := func(v *int) int {
+		if v == nil {
+			return nullSortValue
+		}
+		return *v
+	}
+
+	switch field {
+	case "id":
+		return b.ID
+	case "title":
+		return b.Title
+	case "author":
+		return b.Author
+	case "genre":
+		return b.Genre
+	case "numberOfPages":
+		return orNull(b.NumberOfPages)
+	case "price":
+		if b.Price == nil {
+			return float64(nullSortValue)
+		}
+		return b.Price.Float64()
+	case "releaseYear":
+		return orNull(b.ReleaseYear)
+	}
+	return nil
+}
+
+// sortSpec formats keys back into sort query parameter form
+func sortSpec(keys []sortKey) string {
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if k.Desc {
+			parts = append(parts, "-"+k.Field)
+		} else {
+			parts = append(parts, k.Field)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseSort parses comma separated field list, field prefixed with "-" is sorted in descending order
+func parseSort(values url.Values) ([]sortKey, *database.APIError) {
+	keys := make([]sortKey, 0)
+	if !values.Has("sort") {
+		return keys, nil
+	}
+	if len(values["sort"]) > 1 {
+		e := invalidParamErr("sort")
+		return keys, &e
+	}
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(values.Get("sort"), ",") {
+		k := sortKey{Field: strings.TrimPrefix(part, "-")}
+		k.Desc = k.Field != part
+
+		if _, ok := sortableFields[k.Field]; !ok || seen[k.Field] {
+			e := database.APIError{
+				Status:  http.StatusBadRequest,
+				Message: fmt.Sprintf("invalid sort field %q in sort query parameter", part),
+			}
+			return keys, &e
+		}
+		seen[k.Field] = true
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+func invalidParamErr(name string) database.APIError {
+	return database.APIError{
+		Status:  http.StatusBadRequest,
+		Message: fmt.Sprintf("invalid value for %s query parameter", name),
+	}
+}
+
+// pageQueryParams lists query parameters GET /api/books understands besides filterQueryParams
+var pageQueryParams = map[string]bool{
+	"limit":  true,
+	"offset": true,
+	"after":  true,
+	"sort":   true,
+}
+
+// filterQueryParams lists query parameters parseBooksFilter reads
+var filterQueryParams = map[string]bool{
+	"title":            true,
+	"author":           true,
+	"genre":            true,
+	"minPrice":         true,
+	"maxPrice":         true,
+	"currency":         true,
+	"pagesGt":          true,
+	"pagesLt":          true,
+	"releaseYearFrom":  true,
+	"releaseYearTo":    true,
+	"includeSubgenres": true,
+}
+
+// unknownParamErr reports the first query parameter missing from all known sets
+func unknownParamErr(values url.Values, known ...map[string]bool) *database.APIError {
+	for name := range values {
+		if !slices.ContainsFunc(known, func(params map[string]bool) bool { return params[name] }) {
+			return &database.APIError{
+				Status:  http.StatusBadRequest,
+				Message: fmt.Sprintf("unknown query parameter %s", name),
+			}
+		}
+	}
+	return nil
+}
+
+func parseStrParam(values url.Values, name string) (*string, *database.APIError) {
+	if !values.Has(name) {
+		return nil, nil
+	}
+	v := values.Get(name)
+	if v == "" || len(values[name]) > 1 {
+		e := invalidParamErr(name)
+		return nil, &e
+	}
+	return &v, nil
+}
+
+func parseIntParam(values url.Values, name string) (*int, *database.APIError) {
+	if !values.Has(name) {
+		return nil, nil
+	}
+	v, err := strconv.Atoi(values.Get(name))
+	if err != nil || v < 0 || len(values[name]) > 1 {
+		e := invalidParamErr(name)
+		return nil, &e
+	}
+	return &v, nil
+}
+
+// parseDecimalParam reads a non negative decimal number like 19.99
+func parseDecimalParam(values url.Values, name string) (*float64, *database.APIError) {
+	if !values.Has(name) {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(values.Get(name), 64)
+	if err != nil || v < 0 || math.IsNaN(v) || math.IsInf(v, 0) || len(values[name]) > 1 {
+		e := invalidParamErr(name)
+		return nil, &e
+	}
+	return &v, nil
+}
+
+// parseCurrencyParam reads an ISO 4217 currency code, lowercase codes are accepted
+func parseCurrencyParam(values url.Values, name string) (*string, *database.APIError) {
+	v, e := parseStrParam(values, name)
+	if v == nil || e != nil {
+		return v, e
+	}
+	code := strings.ToUpper(strings.TrimSpace(*v))
+	if _, ok := money.Exponent(code); !ok {
+		e := invalidParamErr(name)
+		return nil, &e
+	}
+	return &code, nil
+}
+
+func parseBooksFilter(values url.Values) (booksFilter, *database.APIError) {
+	var f booksFilter
+	var e *database.APIError
+
+	strParams := []struct {
+		name string
+		dst  **string
+	}{
+		{"title", &f.Title},
+		{"author", &f.Author},
+		{"genre", &f.Genre},
+	}
+	for _, p := range strParams {
+		if *p.dst, e = parseStrParam(values, p.name); e != nil {
+			return f, e
+		}
+	}
+
+	intParams := []struct {
+		name string
+		dst  **int
+	}{
+		{"pagesGt", &f.PagesGt},
+		{"pagesLt", &f.PagesLt},
+		{"releaseYearFrom", &f.ReleaseYearFrom},
+		{"releaseYearTo", &f.ReleaseYearTo},
+	}
+	for _, p := range intParams {
+		if *p.dst, e = parseIntParam(values, p.name); e != nil {
+			return f, e
+		}
+	}
+	if f.Currency, e = parseCurrencyParam(values, "currency"); e != nil {
+		return f, e
+	}
+	if f.MinPrice, e = parseDecimalParam(values, "minPrice"); e != nil {
+		return f, e
+	}
+	if f.MaxPrice, e = parseDecimalParam(values, "maxPrice"); e != nil {
+		return f, e
+	}
+	// amounts of different currencies can't be compared
+	for _, p := range []struct {
+		name  string
+		value *float64
+	}{{"minPrice", f.MinPrice}, {"maxPrice", f.MaxPrice}} {
+		if p.value != nil && f.Currency == nil {
+			return f, &database.APIError{
+				Status:  http.StatusBadRequest,
+				Message: fmt.Sprintf("%s query parameter requires currency", p.name),
+			}
+		}
+	}
+	if f.MinPrice != nil && f.MaxPrice != nil && *f.MaxPrice < *f.MinPrice {
+		return f, &database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "minPrice query parameter can't be greater than maxPrice",
+		}
+	}
+
+	if values.Has("includeSubgenres") {
+		include, err := strconv.ParseBool(values.Get("includeSubgenres"))
+		if err != nil || len(values["includeSubgenres"]) > 1 {
+			e := invalidParamErr("includeSubgenres")
+			return f, &e
+		}
+		if include && f.Genre == nil {
+			return f, &database.APIError{
+				Status:  http.StatusBadRequest,
+				Message: "includeSubgenres query parameter requires genre",
+			}
+		}
+		f.IncludeSubgenres = include
+	}
+
+	ranges := []struct {
+		lowName, topName string
+		low, top         *int
+	}{
+		{"pagesGt", "pagesLt", f.PagesGt, f.PagesLt},
+		{"releaseYearFrom", "releaseYearTo", f.ReleaseYearFrom, f.ReleaseYearTo},
+	}
+	for _, r := range ranges {
+		if r.low != nil && r.top != nil && *r.top < *r.low {
+			err := database.APIError{
+				Status:  http.StatusBadRequest,
+				Message: fmt.Sprintf("%s query parameter can't be greater than %s", r.lowName, r.topName),
+			}
+			return f, &err
+		}
+	}
+
+	return f, nil
+}
+
+func parseBooksQuery(values url.Values) (booksQuery, *database.APIError) {
+	q := booksQuery{
+		Limit: defaultPageLimit,
+	}
+
+	if e := unknownParamErr(values, pageQueryParams, filterQueryParams); e != nil {
+		return q, e
+	}
+
+	f, e := parseBooksFilter(values)
+	if e != nil {
+		return q, e
+	}
+	q.Filter = f
+
+	keys, e := parseSort(values)
+	if e != nil {
+		return q, e
+	}
+	if slices.ContainsFunc(keys, func(k sortKey) bool { return k.Field == "price" }) && f.Currency == nil {
+		return q, &database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "sort by price requires currency query parameter",
+		}
+	}
+	q.Sort = keys
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			e := invalidParamErr("limit")
+			return q, &e
+		}
+		q.Limit = limit
+	}
+
+	if v := values.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			e := invalidParamErr("offset")
+			return q, &e
+		}
+		q.Offset = offset
+	}
+
+	if v := values.Get("after"); v != "" {
+		if q.Offset != 0 {
+			e := database.APIError{
+				Status:  http.StatusBadRequest,
+				Message: "offset and after query parameters can't be used together",
+			}
+			return q, &e
+		}
+		c, err := decodeCursor(v)
+		if err == nil {
+			err = c.matchSort(q.Sort)
+		}
+		if err != nil {
+			e := invalidParamErr("after")
+			return q, &e
+		}
+		q.After = &c
+	}
+
+	return q, nil
+}
+
+func parseExportQuery(values url.Values) (exportQuery, *database.APIError) {
+	q := exportQuery{
+		Format: exportFormats["csv"],
+	}
+
+	if e := unknownParamErr(values, map[string]bool{"format": true}, filterQueryParams); e != nil {
+		return q, e
+	}
+
+	if values.Has("format") {
+		format, ok := exportFormats[values.Get("format")]
+		if !ok || len(values["format"]) > 1 {
+			e := database.APIError{
+				Status:  http.StatusBadRequest,
+				Message: fmt.Sprintf("format query parameter must be one of %s", strings.Join(exportFormatNames(), ", ")),
+			}
+			return q, &e
+		}
+		q.Format = format
+	}
+
+	f, e := parseBooksFilter(values)
+	q.Filter = f
+	return q, e
+}
+
+func parseSearchQuery(values url.Values) (searchQuery, *database.APIError) {
+	q := searchQuery{
+		Limit: defaultPageLimit,
+	}
+
+	if e := unknownParamErr(values, map[string]bool{"q": true, "limit": true, "offset": true}); e != nil {
+		return q, e
+	}
+
+	text, e := parseStrParam(values, "q")
+	if e != nil {
+		return q, e
+	}
+	if text != nil {
+		q.Terms = strings.FieldsFunc(*text, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+	}
+	if len(q.Terms) == 0 {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "q query parameter must contain at least one word",
+		}
+		return q, &e
+	}
+
+	q.Limit, q.Offset, e = parsePaging(values)
+	return q, e
+}
+
+// parsePaging reads limit and offset query parameters, limit defaults to defaultPageLimit
+func parsePaging(values url.Values) (limit int, offset int, e *database.APIError) {
+	limit = defaultPageLimit
+
+	l, e := parseIntParam(values, "limit")
+	if e != nil {
+		return 0, 0, e
+	}
+	if l != nil {
+		if *l < 1 || *l > maxPageLimit {
+			err := invalidParamErr("limit")
+			return 0, 0, &err
+		}
+		limit = *l
+	}
+
+	o, e := parseIntParam(values, "offset")
+	if e != nil {
+		return 0, 0, e
+	}
+	if o != nil {
+		offset = *o
+	}
+
+	return limit, offset, nil
+}
+
+func parseHistoryQuery(values url.Values) (historyQuery, *database.APIError) {
+	var q historyQuery
+
+	if e := unknownParamErr(values, map[string]bool{"limit": true, "offset": true}); e != nil {
+		return q, e
+	}
+
+	var e *database.APIError
+	q.Limit, q.Offset, e = parsePaging(values)
+	return q, e
+}
+
+// requestURL returns url the client actually called, before any group prefix was stripped
+func requestURL(r *http.Request) *url.URL {
+	if r.RequestURI != "" {
+		if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+			return u
+		}
+	}
+	if r.URL != nil {
+		u := *r.URL
+		return &u
+	}
+	return &url.URL{}
+}
+
+func pageLink(base *url.URL, rel string, set map[string]string, del ...string) string {
+	u := *base
+	values := u.Query()
+	for _, k := range del {
+		values.Del(k)
+	}
+	for k, v := range set {
+		values.Set(k, v)
+	}
+	u.RawQuery = values.Encode()
+	return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+}
+
+// pageLinks builds RFC 8288 Link header values for the page
+func pageLinks(r *http.Request, q booksQuery, page booksPage) []string {
+	base := requestURL(r)
+	limit := strconv.Itoa(q.Limit)
+	links := make([]string, 0, 3)
+
+	links = append(links, pageLink(base, "first", map[string]string{"limit": limit}, "offset", "after"))
+
+	if page.NextCursor != nil {
+		if q.After != nil {
+			links = append(links, pageLink(base, "next",
+				map[string]string{"limit": limit, "after": *page.NextCursor}, "offset"))
+		} else {
+			links = append(links, pageLink(base, "next",
+				map[string]string{"limit": limit, "offset": strconv.Itoa(q.Offset + q.Limit)}, "after"))
+		}
+	}
+
+	if q.After == nil && q.Offset > 0 {
+		prev := max(q.Offset-q.Limit, 0)
+		links = append(links, pageLink(base, "prev",
+			map[string]string{"limit": limit, "offset": strconv.Itoa(prev)}, "after"))
+	}
+
+	return links
+}
+
+func parseMovementsQuery(values url.Values) (movementsQuery, *database.APIError) {
+	var q movementsQuery
+
+	if e := unknownParamErr(values, map[string]bool{"limit": true, "offset": true}); e != nil {
+		return q, e
+	}
+
+	var e *database.APIError
+	q.Limit, q.Offset, e = parsePaging(values)
+	return q, e
+}
+
+// parseReviewsQuery reads paging of reviews of a book, which are listed once approved
+func parseReviewsQuery(values url.Values) (reviewsQuery, *database.APIError) {
+	q := reviewsQuery{Status: reviewApproved}
+
+	if e := unknownParamErr(values, map[string]bool{"limit": true, "offset": true}); e != nil {
+		return q, e
+	}
+
+	var e *database.APIError
+	q.Limit, q.Offset, e = parsePaging(values)
+	return q, e
+}
+
+// parseModerationQuery reads status and paging of the moderation queue, pending reviews are listed by default
+func parseModerationQuery(values url.Values) (reviewsQuery, *database.APIError) {
+	q := reviewsQuery{Status: reviewPending}
+
+	if e := unknownParamErr(values, map[string]bool{"status": true, "limit": true, "offset": true}); e != nil {
+		return q, e
+	}
+
+	status, e := parseStrParam(values, "status")
+	if e != nil {
+		return q, e
+	}
+	if status != nil {
+		if !slices.Contains(reviewStatuses, *status) {
+			e := invalidParamErr("status")
+			return q, &e
+		}
+		q.Status = *status
+	}
+
+	q.Limit, q.Offset, e = parsePaging(values)
+	return q, e
+}
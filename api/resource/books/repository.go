@@ -2,175 +2,1849 @@ package books
 
 import (
 	"booksapi/api/database"
+	"booksapi/api/money"
 	"booksapi/logger"
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type IBooksRepo interface {
-	GetBooks() ([]bookEntity, error)
-	GetBookById(int) (bookEntity, error)
-	AddBook(bookRequestBody) (int, error)
-	RemoveBook(int) error
-	UpdateBook(int, bookRequestBody) error
+	GetBooks(context.Context, booksQuery) (booksPage, error)
+	SearchBooks(context.Context, searchQuery) (searchPage, error)
+	GetBookById(context.Context, int) (bookEntity, error)
+	// GetBookByISBN finds live book which has an edition of given ISBN-13, not only the first one
+	GetBookByISBN(ctx context.Context, isbn string) (bookEntity, error)
+	// AddBook, UpdateBook, ImportBooks and edition writes fail with ConflictErr when isbn belongs to another edition
+	AddBook(context.Context, bookRequestBody) (int, error)
+	// RemoveBook and UpdateBook fail with PreconditionErr when ifMatch is set and differs from book version.
+	// RemoveBook moves the book to trash, trashed books are invisible to every other method
+	// except GetBooks with Filter.Deleted set
+	RemoveBook(ctx context.Context, id int, ifMatch *int) error
+	UpdateBook(ctx context.Context, id int, b bookRequestBody, ifMatch *int) (int, error)
+	// RestoreBook takes the book out of trash, it fails with NotFoundErr when book is not in trash
+	RestoreBook(ctx context.Context, id int) error
+	// PurgeBooks permanently deletes books moved to trash before given time and returns their count
+	PurgeBooks(ctx context.Context, before time.Time) (int, error)
+	// ImportBooks adds books of rows without Errors in one transaction and returns their count, audit records
+	// each of them. Rows which name a series that does not exist get an error and are left out.
+	// Dry run checks the rows the same way and adds none of them
+	ImportBooks(ctx context.Context, rows []importRow, dryRun bool) (int, error)
+	// ExportBooks passes books matching filter to fn in id order as they are read, without holding
+	// all of them in memory. Export is not bound by query timeout, error returned by fn stops it
+	// and is returned as is
+	ExportBooks(ctx context.Context, f booksFilter, fn func(bookEntity) error) error
+	// GetBookHistory lists changes of a book, every write above records one in the same transaction
+	GetBookHistory(ctx context.Context, id int, q historyQuery) (historyPage, error)
+	// GetEditions lists editions of a live book in id order, the first one is the edition
+	// book copies ISBN, NumberOfPages and Price of
+	GetEditions(ctx context.Context, bookID int) ([]editionEntity, error)
+	// GetEditionById finds edition of a live book, editions of trashed books are not found
+	GetEditionById(ctx context.Context, id int) (editionEntity, error)
+	// AddEdition, UpdateEdition and RemoveEdition fail with BadRequestErr when publisher does not exist.
+	// Change of the first edition updates the book and is recorded in its history
+	AddEdition(ctx context.Context, bookID int, b editionRequestBody) (int, error)
+	UpdateEdition(ctx context.Context, id int, b editionRequestBody) error
+	RemoveEdition(ctx context.Context, id int) error
+	// GetSeriesBooks lists books of the series in reading order, trashed ones included and books without position last.
+	// AddBook, UpdateBook and ImportBooks fail with BadRequestErr when series of a book does not exist
+	GetSeriesBooks(ctx context.Context, seriesID int) ([]bookEntity, error)
 }
 
-type BooksRepo struct{}
+func notInTrash(id int) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("book with id %d is not in trash", id)}
+}
+
+func versionMismatch(id int) database.PreconditionErr {
+	return database.PreconditionErr{Message: fmt.Sprintf("book with id %d does not match expected version", id)}
+}
+
+// editionErr turns unique violation of an edition write into isbnTaken, editions_isbn_idx
+// is their only unique index
+func editionErr(err error, isbn *string) error {
+	if isbn != nil && database.IsUniqueViolation(err) {
+		return isbnTaken(*isbn)
+	}
+	return err
+}
+
+// bookColumns are selected in order of bookFields
+const bookColumns = `id, title, author, genre, genre_id, isbn, number_of_pages, price, currency, release_year, series_id, series_position, rating_count, rating_sum, version, deleted_at`
+
+// bookFields returns scan destinations for bookColumns
+func bookFields(b *bookEntity) []any {
+	price, currency := money.ScanFields(&b.Price)
+	return []any{&b.ID, &b.Title, &b.Author, &b.Genre, &b.GenreID, &b.ISBN, &b.NumberOfPages, price, currency, &b.ReleaseYear,
+		&b.SeriesID, &b.SeriesPosition, &b.RatingCount, &b.RatingSum, &b.Version, &b.DeletedAt}
+}
+
+// auditColumns are selected in order of auditFields
+const auditColumns = `id, book_id, action, changes, actor, request_id, created_at`
+
+// auditFields returns scan destinations for auditColumns
+func auditFields(e *auditEntry) []any {
+	return []any{&e.ID, &e.BookID, &e.Action, &e.Changes, &e.Actor, &e.RequestID, &e.CreatedAt}
+}
+
+// prefixColumns qualifies every column of comma separated list with table alias
+func prefixColumns(alias, columns string) string {
+	parts := strings.Split(columns, ", ")
+	for i, c := range parts {
+		parts[i] = alias + "." + c
+	}
+	return strings.Join(parts, ", ")
+}
+
+// BooksRepo keeps books in postgres
+type BooksRepo struct {
+	pool *pgxpool.Pool
+	// authors are those of authors package, books are not linked to any author while it is nil
+	authors AuthorLinker
+}
+
+// whereClause turns filter into parameterized predicates and registers their values in args,
+// returned string starts with WHERE
+func whereClause(f booksFilter, args map[string]any) string {
+	predicates := make([]string, 0)
+
+	if f.Deleted {
+		predicates = append(predicates, "deleted_at IS NOT NULL")
+	} else {
+		predicates = append(predicates, "deleted_at IS NULL")
+	}
+
+	add := func(predicate, name string, value any) {
+		predicates = append(predicates, predicate)
+		args[name] = value
+	}
+
+	if f.Title != nil {
+		add("lower(title) = lower(@title)", "title", *f.Title)
+	}
+	if f.Author != nil {
+		add("lower(author) = lower(@author)", "author", *f.Author)
+	}
+	if f.Genre != nil && f.IncludeSubgenres {
+		add("genre_id IN ("+subgenresClause+")", "genre", *f.Genre)
+	} else if f.Genre != nil {
+		add("lower(genre) = lower(@genre)", "genre", *f.Genre)
+	}
+	if f.Currency != nil {
+		add("currency = @currency", "currency", *f.Currency)
+	}
+	if f.MinPrice != nil {
+		add("price >= @min_price", "min_price", *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		add("price <= @max_price", "max_price", *f.MaxPrice)
+	}
+	if f.PagesGt != nil {
+		add("number_of_pages > @pages_gt", "pages_gt", *f.PagesGt)
+	}
+	if f.PagesLt != nil {
+		add("number_of_pages < @pages_lt", "pages_lt", *f.PagesLt)
+	}
+	if f.ReleaseYearFrom != nil {
+		add("release_year >= @release_year_from", "release_year_from", *f.ReleaseYearFrom)
+	}
+	if f.ReleaseYearTo != nil {
+		add("release_year <= @release_year_to", "release_year_to", *f.ReleaseYearTo)
+	}
+
+	return "WHERE " + strings.Join(predicates, " AND ")
+}
+
+// sortColumns maps sortableFields to sql expressions,
+// nullable columns are coalesced so keyset comparisons work on them
+var sortColumns = map[string]string{
+	"id":            "id",
+	"title":         "title",
+	"author":        "author",
+	"genre":         "genre",
+	"numberOfPages": fmt.Sprintf("COALESCE(number_of_pages, %d)", nullSortValue),
+	"price":         fmt.Sprintf("COALESCE(price, %d)", nullSortValue),
+	"releaseYear":   fmt.Sprintf("COALESCE(release_year, %d)", nullSortValue),
+}
+
+func orderClause(keys []sortKey) string {
+	parts := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		if k.Desc {
+			parts = append(parts, sortColumns[k.Field]+" DESC")
+		} else {
+			parts = append(parts, sortColumns[k.Field]+" ASC")
+		}
+	}
+	parts = append(parts, "id ASC")
+	return "ORDER BY " + strings.Join(parts, ", ")
+}
+
+// keysetPredicate selects rows which come after cursor in keys order, e.g. for sort=-price,title
+// (price < @c0) OR (price = @c0 AND title > @c1) OR (price = @c0 AND title = @c1 AND id > @after_id)
+func keysetPredicate(keys []sortKey, c bookCursor, args map[string]any) string {
+	alternatives := make([]string, 0, len(keys)+1)
+	equals := make([]string, 0, len(keys))
+
+	for i, k := range keys {
+		name := fmt.Sprintf("c%d", i)
+		args[name] = c.Values[i]
+
+		op := ">"
+		if k.Desc {
+			op = "<"
+		}
+		cond := append(slices.Clip(equals), fmt.Sprintf("%s %s @%s", sortColumns[k.Field], op, name))
+		alternatives = append(alternatives, "("+strings.Join(cond, " AND ")+")")
+		equals = append(equals, fmt.Sprintf("%s = @%s", sortColumns[k.Field], name))
+	}
+
+	args["after_id"] = c.ID
+	cond := append(slices.Clip(equals), "id > @after_id")
+	alternatives = append(alternatives, "("+strings.Join(cond, " AND ")+")")
+
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+func (repo *BooksRepo) GetBooks(ctx context.Context, q booksQuery) (booksPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := booksPage{
+		Books: make([]bookEntity, 0),
+	}
+
+	countArgs := pgx.NamedArgs{}
+	countQuery := `SELECT count(*) FROM public.books ` + whereClause(q.Filter, countArgs)
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, countQuery, countArgs).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
 
-func (repo *BooksRepo) GetBooks() ([]bookEntity, error) {
-	result := make([]bookEntity, 0)
-	query := `SELECT * FROM public.books`
+	// one extra row is fetched to find out whether next page exists
+	args := pgx.NamedArgs{
+		"limit": q.Limit + 1,
+	}
+	query := `SELECT ` + bookColumns + ` FROM public.books `
+	if q.After != nil {
+		query += whereClause(q.Filter, args) + " AND " + keysetPredicate(q.Sort, *q.After, args) + " " +
+			orderClause(q.Sort) + ` LIMIT @limit`
+	} else {
+		query += whereClause(q.Filter, args) + " " + orderClause(q.Sort) + ` LIMIT @limit OFFSET @offset`
+		args["offset"] = q.Offset
+	}
 
-	rows, err := database.Pool.Query(context.Background(), query)
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, args)
 	if err != nil {
 		logger.Error(err.Error())
-		return result, internalErr{message: err.Error()}
+		return page, database.RepoErr(ctx, err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var r bookEntity
-		err := rows.Scan(&r.ID, &r.Title, &r.Author, &r.Genre,
-			&r.NumberOfPages, &r.Price, &r.ReleaseYear)
+		err := rows.Scan(bookFields(&r)...)
+		if err != nil {
+			logger.Error(err.Error())
+			return page, database.RepoErr(ctx, err)
+		}
+		page.Books = append(page.Books, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	if len(page.Books) > q.Limit {
+		page.Books = page.Books[:q.Limit]
+		cursor := newCursor(page.Books[q.Limit-1], q.Sort).encode()
+		page.NextCursor = &cursor
+	}
+
+	return page, nil
+}
+
+// searchDocument is the weighted text SearchBooks matches against, title matches rank highest.
+// books_search_idx index is built on the same expression, keep them in sync
+const searchDocument = `setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+                        setweight(to_tsvector('simple', coalesce(author, '')), 'B') ||
+                        setweight(to_tsvector('simple', coalesce(genre, '')), 'C')`
+
+const headlineOptions = `StartSel=<mark>, StopSel=</mark>, HighlightAll=true`
+
+func (repo *BooksRepo) SearchBooks(ctx context.Context, q searchQuery) (searchPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := searchPage{
+		Results: make([]bookSearchResult, 0),
+	}
+
+	// every term is matched as a prefix, "fellow ring" becomes "fellow:* & ring:*"
+	terms := make([]string, 0, len(q.Terms))
+	for _, t := range q.Terms {
+		terms = append(terms, t+":*")
+	}
+	args := pgx.NamedArgs{
+		"query":    strings.Join(terms, " & "),
+		"limit":    q.Limit,
+		"offset":   q.Offset,
+		"headline": headlineOptions,
+	}
+
+	countQuery := `SELECT count(*) FROM public.books
+                   WHERE deleted_at IS NULL AND ` + searchDocument + ` @@ to_tsquery('simple', @query)`
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, countQuery, args).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	// headlines are expensive so they are built only for rows of the requested page
+	query := `WITH matches AS (
+                  SELECT b.*, ts_rank(` + searchDocument + `, q) AS rank, q
+                  FROM public.books b, to_tsquery('simple', @query) q
+                  WHERE b.deleted_at IS NULL AND ` + searchDocument + ` @@ q
+                  ORDER BY rank DESC, id
+                  LIMIT @limit OFFSET @offset
+              )
+              SELECT ` + bookColumns + `, rank,
+                     ts_headline('simple', title, q, @headline),
+                     ts_headline('simple', author, q, @headline),
+                     ts_headline('simple', genre, q, @headline)
+              FROM matches
+              ORDER BY rank DESC, id`
+
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r bookSearchResult
+		err := rows.Scan(append(bookFields(&r.Book),
+			&r.Rank, &r.Highlights.Title, &r.Highlights.Author, &r.Highlights.Genre)...)
 		if err != nil {
 			logger.Error(err.Error())
-			return result, internalErr{message: err.Error()}
+			return page, database.RepoErr(ctx, err)
 		}
-		result = append(result, r)
+		page.Results = append(page.Results, r)
 	}
 
 	if err = rows.Err(); err != nil {
 		logger.Error(err.Error())
-		return result, internalErr{message: err.Error()}
+		return page, database.RepoErr(ctx, err)
 	}
 
-	return result, nil
+	return page, nil
 }
 
-func (repo *BooksRepo) GetBookById(id int) (bookEntity, error) {
-	query := `SELECT * FROM public.books WHERE id = @id`
+func (repo *BooksRepo) GetBookById(ctx context.Context, id int) (bookEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + bookColumns + ` FROM public.books WHERE id = @id AND deleted_at IS NULL`
 	args := pgx.NamedArgs{
 		"id": id,
 	}
 
 	var b bookEntity
-	err := database.Pool.QueryRow(context.Background(), query, args).
-		Scan(&b.ID, &b.Title, &b.Author, &b.Genre, &b.NumberOfPages, &b.Price, &b.ReleaseYear)
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, query, args).
+		Scan(bookFields(&b)...)
 	if err != nil {
 		logger.Error(err.Error())
 		if errors.Is(err, pgx.ErrNoRows) {
-			return b, notfoundErr{message: err.Error()}
+			return b, database.NotFoundErr{Message: err.Error()}
 		}
-		return b, internalErr{message: err.Error()}
+		return b, database.RepoErr(ctx, err)
 	}
 
 	return b, nil
 }
 
-func (repo *BooksRepo) AddBook(b bookRequestBody) (int, error) {
-	query := `INSERT INTO public.books
-                (title, author, genre, number_of_pages, price, release_year)
-                VALUES(@title, @author, @genre, @number_of_pages, @price, @release_year) RETURNING id`
-	args := pgx.NamedArgs{
-		"title":           b.Title,
-		"author":          b.Author,
-		"genre":           b.Genre,
-		"number_of_pages": b.NumberOfPages,
-		"price":           b.Price,
-		"release_year":    b.ReleaseYear,
-	}
+func (repo *BooksRepo) GetBookByISBN(ctx context.Context, isbn string) (bookEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
 
-	var id int
-	err := database.Pool.QueryRow(context.Background(), query, args).Scan(&id)
+	query := `SELECT ` + prefixColumns("b", bookColumns) + ` FROM public.books b
+              JOIN public.editions e ON e.book_id = b.id
+              WHERE e.isbn = @isbn AND b.deleted_at IS NULL`
+
+	var b bookEntity
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, query, pgx.NamedArgs{"isbn": isbn}).Scan(bookFields(&b)...)
 	if err != nil {
-		logger.Error(err.Error())
 		if errors.Is(err, pgx.ErrNoRows) {
-			return 0, notfoundErr{message: err.Error()}
+			return b, bookByISBNNotFound(isbn)
 		}
-		return 0, internalErr{message: err.Error()}
+		logger.Error(err.Error())
+		return b, database.RepoErr(ctx, err)
 	}
 
-	return id, nil
+	return b, nil
 }
 
-func (repo *BooksRepo) RemoveBook(id int) error {
-	query := `DELETE FROM public.books WHERE id = @id`
-	args := pgx.NamedArgs{
-		"id": id,
+// ExportBooks iterates rows of the query cursor, pgx reads them from the connection one at a time
+func (repo *BooksRepo) ExportBooks(ctx context.Context, f booksFilter, fn func(bookEntity) error) error {
+	args := pgx.NamedArgs{}
+	query := `SELECT ` + bookColumns + ` FROM public.books ` + whereClause(f, args) + ` ORDER BY id`
+
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
 	}
 
-	_, err := database.Pool.Exec(context.Background(), query, args)
+	var b bookEntity
+	var fnErr error
+	_, err = pgx.ForEachRow(rows, bookFields(&b), func() error {
+		fnErr = fn(b)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
 	if err != nil {
 		logger.Error(err.Error())
-		if errors.Is(err, pgx.ErrNoRows) {
-			return notfoundErr{message: err.Error()}
-		}
-		return internalErr{message: err.Error()}
+		return database.RepoErr(ctx, err)
 	}
 
 	return nil
 }
 
-func (repo *BooksRepo) UpdateBook(id int, b bookRequestBody) error {
-	existing, err := repo.GetBookById(id)
-	if err != nil {
-		logger.Error(err.Error())
+// writeAudit records change of a book in transaction which made it
+func (repo *BooksRepo) writeAudit(ctx context.Context, tx pgx.Tx, e auditEntry) error {
+	query := `INSERT INTO public.book_audit (book_id, action, changes, actor, request_id)
+              VALUES (@book_id, @action, @changes, @actor, @request_id)`
+	args := pgx.NamedArgs{
+		"book_id":    e.BookID,
+		"action":     e.Action,
+		"changes":    string(e.Changes),
+		"actor":      e.Actor,
+		"request_id": e.RequestID,
+	}
+
+	_, err := tx.Exec(ctx, query, args)
+	return err
+}
+
+// writePriceHistory ends the current price history entry of a book and starts one of its new price
+// when the change of the book sets another price, in transaction which made the change
+func (repo *BooksRepo) writePriceHistory(ctx context.Context, tx pgx.Tx, before, after *bookEntity) error {
+	if !priceChanged(before, after) {
+		return nil
+	}
+
+	_, err := tx.Exec(ctx, `UPDATE public.price_history SET effective_to = now()
+                            WHERE book_id = @book_id AND effective_to IS NULL`, pgx.NamedArgs{"book_id": after.ID})
+	if err != nil || after.Price == nil {
 		return err
 	}
-	updated := bookEntity{
-		ID:            id,
-		Title:         existing.Title,
-		Author:        existing.Author,
-		Genre:         existing.Genre,
-		NumberOfPages: existing.NumberOfPages,
-		Price:         existing.Price,
-		ReleaseYear:   existing.ReleaseYear,
+
+	e := newPriceEntry(ctx, after)
+	args := pgx.NamedArgs{
+		"book_id":    e.BookID,
+		"price":      priceAmount(e.Price),
+		"currency":   priceCurrency(e.Price),
+		"actor":      e.Actor,
+		"request_id": e.RequestID,
 	}
+	_, err = tx.Exec(ctx, `INSERT INTO public.price_history (book_id, price, currency, actor, request_id)
+                           VALUES (@book_id, @price, @currency, @actor, @request_id)`, args)
+	return err
+}
 
-	if b.Author != nil {
-		updated.Author = *b.Author
+// lockBook reads a live or trashed book and locks its row until tx ends,
+// database errors are returned as they are so that RunPgxTx can retry on them
+func (repo *BooksRepo) lockBook(ctx context.Context, tx pgx.Tx, id int, deleted bool) (bookEntity, error) {
+	query := `SELECT ` + bookColumns + ` FROM public.books WHERE id = @id AND deleted_at IS NULL FOR UPDATE`
+	if deleted {
+		query = `SELECT ` + bookColumns + ` FROM public.books WHERE id = @id AND deleted_at IS NOT NULL FOR UPDATE`
 	}
-	if b.Title != nil {
-		updated.Title = *b.Author
+
+	var b bookEntity
+	err := tx.QueryRow(ctx, query, pgx.NamedArgs{"id": id}).Scan(bookFields(&b)...)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows) && deleted:
+		return b, notInTrash(id)
+	case errors.Is(err, pgx.ErrNoRows):
+		return b, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", id)}
 	}
-	if b.Genre != nil {
-		updated.Genre = *b.Genre
+
+	return b, err
+}
+
+// lockGenres reads genres books refer to in tx, key share lock keeps them from removal until tx ends
+// and leaves the rest of the taxonomy to other writers
+func (repo *BooksRepo) lockGenres(ctx context.Context, tx pgx.Tx, books ...bookRequestBody) (genreIndex, error) {
+	ids, names := genreRefs(books...)
+	if len(ids) == 0 && len(names) == 0 {
+		return newGenreIndex(map[int]string{}), nil
 	}
-	if b.NumberOfPages != nil {
-		updated.NumberOfPages = b.NumberOfPages
+
+	rows, err := tx.Query(ctx, `SELECT id, name FROM public.genres
+                                WHERE id = ANY(@ids) OR lower(name) = ANY(@names)
+                                FOR KEY SHARE`, pgx.NamedArgs{"ids": ids, "names": names})
+	if err != nil {
+		return genreIndex{}, err
 	}
-	if b.Price != nil {
-		updated.Price = b.Price
+
+	found := map[int]string{}
+	var id int
+	var name string
+	_, err = pgx.ForEachRow(rows, []any{&id, &name}, func() error {
+		found[id] = name
+		return nil
+	})
+
+	return newGenreIndex(found), err
+}
+
+// linkGenre links request body to a genre in tx, see bookRequestBody.linkGenre
+func (repo *BooksRepo) linkGenre(ctx context.Context, tx pgx.Tx, b bookRequestBody) (bookRequestBody, error) {
+	if !b.touchesGenre() {
+		return b, nil
 	}
-	if b.ReleaseYear != nil {
-		updated.ReleaseYear = b.ReleaseYear
+	genres, err := repo.lockGenres(ctx, tx, b)
+	if err != nil {
+		return b, err
 	}
+	return b.linkGenre(genres)
+}
+
+func (repo *BooksRepo) AddBook(ctx context.Context, b bookRequestBody) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO public.books
+                (title, author, genre, genre_id, isbn, number_of_pages, price, currency, release_year, series_id, series_position)
+                VALUES(@title, @author, COALESCE(@genre, ''), @genre_id, @isbn, @number_of_pages, @price, @currency, @release_year,
+                       @series_id, @series_position)
+                RETURNING ` + bookColumns
+
+	var created bookEntity
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		b, err := repo.linkGenre(ctx, tx, b)
+		if err != nil {
+			return err
+		}
+		if err := repo.lockSeries(ctx, tx, b.seriesID()); err != nil {
+			return err
+		}
+
+		args := pgx.NamedArgs{
+			"title":           b.Title,
+			"author":          b.Author,
+			"genre":           b.Genre,
+			"genre_id":        b.GenreID,
+			"isbn":            b.ISBN,
+			"number_of_pages": b.NumberOfPages,
+			"price":           priceAmount(b.price()),
+			"currency":        priceCurrency(b.price()),
+			"release_year":    b.ReleaseYear,
+			"series_id":       b.SeriesID.Value,
+			"series_position": b.SeriesPosition,
+		}
+
+		err = tx.QueryRow(ctx, query, args).Scan(bookFields(&created)...)
+		if err != nil {
+			return err
+		}
+		if b.touchesEdition() {
+			if err := repo.saveFirstEdition(ctx, tx, created); err != nil {
+				return err
+			}
+		}
+		if err := repo.writePriceHistory(ctx, tx, nil, &created); err != nil {
+			return err
+		}
+		if err := repo.writeAudit(ctx, tx, newAudit(ctx, auditCreate, nil, &created)); err != nil {
+			return err
+		}
+		return linkAuthors(ctx, repo.authors, created)
+	})
+
+	return created.ID, database.TxErr(ctx, err)
+}
+
+func (repo *BooksRepo) RemoveBook(ctx context.Context, id int, ifMatch *int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE public.books SET deleted_at = now(), version = version + 1
+              WHERE id = @id
+              RETURNING ` + bookColumns
+
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		existing, err := repo.lockBook(ctx, tx, id, false)
+		if err != nil {
+			return err
+		}
+		if ifMatch != nil && *ifMatch != existing.Version {
+			return versionMismatch(id)
+		}
+
+		var removed bookEntity
+		err = tx.QueryRow(ctx, query, pgx.NamedArgs{"id": id}).Scan(bookFields(&removed)...)
+		if err != nil {
+			return err
+		}
+		return repo.writeAudit(ctx, tx, newAudit(ctx, auditDelete, &existing, &removed))
+	})
 
-	logger.Info(fmt.Sprintf("title: %s, author: %s, genre: %s",
-		updated.Title, updated.Author, updated.Genre))
+	return database.TxErr(ctx, err)
+}
+
+func (repo *BooksRepo) UpdateBook(ctx context.Context, id int, b bookRequestBody, ifMatch *int) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
 
 	query := `UPDATE public.books
-	          SET title = @title, author = @author, genre = @genre, number_of_pages = @number_of_pages,
-                            price = @price, release_year = @release_year
-              WHERE id = @id`
+	          SET title = @title, author = @author, genre = @genre, genre_id = @genre_id,
+                  isbn = @isbn, number_of_pages = @number_of_pages, price = @price, currency = @currency,
+                  release_year = @release_year, series_id = @series_id, series_position = @series_position,
+                  version = version + 1
+              WHERE id = @id
+              RETURNING version`
+
+	var updated bookEntity
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		// row stays locked until commit so nothing can change the book between the read and the update
+		existing, err := repo.lockBook(ctx, tx, id, false)
+		if err != nil {
+			return err
+		}
+		if ifMatch != nil && *ifMatch != existing.Version {
+			return versionMismatch(id)
+		}
+		b, err := repo.linkGenre(ctx, tx, b)
+		if err != nil {
+			return err
+		}
+		if err := repo.lockSeries(ctx, tx, b.seriesID()); err != nil {
+			return err
+		}
+		updated = b.applyTo(existing)
+
+		args := pgx.NamedArgs{
+			"id":              id,
+			"title":           updated.Title,
+			"author":          updated.Author,
+			"genre":           updated.Genre,
+			"genre_id":        updated.GenreID,
+			"isbn":            updated.ISBN,
+			"number_of_pages": updated.NumberOfPages,
+			"price":           priceAmount(updated.Price),
+			"currency":        priceCurrency(updated.Price),
+			"release_year":    updated.ReleaseYear,
+			"series_id":       updated.SeriesID,
+			"series_position": updated.SeriesPosition,
+		}
+
+		err = tx.QueryRow(ctx, query, args).Scan(&updated.Version)
+		if err != nil {
+			return err
+		}
+		if b.touchesEdition() {
+			if err := repo.saveFirstEdition(ctx, tx, updated); err != nil {
+				return err
+			}
+		}
+		if err := repo.writePriceHistory(ctx, tx, &existing, &updated); err != nil {
+			return err
+		}
+		if err := repo.writeAudit(ctx, tx, newAudit(ctx, auditUpdate, &existing, &updated)); err != nil {
+			return err
+		}
+		if b.Author == nil {
+			return nil
+		}
+		return linkAuthors(ctx, repo.authors, updated)
+	})
+
+	return updated.Version, database.TxErr(ctx, err)
+}
+
+func (repo *BooksRepo) RestoreBook(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE public.books SET deleted_at = NULL, version = version + 1
+              WHERE id = @id
+              RETURNING ` + bookColumns
+
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		existing, err := repo.lockBook(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+
+		var restored bookEntity
+		err = tx.QueryRow(ctx, query, pgx.NamedArgs{"id": id}).Scan(bookFields(&restored)...)
+		if err != nil {
+			return err
+		}
+		return repo.writeAudit(ctx, tx, newAudit(ctx, auditRestore, &existing, &restored))
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+// lockPublisher checks that publisher exists in tx, key share lock keeps it from removal until tx ends
+func (repo *BooksRepo) lockPublisher(ctx context.Context, tx pgx.Tx, id *int) error {
+	if id == nil {
+		return nil
+	}
+
+	var found int
+	err := tx.QueryRow(ctx, `SELECT id FROM public.publishers WHERE id = @id FOR KEY SHARE`,
+		pgx.NamedArgs{"id": *id}).Scan(&found)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return publisherNotFound(*id)
+	}
+	return err
+}
+
+// lockSeries checks that series exists in tx, key share lock keeps it from removal until tx ends
+func (repo *BooksRepo) lockSeries(ctx context.Context, tx pgx.Tx, id *int) error {
+	if id == nil {
+		return nil
+	}
+
+	var found int
+	err := tx.QueryRow(ctx, `SELECT id FROM public.series WHERE id = @id FOR KEY SHARE`,
+		pgx.NamedArgs{"id": *id}).Scan(&found)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return unknownSeries(*id)
+	}
+	return err
+}
+
+// saveFirstEdition copies ISBN, NumberOfPages and Price of the book to its first edition,
+// the edition is added when the book has none
+func (repo *BooksRepo) saveFirstEdition(ctx context.Context, tx pgx.Tx, b bookEntity) error {
+	args := pgx.NamedArgs{
+		"book_id":         b.ID,
+		"isbn":            b.ISBN,
+		"number_of_pages": b.NumberOfPages,
+		"price":           priceAmount(b.Price),
+		"currency":        priceCurrency(b.Price),
+	}
+
+	tag, err := tx.Exec(ctx, `UPDATE public.editions
+                              SET isbn = @isbn, number_of_pages = @number_of_pages, price = @price, currency = @currency
+                              WHERE id = (SELECT min(id) FROM public.editions WHERE book_id = @book_id)`, args)
+	if err == nil && tag.RowsAffected() == 0 {
+		_, err = tx.Exec(ctx, `INSERT INTO public.editions (book_id, isbn, number_of_pages, price, currency)
+                               VALUES (@book_id, @isbn, @number_of_pages, @price, @currency)`, args)
+	}
+	return editionErr(err, b.ISBN)
+}
+
+// syncFirstEdition updates locked book with fields of its first edition after editions of the book change,
+// the update is recorded unless the book already has them
+func (repo *BooksRepo) syncFirstEdition(ctx context.Context, tx pgx.Tx, existing bookEntity) error {
+	var first *editionEntity
+	var e editionEntity
+	err := tx.QueryRow(ctx, `SELECT `+editionColumns+` FROM public.editions WHERE book_id = @book_id ORDER BY id LIMIT 1`,
+		pgx.NamedArgs{"book_id": existing.ID}).Scan(editionFields(&e)...)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		return err
+	default:
+		first = &e
+	}
+
+	updated := existing.copyFirstEdition(first)
+	if sameEditionFields(existing, updated) {
+		return nil
+	}
 
+	query := `UPDATE public.books
+              SET isbn = @isbn, number_of_pages = @number_of_pages, price = @price, currency = @currency,
+                  version = version + 1
+              WHERE id = @id
+              RETURNING version`
 	args := pgx.NamedArgs{
-		"id":              id,
-		"title":           updated.Title,
-		"author":          updated.Author,
-		"genre":           updated.Genre,
+		"id":              existing.ID,
+		"isbn":            updated.ISBN,
 		"number_of_pages": updated.NumberOfPages,
-		"price":           updated.Price,
-		"release_year":    updated.ReleaseYear,
+		"price":           priceAmount(updated.Price),
+		"currency":        priceCurrency(updated.Price),
+	}
+	if err := tx.QueryRow(ctx, query, args).Scan(&updated.Version); err != nil {
+		return err
+	}
+	if err := repo.writePriceHistory(ctx, tx, &existing, &updated); err != nil {
+		return err
+	}
+	return repo.writeAudit(ctx, tx, newAudit(ctx, auditUpdate, &existing, &updated))
+}
+
+// lockEdition reads an edition of a live book and locks the book until tx ends,
+// every change of editions locks their book first so the edition can't change after the read
+func (repo *BooksRepo) lockEdition(ctx context.Context, tx pgx.Tx, id int) (editionEntity, bookEntity, error) {
+	query := `SELECT ` + editionColumns + ` FROM public.editions WHERE id = @id`
+
+	var e editionEntity
+	err := tx.QueryRow(ctx, query, pgx.NamedArgs{"id": id}).Scan(editionFields(&e)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return e, bookEntity{}, editionNotFound(id)
+	}
+	if err != nil {
+		return e, bookEntity{}, err
+	}
+
+	b, err := repo.lockBook(ctx, tx, e.BookID, false)
+	var nf database.NotFoundErr
+	if errors.As(err, &nf) {
+		return e, b, editionNotFound(id)
+	}
+	if err != nil {
+		return e, b, err
 	}
 
-	_, err = database.Pool.Exec(context.Background(), query, args)
+	err = tx.QueryRow(ctx, query, pgx.NamedArgs{"id": id}).Scan(editionFields(&e)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return e, b, editionNotFound(id)
+	}
+	return e, b, err
+}
+
+func (repo *BooksRepo) GetEditions(ctx context.Context, bookID int) ([]editionEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var found bool
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM public.books WHERE id = @id AND deleted_at IS NULL)`,
+		pgx.NamedArgs{"id": bookID}).Scan(&found)
 	if err != nil {
 		logger.Error(err.Error())
-		return internalErr{message: err.Error()}
+		return nil, database.RepoErr(ctx, err)
+	}
+	if !found {
+		return nil, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
 	}
 
-	return nil
+	query := `SELECT ` + editionColumns + ` FROM public.editions WHERE book_id = @book_id ORDER BY id`
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, pgx.NamedArgs{"book_id": bookID})
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	editions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (editionEntity, error) {
+		var e editionEntity
+		err := row.Scan(editionFields(&e)...)
+		return e, err
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	return editions, nil
+}
+
+func (repo *BooksRepo) GetEditionById(ctx context.Context, id int) (editionEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + prefixColumns("e", editionColumns) + `
+              FROM public.editions e
+              JOIN public.books b ON b.id = e.book_id AND b.deleted_at IS NULL
+              WHERE e.id = @id`
+
+	var e editionEntity
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, query, pgx.NamedArgs{"id": id}).Scan(editionFields(&e)...)
+	if err != nil {
+		logger.Error(err.Error())
+		if errors.Is(err, pgx.ErrNoRows) {
+			return e, editionNotFound(id)
+		}
+		return e, database.RepoErr(ctx, err)
+	}
+
+	return e, nil
+}
+
+func (repo *BooksRepo) AddEdition(ctx context.Context, bookID int, b editionRequestBody) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO public.editions
+                (book_id, publisher_id, format, isbn, number_of_pages, price, currency, release_date)
+                VALUES (@book_id, @publisher_id, @format, @isbn, @number_of_pages, @price, @currency, @release_date)
+                RETURNING id`
+
+	var id int
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		existing, err := repo.lockBook(ctx, tx, bookID, false)
+		if err != nil {
+			return err
+		}
+		if err := repo.lockPublisher(ctx, tx, b.PublisherID); err != nil {
+			return err
+		}
+
+		e := b.applyTo(editionEntity{BookID: bookID})
+		args := editionArgs(e)
+		args["release_date"] = e.ReleaseDate
+
+		if err := tx.QueryRow(ctx, query, pgx.NamedArgs(args)).Scan(&id); err != nil {
+			return editionErr(err, e.ISBN)
+		}
+		return repo.syncFirstEdition(ctx, tx, existing)
+	})
+
+	return id, database.TxErr(ctx, err)
+}
+
+func (repo *BooksRepo) UpdateEdition(ctx context.Context, id int, b editionRequestBody) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE public.editions
+              SET publisher_id = @publisher_id, format = @format, isbn = @isbn,
+                  number_of_pages = @number_of_pages, price = @price, currency = @currency, release_date = @release_date
+              WHERE id = @id`
+
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		existing, book, err := repo.lockEdition(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := repo.lockPublisher(ctx, tx, b.PublisherID); err != nil {
+			return err
+		}
+
+		updated := b.applyTo(existing)
+		args := editionArgs(updated)
+		args["release_date"] = updated.ReleaseDate
+
+		if _, err := tx.Exec(ctx, query, pgx.NamedArgs(args)); err != nil {
+			return editionErr(err, updated.ISBN)
+		}
+		return repo.syncFirstEdition(ctx, tx, book)
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *BooksRepo) RemoveEdition(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		_, book, err := repo.lockEdition(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM public.editions WHERE id = @id`, pgx.NamedArgs{"id": id}); err != nil {
+			return err
+		}
+		return repo.syncFirstEdition(ctx, tx, book)
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+// importBatchSize is number of books copied to database at once
+const importBatchSize = 1000
+
+// importColumns are columns of books_import staging table, values are copied in order of importValues
+var importColumns = []string{"title", "author", "genre", "genre_id", "isbn", "number_of_pages", "price", "currency",
+	"release_year", "series_id", "series_position"}
+
+func importValues(b bookRequestBody) []any {
+	genre := ""
+	if b.Genre != nil {
+		genre = *b.Genre
+	}
+	return []any{*b.Title, *b.Author, genre, b.GenreID, b.ISBN, b.NumberOfPages, priceAmount(b.price()),
+		priceCurrency(b.price()), b.ReleaseYear, b.SeriesID.Value, b.SeriesPosition}
+}
+
+// ImportBooks copies books to a staging table and moves them to books from there,
+// unlike copying to books directly it gives back inserted rows for audit
+func (repo *BooksRepo) ImportBooks(ctx context.Context, rows []importRow, dryRun bool) (int, error) {
+	// import is bound by request context only, queryTimeout is meant for single row queries
+	imported := 0
+	var rejects importRejects
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		imported = 0
+		rejects = importRejects{}
+
+		if err := repo.rejectUnknownSeries(ctx, tx, rows, rejects); err != nil {
+			return err
+		}
+		genres, err := repo.rejectUnknownGenres(ctx, tx, rows, rejects)
+		if err != nil {
+			return err
+		}
+		if err := repo.rejectTakenISBNs(ctx, tx, rows, rejects); err != nil {
+			return err
+		}
+		if dryRun {
+			return nil
+		}
+
+		books := rejects.accepted(rows)
+		_, err = tx.Exec(ctx, `CREATE TEMP TABLE books_import (
+                                    title           text NOT NULL,
+                                    author          text NOT NULL,
+                                    genre           text NOT NULL,
+                                    genre_id        integer,
+                                    isbn            text,
+                                    number_of_pages integer,
+                                    price           numeric,
+                                    currency        text,
+                                    release_year    integer,
+                                    series_id       integer,
+                                    series_position integer
+                                ) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+
+		for start := 0; start < len(books); start += importBatchSize {
+			batch := books[start:min(start+importBatchSize, len(books))]
+
+			values := make([][]any, 0, len(batch))
+			for _, b := range batch {
+				b, err := b.linkGenre(genres)
+				if err != nil {
+					return err
+				}
+				values = append(values, importValues(b))
+			}
+			_, err := tx.CopyFrom(ctx, pgx.Identifier{"books_import"}, importColumns, pgx.CopyFromRows(values))
+			if err != nil {
+				return err
+			}
+
+			created, err := repo.moveImported(ctx, tx)
+			if err != nil {
+				return err
+			}
+
+			editions := make([][]any, 0, len(created))
+			for _, b := range created {
+				if b.ISBN != nil || b.NumberOfPages != nil || b.Price != nil {
+					editions = append(editions, []any{b.ID, b.ISBN, b.NumberOfPages, priceAmount(b.Price), priceCurrency(b.Price)})
+				}
+			}
+			_, err = tx.CopyFrom(ctx, pgx.Identifier{"public", "editions"},
+				[]string{"book_id", "isbn", "number_of_pages", "price", "currency"}, pgx.CopyFromRows(editions))
+			if err != nil {
+				return err
+			}
+
+			audit := make([][]any, 0, len(created))
+			for _, b := range created {
+				e := newAudit(ctx, auditCreate, nil, &b)
+				audit = append(audit, []any{e.BookID, e.Action, string(e.Changes), e.Actor, e.RequestID})
+			}
+			_, err = tx.CopyFrom(ctx, pgx.Identifier{"public", "book_audit"},
+				[]string{"book_id", "action", "changes", "actor", "request_id"}, pgx.CopyFromRows(audit))
+			if err != nil {
+				return err
+			}
+
+			prices := make([][]any, 0, len(created))
+			for _, b := range created {
+				if b.Price != nil {
+					e := newPriceEntry(ctx, &b)
+					prices = append(prices, []any{e.BookID, priceAmount(e.Price), priceCurrency(e.Price), e.Actor, e.RequestID})
+				}
+			}
+			_, err = tx.CopyFrom(ctx, pgx.Identifier{"public", "price_history"},
+				[]string{"book_id", "price", "currency", "actor", "request_id"}, pgx.CopyFromRows(prices))
+			if err != nil {
+				return err
+			}
+
+			if err := linkAuthors(ctx, repo.authors, created...); err != nil {
+				return err
+			}
+
+			imported += len(created)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, database.TxErr(ctx, err)
+	}
+	rejects.apply(rows)
+
+	return imported, nil
+}
+
+// rejectTakenISBNs rejects rows whose isbn belongs to an edition already, trashed books included,
+// parseImport rejects rows which repeat isbn of another row
+func (repo *BooksRepo) rejectTakenISBNs(ctx context.Context, tx pgx.Tx, rows []importRow, rejects importRejects) error {
+	isbns := make([]string, 0)
+	for i, row := range rows {
+		if row.Book.ISBN != nil && rejects.isAccepted(rows, i) {
+			isbns = append(isbns, *row.Book.ISBN)
+		}
+	}
+	if len(isbns) == 0 {
+		return nil
+	}
+
+	found, err := tx.Query(ctx, `SELECT isbn FROM public.editions WHERE isbn = ANY(@isbns)`, pgx.NamedArgs{"isbns": isbns})
+	if err != nil {
+		return err
+	}
+	taken, err := pgx.CollectRows(found, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if row.Book.ISBN != nil && rejects.isAccepted(rows, i) && slices.Contains(taken, *row.Book.ISBN) {
+			rejects.add(i, isbnTaken(*row.Book.ISBN))
+		}
+	}
+	return nil
+}
+
+// rejectUnknownSeries rejects rows which name a series that does not exist, key share lock keeps
+// series of the other rows from removal until tx ends
+func (repo *BooksRepo) rejectUnknownSeries(ctx context.Context, tx pgx.Tx, rows []importRow, rejects importRejects) error {
+	ids := make([]int, 0)
+	for i, row := range rows {
+		if id := row.Book.seriesID(); id != nil && rejects.isAccepted(rows, i) {
+			ids = append(ids, *id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	found, err := tx.Query(ctx, `SELECT id FROM public.series WHERE id = ANY(@ids) FOR KEY SHARE`, pgx.NamedArgs{"ids": ids})
+	if err != nil {
+		return err
+	}
+	existing, err := pgx.CollectRows(found, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if id := row.Book.seriesID(); id != nil && rejects.isAccepted(rows, i) && !slices.Contains(existing, *id) {
+			rejects.add(i, unknownSeries(*id))
+		}
+	}
+	return nil
+}
+
+// rejectUnknownGenres rejects rows which name a genre that does not exist and returns genres
+// of the other rows, locked like in lockGenres
+func (repo *BooksRepo) rejectUnknownGenres(ctx context.Context, tx pgx.Tx, rows []importRow, rejects importRejects) (genreIndex, error) {
+	genres, err := repo.lockGenres(ctx, tx, rejects.accepted(rows)...)
+	if err != nil {
+		return genres, err
+	}
+
+	for i, row := range rows {
+		if !rejects.isAccepted(rows, i) {
+			continue
+		}
+		if _, err := row.Book.linkGenre(genres); err != nil {
+			rejects.add(i, err)
+		}
+	}
+	return genres, nil
+}
+
+// moveImported inserts staged books into books and empties the staging table
+func (repo *BooksRepo) moveImported(ctx context.Context, tx pgx.Tx) ([]bookEntity, error) {
+	rows, err := tx.Query(ctx, `INSERT INTO public.books
+                                    (title, author, genre, genre_id, isbn, number_of_pages, price, currency,
+                                     release_year, series_id, series_position)
+                                SELECT title, author, genre, genre_id, isbn, number_of_pages, price, currency,
+                                       release_year, series_id, series_position
+                                FROM books_import
+                                RETURNING `+bookColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (bookEntity, error) {
+		var b bookEntity
+		err := row.Scan(bookFields(&b)...)
+		return b, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `TRUNCATE books_import`)
+	return created, err
+}
+
+func (repo *BooksRepo) GetBookHistory(ctx context.Context, id int, q historyQuery) (historyPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := historyPage{
+		Entries: make([]auditEntry, 0),
+	}
+	args := pgx.NamedArgs{
+		"book_id": id,
+		"limit":   q.Limit,
+		"offset":  q.Offset,
+	}
+
+	countQuery := `SELECT count(*) FROM public.book_audit WHERE book_id = @book_id`
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, countQuery, args).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM public.book_audit
+              WHERE book_id = @book_id
+              ORDER BY id
+              LIMIT @limit OFFSET @offset`
+
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e auditEntry
+		if err := rows.Scan(auditFields(&e)...); err != nil {
+			logger.Error(err.Error())
+			return page, database.RepoErr(ctx, err)
+		}
+		page.Entries = append(page.Entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *BooksRepo) PurgeBooks(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `DELETE FROM public.books WHERE deleted_at < @before`
+	args := pgx.NamedArgs{
+		"before": before,
+	}
+
+	tag, err := database.PgxConn(ctx, repo.pool).Exec(ctx, query, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, database.RepoErr(ctx, err)
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+func (repo *BooksRepo) GetSeriesBooks(ctx context.Context, seriesID int) ([]bookEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + bookColumns + ` FROM public.books
+              WHERE series_id = @series_id
+              ORDER BY series_position NULLS LAST, id`
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, pgx.NamedArgs{"series_id": seriesID})
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	books, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (bookEntity, error) {
+		var b bookEntity
+		err := row.Scan(bookFields(&b)...)
+		return b, err
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	return books, nil
+}
+
+// stockLevelColumns are selected in order of stockLevelFields, reserved copies are summed up
+// over unexpired reservations of the location
+const stockLevelColumns = `l.book_id, l.location, l.quantity,
+                           COALESCE((SELECT sum(r.quantity) FROM public.stock_reservations r
+                                     WHERE r.book_id = l.book_id AND r.location = l.location AND r.expires_at > now()), 0)`
+
+func stockLevelFields(bookID *int, l *stockLevelEntity) []any {
+	return []any{bookID, &l.Location, &l.Quantity, &l.Reserved}
+}
+
+// stockOf reads stock levels of books in location order
+func (repo *BooksRepo) stockOf(ctx context.Context, bookIDs []int) (map[int]stockEntity, error) {
+	query := `SELECT ` + stockLevelColumns + ` FROM public.stock_levels l
+              WHERE l.book_id = ANY(@ids)
+              ORDER BY l.book_id, l.location`
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, pgx.NamedArgs{"ids": bookIDs})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stock := map[int]stockEntity{}
+	for rows.Next() {
+		var bookID int
+		var l stockLevelEntity
+		if err := rows.Scan(stockLevelFields(&bookID, &l)...); err != nil {
+			return nil, err
+		}
+		s := stock[bookID]
+		s.BookID = bookID
+		s.Levels = append(s.Levels, l)
+		stock[bookID] = s
+	}
+	return stock, rows.Err()
+}
+
+func (repo *BooksRepo) GetStock(ctx context.Context, bookID int) (stockEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	stock := stockEntity{BookID: bookID, Levels: make([]stockLevelEntity, 0)}
+
+	var found bool
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM public.books WHERE id = @id AND deleted_at IS NULL)`,
+		pgx.NamedArgs{"id": bookID}).Scan(&found)
+	if err != nil {
+		logger.Error(err.Error())
+		return stock, database.RepoErr(ctx, err)
+	}
+	if !found {
+		return stock, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	levels, err := repo.stockOf(ctx, []int{bookID})
+	if err != nil {
+		logger.Error(err.Error())
+		return stock, database.RepoErr(ctx, err)
+	}
+	if s, ok := levels[bookID]; ok {
+		stock = s
+	}
+
+	return stock, nil
+}
+
+func (repo *BooksRepo) GetAvailable(ctx context.Context, bookIDs []int) (map[int]int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	stock, err := repo.stockOf(ctx, bookIDs)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	available := make(map[int]int, len(bookIDs))
+	for _, id := range bookIDs {
+		available[id] = stock[id].available()
+	}
+	return available, nil
+}
+
+func (repo *BooksRepo) GetOffers(ctx context.Context, bookIDs []int) (map[int]Offer, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var offers map[int]Offer
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		offers = make(map[int]Offer, len(bookIDs))
+
+		// stock writes lock the book for update, see lockBook, so the share lock holds them off
+		rows, err := tx.Query(ctx, `SELECT `+bookColumns+` FROM public.books
+                                    WHERE id = ANY(@ids) AND deleted_at IS NULL
+                                    ORDER BY id
+                                    FOR SHARE`, pgx.NamedArgs{"ids": bookIDs})
+		if err != nil {
+			return err
+		}
+		books, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (bookEntity, error) {
+			var b bookEntity
+			err := row.Scan(bookFields(&b)...)
+			return b, err
+		})
+		if err != nil {
+			return err
+		}
+
+		stock, err := repo.stockOf(ctx, bookIDs)
+		if err != nil {
+			return err
+		}
+		for _, b := range books {
+			offers[b.ID] = Offer{Title: b.Title, Price: b.Price, Available: stock[b.ID].available()}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, database.TxErr(ctx, err)
+	}
+
+	return offers, nil
+}
+
+// lockedStock reads copies on hand and reserved at a location of a book locked by lockBook
+func (repo *BooksRepo) lockedStock(ctx context.Context, tx pgx.Tx, bookID int, location string) (onHand, reserved int, err error) {
+	args := pgx.NamedArgs{"book_id": bookID, "location": location}
+
+	err = tx.QueryRow(ctx, `SELECT quantity FROM public.stock_levels WHERE book_id = @book_id AND location = @location`,
+		args).Scan(&onHand)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, err
+	}
+
+	err = tx.QueryRow(ctx, `SELECT COALESCE(sum(quantity), 0) FROM public.stock_reservations
+                            WHERE book_id = @book_id AND location = @location AND expires_at > now()`, args).Scan(&reserved)
+	return onHand, reserved, err
+}
+
+func (repo *BooksRepo) AdjustStock(ctx context.Context, bookID int, b stockAdjustRequestBody) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		// the book row serializes stock changes of the book
+		if _, err := repo.lockBook(ctx, tx, bookID, false); err != nil {
+			return err
+		}
+		onHand, reserved, err := repo.lockedStock(ctx, tx, bookID, *b.Location)
+		if err != nil {
+			return err
+		}
+
+		held := 0
+		if b.ReservationID != nil {
+			err := tx.QueryRow(ctx, `DELETE FROM public.stock_reservations
+                                     WHERE id = @id AND book_id = @book_id AND location = @location AND expires_at > now()
+                                     RETURNING quantity`,
+				pgx.NamedArgs{"id": *b.ReservationID, "book_id": bookID, "location": *b.Location}).Scan(&held)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return unknownReservation(*b.ReservationID, *b.Location)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err := b.check(bookID, onHand, reserved, held); err != nil {
+			return err
+		}
+
+		// the level is created empty first, an upsert adding the delta right away would fail
+		// the quantity check with the negative delta of copies going out
+		m := newMovement(ctx, bookID, b)
+		levelArgs := pgx.NamedArgs{"book_id": bookID, "location": m.Location, "delta": m.Delta}
+		_, err = tx.Exec(ctx, `INSERT INTO public.stock_levels (book_id, location, quantity)
+                               VALUES (@book_id, @location, 0)
+                               ON CONFLICT (book_id, location) DO NOTHING`, levelArgs)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE public.stock_levels SET quantity = quantity + @delta
+                               WHERE book_id = @book_id AND location = @location`, levelArgs)
+		if err != nil {
+			return err
+		}
+
+		args := pgx.NamedArgs{
+			"book_id":        m.BookID,
+			"location":       m.Location,
+			"delta":          m.Delta,
+			"reason":         m.Reason,
+			"reservation_id": m.ReservationID,
+			"actor":          m.Actor,
+			"request_id":     m.RequestID,
+		}
+		return tx.QueryRow(ctx, `INSERT INTO public.stock_movements
+                                     (book_id, location, delta, reason, reservation_id, actor, request_id)
+                                 VALUES (@book_id, @location, @delta, @reason, @reservation_id, @actor, @request_id)
+                                 RETURNING id`, args).Scan(&id)
+	})
+
+	return id, database.TxErr(ctx, err)
+}
+
+// movementColumns are selected in order of movementFields
+const movementColumns = `id, book_id, location, delta, reason, reservation_id, actor, request_id, created_at`
+
+func movementFields(m *stockMovement) []any {
+	return []any{&m.ID, &m.BookID, &m.Location, &m.Delta, &m.Reason, &m.ReservationID, &m.Actor, &m.RequestID, &m.CreatedAt}
+}
+
+func (repo *BooksRepo) GetStockMovements(ctx context.Context, bookID int, q movementsQuery) (movementsPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := movementsPage{
+		Movements: make([]stockMovement, 0),
+	}
+	args := pgx.NamedArgs{
+		"book_id": bookID,
+		"limit":   q.Limit,
+		"offset":  q.Offset,
+	}
+
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT count(*) FROM public.stock_movements WHERE book_id = @book_id`, args).
+		Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	query := `SELECT ` + movementColumns + ` FROM public.stock_movements
+              WHERE book_id = @book_id
+              ORDER BY id
+              LIMIT @limit OFFSET @offset`
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	page.Movements, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (stockMovement, error) {
+		var m stockMovement
+		err := row.Scan(movementFields(&m)...)
+		return m, err
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *BooksRepo) ReserveStock(ctx context.Context, bookID int, b reservationRequestBody, expiresAt time.Time) (reservationEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	reservation := reservationEntity{
+		BookID:    bookID,
+		Location:  *b.Location,
+		Quantity:  *b.Quantity,
+		ExpiresAt: expiresAt.UTC(),
+	}
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := repo.lockBook(ctx, tx, bookID, false); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM public.stock_reservations WHERE book_id = @book_id AND expires_at <= now()`,
+			pgx.NamedArgs{"book_id": bookID})
+		if err != nil {
+			return err
+		}
+
+		onHand, reserved, err := repo.lockedStock(ctx, tx, bookID, reservation.Location)
+		if err != nil {
+			return err
+		}
+		if err := b.check(bookID, onHand, reserved); err != nil {
+			return err
+		}
+
+		args := pgx.NamedArgs{
+			"book_id":    bookID,
+			"location":   reservation.Location,
+			"quantity":   reservation.Quantity,
+			"expires_at": reservation.ExpiresAt,
+		}
+		return tx.QueryRow(ctx, `INSERT INTO public.stock_reservations (book_id, location, quantity, expires_at)
+                                 VALUES (@book_id, @location, @quantity, @expires_at)
+                                 RETURNING id`, args).Scan(&reservation.ID)
+	})
+
+	return reservation, database.TxErr(ctx, err)
+}
+
+func (repo *BooksRepo) ReleaseReservation(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	tag, err := database.PgxConn(ctx, repo.pool).Exec(ctx, `DELETE FROM public.stock_reservations WHERE id = @id AND expires_at > now()`,
+		pgx.NamedArgs{"id": id})
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return reservationNotFound(id)
+	}
+
+	return nil
+}
+
+func (repo *BooksRepo) GetPrices(ctx context.Context, bookID int) (pricesEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	prices := pricesEntity{BookID: bookID, History: make([]priceEntry, 0), Scheduled: make([]scheduledPrice, 0)}
+	args := pgx.NamedArgs{"book_id": bookID}
+
+	var found bool
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM public.books WHERE id = @book_id AND deleted_at IS NULL)`,
+		args).Scan(&found)
+	if err != nil {
+		logger.Error(err.Error())
+		return prices, database.RepoErr(ctx, err)
+	}
+	if !found {
+		return prices, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, `SELECT `+priceEntryColumns+` FROM public.price_history
+                                            WHERE book_id = @book_id
+                                            ORDER BY id`, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return prices, database.RepoErr(ctx, err)
+	}
+	prices.History, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (priceEntry, error) {
+		var e priceEntry
+		err := row.Scan(priceEntryFields(&e)...)
+		return e, err
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return prices, database.RepoErr(ctx, err)
+	}
+
+	rows, err = database.PgxConn(ctx, repo.pool).Query(ctx, `SELECT `+scheduledPriceColumns+` FROM public.scheduled_prices
+                                           WHERE book_id = @book_id
+                                           ORDER BY effective_from, id`, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return prices, database.RepoErr(ctx, err)
+	}
+	prices.Scheduled, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (scheduledPrice, error) {
+		var s scheduledPrice
+		err := row.Scan(scheduledPriceFields(&s)...)
+		return s, err
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return prices, database.RepoErr(ctx, err)
+	}
+
+	return prices, nil
+}
+
+func (repo *BooksRepo) SchedulePrice(ctx context.Context, bookID int, b priceScheduleRequestBody) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := repo.lockBook(ctx, tx, bookID, false); err != nil {
+			return err
+		}
+
+		s := newScheduledPrice(ctx, bookID, b)
+		args := pgx.NamedArgs{
+			"book_id":        s.BookID,
+			"price":          priceAmount(s.Price),
+			"currency":       priceCurrency(s.Price),
+			"effective_from": s.EffectiveFrom,
+			"actor":          s.Actor,
+			"request_id":     s.RequestID,
+		}
+		return tx.QueryRow(ctx, `INSERT INTO public.scheduled_prices (book_id, price, currency, effective_from, actor, request_id)
+                                 VALUES (@book_id, @price, @currency, @effective_from, @actor, @request_id)
+                                 RETURNING id`, args).Scan(&id)
+	})
+
+	return id, database.TxErr(ctx, err)
+}
+
+func (repo *BooksRepo) GetDuePrices(ctx context.Context, now time.Time) ([]scheduledPrice, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + prefixColumns("s", scheduledPriceColumns) + ` FROM public.scheduled_prices s
+              JOIN public.books b ON b.id = s.book_id AND b.deleted_at IS NULL
+              WHERE s.effective_from <= @now
+              ORDER BY s.effective_from, s.id`
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, pgx.NamedArgs{"now": now})
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	due, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (scheduledPrice, error) {
+		var s scheduledPrice
+		err := row.Scan(scheduledPriceFields(&s)...)
+		return s, err
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	return due, nil
+}
+
+func (repo *BooksRepo) RemoveScheduledPrice(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	tag, err := database.PgxConn(ctx, repo.pool).Exec(ctx, `DELETE FROM public.scheduled_prices WHERE id = @id`, pgx.NamedArgs{"id": id})
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return scheduledPriceNotFound(id)
+	}
+
+	return nil
+}
+
+func (repo *BooksRepo) AddReview(ctx context.Context, bookID int, b reviewRequestBody) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := repo.lockBook(ctx, tx, bookID, false); err != nil {
+			return err
+		}
+
+		args := pgx.NamedArgs{
+			"book_id": bookID,
+			"rating":  b.Rating,
+			"text":    b.Text,
+			"author":  b.Author,
+			"status":  reviewPending,
+		}
+		return tx.QueryRow(ctx, `INSERT INTO public.reviews (book_id, rating, text, author, status)
+                                 VALUES (@book_id, @rating, @text, @author, @status)
+                                 RETURNING id`, args).Scan(&id)
+	})
+
+	return id, database.TxErr(ctx, err)
+}
+
+// reviewsPage reads a page of reviews matching where, which may use book_id and status arguments
+func (repo *BooksRepo) reviewsPage(ctx context.Context, where, order string, args pgx.NamedArgs) (reviewsPage, error) {
+	page := reviewsPage{Reviews: make([]reviewEntity, 0)}
+
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT count(*) FROM public.reviews WHERE `+where, args).Scan(&page.Total)
+	if err != nil {
+		return page, err
+	}
+
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, `SELECT `+reviewColumns+` FROM public.reviews
+                                            WHERE `+where+`
+                                            ORDER BY `+order+`
+                                            LIMIT @limit OFFSET @offset`, args)
+	if err != nil {
+		return page, err
+	}
+	page.Reviews, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (reviewEntity, error) {
+		var r reviewEntity
+		err := row.Scan(reviewFields(&r)...)
+		return r, err
+	})
+	return page, err
+}
+
+func (repo *BooksRepo) GetBookReviews(ctx context.Context, bookID int, q reviewsQuery) (reviewsPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var found bool
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM public.books WHERE id = @id AND deleted_at IS NULL)`,
+		pgx.NamedArgs{"id": bookID}).Scan(&found)
+	if err != nil {
+		logger.Error(err.Error())
+		return reviewsPage{}, database.RepoErr(ctx, err)
+	}
+	if !found {
+		return reviewsPage{}, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	args := pgx.NamedArgs{"book_id": bookID, "status": q.Status, "limit": q.Limit, "offset": q.Offset}
+	page, err := repo.reviewsPage(ctx, `book_id = @book_id AND status = @status`, `id DESC`, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *BooksRepo) GetReviews(ctx context.Context, q reviewsQuery) (reviewsPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	args := pgx.NamedArgs{"status": q.Status, "limit": q.Limit, "offset": q.Offset}
+	page, err := repo.reviewsPage(ctx, `status = @status`, `id`, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *BooksRepo) ModerateReview(ctx context.Context, id int, status string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		var r reviewEntity
+		err := tx.QueryRow(ctx, `SELECT `+reviewColumns+` FROM public.reviews WHERE id = @id FOR UPDATE`,
+			pgx.NamedArgs{"id": id}).Scan(reviewFields(&r)...)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return reviewNotFound(id)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE public.reviews SET status = @status, moderated_at = now() WHERE id = @id`,
+			pgx.NamedArgs{"id": id, "status": status})
+		if err != nil {
+			return err
+		}
+
+		count, sum := ratingDelta(r.Status, status, r.Rating)
+		if count == 0 && sum == 0 {
+			return nil
+		}
+		// rating is part of the book, a new version makes cached copies of it stale
+		_, err = tx.Exec(ctx, `UPDATE public.books
+                               SET rating_count = rating_count + @count, rating_sum = rating_sum + @sum, version = version + 1
+                               WHERE id = @book_id`, pgx.NamedArgs{"book_id": r.BookID, "count": count, "sum": sum})
+		return err
+	})
+
+	return database.TxErr(ctx, err)
 }
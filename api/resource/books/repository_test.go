@@ -0,0 +1,1469 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/router/middlewares"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// repository tests run the same scenarios against every IBooksRepo implementation
+// which does not need an external server
+
+var ctx = context.Background()
+
+func seedRepo(t *testing.T, repo storageRepo) storageRepo {
+	books := []bookRequestBody{
+		{Title: strptr("The Fellowship of the Ring"), Author: strptr("JRR Tolkien"), Genre: strptr("fantasy"),
+			Price: eur("20"), NumberOfPages: intptr(432), ReleaseYear: intptr(1954)},
+		{Title: strptr("The Two Towers"), Author: strptr("JRR Tolkien"), Genre: strptr("fantasy"),
+			Price: eur("20"), NumberOfPages: intptr(352), ReleaseYear: intptr(1954)},
+		{Title: strptr("The Return of the King"), Author: strptr("JRR Tolkien"), Genre: strptr("fantasy"),
+			Price: eur("25"), ReleaseYear: intptr(1955)},
+		{Title: strptr("Dune"), Author: strptr("Frank Herbert"), Genre: strptr("science fiction"),
+			Price: eur("15"), NumberOfPages: intptr(412), ReleaseYear: intptr(1965)},
+		{Title: strptr("Solaris"), Author: strptr("Stanislaw Lem")},
+	}
+	// sqlite books name genres of its taxonomy only, genres of seeded books are added unless they exist
+	if sqlite, ok := repo.(*SQLiteRepo); ok {
+		for _, name := range []string{"fantasy", "science fiction"} {
+			if _, err := sqlite.db.Exec(`INSERT OR IGNORE INTO genres (name) VALUES (?)`, name); err != nil {
+				t.Fatalf("could not insert genre %s", err.Error())
+			}
+		}
+	}
+	for _, b := range books {
+		if _, err := repo.AddBook(ctx, b); err != nil {
+			t.Fatalf("AddBook failed\nunexpected error %s", err.Error())
+		}
+	}
+	return repo
+}
+
+func newSQLiteStorage(t *testing.T) *database.SQLiteStorage {
+	s, err := database.OpenSQLite(filepath.Join(t.TempDir(), "books.db"))
+	if err != nil {
+		t.Fatalf("could not open sqlite database %s", err.Error())
+	}
+	t.Cleanup(s.Close)
+
+	if err := database.MigrateUp(ctx, s); err != nil {
+		t.Fatalf("could not migrate sqlite database %s", err.Error())
+	}
+
+	return s
+}
+
+func newSQLiteRepo(t *testing.T) storageRepo {
+	return &SQLiteRepo{db: newSQLiteStorage(t).DB}
+}
+
+func testRepos(t *testing.T) map[string]storageRepo {
+	return map[string]storageRepo{
+		"memory": seedRepo(t, NewMemoryRepo()),
+		"sqlite": seedRepo(t, newSQLiteRepo(t)),
+	}
+}
+
+func bookIDs(books []bookEntity) []int {
+	ids := make([]int, 0, len(books))
+	for _, b := range books {
+		ids = append(ids, b.ID)
+	}
+	return ids
+}
+
+// importRows makes rows of an import which passed parseImport
+func importRows(books ...bookRequestBody) []importRow {
+	rows := make([]importRow, 0, len(books))
+	for i, b := range books {
+		rows = append(rows, importRow{Line: i + 1, Book: b})
+	}
+	return rows
+}
+
+func TestRepoGetBooks(t *testing.T) {
+	tcases := []struct {
+		query    booksQuery
+		expected []int
+		total    int
+	}{
+		{
+			query:    booksQuery{Limit: 10},
+			expected: []int{1, 2, 3, 4, 5},
+			total:    5,
+		},
+		{
+			query:    booksQuery{Limit: 2, Offset: 3},
+			expected: []int{4, 5},
+			total:    5,
+		},
+		{
+			query:    booksQuery{Limit: 10, Filter: booksFilter{Author: strptr("jrr tolkien"), MinPrice: floatptr(21), Currency: strptr("EUR")}},
+			expected: []int{3},
+			total:    1,
+		},
+		{
+			query:    booksQuery{Limit: 10, Filter: booksFilter{PagesLt: intptr(400)}},
+			expected: []int{2},
+			total:    1,
+		},
+		{
+			query: booksQuery{Limit: 10, Sort: []sortKey{{Field: "price", Desc: true}, {Field: "title"}},
+				Filter: booksFilter{Currency: strptr("EUR")}},
+			expected: []int{3, 1, 2, 4},
+			total:    4,
+		},
+	}
+
+	for name, repo := range testRepos(t) {
+		for _, tc := range tcases {
+			page, err := repo.GetBooks(ctx, tc.query)
+			if err != nil {
+				t.Errorf("%s GetBooks failed\nunexpected error %s", name, err.Error())
+				continue
+			}
+			if ids := bookIDs(page.Books); !slices.Equal(ids, tc.expected) || page.Total != tc.total {
+				t.Errorf("%s GetBooks failed\nexpected %v of %v\ngot %v of %v",
+					name, tc.expected, tc.total, ids, page.Total)
+			}
+		}
+	}
+}
+
+func TestRepoPriceCurrency(t *testing.T) {
+	// amounts of books priced in dollars would sort among euro amounts if currency was ignored
+	books := []bookRequestBody{
+		{Title: strptr("Hyperion"), Author: strptr("Dan Simmons"), Price: &priceBody{Amount: "22", Currency: "USD"}},
+		{Title: strptr("Ubik"), Author: strptr("Philip K. Dick"), Price: &priceBody{Amount: "12", Currency: "USD"}},
+	}
+	tcases := []struct {
+		query    booksQuery
+		expected []int
+	}{
+		{booksQuery{Limit: 10, Filter: booksFilter{MinPrice: floatptr(21), Currency: strptr("EUR")}}, []int{3}},
+		{booksQuery{Limit: 10, Filter: booksFilter{MinPrice: floatptr(21), Currency: strptr("USD")}}, []int{6}},
+		{booksQuery{Limit: 10, Filter: booksFilter{MaxPrice: floatptr(15), Currency: strptr("USD")}}, []int{7}},
+		{booksQuery{Limit: 10, Sort: []sortKey{{Field: "price"}}, Filter: booksFilter{Currency: strptr("USD")}}, []int{7, 6}},
+		{booksQuery{Limit: 10, Sort: []sortKey{{Field: "price"}, {Field: "id"}}, Filter: booksFilter{Currency: strptr("EUR")}}, []int{4, 1, 2, 3}},
+	}
+
+	for name, repo := range testRepos(t) {
+		for _, b := range books {
+			if _, err := repo.AddBook(ctx, b); err != nil {
+				t.Fatalf("%s AddBook failed\nunexpected error %s", name, err.Error())
+			}
+		}
+		for _, tc := range tcases {
+			page, err := repo.GetBooks(ctx, tc.query)
+			if ids := bookIDs(page.Books); err != nil || !slices.Equal(ids, tc.expected) || page.Total != len(tc.expected) {
+				t.Errorf("%s GetBooks in %s failed\nexpected %v\ngot %v, %v", name, *tc.query.Filter.Currency, tc.expected, ids, err)
+			}
+		}
+	}
+}
+
+func TestRepoCursorWalk(t *testing.T) {
+	keys := []sortKey{{Field: "releaseYear", Desc: true}, {Field: "title"}}
+	expected := []int{4, 3, 1, 2, 5}
+
+	for name, repo := range testRepos(t) {
+		var after *bookCursor
+		visited := make([]int, 0)
+		for range 10 {
+			page, err := repo.GetBooks(ctx, booksQuery{Limit: 2, Sort: keys, After: after})
+			if err != nil {
+				t.Fatalf("%s GetBooks failed\nunexpected error %s", name, err.Error())
+			}
+			visited = append(visited, bookIDs(page.Books)...)
+			if page.NextCursor == nil {
+				break
+			}
+
+			c, err := decodeCursor(*page.NextCursor)
+			if err == nil {
+				err = c.matchSort(keys)
+			}
+			if err != nil {
+				t.Fatalf("%s GetBooks returned invalid cursor %s", name, err.Error())
+			}
+			after = &c
+		}
+
+		if !slices.Equal(visited, expected) {
+			t.Errorf("%s cursor walk failed\nexpected %v\ngot %v", name, expected, visited)
+		}
+	}
+}
+
+func TestRepoSearchBooks(t *testing.T) {
+	expected := bookHighlights{
+		Title:  "The Fellowship of the <mark>Ring</mark>",
+		Author: "JRR <mark>Tolkien</mark>",
+		Genre:  "fantasy",
+	}
+
+	for name, repo := range testRepos(t) {
+		page, err := repo.SearchBooks(ctx, searchQuery{Terms: []string{"tolk", "RING"}, Limit: 10})
+		if err != nil {
+			t.Fatalf("%s SearchBooks failed\nunexpected error %s", name, err.Error())
+		}
+		if page.Total != 1 || len(page.Results) != 1 || page.Results[0].Book.ID != 1 {
+			t.Fatalf("%s SearchBooks failed\nexpected book 1\ngot %+v", name, page.Results)
+		}
+		if page.Results[0].Highlights != expected {
+			t.Errorf("%s SearchBooks highlights failed\nexpected %+v\ngot %+v",
+				name, expected, page.Results[0].Highlights)
+		}
+	}
+}
+
+func TestRepoWrites(t *testing.T) {
+	for name, repo := range testRepos(t) {
+		version, err := repo.UpdateBook(ctx, 5, bookRequestBody{Title: strptr("Solaris (1961)"), Price: eur("12")}, nil)
+		if err != nil {
+			t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+		}
+		if version != 2 {
+			t.Errorf("%s UpdateBook failed\nexpected version 2\ngot %v", name, version)
+		}
+		b, _ := repo.GetBookById(ctx, 5)
+		if b.Title != "Solaris (1961)" || b.Author != "Stanislaw Lem" || b.Price == nil || *b.Price != *euros(12) {
+			t.Errorf("%s UpdateBook failed\ngot %+v", name, b)
+		}
+
+		var pe database.PreconditionErr
+		if _, err := repo.UpdateBook(ctx, 5, bookRequestBody{Price: eur("1")}, intptr(1)); !errors.As(err, &pe) {
+			t.Errorf("%s UpdateBook expected PreconditionErr\ngot %v", name, err)
+		}
+		if err := repo.RemoveBook(ctx, 5, intptr(1)); !errors.As(err, &pe) {
+			t.Errorf("%s RemoveBook expected PreconditionErr\ngot %v", name, err)
+		}
+		if b, _ := repo.GetBookById(ctx, 5); b.Version != 2 || *b.Price != *euros(12) {
+			t.Errorf("%s failed precondition must leave book untouched\ngot %+v", name, b)
+		}
+
+		if err := repo.RemoveBook(ctx, 5, intptr(2)); err != nil {
+			t.Fatalf("%s RemoveBook failed\nunexpected error %s", name, err.Error())
+		}
+
+		var nf database.NotFoundErr
+		if _, err := repo.GetBookById(ctx, 5); !errors.As(err, &nf) {
+			t.Errorf("%s GetBookById expected NotFoundErr\ngot %v", name, err)
+		}
+		if err := repo.RemoveBook(ctx, 5, nil); !errors.As(err, &nf) {
+			t.Errorf("%s RemoveBook expected NotFoundErr\ngot %v", name, err)
+		}
+		if _, err := repo.UpdateBook(ctx, 5, bookRequestBody{}, nil); !errors.As(err, &nf) {
+			t.Errorf("%s UpdateBook expected NotFoundErr\ngot %v", name, err)
+		}
+
+		id, _ := repo.AddBook(ctx, bookRequestBody{Title: strptr("Eden"), Author: strptr("Stanislaw Lem")})
+		if id != 6 {
+			t.Errorf("%s AddBook failed\nexpected id 6\ngot %v", name, id)
+		}
+	}
+}
+
+func TestRepoTrash(t *testing.T) {
+	for name, repo := range testRepos(t) {
+		if err := repo.RemoveBook(ctx, 4, nil); err != nil {
+			t.Fatalf("%s RemoveBook failed\nunexpected error %s", name, err.Error())
+		}
+
+		var nf database.NotFoundErr
+		if _, err := repo.GetBookById(ctx, 4); !errors.As(err, &nf) {
+			t.Errorf("%s GetBookById expected NotFoundErr for trashed book\ngot %v", name, err)
+		}
+		if _, err := repo.UpdateBook(ctx, 4, bookRequestBody{}, nil); !errors.As(err, &nf) {
+			t.Errorf("%s UpdateBook expected NotFoundErr for trashed book\ngot %v", name, err)
+		}
+		if page, _ := repo.GetBooks(ctx, booksQuery{Limit: 10}); !slices.Equal(bookIDs(page.Books), []int{1, 2, 3, 5}) {
+			t.Errorf("%s GetBooks must skip trashed book\ngot %v", name, bookIDs(page.Books))
+		}
+		if page, _ := repo.SearchBooks(ctx, searchQuery{Terms: []string{"dune"}, Limit: 10}); page.Total != 0 {
+			t.Errorf("%s SearchBooks must skip trashed book\ngot %+v", name, page.Results)
+		}
+
+		trash, err := repo.GetBooks(ctx, booksQuery{Limit: 10, Filter: booksFilter{Deleted: true}})
+		if err != nil || trash.Total != 1 || trash.Books[0].ID != 4 || trash.Books[0].DeletedAt == nil {
+			t.Errorf("%s GetBooks of trash failed\ngot %+v, %v", name, trash, err)
+		}
+
+		if err := repo.RestoreBook(ctx, 4); err != nil {
+			t.Fatalf("%s RestoreBook failed\nunexpected error %s", name, err.Error())
+		}
+		if b, err := repo.GetBookById(ctx, 4); err != nil || b.DeletedAt != nil || b.Version != 3 {
+			t.Errorf("%s RestoreBook failed\ngot %+v, %v", name, b, err)
+		}
+		if err := repo.RestoreBook(ctx, 4); !errors.As(err, &nf) {
+			t.Errorf("%s RestoreBook expected NotFoundErr for live book\ngot %v", name, err)
+		}
+
+		repo.RemoveBook(ctx, 4, nil)
+		if n, err := repo.PurgeBooks(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+			t.Errorf("%s PurgeBooks must keep recently trashed book\ngot %v, %v", name, n, err)
+		}
+		if n, err := repo.PurgeBooks(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+			t.Errorf("%s PurgeBooks failed\nexpected 1 purged\ngot %v, %v", name, n, err)
+		}
+		if err := repo.RestoreBook(ctx, 4); !errors.As(err, &nf) {
+			t.Errorf("%s RestoreBook expected NotFoundErr for purged book\ngot %v", name, err)
+		}
+	}
+}
+
+// requestContext returns context handlers get for a request made by actor and the request id
+func requestContext(actor string) (context.Context, string) {
+	var result context.Context
+	handler := middlewares.RequestID(middlewares.Actor(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		result = r.Context()
+	})))
+
+	rq := httptest.NewRequest("PATCH", "/api/books/1", nil)
+	rq.Header.Set("X-Actor", actor)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, rq)
+
+	return result, rec.Header().Get("XRequestID")
+}
+
+func TestRepoHistory(t *testing.T) {
+	for name, repo := range testRepos(t) {
+		reqCtx, requestID := requestContext("alice")
+		if _, err := repo.UpdateBook(reqCtx, 1, bookRequestBody{Price: eur("12"), Genre: strptr("fantasy")}, nil); err != nil {
+			t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+		}
+		if err := repo.RemoveBook(ctx, 1, nil); err != nil {
+			t.Fatalf("%s RemoveBook failed\nunexpected error %s", name, err.Error())
+		}
+
+		page, err := repo.GetBookHistory(ctx, 1, historyQuery{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s GetBookHistory failed\nunexpected error %s", name, err.Error())
+		}
+		actions := make([]string, 0)
+		for _, e := range page.Entries {
+			actions = append(actions, e.Action)
+		}
+		if page.Total != 3 || !slices.Equal(actions, []string{auditCreate, auditUpdate, auditDelete}) {
+			t.Fatalf("%s GetBookHistory failed\nexpected create, update, delete\ngot %v of %v", name, actions, page.Total)
+		}
+
+		created, updated, removed := page.Entries[0], page.Entries[1], page.Entries[2]
+		if !strings.Contains(string(created.Changes), `"title":{"before":null,"after":"The Fellowship of the Ring"}`) ||
+			strings.Contains(string(created.Changes), "deletedAt") {
+			t.Errorf("%s create changes failed\ngot %s", name, created.Changes)
+		}
+		if string(updated.Changes) != `{"price":{"before":{"amount":"20.00","currency":"EUR"},"after":{"amount":"12.00","currency":"EUR"}}}` ||
+			updated.Actor != "alice" || updated.RequestID != requestID || updated.RequestID == "" {
+			t.Errorf("%s update audit failed\ngot %+v\nchanges %s", name, updated, updated.Changes)
+		}
+		if !strings.Contains(string(removed.Changes), `"deletedAt":{"before":null,"after":"`) || removed.Actor != systemActor {
+			t.Errorf("%s delete audit failed\ngot %+v\nchanges %s", name, removed, removed.Changes)
+		}
+		if created.CreatedAt.IsZero() || updated.CreatedAt.Before(created.CreatedAt) {
+			t.Errorf("%s audit timestamps failed\ngot %v, %v", name, created.CreatedAt, updated.CreatedAt)
+		}
+
+		page, _ = repo.GetBookHistory(ctx, 1, historyQuery{Limit: 1, Offset: 1})
+		if page.Total != 3 || len(page.Entries) != 1 || page.Entries[0].Action != auditUpdate {
+			t.Errorf("%s GetBookHistory paging failed\ngot %+v", name, page)
+		}
+	}
+}
+
+func TestRepoUnitOfWork(t *testing.T) {
+	s := newSQLiteStorage(t)
+	storages := map[string]struct {
+		repo storageRepo
+		inTx func(ctx context.Context, fn func(ctx context.Context) error) error
+	}{
+		"memory": {repo: seedRepo(t, NewMemoryRepo()), inTx: database.RunMemoryTx},
+		"sqlite": {repo: seedRepo(t, &SQLiteRepo{db: s.DB}), inTx: s.InTx},
+	}
+
+	for name, storage := range storages {
+		repo := storage.repo
+		err := storage.inTx(ctx, func(ctx context.Context) error {
+			if _, err := repo.UpdateBook(ctx, 1, bookRequestBody{Price: eur("1")}, nil); err != nil {
+				return err
+			}
+			if err := repo.RemoveBook(ctx, 2, nil); err != nil {
+				return err
+			}
+			// reads inside the unit of work see its changes
+			if _, err := repo.GetBookById(ctx, 2); err == nil {
+				return errors.New("removed book is still visible")
+			}
+			return errors.New("handler failed")
+		})
+		if err == nil || err.Error() != "handler failed" {
+			t.Fatalf("%s InTx expected handler error\ngot %v", name, err)
+		}
+
+		if b, _ := repo.GetBookById(ctx, 1); *b.Price != *euros(20) || b.Version != 1 {
+			t.Errorf("%s failed unit of work must roll back update\ngot %+v", name, b)
+		}
+		if _, err := repo.GetBookById(ctx, 2); err != nil {
+			t.Errorf("%s failed unit of work must roll back removal\ngot %v", name, err)
+		}
+		if page, _ := repo.GetBookHistory(ctx, 1, historyQuery{Limit: 10}); page.Total != 1 {
+			t.Errorf("%s failed unit of work must roll back audit\ngot %v entries", name, page.Total)
+		}
+
+		err = storage.inTx(ctx, func(ctx context.Context) error {
+			if _, err := repo.UpdateBook(ctx, 1, bookRequestBody{Price: eur("1")}, nil); err != nil {
+				return err
+			}
+			// failed repository call rolls back only its own savepoint
+			var nf database.NotFoundErr
+			if _, err := repo.UpdateBook(ctx, 42, bookRequestBody{}, nil); !errors.As(err, &nf) {
+				return fmt.Errorf("expected NotFoundErr, got %v", err)
+			}
+			return repo.RemoveBook(ctx, 2, nil)
+		})
+		if err != nil {
+			t.Fatalf("%s InTx failed\nunexpected error %s", name, err.Error())
+		}
+
+		if b, _ := repo.GetBookById(ctx, 1); *b.Price != *euros(1) || b.Version != 2 {
+			t.Errorf("%s unit of work must commit update\ngot %+v", name, b)
+		}
+		if _, err := repo.GetBookById(ctx, 2); err == nil {
+			t.Errorf("%s unit of work must commit removal", name)
+		}
+	}
+}
+
+func TestRepoImportBooks(t *testing.T) {
+	for name, repo := range testRepos(t) {
+		rows := importRows(
+			bookRequestBody{Title: strptr("Eden"), Author: strptr("Stanislaw Lem"), ReleaseYear: intptr(1959)},
+			bookRequestBody{Title: strptr("Ubik"), Author: strptr("Philip K. Dick"), Genre: strptr("science fiction")},
+		)
+		n, err := repo.ImportBooks(ctx, rows, false)
+		if err != nil || n != 2 {
+			t.Fatalf("%s ImportBooks failed\nexpected 2 imported\ngot %v, %v", name, n, err)
+		}
+
+		page, _ := repo.GetBooks(ctx, booksQuery{Limit: 10, Filter: booksFilter{Author: strptr("stanislaw lem")}})
+		if page.Total != 2 {
+			t.Errorf("%s ImportBooks failed\nexpected 2 books of Stanislaw Lem\ngot %v", name, bookIDs(page.Books))
+		}
+		b, err := repo.GetBookById(ctx, 7)
+		if err != nil || b.Title != "Ubik" || b.Genre != "science fiction" || b.Version != 1 {
+			t.Errorf("%s ImportBooks failed\ngot %+v, %v", name, b, err)
+		}
+		if history, _ := repo.GetBookHistory(ctx, 7, historyQuery{Limit: 10}); history.Total != 1 ||
+			history.Entries[0].Action != auditCreate {
+			t.Errorf("%s ImportBooks must audit every book\ngot %+v", name, history)
+		}
+	}
+}
+
+// fakeAuthors is AuthorLinker which records author strings of linked books, inTx tells that books
+// must be linked in the transaction of their write
+type fakeAuthors struct {
+	linked map[int]string
+	inTx   bool
+}
+
+func (a *fakeAuthors) LinkAuthors(ctx context.Context, books map[int]string) error {
+	if _, ok := database.SQLTx(ctx); a.inTx && !ok {
+		return errors.New("books linked outside of their transaction")
+	}
+	for id, author := range books {
+		a.linked[id] = author
+	}
+	return nil
+}
+
+func TestRepoLinkAuthors(t *testing.T) {
+	memory := NewMemoryRepo()
+	memory.authors = &fakeAuthors{linked: map[int]string{}}
+	repos := map[string]storageRepo{
+		"memory": memory,
+		"sqlite": &SQLiteRepo{db: newSQLiteStorage(t).DB, authors: &fakeAuthors{linked: map[int]string{}, inTx: true}},
+	}
+
+	for name, repo := range repos {
+		var authors *fakeAuthors
+		switch r := repo.(type) {
+		case *MemoryRepo:
+			authors = r.authors.(*fakeAuthors)
+		case *SQLiteRepo:
+			authors = r.authors.(*fakeAuthors)
+		}
+
+		id, err := repo.AddBook(ctx, bookRequestBody{Title: strptr("Good Omens"), Author: strptr("Terry Pratchett & Neil Gaiman")})
+		if err != nil || authors.linked[id] != "Terry Pratchett & Neil Gaiman" {
+			t.Errorf("%s AddBook must link authors\ngot %v, %v", name, authors.linked, err)
+		}
+
+		delete(authors.linked, id)
+		if _, err := repo.UpdateBook(ctx, id, bookRequestBody{Title: strptr("Good Omens!")}, nil); err != nil {
+			t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+		}
+		if _, linked := authors.linked[id]; linked {
+			t.Errorf("%s UpdateBook must not link authors of unchanged author", name)
+		}
+		if _, err := repo.UpdateBook(ctx, id, bookRequestBody{Author: strptr("Neil Gaiman")}, nil); err != nil ||
+			authors.linked[id] != "Neil Gaiman" {
+			t.Errorf("%s UpdateBook must link authors\ngot %v, %v", name, authors.linked, err)
+		}
+
+		rows := importRows(bookRequestBody{Title: strptr("Mort"), Author: strptr("Terry Pratchett")})
+		if imported, err := repo.ImportBooks(ctx, rows, false); err != nil || imported != 1 || authors.linked[id+1] != "Terry Pratchett" {
+			t.Errorf("%s ImportBooks must link authors\ngot %v, %v", name, authors.linked, err)
+		}
+	}
+}
+
+func TestRepoExportBooks(t *testing.T) {
+	stop := errors.New("stop")
+
+	for name, repo := range testRepos(t) {
+		if err := repo.RemoveBook(ctx, 2, nil); err != nil {
+			t.Fatalf("%s RemoveBook failed\nunexpected error %s", name, err.Error())
+		}
+
+		exported := make([]bookEntity, 0)
+		err := repo.ExportBooks(ctx, booksFilter{Author: strptr("jrr tolkien")}, func(b bookEntity) error {
+			exported = append(exported, b)
+			return nil
+		})
+		if err != nil || !slices.Equal(bookIDs(exported), []int{1, 3}) || exported[1].NumberOfPages != nil {
+			t.Errorf("%s ExportBooks failed\nexpected books [1 3]\ngot %+v, %v", name, exported, err)
+		}
+
+		calls := 0
+		err = repo.ExportBooks(ctx, booksFilter{}, func(b bookEntity) error {
+			calls++
+			return stop
+		})
+		if err != stop || calls != 1 {
+			t.Errorf("%s ExportBooks must stop on error of fn\ngot %v after %d calls", name, err, calls)
+		}
+	}
+}
+
+func TestRepoCanceledContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	for name, repo := range testRepos(t) {
+		var ce database.CanceledErr
+		if _, err := repo.GetBooks(canceled, booksQuery{Limit: 10}); !errors.As(err, &ce) || ce.Timeout {
+			t.Errorf("%s GetBooks expected CanceledErr\ngot %#v", name, err)
+		}
+		if _, err := repo.GetBookById(canceled, 1); !errors.As(err, &ce) || ce.Timeout {
+			t.Errorf("%s GetBookById expected CanceledErr\ngot %#v", name, err)
+		}
+	}
+}
+
+// testGenres are Fiction > Fantasy > High Fantasy, Fiction > Science Fiction and Poetry,
+// ids follow their order and parent 0 means top level
+var testGenres = []struct {
+	name   string
+	parent int
+}{
+	{"Fiction", 0},
+	{"Fantasy", 1},
+	{"High Fantasy", 2},
+	{"Science Fiction", 1},
+	{"Poetry", 0},
+}
+
+// fakeGenres is GenreTree of memory repo holding testGenres
+type fakeGenres struct{}
+
+func (fakeGenres) GenreNames(context.Context) map[int]string {
+	names := map[int]string{}
+	for i, g := range testGenres {
+		names[i+1] = g.name
+	}
+	return names
+}
+
+func (fakeGenres) Subgenres(_ context.Context, name string) []int {
+	ids := make([]int, 0)
+	for i, g := range testGenres {
+		if strings.EqualFold(g.name, name) || slices.Contains(ids, g.parent) {
+			ids = append(ids, i+1)
+		}
+	}
+	return ids
+}
+
+// genreRepos are seeded after testGenres, so books of known genres are linked to them
+func genreRepos(t *testing.T) map[string]IBooksRepo {
+	memory := NewMemoryRepo()
+	memory.genres = fakeGenres{}
+
+	s := newSQLiteStorage(t)
+	for _, g := range testGenres {
+		var parent *int
+		if g.parent > 0 {
+			parent = &g.parent
+		}
+		if _, err := s.DB.Exec(`INSERT INTO genres (name, parent_id) VALUES (?, ?)`, g.name, parent); err != nil {
+			t.Fatalf("could not insert genre %s", err.Error())
+		}
+	}
+
+	return map[string]IBooksRepo{
+		"memory": seedRepo(t, memory),
+		"sqlite": seedRepo(t, &SQLiteRepo{db: s.DB}),
+	}
+}
+
+func TestRepoGenres(t *testing.T) {
+	for name, repo := range genreRepos(t) {
+		if b, _ := repo.GetBookById(ctx, 1); b.Genre != "Fantasy" || b.GenreID == nil || *b.GenreID != 2 {
+			t.Errorf("%s AddBook must link genre to the genre of its name\ngot %+v", name, b)
+		}
+		if b, _ := repo.GetBookById(ctx, 5); b.GenreID != nil {
+			t.Errorf("%s AddBook must not link book without genre\ngot %+v", name, b)
+		}
+
+		id, err := repo.AddBook(ctx, bookRequestBody{Title: strptr("The Hobbit"), Author: strptr("JRR Tolkien"),
+			GenreID: intptr(3)})
+		if b, _ := repo.GetBookById(ctx, id); err != nil || b.Genre != "High Fantasy" {
+			t.Errorf("%s AddBook must name genre after genreId\ngot %+v, %v", name, b, err)
+		}
+
+		var br database.BadRequestErr
+		if _, err := repo.AddBook(ctx, bookRequestBody{Title: strptr("x"), Author: strptr("y"),
+			GenreID: intptr(42)}); !errors.As(err, &br) {
+			t.Errorf("%s AddBook expected BadRequestErr for unknown genre\ngot %v", name, err)
+		}
+		if _, err := repo.AddBook(ctx, bookRequestBody{Title: strptr("x"), Author: strptr("y"),
+			Genre: strptr("poetry"), GenreID: intptr(2)}); !errors.As(err, &br) {
+			t.Errorf("%s AddBook expected BadRequestErr for genre of another name\ngot %v", name, err)
+		}
+
+		tcases := []struct {
+			filter   booksFilter
+			expected []int
+		}{
+			{booksFilter{Genre: strptr("fiction"), IncludeSubgenres: true}, []int{1, 2, 3, 4, 6}},
+			{booksFilter{Genre: strptr("Fantasy"), IncludeSubgenres: true}, []int{1, 2, 3, 6}},
+			{booksFilter{Genre: strptr("fiction")}, []int{}},
+			{booksFilter{Genre: strptr("horror"), IncludeSubgenres: true}, []int{}},
+		}
+		for _, tc := range tcases {
+			page, err := repo.GetBooks(ctx, booksQuery{Limit: 10, Filter: tc.filter})
+			if ids := bookIDs(page.Books); err != nil || !slices.Equal(ids, tc.expected) || page.Total != len(tc.expected) {
+				t.Errorf("%s GetBooks of genre %s failed\nexpected %v\ngot %v, %v", name, *tc.filter.Genre, tc.expected, ids, err)
+			}
+		}
+
+		if _, err := repo.UpdateBook(ctx, 4, bookRequestBody{Genre: strptr("space opera")}, nil); !errors.As(err, &br) {
+			t.Errorf("%s UpdateBook expected BadRequestErr for genre out of taxonomy\ngot %v", name, err)
+		}
+		if b, _ := repo.GetBookById(ctx, 4); b.Genre != "Science Fiction" || b.GenreID == nil || *b.GenreID != 4 {
+			t.Errorf("%s UpdateBook must keep genre of book when the new one is unknown\ngot %+v", name, b)
+		}
+		if _, err := repo.UpdateBook(ctx, 4, bookRequestBody{Genre: strptr("")}, nil); err != nil {
+			t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+		}
+		if b, _ := repo.GetBookById(ctx, 4); b.Genre != "" || b.GenreID != nil {
+			t.Errorf("%s UpdateBook must unlink book of empty genre\ngot %+v", name, b)
+		}
+		if _, err := repo.UpdateBook(ctx, 4, bookRequestBody{GenreID: intptr(4)}, nil); err != nil {
+			t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+		}
+		if b, _ := repo.GetBookById(ctx, 4); b.Genre != "Science Fiction" || b.GenreID == nil || *b.GenreID != 4 {
+			t.Errorf("%s UpdateBook must link book to genreId\ngot %+v", name, b)
+		}
+
+		for _, dryRun := range []bool{true, false} {
+			rows := importRows(
+				bookRequestBody{Title: strptr("Hyperion"), Author: strptr("Dan Simmons"), Genre: strptr("space opera")},
+				bookRequestBody{Title: strptr("Silmarillion"), Author: strptr("JRR Tolkien"), Genre: strptr("high fantasy")},
+			)
+			n, err := repo.ImportBooks(ctx, rows, dryRun)
+			if err != nil || len(rows[0].Errors) != 1 || len(rows[1].Errors) != 0 {
+				t.Errorf("%s ImportBooks dry run %v must reject row of unknown genre only\ngot %+v, %v", name, dryRun, rows, err)
+			}
+			if !dryRun && n != 1 {
+				t.Errorf("%s ImportBooks must import row of known genre\ngot %d", name, n)
+			}
+		}
+
+		exported := make([]bookEntity, 0)
+		repo.ExportBooks(ctx, booksFilter{Genre: strptr("science fiction"), IncludeSubgenres: true},
+			func(b bookEntity) error {
+				exported = append(exported, b)
+				return nil
+			})
+		if ids := bookIDs(exported); !slices.Equal(ids, []int{4}) {
+			t.Errorf("%s ExportBooks of genre with subgenres failed\nexpected [4]\ngot %v", name, ids)
+		}
+	}
+}
+
+func TestMemoryRenameGenre(t *testing.T) {
+	repo := genreRepos(t)["memory"].(*MemoryRepo)
+
+	repo.renameGenre(ctx, 2, "Fantasy Fiction")
+	for _, id := range []int{1, 2, 3} {
+		b, _ := repo.GetBookById(ctx, id)
+		if b.Genre != "Fantasy Fiction" || b.Version != 2 {
+			t.Errorf("renameGenre must rename genre of book %d and bump its version\ngot %+v", id, b)
+		}
+		history, _ := repo.GetBookHistory(ctx, id, historyQuery{Limit: 10})
+		if history.Total != 2 || history.Entries[1].Action != auditUpdate ||
+			string(history.Entries[1].Changes) != `{"genre":{"before":"Fantasy","after":"Fantasy Fiction"}}` {
+			t.Errorf("renameGenre must audit book %d\ngot %+v", id, history)
+		}
+	}
+	if b, _ := repo.GetBookById(ctx, 4); b.Genre != "Science Fiction" || b.Version != 1 {
+		t.Errorf("renameGenre must keep books of other genres\ngot %+v", b)
+	}
+}
+
+func TestMemoryRenameAuthor(t *testing.T) {
+	repo := seedRepo(t, NewMemoryRepo()).(*MemoryRepo)
+
+	repo.renameAuthor(ctx, []int{1, 4}, func(author string) string {
+		return strings.Replace(author, "JRR Tolkien", "J.R.R. Tolkien", 1)
+	})
+	if b, _ := repo.GetBookById(ctx, 1); b.Author != "J.R.R. Tolkien" || b.Version != 2 {
+		t.Errorf("renameAuthor must rename author of the book and bump its version\ngot %+v", b)
+	}
+	history, _ := repo.GetBookHistory(ctx, 1, historyQuery{Limit: 10})
+	if history.Total != 2 || history.Entries[1].Action != auditUpdate ||
+		string(history.Entries[1].Changes) != `{"author":{"before":"JRR Tolkien","after":"J.R.R. Tolkien"}}` {
+		t.Errorf("renameAuthor must audit renamed book\ngot %+v", history)
+	}
+	if history, _ := repo.GetBookHistory(ctx, 4, historyQuery{Limit: 10}); history.Total != 1 {
+		t.Errorf("renameAuthor must not audit books it leaves as they are\ngot %+v", history)
+	}
+}
+
+// fakePublishers is PublisherFinder of memory repo
+type fakePublishers map[int]string
+
+func (p fakePublishers) FindPublisher(_ context.Context, id int) (string, bool) {
+	name, ok := p[id]
+	return name, ok
+}
+
+// editionRepos know publisher 1, seeded books 1 to 4 have an edition each
+func editionRepos(t *testing.T) map[string]IBooksRepo {
+	memory := NewMemoryRepo()
+	memory.publishers = fakePublishers{1: "Allen & Unwin"}
+
+	s := newSQLiteStorage(t)
+	if _, err := s.DB.Exec(`INSERT INTO publishers (name) VALUES ('Allen & Unwin')`); err != nil {
+		t.Fatalf("could not insert publisher %s", err.Error())
+	}
+
+	return map[string]IBooksRepo{
+		"memory": seedRepo(t, memory),
+		"sqlite": seedRepo(t, &SQLiteRepo{db: s.DB}),
+	}
+}
+
+func TestRepoEditions(t *testing.T) {
+	for name, repo := range editionRepos(t) {
+		editions, err := repo.GetEditions(ctx, 1)
+		if err != nil || len(editions) != 1 || *editions[0].NumberOfPages != 432 || *editions[0].Price != *euros(20) {
+			t.Errorf("%s AddBook must save pages and price to first edition\ngot %+v, %v", name, editions, err)
+		}
+		if editions, err := repo.GetEditions(ctx, 5); err != nil || len(editions) != 0 {
+			t.Errorf("%s book without pages and price must have no editions\ngot %+v, %v", name, editions, err)
+		}
+
+		var nf database.NotFoundErr
+		var br database.BadRequestErr
+		if _, err := repo.GetEditions(ctx, 42); !errors.As(err, &nf) {
+			t.Errorf("%s GetEditions expected NotFoundErr\ngot %v", name, err)
+		}
+		if _, err := repo.AddEdition(ctx, 1, editionRequestBody{PublisherID: intptr(2)}); !errors.As(err, &br) {
+			t.Errorf("%s AddEdition expected BadRequestErr for unknown publisher\ngot %v", name, err)
+		}
+
+		// later editions leave the book as it is
+		id, err := repo.AddEdition(ctx, 1, editionRequestBody{PublisherID: intptr(1), Format: strptr("hardcover"),
+			NumberOfPages: intptr(480), Price: eur("30"), ReleaseDate: strptr("1966-10-27")})
+		if err != nil || id != 5 {
+			t.Fatalf("%s AddEdition failed\nexpected id 5\ngot %v, %v", name, id, err)
+		}
+		if b, _ := repo.GetBookById(ctx, 1); b.Version != 1 || *b.Price != *euros(20) {
+			t.Errorf("%s AddEdition must not change book of later edition\ngot %+v", name, b)
+		}
+		e, err := repo.GetEditionById(ctx, 5)
+		if err != nil || e.ReleaseDate == nil || e.ReleaseDate.Format(time.DateOnly) != "1966-10-27" || *e.PublisherID != 1 {
+			t.Errorf("%s GetEditionById failed\ngot %+v, %v", name, e, err)
+		}
+
+		// first edition and book change together both ways
+		if err := repo.UpdateEdition(ctx, 1, editionRequestBody{Price: eur("22")}); err != nil {
+			t.Fatalf("%s UpdateEdition failed\nunexpected error %s", name, err.Error())
+		}
+		if b, _ := repo.GetBookById(ctx, 1); b.Version != 2 || *b.Price != *euros(22) || *b.NumberOfPages != 432 {
+			t.Errorf("%s UpdateEdition must copy first edition to book\ngot %+v", name, b)
+		}
+		if _, err := repo.UpdateBook(ctx, 1, bookRequestBody{NumberOfPages: intptr(440)}, nil); err != nil {
+			t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+		}
+		if e, _ := repo.GetEditionById(ctx, 1); *e.NumberOfPages != 440 || *e.Price != *euros(22) {
+			t.Errorf("%s UpdateBook must copy pages and price to first edition\ngot %+v", name, e)
+		}
+
+		if err := repo.RemoveEdition(ctx, 1); err != nil {
+			t.Fatalf("%s RemoveEdition failed\nunexpected error %s", name, err.Error())
+		}
+		if b, _ := repo.GetBookById(ctx, 1); b.Version != 4 || *b.Price != *euros(30) || *b.NumberOfPages != 480 {
+			t.Errorf("%s RemoveEdition must make next edition the first one\ngot %+v", name, b)
+		}
+		history, _ := repo.GetBookHistory(ctx, 1, historyQuery{Limit: 10})
+		if history.Total != 4 {
+			t.Errorf("%s edition changes of book must be in its history\nexpected 4 entries\ngot %d", name, history.Total)
+		}
+
+		if err := repo.RemoveEdition(ctx, 5); err != nil {
+			t.Fatalf("%s RemoveEdition failed\nunexpected error %s", name, err.Error())
+		}
+		if b, _ := repo.GetBookById(ctx, 1); b.Price != nil || b.NumberOfPages != nil {
+			t.Errorf("%s book without editions must have no pages and price\ngot %+v", name, b)
+		}
+
+		if err := repo.RemoveBook(ctx, 2, nil); err != nil {
+			t.Fatalf("%s RemoveBook failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := repo.GetEditionById(ctx, 2); !errors.As(err, &nf) {
+			t.Errorf("%s GetEditionById expected NotFoundErr for edition of trashed book\ngot %v", name, err)
+		}
+		if err := repo.UpdateEdition(ctx, 2, editionRequestBody{Price: eur("1")}); !errors.As(err, &nf) {
+			t.Errorf("%s UpdateEdition expected NotFoundErr for edition of trashed book\ngot %v", name, err)
+		}
+	}
+}
+
+// migrateDownTo reverts every applied migration newer than version
+func migrateDownTo(t *testing.T, s *database.SQLiteStorage, version int) {
+	status, err := database.GetMigrationStatus(ctx, s)
+	if err != nil {
+		t.Fatalf("could not read migration status %s", err.Error())
+	}
+	steps := 0
+	for _, m := range status {
+		if m.Version > version && m.AppliedAt != nil {
+			steps++
+		}
+	}
+	if err := database.MigrateDown(ctx, s, steps); err != nil {
+		t.Fatalf("could not revert migrations %s", err.Error())
+	}
+}
+
+func TestEditionsMigration(t *testing.T) {
+	s := newSQLiteStorage(t)
+	// 0007 is the last migration before editions
+	migrateDownTo(t, s, 7)
+	_, err := s.DB.Exec(`INSERT INTO books (title, author, number_of_pages, price) VALUES
+	                     ('Dune', 'Frank Herbert', 412, 15), ('Solaris', 'Stanislaw Lem', NULL, NULL),
+	                     ('Eden', 'Stanislaw Lem', NULL, 9)`)
+	if err != nil {
+		t.Fatalf("could not insert books %s", err.Error())
+	}
+	if err := database.MigrateUp(ctx, s); err != nil {
+		t.Fatalf("could not migrate sqlite database %s", err.Error())
+	}
+
+	repo := &SQLiteRepo{db: s.DB}
+	expected := map[int]string{
+		1: `[{"id":1,"bookId":1,"publisherId":null,"format":null,"isbn":null,"numberOfPages":412,"price":{"amount":"15.00","currency":"EUR"},"releaseDate":null}]`,
+		2: `[]`,
+		3: `[{"id":2,"bookId":3,"publisherId":null,"format":null,"isbn":null,"numberOfPages":null,"price":{"amount":"9.00","currency":"EUR"},"releaseDate":null}]`,
+	}
+	for id, want := range expected {
+		editions, err := repo.GetEditions(ctx, id)
+		dto := make([]editionDTO, 0, len(editions))
+		for _, e := range editions {
+			dto = append(dto, e.ToDto())
+		}
+		if got, _ := json.Marshal(dto); err != nil || string(got) != want {
+			t.Errorf("editions migration failed for book %d\nexpected %s\ngot %s, %v", id, want, got, err)
+		}
+	}
+}
+
+func TestRepoISBN(t *testing.T) {
+	for name, repo := range testRepos(t) {
+		var conflict database.ConflictErr
+		var nf database.NotFoundErr
+
+		// isbn of a book goes to its first edition, lookup finds it by any edition
+		if _, err := repo.UpdateBook(ctx, 1, bookRequestBody{ISBN: strptr("9780306406157")}, nil); err != nil {
+			t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+		}
+		if e, _ := repo.GetEditionById(ctx, 1); e.ISBN == nil || *e.ISBN != "9780306406157" || *e.Price != *euros(20) {
+			t.Errorf("%s UpdateBook must save isbn to first edition\ngot %+v", name, e)
+		}
+		if _, err := repo.UpdateBook(ctx, 1, bookRequestBody{ISBN: strptr("9780306406157"), Price: eur("21")}, nil); err != nil {
+			t.Errorf("%s UpdateBook must keep isbn of its own first edition\ngot %v", name, err)
+		}
+		if _, err := repo.AddEdition(ctx, 1, editionRequestBody{ISBN: strptr("9791000000008")}); err != nil {
+			t.Fatalf("%s AddEdition failed\nunexpected error %s", name, err.Error())
+		}
+		for _, isbn := range []string{"9780306406157", "9791000000008"} {
+			if b, err := repo.GetBookByISBN(ctx, isbn); err != nil || b.ID != 1 || *b.ISBN != "9780306406157" {
+				t.Errorf("%s GetBookByISBN(%s) failed\ngot %+v, %v", name, isbn, b, err)
+			}
+		}
+
+		if _, err := repo.AddBook(ctx, bookRequestBody{Title: strptr("Eden"), Author: strptr("Stanislaw Lem"),
+			ISBN: strptr("9791000000008")}); !errors.As(err, &conflict) {
+			t.Errorf("%s AddBook expected ConflictErr for isbn of later edition\ngot %v", name, err)
+		}
+		if _, err := repo.UpdateBook(ctx, 2, bookRequestBody{ISBN: strptr("9780306406157")}, nil); !errors.As(err, &conflict) {
+			t.Errorf("%s UpdateBook expected ConflictErr\ngot %v", name, err)
+		}
+		if err := repo.UpdateEdition(ctx, 2, editionRequestBody{ISBN: strptr("9791000000008")}); !errors.As(err, &conflict) {
+			t.Errorf("%s UpdateEdition expected ConflictErr\ngot %v", name, err)
+		}
+		if b, _ := repo.GetBookById(ctx, 2); b.ISBN != nil || b.Version != 1 {
+			t.Errorf("%s failed writes must leave the book as it was\ngot %+v", name, b)
+		}
+
+		// import rejects rows of taken isbn and adds the rest, dry run rejects them as well
+		for _, dryRun := range []bool{true, false} {
+			rows := importRows(
+				bookRequestBody{Title: strptr("Eden"), Author: strptr("Stanislaw Lem"), ISBN: strptr("9780804429573")},
+				bookRequestBody{Title: strptr("Ubik"), Author: strptr("Philip K. Dick"), ISBN: strptr("9780306406157")},
+			)
+			n, err := repo.ImportBooks(ctx, rows, dryRun)
+			if err != nil || len(rows[0].Errors) != 0 || len(rows[1].Errors) != 1 {
+				t.Errorf("%s ImportBooks dry run %v must reject row of taken isbn only\ngot %+v, %v", name, dryRun, rows, err)
+			}
+			if !dryRun && n != 1 {
+				t.Errorf("%s ImportBooks must import row of free isbn\ngot %d", name, n)
+			}
+		}
+		if b, err := repo.GetBookByISBN(ctx, "9780804429573"); err != nil || b.Title != "Eden" {
+			t.Errorf("%s ImportBooks must add book of free isbn\ngot %+v, %v", name, b, err)
+		}
+
+		// trashed books keep their isbn
+		if err := repo.RemoveBook(ctx, 1, nil); err != nil {
+			t.Fatalf("%s RemoveBook failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := repo.GetBookByISBN(ctx, "9780306406157"); !errors.As(err, &nf) {
+			t.Errorf("%s GetBookByISBN expected NotFoundErr for trashed book\ngot %v", name, err)
+		}
+		if _, err := repo.AddEdition(ctx, 2, editionRequestBody{ISBN: strptr("9780306406157")}); !errors.As(err, &conflict) {
+			t.Errorf("%s AddEdition expected ConflictErr for isbn of trashed book\ngot %v", name, err)
+		}
+	}
+}
+
+func TestISBNMigration(t *testing.T) {
+	s := newSQLiteStorage(t)
+	// 0008 is the last migration before isbn normalization
+	migrateDownTo(t, s, 8)
+	_, err := s.DB.Exec(`INSERT INTO books (title, author) VALUES ('Dune', 'Frank Herbert'), ('Solaris', 'Stanislaw Lem');
+	                     INSERT INTO editions (book_id, isbn) VALUES
+	                     (1, '0-306-40615-2'), (1, '080442957x'), (2, '978 0 306 40615 7'), (2, '0306406153'), (2, ' - ')`)
+	if err != nil {
+		t.Fatalf("could not insert editions %s", err.Error())
+	}
+	if err := database.MigrateUp(ctx, s); err != nil {
+		t.Fatalf("could not migrate sqlite database %s", err.Error())
+	}
+
+	repo := &SQLiteRepo{db: s.DB}
+	expected := map[int][]string{
+		1: {"9780306406157", "9780804429573"},
+		2: {"<nil>", "0306406153", "<nil>"},
+	}
+	for id, want := range expected {
+		editions, err := repo.GetEditions(ctx, id)
+		got := make([]string, 0, len(editions))
+		for _, e := range editions {
+			if e.ISBN == nil {
+				got = append(got, "<nil>")
+			} else {
+				got = append(got, *e.ISBN)
+			}
+		}
+		if err != nil || !slices.Equal(got, want) {
+			t.Errorf("isbn migration failed for book %d\nexpected %v\ngot %v, %v", id, want, got, err)
+		}
+	}
+
+	if b, err := repo.GetBookByISBN(ctx, "9780306406157"); err != nil || b.ID != 1 || *b.ISBN != "9780306406157" {
+		t.Errorf("isbn migration must copy isbn of first edition to book\ngot %+v, %v", b, err)
+	}
+}
+
+// fakeSeries is SeriesFinder of memory repo
+type fakeSeries map[int]string
+
+func (f fakeSeries) FindSeries(_ context.Context, id int) (string, bool) {
+	name, ok := f[id]
+	return name, ok
+}
+
+// seriesRepos know series 1 and 2
+func seriesRepos(t *testing.T) map[string]IBooksRepo {
+	memory := NewMemoryRepo()
+	memory.series = fakeSeries{1: "The Lord of the Rings", 2: "Dune Chronicles"}
+
+	s := newSQLiteStorage(t)
+	if _, err := s.DB.Exec(`INSERT INTO series (name) VALUES ('The Lord of the Rings'), ('Dune Chronicles')`); err != nil {
+		t.Fatalf("could not insert series %s", err.Error())
+	}
+
+	return map[string]IBooksRepo{
+		"memory": seedRepo(t, memory),
+		"sqlite": seedRepo(t, &SQLiteRepo{db: s.DB}),
+	}
+}
+
+func TestRepoSeries(t *testing.T) {
+	const lotr, dune = 1, 2
+
+	for name, repo := range seriesRepos(t) {
+		var badreq database.BadRequestErr
+
+		// The Fellowship of the Ring is volume 1 of 3, a book without position comes last
+		moves := map[int]bookRequestBody{
+			1: {SeriesID: nullableID{Set: true, Value: intptr(lotr)}, SeriesPosition: intptr(1)},
+			2: {SeriesID: nullableID{Set: true, Value: intptr(lotr)}},
+			3: {SeriesID: nullableID{Set: true, Value: intptr(lotr)}, SeriesPosition: intptr(3)},
+		}
+		for id, b := range moves {
+			if _, err := repo.UpdateBook(ctx, id, b, nil); err != nil {
+				t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+			}
+		}
+		if books, err := repo.GetSeriesBooks(ctx, lotr); err != nil || !slices.Equal(bookIDs(books), []int{1, 3, 2}) {
+			t.Errorf("%s GetSeriesBooks failed\nexpected books [1 3 2]\ngot %v, %v", name, bookIDs(books), err)
+		}
+		if _, err := repo.UpdateBook(ctx, 2, bookRequestBody{SeriesID: moves[1].SeriesID, SeriesPosition: intptr(2)}, nil); err != nil {
+			t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+		}
+		if books, err := repo.GetSeriesBooks(ctx, lotr); err != nil || !slices.Equal(bookIDs(books), []int{1, 2, 3}) {
+			t.Errorf("%s GetSeriesBooks failed\nexpected books [1 2 3]\ngot %v, %v", name, bookIDs(books), err)
+		}
+		if b, _ := repo.GetBookById(ctx, 1); b.SeriesID == nil || *b.SeriesID != lotr || *b.SeriesPosition != 1 {
+			t.Errorf("%s GetBookById must return series of the book\ngot %+v", name, b)
+		}
+
+		// unknown series fails the write, import rejects only the row which names it
+		if _, err := repo.AddBook(ctx, bookRequestBody{Title: strptr("Eden"), Author: strptr("Stanislaw Lem"),
+			SeriesID: nullableID{Set: true, Value: intptr(99)}}); !errors.As(err, &badreq) {
+			t.Errorf("%s AddBook expected BadRequestErr for unknown series\ngot %v", name, err)
+		}
+		if _, err := repo.UpdateBook(ctx, 4, bookRequestBody{SeriesID: nullableID{Set: true, Value: intptr(99)}}, nil); !errors.As(err, &badreq) {
+			t.Errorf("%s UpdateBook expected BadRequestErr for unknown series\ngot %v", name, err)
+		}
+		imports := func() []importRow {
+			return importRows(
+				bookRequestBody{Title: strptr("Dune Messiah"), Author: strptr("Frank Herbert"), SeriesID: nullableID{Set: true, Value: intptr(dune)}},
+				bookRequestBody{Title: strptr("Eden"), Author: strptr("Stanislaw Lem"), SeriesID: nullableID{Set: true, Value: intptr(99)}},
+			)
+		}
+		dry := imports()
+		if n, err := repo.ImportBooks(ctx, dry, true); err != nil || n != 0 || len(dry[0].Errors) != 0 ||
+			!slices.Equal(dry[1].Errors, []string{"series with id 99 not found"}) {
+			t.Errorf("%s ImportBooks dry run must reject the row of unknown series\ngot %d, %+v, %v", name, n, dry, err)
+		}
+		if books, _ := repo.GetSeriesBooks(ctx, dune); len(books) != 0 {
+			t.Errorf("%s ImportBooks dry run must add no books\ngot %v", name, bookIDs(books))
+		}
+		rows := imports()
+		if n, err := repo.ImportBooks(ctx, rows, false); err != nil || n != 1 || len(rows[0].Errors) != 0 ||
+			!slices.Equal(rows[1].Errors, []string{"series with id 99 not found"}) {
+			t.Errorf("%s ImportBooks must reject the row of unknown series only\ngot %d, %+v, %v", name, n, rows, err)
+		}
+		if books, _ := repo.GetSeriesBooks(ctx, dune); len(books) != 1 || books[0].Title != "Dune Messiah" {
+			t.Errorf("%s ImportBooks must add book of known series\ngot %+v", name, books)
+		}
+
+		// trashed books stay in the series
+		if err := repo.RemoveBook(ctx, 3, nil); err != nil {
+			t.Fatalf("%s RemoveBook failed\nunexpected error %s", name, err.Error())
+		}
+		if books, _ := repo.GetSeriesBooks(ctx, lotr); !slices.Equal(bookIDs(books), []int{1, 2, 3}) || books[2].DeletedAt == nil {
+			t.Errorf("%s GetSeriesBooks must keep trashed books\ngot %+v", name, books)
+		}
+		for _, id := range []int{1, 2} {
+			if _, err := repo.UpdateBook(ctx, id, bookRequestBody{SeriesID: nullableID{Set: true}}, nil); err != nil {
+				t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+			}
+		}
+		if b, _ := repo.GetBookById(ctx, 1); b.SeriesID != nil || b.SeriesPosition != nil {
+			t.Errorf("%s UpdateBook with null series must clear position\ngot %+v", name, b)
+		}
+	}
+}
+
+func TestRepoStock(t *testing.T) {
+	for name, repo := range testRepos(t) {
+		var badreq database.BadRequestErr
+		var conflict database.ConflictErr
+		var nf database.NotFoundErr
+
+		adjust := func(bookID int, location string, quantity int, reason string, reservationID *int) (int, error) {
+			return repo.AdjustStock(ctx, bookID, stockAdjustRequestBody{Location: &location, Quantity: &quantity,
+				Reason: &reason, ReservationID: reservationID})
+		}
+		reserve := func(bookID int, location string, quantity int, expiresAt time.Time) (reservationEntity, error) {
+			return repo.ReserveStock(ctx, bookID, reservationRequestBody{Location: &location, Quantity: &quantity}, expiresAt)
+		}
+
+		if _, err := adjust(1, "berlin", 5, stockReceived, nil); err != nil {
+			t.Fatalf("%s AdjustStock failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := adjust(1, "paris", 1, stockReceived, nil); err != nil {
+			t.Fatalf("%s AdjustStock failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := adjust(1, "paris", 2, stockDamaged, nil); !errors.As(err, &conflict) {
+			t.Errorf("%s AdjustStock expected ConflictErr for damaging missing copies\ngot %v", name, err)
+		}
+		if _, err := adjust(42, "berlin", 1, stockReceived, nil); !errors.As(err, &nf) {
+			t.Errorf("%s AdjustStock expected NotFoundErr for unknown book\ngot %v", name, err)
+		}
+
+		// an expired reservation holds nothing, a live one keeps its copies from sale
+		if _, err := reserve(1, "berlin", 5, time.Now().Add(-time.Minute)); err != nil {
+			t.Fatalf("%s ReserveStock failed\nunexpected error %s", name, err.Error())
+		}
+		held, err := reserve(1, "berlin", 3, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("%s ReserveStock failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := reserve(1, "berlin", 3, time.Now().Add(time.Hour)); !errors.As(err, &conflict) {
+			t.Errorf("%s ReserveStock expected ConflictErr for reserved copies\ngot %v", name, err)
+		}
+		s, err := repo.GetStock(ctx, 1)
+		expected := []stockLevelEntity{{Location: "berlin", Quantity: 5, Reserved: 3}, {Location: "paris", Quantity: 1}}
+		if err != nil || !slices.Equal(s.Levels, expected) || s.available() != 3 {
+			t.Errorf("%s GetStock failed\nexpected %+v\ngot %+v, %v", name, expected, s.Levels, err)
+		}
+		if available, err := repo.GetAvailable(ctx, []int{1, 2}); err != nil || available[1] != 3 || available[2] != 0 || len(available) != 2 {
+			t.Errorf("%s GetAvailable failed\nexpected map[1:3 2:0]\ngot %v, %v", name, available, err)
+		}
+		offers, err := repo.GetOffers(ctx, []int{1, 5, 42})
+		if o := offers[1]; err != nil || len(offers) != 2 || o.Title != "The Fellowship of the Ring" || o.Available != 3 ||
+			o.Price == nil || o.Price.String() != "20.00 EUR" || offers[5].Price != nil {
+			t.Errorf("%s GetOffers failed\nexpected offers of books 1 and 5\ngot %+v, %v", name, offers, err)
+		}
+		if _, err := adjust(1, "berlin", 3, stockSold, nil); !errors.As(err, &conflict) {
+			t.Errorf("%s AdjustStock expected ConflictErr for selling reserved copies\ngot %v", name, err)
+		}
+		if _, err := adjust(1, "paris", 1, stockSold, &held.ID); !errors.As(err, &badreq) {
+			t.Errorf("%s AdjustStock expected BadRequestErr for reservation at other location\ngot %v", name, err)
+		}
+		if _, err := adjust(1, "berlin", 4, stockSold, &held.ID); err != nil {
+			t.Fatalf("%s AdjustStock failed\nunexpected error %s", name, err.Error())
+		}
+		if err := repo.ReleaseReservation(ctx, held.ID); !errors.As(err, &nf) {
+			t.Errorf("%s ReleaseReservation expected NotFoundErr for sold reservation\ngot %v", name, err)
+		}
+		if s, _ := repo.GetStock(ctx, 1); s.available() != 2 {
+			t.Errorf("%s GetStock failed\nexpected 2 copies available\ngot %+v", name, s.Levels)
+		}
+
+		page, err := repo.GetStockMovements(ctx, 1, movementsQuery{Limit: 10})
+		deltas := []int{}
+		for _, m := range page.Movements {
+			deltas = append(deltas, m.Delta)
+		}
+		if err != nil || page.Total != 3 || !slices.Equal(deltas, []int{5, 1, -4}) {
+			t.Errorf("%s GetStockMovements failed\nexpected deltas [5 1 -4]\ngot %v, %v", name, deltas, err)
+		}
+		if m := page.Movements[2]; m.Reason != stockSold || m.ReservationID == nil || *m.ReservationID != held.ID || m.Actor != systemActor {
+			t.Errorf("%s GetStockMovements must return sale of the reservation\ngot %+v", name, m)
+		}
+
+		// movements outlive purged books, stock goes with them
+		if err := repo.RemoveBook(ctx, 1, nil); err != nil {
+			t.Fatalf("%s RemoveBook failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := repo.GetStock(ctx, 1); !errors.As(err, &nf) {
+			t.Errorf("%s GetStock expected NotFoundErr for trashed book\ngot %v", name, err)
+		}
+		if offers, err := repo.GetOffers(ctx, []int{1}); err != nil || len(offers) != 0 {
+			t.Errorf("%s GetOffers must leave out trashed book\ngot %+v, %v", name, offers, err)
+		}
+		if _, err := repo.PurgeBooks(ctx, time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("%s PurgeBooks failed\nunexpected error %s", name, err.Error())
+		}
+		if page, err := repo.GetStockMovements(ctx, 1, movementsQuery{Limit: 10}); err != nil || page.Total != 3 {
+			t.Errorf("%s GetStockMovements must keep movements of purged book\ngot %+v, %v", name, page, err)
+		}
+	}
+}
+
+func TestRepoPrices(t *testing.T) {
+	memory := seedRepo(t, NewMemoryRepo()).(*MemoryRepo)
+	s := newSQLiteStorage(t)
+	storages := map[string]struct {
+		repo storageRepo
+		inTx func(ctx context.Context, fn func(ctx context.Context) error) error
+	}{
+		"memory": {repo: memory, inTx: database.RunMemoryTx},
+		"sqlite": {repo: seedRepo(t, &SQLiteRepo{db: s.DB}), inTx: s.InTx},
+	}
+
+	for name, storage := range storages {
+		repo := storage.repo
+		api := API{repo: repo, prices: repo, inTx: storage.inTx}.Prices()
+		var nf database.NotFoundErr
+
+		history := func(bookID int) []string {
+			prices, err := repo.GetPrices(ctx, bookID)
+			if err != nil {
+				t.Fatalf("%s GetPrices failed\nunexpected error %s", name, err.Error())
+			}
+			result := []string{}
+			for i, e := range prices.History {
+				current := e.EffectiveTo == nil
+				if current != (i == len(prices.History)-1) || !current && e.EffectiveTo.Before(e.EffectiveFrom) {
+					t.Errorf("%s GetPrices must end every entry but the last one\ngot %+v", name, prices.History)
+				}
+				result = append(result, e.Price.String())
+			}
+			return result
+		}
+
+		if _, err := repo.UpdateBook(ctx, 1, bookRequestBody{Price: eur("18.50")}, nil); err != nil {
+			t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := repo.UpdateBook(ctx, 1, bookRequestBody{Title: strptr("The Fellowship")}, nil); err != nil {
+			t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+		}
+		editions, _ := repo.GetEditions(ctx, 1)
+		if err := repo.UpdateEdition(ctx, editions[0].ID, editionRequestBody{Price: eur("17")}); err != nil {
+			t.Fatalf("%s UpdateEdition failed\nunexpected error %s", name, err.Error())
+		}
+		expected := []string{"20.00 EUR", "18.50 EUR", "17.00 EUR"}
+		if got := history(1); !slices.Equal(got, expected) {
+			t.Errorf("%s GetPrices failed\nexpected %v\ngot %v", name, expected, got)
+		}
+		if got := history(5); len(got) != 0 {
+			t.Errorf("%s GetPrices of book without price failed\ngot %v", name, got)
+		}
+
+		now := time.Now()
+		schedule := func(bookID int, amount string, at time.Time) int {
+			id, err := repo.SchedulePrice(ctx, bookID, priceScheduleRequestBody{Price: eur(amount), EffectiveFrom: &at})
+			if err != nil {
+				t.Fatalf("%s SchedulePrice failed\nunexpected error %s", name, err.Error())
+			}
+			return id
+		}
+		later := schedule(1, "12", now.Add(2*time.Hour))
+		sooner := schedule(1, "15", now.Add(time.Hour))
+		schedule(2, "19.99", now.Add(time.Hour))
+		if _, err := repo.SchedulePrice(ctx, 42, priceScheduleRequestBody{Price: eur("1"), EffectiveFrom: &now}); !errors.As(err, &nf) {
+			t.Errorf("%s SchedulePrice expected NotFoundErr for unknown book\ngot %v", name, err)
+		}
+		if prices, _ := repo.GetPrices(ctx, 1); len(prices.Scheduled) != 2 || prices.Scheduled[0].ID != sooner ||
+			prices.Scheduled[1].ID != later || *prices.Scheduled[0].Price != *euros(15) {
+			t.Errorf("%s GetPrices must list scheduled prices in the order they apply\ngot %+v", name, prices.Scheduled)
+		}
+
+		if applied, err := api.ApplyScheduledPrices(ctx, now); err != nil || applied != 0 {
+			t.Errorf("%s ApplyScheduledPrices must wait for effectiveFrom\ngot %d, %v", name, applied, err)
+		}
+		if applied, err := api.ApplyScheduledPrices(ctx, now.Add(90*time.Minute)); err != nil || applied != 2 {
+			t.Errorf("%s ApplyScheduledPrices failed\nexpected 2 applied\ngot %d, %v", name, applied, err)
+		}
+		if b, _ := repo.GetBookById(ctx, 2); b.Price == nil || b.Price.String() != "19.99 EUR" {
+			t.Errorf("%s ApplyScheduledPrices must set price of the book\ngot %+v", name, b.Price)
+		}
+		expected = append(expected, "15.00 EUR")
+		if got := history(1); !slices.Equal(got, expected) {
+			t.Errorf("%s GetPrices failed\nexpected %v\ngot %v", name, expected, got)
+		}
+		if prices, _ := repo.GetPrices(ctx, 1); len(prices.Scheduled) != 1 || prices.History[3].Actor != systemActor {
+			t.Errorf("%s ApplyScheduledPrices must remove applied price and record it as system\ngot %+v", name, prices)
+		}
+		if err := repo.RemoveScheduledPrice(ctx, sooner); !errors.As(err, &nf) {
+			t.Errorf("%s RemoveScheduledPrice expected NotFoundErr for applied price\ngot %v", name, err)
+		}
+
+		// prices of trashed books wait until they are restored
+		if err := repo.RemoveBook(ctx, 1, nil); err != nil {
+			t.Fatalf("%s RemoveBook failed\nunexpected error %s", name, err.Error())
+		}
+		if applied, err := api.ApplyScheduledPrices(ctx, now.Add(3*time.Hour)); err != nil || applied != 0 {
+			t.Errorf("%s ApplyScheduledPrices must skip trashed books\ngot %d, %v", name, applied, err)
+		}
+		if _, err := repo.GetPrices(ctx, 1); !errors.As(err, &nf) {
+			t.Errorf("%s GetPrices expected NotFoundErr for trashed book\ngot %v", name, err)
+		}
+		if err := repo.RestoreBook(ctx, 1); err != nil {
+			t.Fatalf("%s RestoreBook failed\nunexpected error %s", name, err.Error())
+		}
+		if applied, err := api.ApplyScheduledPrices(ctx, now.Add(3*time.Hour)); err != nil || applied != 1 {
+			t.Errorf("%s ApplyScheduledPrices failed\nexpected 1 applied\ngot %d, %v", name, applied, err)
+		}
+		if got := history(1); len(got) != 5 || got[4] != "12.00 EUR" {
+			t.Errorf("%s GetPrices failed\nexpected 12.00 EUR last\ngot %v", name, got)
+		}
+	}
+}
+
+// racingRepo is memory repo whose update of book 1 fails and whose book 2 is trashed right after due prices are listed
+type racingRepo struct {
+	*MemoryRepo
+}
+
+func (r racingRepo) GetDuePrices(ctx context.Context, now time.Time) ([]scheduledPrice, error) {
+	due, err := r.MemoryRepo.GetDuePrices(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	return due, r.RemoveBook(ctx, 2, nil)
+}
+
+func (r racingRepo) UpdateBook(ctx context.Context, id int, b bookRequestBody, ifMatch *int) (int, error) {
+	if id == 1 {
+		return 0, errors.New("update failed")
+	}
+	return r.MemoryRepo.UpdateBook(ctx, id, b, ifMatch)
+}
+
+func TestApplyScheduledPricesFailures(t *testing.T) {
+	repo := seedRepo(t, NewMemoryRepo()).(*MemoryRepo)
+	api := PricesAPI{repo: racingRepo{repo}, books: racingRepo{repo}, inTx: database.RunMemoryTx}
+
+	now := time.Now()
+	for _, id := range []int{1, 2, 4} {
+		if _, err := repo.SchedulePrice(ctx, id, priceScheduleRequestBody{Price: eur("9"), EffectiveFrom: &now}); err != nil {
+			t.Fatalf("SchedulePrice failed\nunexpected error %s", err.Error())
+		}
+	}
+
+	if applied, err := api.ApplyScheduledPrices(ctx, now); err != nil || applied != 1 {
+		t.Errorf("ApplyScheduledPrices must go on after failed prices\nexpected 1 applied\ngot %d, %v", applied, err)
+	}
+	if b, _ := repo.GetBookById(ctx, 4); b.Price == nil || b.Price.String() != "9.00 EUR" {
+		t.Errorf("ApplyScheduledPrices must set price after failed ones\ngot %+v", b.Price)
+	}
+	if prices, _ := repo.GetPrices(ctx, 1); len(prices.Scheduled) != 1 {
+		t.Errorf("ApplyScheduledPrices must keep failed price to try it again\ngot %+v", prices.Scheduled)
+	}
+	if due, _ := repo.GetDuePrices(ctx, now); len(due) != 1 || due[0].BookID != 1 {
+		t.Errorf("ApplyScheduledPrices must leave price of trashed book out of due prices\ngot %+v", due)
+	}
+
+	repo.RestoreBook(ctx, 2)
+	if prices, _ := repo.GetPrices(ctx, 2); len(prices.Scheduled) != 1 {
+		t.Errorf("ApplyScheduledPrices must keep price of trashed book until it is restored\ngot %+v", prices.Scheduled)
+	}
+}
+
+func TestRepoReviews(t *testing.T) {
+	for name, repo := range testRepos(t) {
+		var nf database.NotFoundErr
+
+		add := func(bookID, rating int, author string) int {
+			b := reviewRequestBody{Rating: &rating, Author: &author}
+			if err := b.validate(); err != nil {
+				t.Fatalf("%s validate failed\nunexpected error %s", name, err.Error())
+			}
+			id, err := repo.AddReview(ctx, bookID, b)
+			if err != nil {
+				t.Fatalf("%s AddReview failed\nunexpected error %s", name, err.Error())
+			}
+			return id
+		}
+		moderate := func(id int, status string) {
+			if err := repo.ModerateReview(ctx, id, status); err != nil {
+				t.Fatalf("%s ModerateReview failed\nunexpected error %s", name, err.Error())
+			}
+		}
+		rating := func(bookID int) (int, int) {
+			b, err := repo.GetBookById(ctx, bookID)
+			if err != nil {
+				t.Fatalf("%s GetBookById failed\nunexpected error %s", name, err.Error())
+			}
+			return b.RatingCount, b.RatingSum
+		}
+
+		five, four, two := add(1, 5, "Sam"), add(1, 4, "Frodo"), add(1, 2, "Gollum")
+		add(2, 3, "Merry")
+		if count, sum := rating(1); count != 0 || sum != 0 {
+			t.Errorf("%s pending reviews must not rate the book\ngot %d ratings of %d", name, count, sum)
+		}
+		if _, err := repo.AddReview(ctx, 42, reviewRequestBody{}); !errors.As(err, &nf) {
+			t.Errorf("%s AddReview expected NotFoundErr for unknown book\ngot %v", name, err)
+		}
+
+		moderate(five, reviewApproved)
+		moderate(four, reviewApproved)
+		moderate(two, reviewRejected)
+		if count, sum := rating(1); count != 2 || sum != 9 {
+			t.Errorf("%s ModerateReview failed\nexpected 2 ratings of 9\ngot %d ratings of %d", name, count, sum)
+		}
+		// approving twice counts once, withdrawing approval takes the rating back
+		moderate(five, reviewApproved)
+		moderate(four, reviewPending)
+		moderate(two, reviewApproved)
+		if count, sum := rating(1); count != 2 || sum != 7 {
+			t.Errorf("%s ModerateReview failed\nexpected 2 ratings of 7\ngot %d ratings of %d", name, count, sum)
+		}
+		if err := repo.ModerateReview(ctx, 42, reviewApproved); !errors.As(err, &nf) {
+			t.Errorf("%s ModerateReview expected NotFoundErr for unknown review\ngot %v", name, err)
+		}
+
+		if _, err := repo.UpdateBook(ctx, 1, bookRequestBody{Title: strptr("The Fellowship")}, nil); err != nil {
+			t.Fatalf("%s UpdateBook failed\nunexpected error %s", name, err.Error())
+		}
+		if count, sum := rating(1); count != 2 || sum != 7 {
+			t.Errorf("%s UpdateBook must keep rating of the book\ngot %d ratings of %d", name, count, sum)
+		}
+
+		page, err := repo.GetBookReviews(ctx, 1, reviewsQuery{Status: reviewApproved, Limit: 1})
+		if err != nil {
+			t.Fatalf("%s GetBookReviews failed\nunexpected error %s", name, err.Error())
+		}
+		if page.Total != 2 || len(page.Reviews) != 1 || page.Reviews[0].ID != two || page.Reviews[0].Author != "Gollum" {
+			t.Errorf("%s GetBookReviews must list approved reviews newest first\ngot %+v", name, page)
+		}
+		if page.Reviews[0].ModeratedAt == nil || page.Reviews[0].CreatedAt.IsZero() {
+			t.Errorf("%s GetBookReviews must read creation and moderation time\ngot %+v", name, page.Reviews[0])
+		}
+
+		queue, err := repo.GetReviews(ctx, reviewsQuery{Status: reviewPending, Limit: 10})
+		if err != nil {
+			t.Fatalf("%s GetReviews failed\nunexpected error %s", name, err.Error())
+		}
+		if queue.Total != 2 || len(queue.Reviews) != 2 || queue.Reviews[0].ID != four || queue.Reviews[1].BookID != 2 {
+			t.Errorf("%s GetReviews must list the queue of every book oldest first\ngot %+v", name, queue)
+		}
+
+		if err := repo.RemoveBook(ctx, 1, nil); err != nil {
+			t.Fatalf("%s RemoveBook failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := repo.GetBookReviews(ctx, 1, reviewsQuery{Status: reviewApproved, Limit: 10}); !errors.As(err, &nf) {
+			t.Errorf("%s GetBookReviews expected NotFoundErr for trashed book\ngot %v", name, err)
+		}
+		if _, err := repo.PurgeBooks(ctx, time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("%s PurgeBooks failed\nunexpected error %s", name, err.Error())
+		}
+		if all, _ := repo.GetReviews(ctx, reviewsQuery{Status: reviewApproved, Limit: 10}); all.Total != 0 {
+			t.Errorf("%s PurgeBooks must remove reviews of purged books\ngot %+v", name, all)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// review statuses are moderation states of a review, reviews are pending until an admin approves or rejects them
+const (
+	reviewPending  = "pending"
+	reviewApproved = "approved"
+	reviewRejected = "rejected"
+)
+
+var reviewStatuses = []string{reviewPending, reviewApproved, reviewRejected}
+
+// maxReviewText is the longest review text in characters
+const maxReviewText = 5000
+
+// IReviewsRepo keeps reviews of books and their moderation. Ratings of books are made of approved reviews,
+// so every books repository implements it
+type IReviewsRepo interface {
+	// AddReview adds pending review of a live book and returns its id
+	AddReview(ctx context.Context, bookID int, b reviewRequestBody) (int, error)
+	// GetBookReviews lists reviews of a live book in status of q newest first
+	GetBookReviews(ctx context.Context, bookID int, q reviewsQuery) (reviewsPage, error)
+	// GetReviews lists reviews of every book in status of q oldest first, trashed books included
+	GetReviews(ctx context.Context, q reviewsQuery) (reviewsPage, error)
+	// ModerateReview sets status of a review. RatingCount and RatingSum of its book count approved reviews
+	// and follow the change in the same transaction
+	ModerateReview(ctx context.Context, id int, status string) error
+}
+
+func reviewNotFound(id int) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("review with id %d not found", id)}
+}
+
+// validate trims author and text
+func (b *reviewRequestBody) validate() error {
+	if b.Rating == nil || *b.Rating < 1 || *b.Rating > 5 {
+		return database.BadRequestErr{Message: "rating must be between 1 and 5"}
+	}
+	if b.Author == nil || strings.TrimSpace(*b.Author) == "" {
+		return database.BadRequestErr{Message: "author is required"}
+	}
+	author := strings.TrimSpace(*b.Author)
+	b.Author = &author
+	text := ""
+	if b.Text != nil {
+		text = strings.TrimSpace(*b.Text)
+	}
+	if len([]rune(text)) > maxReviewText {
+		return database.BadRequestErr{Message: fmt.Sprintf("text can't be longer than %d characters", maxReviewText)}
+	}
+	b.Text = &text
+	return nil
+}
+
+// validate lowercases status
+func (b *reviewModerationRequestBody) validate() error {
+	if b.Status == nil || !slices.Contains(reviewStatuses, strings.ToLower(strings.TrimSpace(*b.Status))) {
+		return database.BadRequestErr{Message: fmt.Sprintf("status must be one of %s", strings.Join(reviewStatuses, ", "))}
+	}
+	status := strings.ToLower(strings.TrimSpace(*b.Status))
+	b.Status = &status
+	return nil
+}
+
+// ratingDelta is change of rating count and sum of a book when its review of rating moves from one status to another,
+// approved reviews are counted only
+func ratingDelta(from, to string, rating int) (count, sum int) {
+	if from == reviewApproved {
+		count, sum = count-1, sum-rating
+	}
+	if to == reviewApproved {
+		count, sum = count+1, sum+rating
+	}
+	return count, sum
+}
+
+// averageRating of approved reviews rounded to two decimal places, nil while the book has none
+func (b bookEntity) averageRating() *float64 {
+	if b.RatingCount == 0 {
+		return nil
+	}
+	average := math.Round(float64(b.RatingSum)/float64(b.RatingCount)*100) / 100
+	return &average
+}
+
+// reviewColumns are selected in order of reviewFields
+const reviewColumns = `id, book_id, rating, text, author, status, created_at, moderated_at`
+
+func reviewFields(r *reviewEntity) []any {
+	return []any{&r.ID, &r.BookID, &r.Rating, &r.Text, &r.Author, &r.Status, &r.CreatedAt, &r.ModeratedAt}
+}
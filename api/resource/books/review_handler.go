@@ -0,0 +1,238 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ReviewsAPI serves reviews of books kept by books repository and their moderation, see API.Reviews
+type ReviewsAPI struct {
+	repo IReviewsRepo
+	// inTx runs a unit of work of repo calls, see database.Storage
+	inTx func(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// GetBookReviews returns approved reviews of a book
+//
+//	@Summary		Reviews of book
+//	@Description	lists approved reviews of a book newest first, averageRating and ratingCount of the book are made of them
+//	@Tags			reviews
+//	@Produce		json
+//	@Param			id		path		int	true	"book record Id"
+//	@Param			limit	query		int	false	"page size, 20 by default, 100 at most"
+//	@Param			offset	query		int	false	"number of reviews to skip"
+//	@Success		200		{object}	reviewsPageDTO
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Failure		404		{object}	database.APIError
+//	@Router			/api/books/{id}/reviews [get]
+func (api ReviewsAPI) GetBookReviews(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	q, apiErr := parseReviewsQuery(r.URL.Query())
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	page, err := api.repo.GetBookReviews(r.Context(), id, q)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	json, _ := json.Marshal(page.ToDto())
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// AddReview adds a review of a book
+//
+//	@Summary		Add review
+//	@Description	reviews are pending until an admin approves them, only approved reviews are listed and rate the book
+//	@Tags			reviews
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int					true	"book record Id"
+//	@Param			review	body		reviewRequestBody	true	"request body"
+//	@Success		201		{object}	ActionResponse
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Failure		404		{object}	database.APIError
+//	@Router			/api/books/{id}/reviews [post]
+func (api ReviewsAPI) AddReview(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	req, apiErr := decodeReview(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	var reviewID int
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		reviewID, err = api.repo.AddReview(ctx, id, req)
+		return err
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	j, _ := json.Marshal(ActionResponse{ResourceId: reviewID})
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, string(j[:]))
+}
+
+// GetReviews returns the moderation queue
+//
+//	@Summary		Reviews to moderate
+//	@Description	lists reviews of every book in a moderation status oldest first, pending ones by default. Admin only
+//	@Tags			reviews
+//	@Produce		json
+//	@Param			X-Admin-Key	header		string	true	"admin api key"
+//	@Param			status		query		string	false	"moderation status"	Enums(pending, approved, rejected)
+//	@Param			limit		query		int		false	"page size, 20 by default, 100 at most"
+//	@Param			offset		query		int		false	"number of reviews to skip"
+//	@Success		200			{object}	reviewsPageDTO
+//	@Failure		500			{object}	database.APIError
+//	@Failure		503			{object}	database.APIError
+//	@Failure		400			{object}	database.APIError
+//	@Failure		403			{object}	database.APIError
+//	@Router			/api/reviews [get]
+func (api ReviewsAPI) GetReviews(w http.ResponseWriter, r *http.Request) {
+	q, apiErr := parseModerationQuery(r.URL.Query())
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	page, err := api.repo.GetReviews(r.Context(), q)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	json, _ := json.Marshal(page.ToDto())
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// ModerateReview approves or rejects a review
+//
+//	@Summary		Moderate review
+//	@Description	sets moderation status of a review, rating of its book counts approved reviews only. Admin only
+//	@Tags			reviews
+//	@Accept			json
+//	@Produce		json
+//	@Param			X-Admin-Key	header	string						true	"admin api key"
+//	@Param			id			path	int							true	"Review ID"
+//	@Param			moderation	body	reviewModerationRequestBody	true	"request body"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		403	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/reviews/{id} [patch]
+func (api ReviewsAPI) ModerateReview(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	req, apiErr := decodeReviewModeration(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		return api.repo.ModerateReview(ctx, id, *req.Status)
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeReview(r *http.Request) (reviewRequestBody, *database.APIError) {
+	var req reviewRequestBody
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, &database.APIError{
+			Message: "invalid request model",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if err := req.validate(); err != nil {
+		return req, &database.APIError{
+			Message: err.Error(),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	return req, nil
+}
+
+func decodeReviewModeration(r *http.Request) (reviewModerationRequestBody, *database.APIError) {
+	var req reviewModerationRequestBody
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, &database.APIError{
+			Message: "invalid request model",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if err := req.validate(); err != nil {
+		return req, &database.APIError{
+			Message: err.Error(),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	return req, nil
+}
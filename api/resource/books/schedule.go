@@ -0,0 +1,73 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"booksapi/logger"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ApplyScheduledPrices sets scheduled prices due at now as prices of their books and returns their count.
+// Every price is removed in the unit of work which applies it, a price another server applied meanwhile
+// is not found and skipped. A price whose book was trashed meanwhile is skipped as well, it is kept and due prices
+// leave it out until the book is restored. A price which fails otherwise is logged and tried again next time,
+// it doesn't hold back the prices after it
+func (api PricesAPI) ApplyScheduledPrices(ctx context.Context, now time.Time) (int, error) {
+	due, err := api.repo.GetDuePrices(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	var nf database.NotFoundErr
+	for _, s := range due {
+		bookGone := false
+		err := api.inTx(ctx, func(ctx context.Context) error {
+			if err := api.repo.RemoveScheduledPrice(ctx, s.ID); err != nil {
+				return err
+			}
+			_, err := api.books.UpdateBook(ctx, s.BookID, bookRequestBody{Price: newPriceBody(s.Price)}, nil)
+			bookGone = errors.As(err, &nf)
+			return err
+		})
+
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return applied, err
+		case bookGone:
+			logger.Info(fmt.Sprintf("skipped scheduled price %d, book %d is no longer live", s.ID, s.BookID))
+			continue
+		case errors.As(err, &nf):
+			continue
+		case err != nil:
+			logger.Error(fmt.Sprintf("ERROR applying scheduled price %d of book %d -> %s", s.ID, s.BookID, err.Error()))
+			continue
+		}
+		applied++
+	}
+
+	return applied, nil
+}
+
+// ApplyScheduledPricesPeriodically applies due scheduled prices right away and then every interval until ctx is done
+func (api PricesAPI) ApplyScheduledPricesPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		applied, err := api.ApplyScheduledPrices(ctx, time.Now())
+		if err != nil {
+			logger.Error(fmt.Sprintf("ERROR applying scheduled prices -> %s", err.Error()))
+		} else if applied > 0 {
+			logger.Info(fmt.Sprintf("applied %d scheduled prices", applied))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"context"
+	"fmt"
+	"slices"
+)
+
+// SeriesFinder looks up series kept by series package for memory repo, databases check series table instead
+type SeriesFinder interface {
+	FindSeries(ctx context.Context, id int) (name string, ok bool)
+}
+
+// unknownSeries is returned when a book is put into a series which does not exist
+func unknownSeries(id int) database.BadRequestErr {
+	return database.BadRequestErr{Message: fmt.Sprintf("series with id %d not found", id)}
+}
+
+// validateSeriesFields checks position of a book in series, the position is always sent together with
+// the series so that a book is never left with a position but no series
+func validateSeriesFields(id nullableID, position *int) error {
+	if position == nil {
+		return nil
+	}
+	if id.Value == nil {
+		return database.BadRequestErr{Message: "seriesPosition needs seriesId"}
+	}
+	if *position < 1 {
+		return database.BadRequestErr{Message: "seriesPosition must be greater than 0"}
+	}
+	return nil
+}
+
+// seriesID returns series the request puts the book into, nil when it leaves series alone or takes the book out
+func (b bookRequestBody) seriesID() *int {
+	if !b.SeriesID.Set {
+		return nil
+	}
+	return b.SeriesID.Value
+}
+
+// seriesLinks finds live books read right before and after b among books of its series in reading order
+func seriesLinks(books []bookEntity, b bookEntity) (prev *bookLinkDTO, next *bookLinkDTO) {
+	live := slices.DeleteFunc(slices.Clone(books), func(sb bookEntity) bool { return sb.DeletedAt != nil })
+	for i, sb := range live {
+		if sb.ID != b.ID {
+			continue
+		}
+		if i > 0 {
+			prev = newBookLink(live[i-1])
+		}
+		if i < len(live)-1 {
+			next = newBookLink(live[i+1])
+		}
+		break
+	}
+	return prev, next
+}
+
+func newBookLink(b bookEntity) *bookLinkDTO {
+	return &bookLinkDTO{
+		ID:    b.ID,
+		Title: b.Title,
+		Href:  fmt.Sprintf("/api/books/%d", b.ID),
+	}
+}
@@ -0,0 +1,1524 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"booksapi/logger"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLiteRepo keeps books in sqlite database, it shares query building with BooksRepo
+type SQLiteRepo struct {
+	db *sql.DB
+	// authors are those of authors package, books are not linked to any author while it is nil
+	authors AuthorLinker
+}
+
+// sqlite has no timestamp type, deleted_at is stored as UTC text in sqliteTimeFormat
+// so that it compares in chronological order and is scanned back into time.Time
+const (
+	sqliteTimeFormat = "2006-01-02 15:04:05.000"
+	sqliteNow        = `strftime('%Y-%m-%d %H:%M:%f', 'now')`
+)
+
+// sqliteEditionErr turns unique violation of an edition write into isbnTaken, see editionErr
+func sqliteEditionErr(err error, isbn *string) error {
+	if isbn != nil && database.IsSQLiteUniqueViolation(err) {
+		return isbnTaken(*isbn)
+	}
+	return err
+}
+
+// namedArgs converts named arguments of query builders to database/sql form
+func namedArgs(args map[string]any) []any {
+	result := make([]any, 0, len(args))
+	for name, value := range args {
+		result = append(result, sql.Named(name, value))
+	}
+	return result
+}
+
+func (repo *SQLiteRepo) GetBooks(ctx context.Context, q booksQuery) (booksPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := booksPage{
+		Books: make([]bookEntity, 0),
+	}
+
+	countArgs := map[string]any{}
+	countQuery := `SELECT count(*) FROM books ` + whereClause(q.Filter, countArgs)
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, countQuery, namedArgs(countArgs)...).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	// one extra row is fetched to find out whether next page exists
+	args := map[string]any{
+		"limit": q.Limit + 1,
+	}
+	query := `SELECT ` + bookColumns + ` FROM books `
+	if q.After != nil {
+		query += whereClause(q.Filter, args) + " AND " + keysetPredicate(q.Sort, *q.After, args) + " " +
+			orderClause(q.Sort) + ` LIMIT @limit`
+	} else {
+		query += whereClause(q.Filter, args) + " " + orderClause(q.Sort) + ` LIMIT @limit OFFSET @offset`
+		args["offset"] = q.Offset
+	}
+
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, namedArgs(args)...)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r bookEntity
+		err := rows.Scan(bookFields(&r)...)
+		if err != nil {
+			logger.Error(err.Error())
+			return page, database.RepoErr(ctx, err)
+		}
+		page.Books = append(page.Books, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	if len(page.Books) > q.Limit {
+		page.Books = page.Books[:q.Limit]
+		cursor := newCursor(page.Books[q.Limit-1], q.Sort).encode()
+		page.NextCursor = &cursor
+	}
+
+	return page, nil
+}
+
+func (repo *SQLiteRepo) SearchBooks(ctx context.Context, q searchQuery) (searchPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := searchPage{
+		Results: make([]bookSearchResult, 0),
+	}
+
+	// terms are quoted so words like "and" are not taken for fts5 operators,
+	// every term is matched as a prefix
+	terms := make([]string, 0, len(q.Terms))
+	for _, t := range q.Terms {
+		terms = append(terms, `"`+t+`"*`)
+	}
+	args := map[string]any{
+		"query":  strings.Join(terms, " "),
+		"limit":  q.Limit,
+		"offset": q.Offset,
+	}
+
+	countQuery := `SELECT count(*) FROM books_fts
+                   JOIN books b ON b.id = books_fts.rowid
+                   WHERE books_fts MATCH @query AND b.deleted_at IS NULL`
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, countQuery, namedArgs(args)...).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	// bm25 weights follow searchDocument of BooksRepo, lower bm25 means better match
+	query := `SELECT ` + prefixColumns("b", bookColumns) + `,
+                     -bm25(books_fts, 10.0, 4.0, 2.0) AS rank,
+                     highlight(books_fts, 0, '<mark>', '</mark>'),
+                     highlight(books_fts, 1, '<mark>', '</mark>'),
+                     highlight(books_fts, 2, '<mark>', '</mark>')
+              FROM books_fts
+              JOIN books b ON b.id = books_fts.rowid
+              WHERE books_fts MATCH @query AND b.deleted_at IS NULL
+              ORDER BY rank DESC, b.id
+              LIMIT @limit OFFSET @offset`
+
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, namedArgs(args)...)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r bookSearchResult
+		err := rows.Scan(append(bookFields(&r.Book),
+			&r.Rank, &r.Highlights.Title, &r.Highlights.Author, &r.Highlights.Genre)...)
+		if err != nil {
+			logger.Error(err.Error())
+			return page, database.RepoErr(ctx, err)
+		}
+		page.Results = append(page.Results, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *SQLiteRepo) GetBookById(ctx context.Context, id int) (bookEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + bookColumns + ` FROM books WHERE id = @id AND deleted_at IS NULL`
+
+	var b bookEntity
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, query, sql.Named("id", id)).
+		Scan(bookFields(&b)...)
+	if err != nil {
+		logger.Error(err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return b, database.NotFoundErr{Message: err.Error()}
+		}
+		return b, database.RepoErr(ctx, err)
+	}
+
+	return b, nil
+}
+
+func (repo *SQLiteRepo) GetBookByISBN(ctx context.Context, isbn string) (bookEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + prefixColumns("b", bookColumns) + ` FROM books b
+              JOIN editions e ON e.book_id = b.id
+              WHERE e.isbn = @isbn AND b.deleted_at IS NULL`
+
+	var b bookEntity
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, query, sql.Named("isbn", isbn)).Scan(bookFields(&b)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return b, bookByISBNNotFound(isbn)
+		}
+		logger.Error(err.Error())
+		return b, database.RepoErr(ctx, err)
+	}
+
+	return b, nil
+}
+
+// ExportBooks holds the only database connection until export ends, other requests wait for it
+func (repo *SQLiteRepo) ExportBooks(ctx context.Context, f booksFilter, fn func(bookEntity) error) error {
+	args := map[string]any{}
+	query := `SELECT ` + bookColumns + ` FROM books ` + whereClause(f, args) + ` ORDER BY id`
+
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, namedArgs(args)...)
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b bookEntity
+		if err := rows.Scan(bookFields(&b)...); err != nil {
+			logger.Error(err.Error())
+			return database.RepoErr(ctx, err)
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+
+	return nil
+}
+
+// writeAudit records change of a book in transaction which made it
+func (repo *SQLiteRepo) writeAudit(ctx context.Context, tx *sql.Tx, e auditEntry) error {
+	query := `INSERT INTO book_audit (book_id, action, changes, actor, request_id)
+              VALUES (@book_id, @action, @changes, @actor, @request_id)`
+	args := map[string]any{
+		"book_id":    e.BookID,
+		"action":     e.Action,
+		"changes":    string(e.Changes),
+		"actor":      e.Actor,
+		"request_id": e.RequestID,
+	}
+
+	_, err := tx.ExecContext(ctx, query, namedArgs(args)...)
+	return err
+}
+
+// writePriceHistory ends the current price history entry of a book and starts one of its new price
+// when the change of the book sets another price, in transaction which made the change
+func (repo *SQLiteRepo) writePriceHistory(ctx context.Context, tx *sql.Tx, before, after *bookEntity) error {
+	if !priceChanged(before, after) {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, `UPDATE price_history SET effective_to = `+sqliteNow+`
+                                   WHERE book_id = @book_id AND effective_to IS NULL`, sql.Named("book_id", after.ID))
+	if err != nil || after.Price == nil {
+		return err
+	}
+
+	e := newPriceEntry(ctx, after)
+	args := map[string]any{
+		"book_id":    e.BookID,
+		"price":      priceAmount(e.Price),
+		"currency":   priceCurrency(e.Price),
+		"actor":      e.Actor,
+		"request_id": e.RequestID,
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO price_history (book_id, price, currency, actor, request_id)
+                                  VALUES (@book_id, @price, @currency, @actor, @request_id)`, namedArgs(args)...)
+	return err
+}
+
+// lockBook reads a live or trashed book, transactions begin immediate so the whole database is locked already
+func (repo *SQLiteRepo) lockBook(ctx context.Context, tx *sql.Tx, id int, deleted bool) (bookEntity, error) {
+	query := `SELECT ` + bookColumns + ` FROM books WHERE id = @id AND deleted_at IS NULL`
+	if deleted {
+		query = `SELECT ` + bookColumns + ` FROM books WHERE id = @id AND deleted_at IS NOT NULL`
+	}
+
+	var b bookEntity
+	err := tx.QueryRowContext(ctx, query, sql.Named("id", id)).Scan(bookFields(&b)...)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) && deleted:
+		return b, notInTrash(id)
+	case errors.Is(err, sql.ErrNoRows):
+		return b, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", id)}
+	}
+
+	return b, err
+}
+
+// linkGenre links request body to a genre in tx, see bookRequestBody.linkGenre
+func (repo *SQLiteRepo) linkGenre(ctx context.Context, tx *sql.Tx, b bookRequestBody) (bookRequestBody, error) {
+	if !b.touchesGenre() {
+		return b, nil
+	}
+
+	// the transaction holds the database lock, looked up genre can't be removed before the book is saved
+	var id sql.NullInt64
+	if b.GenreID != nil {
+		id = sql.NullInt64{Int64: int64(*b.GenreID), Valid: true}
+	}
+	var name string
+	if b.Genre != nil {
+		name = *b.Genre
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id, name FROM genres WHERE id = @id OR lower(name) = lower(@name)`,
+		sql.Named("id", id), sql.Named("name", name))
+	if err != nil {
+		return b, err
+	}
+	defer rows.Close()
+
+	names := map[int]string{}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return b, err
+		}
+		names[id] = name
+	}
+	if err := rows.Err(); err != nil {
+		return b, err
+	}
+
+	return b.linkGenre(newGenreIndex(names))
+}
+
+func (repo *SQLiteRepo) AddBook(ctx context.Context, b bookRequestBody) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO books
+                (title, author, genre, genre_id, isbn, number_of_pages, price, currency, release_year, series_id, series_position)
+                VALUES(@title, @author, COALESCE(@genre, ''), @genre_id, @isbn, @number_of_pages, @price, @currency, @release_year,
+                       @series_id, @series_position)
+                RETURNING ` + bookColumns
+
+	var created bookEntity
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		b, err := repo.linkGenre(ctx, tx, b)
+		if err != nil {
+			return err
+		}
+		if err := repo.checkSeries(ctx, tx, b.seriesID()); err != nil {
+			return err
+		}
+
+		args := map[string]any{
+			"title":           b.Title,
+			"author":          b.Author,
+			"genre":           b.Genre,
+			"genre_id":        b.GenreID,
+			"isbn":            b.ISBN,
+			"number_of_pages": b.NumberOfPages,
+			"price":           priceAmount(b.price()),
+			"currency":        priceCurrency(b.price()),
+			"release_year":    b.ReleaseYear,
+			"series_id":       b.SeriesID.Value,
+			"series_position": b.SeriesPosition,
+		}
+
+		err = tx.QueryRowContext(ctx, query, namedArgs(args)...).Scan(bookFields(&created)...)
+		if err != nil {
+			return err
+		}
+		if b.touchesEdition() {
+			if err := repo.saveFirstEdition(ctx, tx, created); err != nil {
+				return err
+			}
+		}
+		if err := repo.writePriceHistory(ctx, tx, nil, &created); err != nil {
+			return err
+		}
+		if err := repo.writeAudit(ctx, tx, newAudit(ctx, auditCreate, nil, &created)); err != nil {
+			return err
+		}
+		return linkAuthors(ctx, repo.authors, created)
+	})
+
+	return created.ID, database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) RemoveBook(ctx context.Context, id int, ifMatch *int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE books SET deleted_at = ` + sqliteNow + `, version = version + 1
+              WHERE id = @id
+              RETURNING ` + bookColumns
+
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		existing, err := repo.lockBook(ctx, tx, id, false)
+		if err != nil {
+			return err
+		}
+		if ifMatch != nil && *ifMatch != existing.Version {
+			return versionMismatch(id)
+		}
+
+		var removed bookEntity
+		err = tx.QueryRowContext(ctx, query, sql.Named("id", id)).Scan(bookFields(&removed)...)
+		if err != nil {
+			return err
+		}
+		return repo.writeAudit(ctx, tx, newAudit(ctx, auditDelete, &existing, &removed))
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) UpdateBook(ctx context.Context, id int, b bookRequestBody, ifMatch *int) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE books
+	          SET title = @title, author = @author, genre = @genre, genre_id = @genre_id,
+                  isbn = @isbn, number_of_pages = @number_of_pages, price = @price, currency = @currency,
+                  release_year = @release_year, series_id = @series_id, series_position = @series_position,
+                  version = version + 1
+              WHERE id = @id
+              RETURNING version`
+
+	var updated bookEntity
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		existing, err := repo.lockBook(ctx, tx, id, false)
+		if err != nil {
+			return err
+		}
+		if ifMatch != nil && *ifMatch != existing.Version {
+			return versionMismatch(id)
+		}
+		b, err := repo.linkGenre(ctx, tx, b)
+		if err != nil {
+			return err
+		}
+		if err := repo.checkSeries(ctx, tx, b.seriesID()); err != nil {
+			return err
+		}
+		updated = b.applyTo(existing)
+
+		args := map[string]any{
+			"id":              id,
+			"title":           updated.Title,
+			"author":          updated.Author,
+			"genre":           updated.Genre,
+			"genre_id":        updated.GenreID,
+			"isbn":            updated.ISBN,
+			"number_of_pages": updated.NumberOfPages,
+			"price":           priceAmount(updated.Price),
+			"currency":        priceCurrency(updated.Price),
+			"release_year":    updated.ReleaseYear,
+			"series_id":       updated.SeriesID,
+			"series_position": updated.SeriesPosition,
+		}
+
+		err = tx.QueryRowContext(ctx, query, namedArgs(args)...).Scan(&updated.Version)
+		if err != nil {
+			return err
+		}
+		if b.touchesEdition() {
+			if err := repo.saveFirstEdition(ctx, tx, updated); err != nil {
+				return err
+			}
+		}
+		if err := repo.writePriceHistory(ctx, tx, &existing, &updated); err != nil {
+			return err
+		}
+		if err := repo.writeAudit(ctx, tx, newAudit(ctx, auditUpdate, &existing, &updated)); err != nil {
+			return err
+		}
+		if b.Author == nil {
+			return nil
+		}
+		return linkAuthors(ctx, repo.authors, updated)
+	})
+
+	return updated.Version, database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) RestoreBook(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE books SET deleted_at = NULL, version = version + 1
+              WHERE id = @id
+              RETURNING ` + bookColumns
+
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		existing, err := repo.lockBook(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+
+		var restored bookEntity
+		err = tx.QueryRowContext(ctx, query, sql.Named("id", id)).Scan(bookFields(&restored)...)
+		if err != nil {
+			return err
+		}
+		return repo.writeAudit(ctx, tx, newAudit(ctx, auditRestore, &existing, &restored))
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+// checkPublisher checks that publisher exists, transaction holds the database lock so it can't be removed meanwhile
+func (repo *SQLiteRepo) checkPublisher(ctx context.Context, tx *sql.Tx, id *int) error {
+	if id == nil {
+		return nil
+	}
+
+	var found bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM publishers WHERE id = @id)`, sql.Named("id", *id)).
+		Scan(&found)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return publisherNotFound(*id)
+	}
+	return nil
+}
+
+// checkSeries checks that series exists, transaction holds the database lock so it can't be removed meanwhile
+func (repo *SQLiteRepo) checkSeries(ctx context.Context, tx *sql.Tx, id *int) error {
+	if id == nil {
+		return nil
+	}
+
+	var found bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM series WHERE id = @id)`, sql.Named("id", *id)).
+		Scan(&found)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return unknownSeries(*id)
+	}
+	return nil
+}
+
+// saveFirstEdition copies ISBN, NumberOfPages and Price of the book to its first edition,
+// the edition is added when the book has none
+func (repo *SQLiteRepo) saveFirstEdition(ctx context.Context, tx *sql.Tx, b bookEntity) error {
+	args := namedArgs(map[string]any{
+		"book_id":         b.ID,
+		"isbn":            b.ISBN,
+		"number_of_pages": b.NumberOfPages,
+		"price":           priceAmount(b.Price),
+		"currency":        priceCurrency(b.Price),
+	})
+
+	res, err := tx.ExecContext(ctx, `UPDATE editions
+                                     SET isbn = @isbn, number_of_pages = @number_of_pages, price = @price, currency = @currency
+                                     WHERE id = (SELECT min(id) FROM editions WHERE book_id = @book_id)`, args...)
+	if err != nil {
+		return sqliteEditionErr(err, b.ISBN)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO editions (book_id, isbn, number_of_pages, price, currency)
+                                  VALUES (@book_id, @isbn, @number_of_pages, @price, @currency)`, args...)
+	return sqliteEditionErr(err, b.ISBN)
+}
+
+// syncFirstEdition updates locked book with fields of its first edition after editions of the book change,
+// the update is recorded unless the book already has them
+func (repo *SQLiteRepo) syncFirstEdition(ctx context.Context, tx *sql.Tx, existing bookEntity) error {
+	var first *editionEntity
+	var e editionEntity
+	err := tx.QueryRowContext(ctx, `SELECT `+editionColumns+` FROM editions WHERE book_id = @book_id ORDER BY id LIMIT 1`,
+		sql.Named("book_id", existing.ID)).Scan(editionFields(&e)...)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	default:
+		first = &e
+	}
+
+	updated := existing.copyFirstEdition(first)
+	if sameEditionFields(existing, updated) {
+		return nil
+	}
+
+	query := `UPDATE books
+              SET isbn = @isbn, number_of_pages = @number_of_pages, price = @price, currency = @currency,
+                  version = version + 1
+              WHERE id = @id
+              RETURNING version`
+	args := map[string]any{
+		"id":              existing.ID,
+		"isbn":            updated.ISBN,
+		"number_of_pages": updated.NumberOfPages,
+		"price":           priceAmount(updated.Price),
+		"currency":        priceCurrency(updated.Price),
+	}
+	if err := tx.QueryRowContext(ctx, query, namedArgs(args)...).Scan(&updated.Version); err != nil {
+		return err
+	}
+	if err := repo.writePriceHistory(ctx, tx, &existing, &updated); err != nil {
+		return err
+	}
+	return repo.writeAudit(ctx, tx, newAudit(ctx, auditUpdate, &existing, &updated))
+}
+
+// lockEdition reads an edition of a live book, transactions begin immediate so the whole database is locked already
+func (repo *SQLiteRepo) lockEdition(ctx context.Context, tx *sql.Tx, id int) (editionEntity, bookEntity, error) {
+	var e editionEntity
+	err := tx.QueryRowContext(ctx, `SELECT `+editionColumns+` FROM editions WHERE id = @id`, sql.Named("id", id)).
+		Scan(editionFields(&e)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return e, bookEntity{}, editionNotFound(id)
+	}
+	if err != nil {
+		return e, bookEntity{}, err
+	}
+
+	b, err := repo.lockBook(ctx, tx, e.BookID, false)
+	var nf database.NotFoundErr
+	if errors.As(err, &nf) {
+		return e, b, editionNotFound(id)
+	}
+	return e, b, err
+}
+
+// sqliteEditionArgs holds values of edition columns, release date is kept as text
+func sqliteEditionArgs(e editionEntity) []any {
+	args := editionArgs(e)
+	args["release_date"] = nil
+	if e.ReleaseDate != nil {
+		args["release_date"] = e.ReleaseDate.Format(time.DateOnly)
+	}
+	return namedArgs(args)
+}
+
+func (repo *SQLiteRepo) GetEditions(ctx context.Context, bookID int) ([]editionEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var found bool
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM books WHERE id = @id AND deleted_at IS NULL)`,
+		sql.Named("id", bookID)).Scan(&found)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+	if !found {
+		return nil, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	query := `SELECT ` + editionColumns + ` FROM editions WHERE book_id = @book_id ORDER BY id`
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, sql.Named("book_id", bookID))
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	editions := make([]editionEntity, 0)
+	for rows.Next() {
+		var e editionEntity
+		if err := rows.Scan(editionFields(&e)...); err != nil {
+			logger.Error(err.Error())
+			return nil, database.RepoErr(ctx, err)
+		}
+		editions = append(editions, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	return editions, nil
+}
+
+func (repo *SQLiteRepo) GetEditionById(ctx context.Context, id int) (editionEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + prefixColumns("e", editionColumns) + `
+              FROM editions e
+              JOIN books b ON b.id = e.book_id AND b.deleted_at IS NULL
+              WHERE e.id = @id`
+
+	var e editionEntity
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, query, sql.Named("id", id)).Scan(editionFields(&e)...)
+	if err != nil {
+		logger.Error(err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return e, editionNotFound(id)
+		}
+		return e, database.RepoErr(ctx, err)
+	}
+
+	return e, nil
+}
+
+func (repo *SQLiteRepo) AddEdition(ctx context.Context, bookID int, b editionRequestBody) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO editions
+                (book_id, publisher_id, format, isbn, number_of_pages, price, currency, release_date)
+                VALUES (@book_id, @publisher_id, @format, @isbn, @number_of_pages, @price, @currency, @release_date)
+                RETURNING id`
+
+	var id int
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		existing, err := repo.lockBook(ctx, tx, bookID, false)
+		if err != nil {
+			return err
+		}
+		if err := repo.checkPublisher(ctx, tx, b.PublisherID); err != nil {
+			return err
+		}
+
+		e := b.applyTo(editionEntity{BookID: bookID})
+		if err := tx.QueryRowContext(ctx, query, sqliteEditionArgs(e)...).Scan(&id); err != nil {
+			return sqliteEditionErr(err, e.ISBN)
+		}
+		return repo.syncFirstEdition(ctx, tx, existing)
+	})
+
+	return id, database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) UpdateEdition(ctx context.Context, id int, b editionRequestBody) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE editions
+              SET publisher_id = @publisher_id, format = @format, isbn = @isbn,
+                  number_of_pages = @number_of_pages, price = @price, currency = @currency, release_date = @release_date
+              WHERE id = @id`
+
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		existing, book, err := repo.lockEdition(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := repo.checkPublisher(ctx, tx, b.PublisherID); err != nil {
+			return err
+		}
+
+		updated := b.applyTo(existing)
+		if _, err := tx.ExecContext(ctx, query, sqliteEditionArgs(updated)...); err != nil {
+			return sqliteEditionErr(err, updated.ISBN)
+		}
+		return repo.syncFirstEdition(ctx, tx, book)
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) RemoveEdition(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		_, book, err := repo.lockEdition(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM editions WHERE id = @id`, sql.Named("id", id)); err != nil {
+			return err
+		}
+		return repo.syncFirstEdition(ctx, tx, book)
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+// checkISBN fails with isbnTaken when isbn belongs to an edition already, trashed books included
+func (repo *SQLiteRepo) checkISBN(ctx context.Context, tx *sql.Tx, isbn *string) error {
+	if isbn == nil {
+		return nil
+	}
+
+	var taken bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM editions WHERE isbn = ?)`, *isbn).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return isbnTaken(*isbn)
+	}
+	return nil
+}
+
+// ImportBooks checks and adds books one by one in a single transaction, sqlite has no bulk copy
+// and inserts are cheap once the transaction holds the database lock
+func (repo *SQLiteRepo) ImportBooks(ctx context.Context, rows []importRow, dryRun bool) (int, error) {
+	imported := 0
+	rejects := importRejects{}
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		for i, row := range rows {
+			if !rejects.isAccepted(rows, i) {
+				continue
+			}
+			var badreq database.BadRequestErr
+			if err := repo.checkSeries(ctx, tx, row.Book.seriesID()); errors.As(err, &badreq) {
+				rejects.add(i, err)
+				continue
+			} else if err != nil {
+				return err
+			}
+			if _, err := repo.linkGenre(ctx, tx, row.Book); errors.As(err, &badreq) {
+				rejects.add(i, err)
+				continue
+			} else if err != nil {
+				return err
+			}
+			var conflict database.ConflictErr
+			if err := repo.checkISBN(ctx, tx, row.Book.ISBN); errors.As(err, &conflict) {
+				rejects.add(i, err)
+				continue
+			} else if err != nil {
+				return err
+			}
+			if dryRun {
+				continue
+			}
+
+			if _, err := repo.AddBook(ctx, row.Book); err != nil {
+				return err
+			}
+			imported++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, database.TxErr(ctx, err)
+	}
+	rejects.apply(rows)
+
+	return imported, nil
+}
+
+func (repo *SQLiteRepo) GetBookHistory(ctx context.Context, id int, q historyQuery) (historyPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := historyPage{
+		Entries: make([]auditEntry, 0),
+	}
+	args := namedArgs(map[string]any{
+		"book_id": id,
+		"limit":   q.Limit,
+		"offset":  q.Offset,
+	})
+
+	countQuery := `SELECT count(*) FROM book_audit WHERE book_id = @book_id`
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, countQuery, args...).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM book_audit
+              WHERE book_id = @book_id
+              ORDER BY id
+              LIMIT @limit OFFSET @offset`
+
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e auditEntry
+		if err := rows.Scan(auditFields(&e)...); err != nil {
+			logger.Error(err.Error())
+			return page, database.RepoErr(ctx, err)
+		}
+		page.Entries = append(page.Entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *SQLiteRepo) PurgeBooks(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `DELETE FROM books WHERE deleted_at < @before`
+
+	res, err := database.SQLConn(ctx, repo.db).ExecContext(ctx, query, sql.Named("before", before.UTC().Format(sqliteTimeFormat)))
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, database.RepoErr(ctx, err)
+	}
+	n, _ := res.RowsAffected()
+
+	return int(n), nil
+}
+
+func (repo *SQLiteRepo) GetSeriesBooks(ctx context.Context, seriesID int) ([]bookEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + bookColumns + ` FROM books
+              WHERE series_id = @series_id
+              ORDER BY series_position IS NULL, series_position, id`
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, sql.Named("series_id", seriesID))
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	books := make([]bookEntity, 0)
+	for rows.Next() {
+		var b bookEntity
+		if err := rows.Scan(bookFields(&b)...); err != nil {
+			logger.Error(err.Error())
+			return nil, database.RepoErr(ctx, err)
+		}
+		books = append(books, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	return books, nil
+}
+
+// sqliteStockLevelColumns are stockLevelColumns of sqlite
+const sqliteStockLevelColumns = `l.book_id, l.location, l.quantity,
+                                 COALESCE((SELECT sum(r.quantity) FROM stock_reservations r
+                                           WHERE r.book_id = l.book_id AND r.location = l.location
+                                             AND r.expires_at > ` + sqliteNow + `), 0)`
+
+// stockOf reads stock levels of books in location order, sqlite has no arrays so ids are passed as json
+func (repo *SQLiteRepo) stockOf(ctx context.Context, bookIDs []int) (map[int]stockEntity, error) {
+	ids, _ := json.Marshal(bookIDs)
+	query := `SELECT ` + sqliteStockLevelColumns + ` FROM stock_levels l
+              WHERE l.book_id IN (SELECT value FROM json_each(@ids))
+              ORDER BY l.book_id, l.location`
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, sql.Named("ids", string(ids)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stock := map[int]stockEntity{}
+	for rows.Next() {
+		var bookID int
+		var l stockLevelEntity
+		if err := rows.Scan(stockLevelFields(&bookID, &l)...); err != nil {
+			return nil, err
+		}
+		s := stock[bookID]
+		s.BookID = bookID
+		s.Levels = append(s.Levels, l)
+		stock[bookID] = s
+	}
+	return stock, rows.Err()
+}
+
+func (repo *SQLiteRepo) GetStock(ctx context.Context, bookID int) (stockEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	stock := stockEntity{BookID: bookID, Levels: make([]stockLevelEntity, 0)}
+
+	var found bool
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM books WHERE id = @id AND deleted_at IS NULL)`,
+		sql.Named("id", bookID)).Scan(&found)
+	if err != nil {
+		logger.Error(err.Error())
+		return stock, database.RepoErr(ctx, err)
+	}
+	if !found {
+		return stock, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	levels, err := repo.stockOf(ctx, []int{bookID})
+	if err != nil {
+		logger.Error(err.Error())
+		return stock, database.RepoErr(ctx, err)
+	}
+	if s, ok := levels[bookID]; ok {
+		stock = s
+	}
+
+	return stock, nil
+}
+
+func (repo *SQLiteRepo) GetAvailable(ctx context.Context, bookIDs []int) (map[int]int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	stock, err := repo.stockOf(ctx, bookIDs)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	available := make(map[int]int, len(bookIDs))
+	for _, id := range bookIDs {
+		available[id] = stock[id].available()
+	}
+	return available, nil
+}
+
+// GetOffers reads books and their stock in one transaction, it holds the database lock until the outer one ends
+func (repo *SQLiteRepo) GetOffers(ctx context.Context, bookIDs []int) (map[int]Offer, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var offers map[int]Offer
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		offers = make(map[int]Offer, len(bookIDs))
+
+		ids, _ := json.Marshal(bookIDs)
+		rows, err := tx.QueryContext(ctx, `SELECT `+bookColumns+` FROM books
+                                           WHERE id IN (SELECT value FROM json_each(@ids)) AND deleted_at IS NULL
+                                           ORDER BY id`, sql.Named("ids", string(ids)))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		books := make([]bookEntity, 0, len(bookIDs))
+		for rows.Next() {
+			var b bookEntity
+			if err := rows.Scan(bookFields(&b)...); err != nil {
+				return err
+			}
+			books = append(books, b)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		stock, err := repo.stockOf(ctx, bookIDs)
+		if err != nil {
+			return err
+		}
+		for _, b := range books {
+			offers[b.ID] = Offer{Title: b.Title, Price: b.Price, Available: stock[b.ID].available()}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, database.TxErr(ctx, err)
+	}
+
+	return offers, nil
+}
+
+// lockedStock reads copies on hand and reserved at a location, transaction holds the database lock
+func (repo *SQLiteRepo) lockedStock(ctx context.Context, tx *sql.Tx, bookID int, location string) (onHand, reserved int, err error) {
+	args := namedArgs(map[string]any{"book_id": bookID, "location": location})
+
+	err = tx.QueryRowContext(ctx, `SELECT quantity FROM stock_levels WHERE book_id = @book_id AND location = @location`,
+		args...).Scan(&onHand)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, err
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(sum(quantity), 0) FROM stock_reservations
+                                   WHERE book_id = @book_id AND location = @location AND expires_at > `+sqliteNow,
+		args...).Scan(&reserved)
+	return onHand, reserved, err
+}
+
+func (repo *SQLiteRepo) AdjustStock(ctx context.Context, bookID int, b stockAdjustRequestBody) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := repo.lockBook(ctx, tx, bookID, false); err != nil {
+			return err
+		}
+		onHand, reserved, err := repo.lockedStock(ctx, tx, bookID, *b.Location)
+		if err != nil {
+			return err
+		}
+
+		held := 0
+		if b.ReservationID != nil {
+			args := namedArgs(map[string]any{"id": *b.ReservationID, "book_id": bookID, "location": *b.Location})
+			err := tx.QueryRowContext(ctx, `DELETE FROM stock_reservations
+                                            WHERE id = @id AND book_id = @book_id AND location = @location
+                                              AND expires_at > `+sqliteNow+`
+                                            RETURNING quantity`, args...).Scan(&held)
+			if errors.Is(err, sql.ErrNoRows) {
+				return unknownReservation(*b.ReservationID, *b.Location)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err := b.check(bookID, onHand, reserved, held); err != nil {
+			return err
+		}
+
+		// the level is created empty first like in BooksRepo.AdjustStock
+		m := newMovement(ctx, bookID, b)
+		levelArgs := namedArgs(map[string]any{"book_id": bookID, "location": m.Location, "delta": m.Delta})
+		_, err = tx.ExecContext(ctx, `INSERT INTO stock_levels (book_id, location, quantity)
+                                      VALUES (@book_id, @location, 0)
+                                      ON CONFLICT (book_id, location) DO NOTHING`, levelArgs...)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE stock_levels SET quantity = quantity + @delta
+                                      WHERE book_id = @book_id AND location = @location`, levelArgs...)
+		if err != nil {
+			return err
+		}
+
+		args := map[string]any{
+			"book_id":        m.BookID,
+			"location":       m.Location,
+			"delta":          m.Delta,
+			"reason":         m.Reason,
+			"reservation_id": m.ReservationID,
+			"actor":          m.Actor,
+			"request_id":     m.RequestID,
+		}
+		return tx.QueryRowContext(ctx, `INSERT INTO stock_movements
+                                            (book_id, location, delta, reason, reservation_id, actor, request_id)
+                                        VALUES (@book_id, @location, @delta, @reason, @reservation_id, @actor, @request_id)
+                                        RETURNING id`, namedArgs(args)...).Scan(&id)
+	})
+
+	return id, database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) GetStockMovements(ctx context.Context, bookID int, q movementsQuery) (movementsPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := movementsPage{
+		Movements: make([]stockMovement, 0),
+	}
+	args := namedArgs(map[string]any{
+		"book_id": bookID,
+		"limit":   q.Limit,
+		"offset":  q.Offset,
+	})
+
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT count(*) FROM stock_movements WHERE book_id = @book_id`, args...).
+		Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	query := `SELECT ` + movementColumns + ` FROM stock_movements
+              WHERE book_id = @book_id
+              ORDER BY id
+              LIMIT @limit OFFSET @offset`
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m stockMovement
+		if err := rows.Scan(movementFields(&m)...); err != nil {
+			logger.Error(err.Error())
+			return page, database.RepoErr(ctx, err)
+		}
+		page.Movements = append(page.Movements, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *SQLiteRepo) ReserveStock(ctx context.Context, bookID int, b reservationRequestBody, expiresAt time.Time) (reservationEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	reservation := reservationEntity{
+		BookID:    bookID,
+		Location:  *b.Location,
+		Quantity:  *b.Quantity,
+		ExpiresAt: expiresAt.UTC(),
+	}
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := repo.lockBook(ctx, tx, bookID, false); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM stock_reservations WHERE book_id = @book_id AND expires_at <= `+sqliteNow,
+			sql.Named("book_id", bookID))
+		if err != nil {
+			return err
+		}
+
+		onHand, reserved, err := repo.lockedStock(ctx, tx, bookID, reservation.Location)
+		if err != nil {
+			return err
+		}
+		if err := b.check(bookID, onHand, reserved); err != nil {
+			return err
+		}
+
+		args := map[string]any{
+			"book_id":    bookID,
+			"location":   reservation.Location,
+			"quantity":   reservation.Quantity,
+			"expires_at": reservation.ExpiresAt.Format(sqliteTimeFormat),
+		}
+		return tx.QueryRowContext(ctx, `INSERT INTO stock_reservations (book_id, location, quantity, expires_at)
+                                        VALUES (@book_id, @location, @quantity, @expires_at)
+                                        RETURNING id`, namedArgs(args)...).Scan(&reservation.ID)
+	})
+
+	return reservation, database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) ReleaseReservation(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := database.SQLConn(ctx, repo.db).ExecContext(ctx, `DELETE FROM stock_reservations WHERE id = @id AND expires_at > `+sqliteNow,
+		sql.Named("id", id))
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return reservationNotFound(id)
+	}
+
+	return nil
+}
+
+// scheduledPrices reads scheduled prices selected by query
+func (repo *SQLiteRepo) scheduledPrices(ctx context.Context, query string, args ...any) ([]scheduledPrice, error) {
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := make([]scheduledPrice, 0)
+	for rows.Next() {
+		var s scheduledPrice
+		if err := rows.Scan(scheduledPriceFields(&s)...); err != nil {
+			return nil, err
+		}
+		prices = append(prices, s)
+	}
+	return prices, rows.Err()
+}
+
+func (repo *SQLiteRepo) GetPrices(ctx context.Context, bookID int) (pricesEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	prices := pricesEntity{BookID: bookID, History: make([]priceEntry, 0), Scheduled: make([]scheduledPrice, 0)}
+
+	var found bool
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM books WHERE id = @id AND deleted_at IS NULL)`,
+		sql.Named("id", bookID)).Scan(&found)
+	if err != nil {
+		logger.Error(err.Error())
+		return prices, database.RepoErr(ctx, err)
+	}
+	if !found {
+		return prices, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, `SELECT `+priceEntryColumns+` FROM price_history
+                                                   WHERE book_id = @book_id
+                                                   ORDER BY id`, sql.Named("book_id", bookID))
+	if err != nil {
+		logger.Error(err.Error())
+		return prices, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e priceEntry
+		if err := rows.Scan(priceEntryFields(&e)...); err != nil {
+			logger.Error(err.Error())
+			return prices, database.RepoErr(ctx, err)
+		}
+		prices.History = append(prices.History, e)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return prices, database.RepoErr(ctx, err)
+	}
+
+	prices.Scheduled, err = repo.scheduledPrices(ctx, `SELECT `+scheduledPriceColumns+` FROM scheduled_prices
+                                                        WHERE book_id = @book_id
+                                                        ORDER BY effective_from, id`, sql.Named("book_id", bookID))
+	if err != nil {
+		logger.Error(err.Error())
+		return prices, database.RepoErr(ctx, err)
+	}
+
+	return prices, nil
+}
+
+func (repo *SQLiteRepo) SchedulePrice(ctx context.Context, bookID int, b priceScheduleRequestBody) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := repo.lockBook(ctx, tx, bookID, false); err != nil {
+			return err
+		}
+
+		s := newScheduledPrice(ctx, bookID, b)
+		args := map[string]any{
+			"book_id":        s.BookID,
+			"price":          priceAmount(s.Price),
+			"currency":       priceCurrency(s.Price),
+			"effective_from": s.EffectiveFrom.Format(sqliteTimeFormat),
+			"actor":          s.Actor,
+			"request_id":     s.RequestID,
+		}
+		return tx.QueryRowContext(ctx, `INSERT INTO scheduled_prices (book_id, price, currency, effective_from, actor, request_id)
+                                        VALUES (@book_id, @price, @currency, @effective_from, @actor, @request_id)
+                                        RETURNING id`, namedArgs(args)...).Scan(&id)
+	})
+
+	return id, database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) GetDuePrices(ctx context.Context, now time.Time) ([]scheduledPrice, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + prefixColumns("s", scheduledPriceColumns) + ` FROM scheduled_prices s
+              JOIN books b ON b.id = s.book_id AND b.deleted_at IS NULL
+              WHERE s.effective_from <= @now
+              ORDER BY s.effective_from, s.id`
+	due, err := repo.scheduledPrices(ctx, query, sql.Named("now", now.UTC().Format(sqliteTimeFormat)))
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	return due, nil
+}
+
+func (repo *SQLiteRepo) RemoveScheduledPrice(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := database.SQLConn(ctx, repo.db).ExecContext(ctx, `DELETE FROM scheduled_prices WHERE id = @id`, sql.Named("id", id))
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return scheduledPriceNotFound(id)
+	}
+
+	return nil
+}
+
+func (repo *SQLiteRepo) AddReview(ctx context.Context, bookID int, b reviewRequestBody) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := repo.lockBook(ctx, tx, bookID, false); err != nil {
+			return err
+		}
+
+		args := map[string]any{
+			"book_id": bookID,
+			"rating":  b.Rating,
+			"text":    b.Text,
+			"author":  b.Author,
+			"status":  reviewPending,
+		}
+		return tx.QueryRowContext(ctx, `INSERT INTO reviews (book_id, rating, text, author, status)
+                                        VALUES (@book_id, @rating, @text, @author, @status)
+                                        RETURNING id`, namedArgs(args)...).Scan(&id)
+	})
+
+	return id, database.TxErr(ctx, err)
+}
+
+// reviewsPage reads a page of reviews matching where, which may use book_id and status arguments
+func (repo *SQLiteRepo) reviewsPage(ctx context.Context, where, order string, args map[string]any) (reviewsPage, error) {
+	page := reviewsPage{Reviews: make([]reviewEntity, 0)}
+
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT count(*) FROM reviews WHERE `+where, namedArgs(args)...).
+		Scan(&page.Total)
+	if err != nil {
+		return page, err
+	}
+
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, `SELECT `+reviewColumns+` FROM reviews
+                                                   WHERE `+where+`
+                                                   ORDER BY `+order+`
+                                                   LIMIT @limit OFFSET @offset`, namedArgs(args)...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r reviewEntity
+		if err := rows.Scan(reviewFields(&r)...); err != nil {
+			return page, err
+		}
+		page.Reviews = append(page.Reviews, r)
+	}
+	return page, rows.Err()
+}
+
+func (repo *SQLiteRepo) GetBookReviews(ctx context.Context, bookID int, q reviewsQuery) (reviewsPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var found bool
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM books WHERE id = @id AND deleted_at IS NULL)`,
+		sql.Named("id", bookID)).Scan(&found)
+	if err != nil {
+		logger.Error(err.Error())
+		return reviewsPage{}, database.RepoErr(ctx, err)
+	}
+	if !found {
+		return reviewsPage{}, database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", bookID)}
+	}
+
+	args := map[string]any{"book_id": bookID, "status": q.Status, "limit": q.Limit, "offset": q.Offset}
+	page, err := repo.reviewsPage(ctx, `book_id = @book_id AND status = @status`, `id DESC`, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *SQLiteRepo) GetReviews(ctx context.Context, q reviewsQuery) (reviewsPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	args := map[string]any{"status": q.Status, "limit": q.Limit, "offset": q.Offset}
+	page, err := repo.reviewsPage(ctx, `status = @status`, `id`, args)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *SQLiteRepo) ModerateReview(ctx context.Context, id int, status string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		var r reviewEntity
+		err := tx.QueryRowContext(ctx, `SELECT `+reviewColumns+` FROM reviews WHERE id = @id`, sql.Named("id", id)).
+			Scan(reviewFields(&r)...)
+		if errors.Is(err, sql.ErrNoRows) {
+			return reviewNotFound(id)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE reviews SET status = @status, moderated_at = `+sqliteNow+` WHERE id = @id`,
+			sql.Named("id", id), sql.Named("status", status))
+		if err != nil {
+			return err
+		}
+
+		count, sum := ratingDelta(r.Status, status, r.Rating)
+		if count == 0 && sum == 0 {
+			return nil
+		}
+		// rating is part of the book, a new version makes cached copies of it stale
+		_, err = tx.ExecContext(ctx, `UPDATE books
+                                      SET rating_count = rating_count + @count, rating_sum = rating_sum + @sum, version = version + 1
+                                      WHERE id = @book_id`,
+			sql.Named("book_id", r.BookID), sql.Named("count", count), sql.Named("sum", sum))
+		return err
+	})
+
+	return database.TxErr(ctx, err)
+}
@@ -0,0 +1,172 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/money"
+	"booksapi/api/router/middlewares"
+	"booksapi/config"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// stock reasons are reason codes of stock adjustments, received copies come in and the rest go out
+const (
+	stockReceived = "received"
+	stockSold     = "sold"
+	stockDamaged  = "damaged"
+)
+
+var stockReasons = []string{stockReceived, stockSold, stockDamaged}
+
+// defaultReservationTimeout is used when appsettings set no stock.reservationMinutes
+const defaultReservationTimeout = 15 * time.Minute
+
+func reservationTimeout() time.Duration {
+	minutes := config.GetAppsettings().Stock.ReservationMinutes
+	if minutes <= 0 {
+		return defaultReservationTimeout
+	}
+	return time.Duration(minutes * int(time.Minute))
+}
+
+// IStockRepo keeps copies of books by warehouse location. Stock belongs to books, so every books
+// repository implements it, books and carts only count available copies with it
+type IStockRepo interface {
+	// GetStock lists stock of a live book by location
+	GetStock(ctx context.Context, bookID int) (stockEntity, error)
+	// GetAvailable counts copies neither sold nor reserved of every given book, books without stock have 0
+	GetAvailable(ctx context.Context, bookIDs []int) (map[int]int, error)
+	// GetOffers returns offers of the live books among given ones by id. Called in a transaction it keeps
+	// stock of the books from changing until the transaction ends, stock writes wait for it
+	GetOffers(ctx context.Context, bookIDs []int) (map[int]Offer, error)
+	// AdjustStock changes copies of a live book and records the movement in the same transaction,
+	// it returns id of the movement and fails with ConflictErr when copies going out are not there
+	AdjustStock(ctx context.Context, bookID int, b stockAdjustRequestBody) (int, error)
+	// GetStockMovements lists movements of a book, they outlive the book when it is purged
+	GetStockMovements(ctx context.Context, bookID int, q movementsQuery) (movementsPage, error)
+	// ReserveStock holds copies of a live book until expiresAt, it fails with ConflictErr when they are not
+	// available. Expired reservations are never counted and those of the book are removed meanwhile
+	ReserveStock(ctx context.Context, bookID int, b reservationRequestBody, expiresAt time.Time) (reservationEntity, error)
+	// ReleaseReservation ends reservation before it expires
+	ReleaseReservation(ctx context.Context, id int) error
+}
+
+// Offer is what carts need of a live book to sell it
+type Offer struct {
+	Title string
+	// Price is nil for books which have no price and can't be sold
+	Price *money.Money
+	// Available counts copies neither sold nor reserved
+	Available int
+}
+
+func reservationNotFound(id int) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("reservation with id %d not found or expired", id)}
+}
+
+// unknownReservation is returned when an adjustment sells copies of a reservation which does not hold them
+func unknownReservation(id int, location string) database.BadRequestErr {
+	return database.BadRequestErr{Message: fmt.Sprintf("reservation with id %d not found or expired at %s", id, location)}
+}
+
+func insufficientStock(bookID int, location string, free, want int) database.ConflictErr {
+	return database.ConflictErr{Message: fmt.Sprintf("book with id %d has %d copies available at %s, can't take %d",
+		bookID, free, location, want)}
+}
+
+func validateLocation(location *string) (string, error) {
+	if location == nil || strings.TrimSpace(*location) == "" {
+		return "", database.BadRequestErr{Message: "location is required"}
+	}
+	return strings.TrimSpace(*location), nil
+}
+
+func validateQuantity(quantity *int) error {
+	if quantity == nil || *quantity < 1 {
+		return database.BadRequestErr{Message: "quantity must be greater than 0"}
+	}
+	return nil
+}
+
+// validate trims location and lowercases reason
+func (b *stockAdjustRequestBody) validate() error {
+	location, err := validateLocation(b.Location)
+	if err != nil {
+		return err
+	}
+	b.Location = &location
+	if err := validateQuantity(b.Quantity); err != nil {
+		return err
+	}
+	if b.Reason == nil || !slices.Contains(stockReasons, strings.ToLower(strings.TrimSpace(*b.Reason))) {
+		return database.BadRequestErr{Message: fmt.Sprintf("reason must be one of %s", strings.Join(stockReasons, ", "))}
+	}
+	reason := strings.ToLower(strings.TrimSpace(*b.Reason))
+	b.Reason = &reason
+	if b.ReservationID != nil && reason != stockSold {
+		return database.BadRequestErr{Message: "reservationId needs reason sold"}
+	}
+	return nil
+}
+
+// delta is change of copies on hand the validated adjustment makes
+func (b stockAdjustRequestBody) delta() int {
+	if *b.Reason == stockReceived {
+		return *b.Quantity
+	}
+	return -*b.Quantity
+}
+
+// check makes sure copies going out are there. Sold copies must not be reserved by anyone else,
+// held is quantity of the reservation the sale ends. Damaged copies may be reserved ones
+func (b stockAdjustRequestBody) check(bookID, onHand, reserved, held int) error {
+	free := onHand
+	switch *b.Reason {
+	case stockReceived:
+		return nil
+	case stockSold:
+		free = max(onHand-reserved+held, 0)
+	}
+	if *b.Quantity > free {
+		return insufficientStock(bookID, *b.Location, free, *b.Quantity)
+	}
+	return nil
+}
+
+// validate trims location
+func (b *reservationRequestBody) validate() error {
+	location, err := validateLocation(b.Location)
+	if err != nil {
+		return err
+	}
+	b.Location = &location
+	return validateQuantity(b.Quantity)
+}
+
+// check makes sure the reservation holds copies nobody else reserved
+func (b reservationRequestBody) check(bookID, onHand, reserved int) error {
+	if free := max(onHand-reserved, 0); *b.Quantity > free {
+		return insufficientStock(bookID, *b.Location, free, *b.Quantity)
+	}
+	return nil
+}
+
+// newMovement records validated adjustment of a book, actor and request id come from ctx like for audit
+func newMovement(ctx context.Context, bookID int, b stockAdjustRequestBody) stockMovement {
+	m := stockMovement{
+		BookID:        bookID,
+		Location:      *b.Location,
+		Delta:         b.delta(),
+		Reason:        *b.Reason,
+		ReservationID: b.ReservationID,
+		Actor:         middlewares.ActorFromContext(ctx),
+		RequestID:     middlewares.RequestIDFromContext(ctx),
+	}
+	if m.Actor == "" {
+		m.Actor = systemActor
+	}
+	return m
+}
@@ -0,0 +1,293 @@
+package books
+
+import (
+	"booksapi/api/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StockAPI serves stock of books kept by books repository, see API.Stock
+type StockAPI struct {
+	repo IStockRepo
+	// inTx runs a unit of work of repo calls, see database.Storage
+	inTx func(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// GetStock returns stock of a book
+//
+//	@Summary		Stock of book
+//	@Description	lists copies on hand and reserved by warehouse location, available counts copies neither sold nor reserved
+//	@Tags			stock
+//	@Produce		json
+//	@Param			id	path		int	true	"book record Id"
+//	@Success		200	{object}	stockDTO
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/books/{id}/stock [get]
+func (api StockAPI) GetStock(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	stock, err := api.repo.GetStock(r.Context(), id)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	json, _ := json.Marshal(stock.ToDto())
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// AdjustStock changes stock of a book
+//
+//	@Summary		Adjust stock
+//	@Description	received copies are added to the location, sold and damaged ones are taken from it. Copies reserved by
+//	@Description	someone else can't be sold, reservationId sells copies of the reservation and ends it.
+//	@Description	Every adjustment is recorded in stock movements with X-Actor header value and request id
+//	@Tags			stock
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int						true	"book record Id"
+//	@Param			adjustment	body		stockAdjustRequestBody	true	"request body"
+//	@Success		201			{object}	ActionResponse
+//	@Failure		500			{object}	database.APIError
+//	@Failure		503			{object}	database.APIError
+//	@Failure		400			{object}	database.APIError
+//	@Failure		404			{object}	database.APIError
+//	@Failure		409			{object}	database.APIError
+//	@Router			/api/books/{id}/stock/adjust [post]
+func (api StockAPI) AdjustStock(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	req, apiErr := decodeStockAdjustment(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	var movementID int
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		movementID, err = api.repo.AdjustStock(ctx, id, req)
+		return err
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	j, _ := json.Marshal(ActionResponse{ResourceId: movementID})
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, string(j[:]))
+}
+
+// GetStockMovements returns stock ledger of a book
+//
+//	@Summary		Stock movements of book
+//	@Description	lists stock adjustments of a book oldest first, they are kept after the book is purged
+//	@Tags			stock
+//	@Produce		json
+//	@Param			id		path		int	true	"book record Id"
+//	@Param			limit	query		int	false	"page size, 20 by default, 100 at most"
+//	@Param			offset	query		int	false	"number of movements to skip"
+//	@Success		200		{object}	movementsPageDTO
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Router			/api/books/{id}/stock/movements [get]
+func (api StockAPI) GetStockMovements(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	q, apiErr := parseMovementsQuery(r.URL.Query())
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	page, err := api.repo.GetStockMovements(r.Context(), id, q)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto := movementsPageDTO{
+		Items: make([]stockMovementDTO, 0, len(page.Movements)),
+		Total: page.Total,
+	}
+	for _, m := range page.Movements {
+		dto.Items = append(dto.Items, m.ToDto())
+	}
+
+	json, _ := json.Marshal(dto)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// ReserveStock reserves copies of a book
+//
+//	@Summary		Reserve stock
+//	@Description	holds available copies at a location until the reservation expires after stock.reservationMinutes,
+//	@Description	reserved copies are not available to anyone else
+//	@Tags			stock
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int						true	"book record Id"
+//	@Param			reservation	body		reservationRequestBody	true	"request body"
+//	@Success		201			{object}	reservationDTO
+//	@Failure		500			{object}	database.APIError
+//	@Failure		503			{object}	database.APIError
+//	@Failure		400			{object}	database.APIError
+//	@Failure		404			{object}	database.APIError
+//	@Failure		409			{object}	database.APIError
+//	@Router			/api/books/{id}/stock/reservations [post]
+func (api StockAPI) ReserveStock(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	req, apiErr := decodeReservation(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	var reservation reservationEntity
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		reservation, err = api.repo.ReserveStock(ctx, id, req, time.Now().Add(reservationTimeout()))
+		return err
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	json, _ := json.Marshal(reservation.ToDto())
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// ReleaseReservation ends a reservation
+//
+//	@Summary		Release reservation
+//	@Description	makes reserved copies available again before the reservation expires
+//	@Tags			stock
+//	@Produce		json
+//	@Param			id	path	int	true	"Reservation ID"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/reservations/{id} [delete]
+func (api StockAPI) ReleaseReservation(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("id")
+	id, err := strconv.Atoi(p)
+	if err != nil {
+		e := database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {id} path parameter",
+		}
+		database.WriteAPIErr(e, w)
+		return
+	}
+
+	err = api.inTx(r.Context(), func(ctx context.Context) error {
+		return api.repo.ReleaseReservation(ctx, id)
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeStockAdjustment(r *http.Request) (stockAdjustRequestBody, *database.APIError) {
+	var req stockAdjustRequestBody
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, &database.APIError{
+			Message: "invalid request model",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if err := req.validate(); err != nil {
+		return req, &database.APIError{
+			Message: err.Error(),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	return req, nil
+}
+
+func decodeReservation(r *http.Request) (reservationRequestBody, *database.APIError) {
+	var req reservationRequestBody
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, &database.APIError{
+			Message: "invalid request model",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if err := req.validate(); err != nil {
+		return req, &database.APIError{
+			Message: err.Error(),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	return req, nil
+}
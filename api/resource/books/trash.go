@@ -0,0 +1,30 @@
+package books
+
+import (
+	"booksapi/logger"
+	"context"
+	"fmt"
+	"time"
+)
+
+// PurgeTrashPeriodically permanently deletes books which stayed in trash longer than retention,
+// first purge runs right away and then every interval until ctx is done
+func (api API) PurgeTrashPeriodically(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := api.repo.PurgeBooks(ctx, time.Now().Add(-retention))
+		if err != nil {
+			logger.Error(fmt.Sprintf("ERROR purging trash -> %s", err.Error()))
+		} else if purged > 0 {
+			logger.Info(fmt.Sprintf("purged %d books from trash", purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
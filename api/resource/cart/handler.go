@@ -0,0 +1,399 @@
+package cart
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/money"
+	"booksapi/api/resource/books"
+	"booksapi/config"
+	"booksapi/logger"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxQuantity is the most copies of a book one cart takes
+const maxQuantity = 100
+
+// defaultExpiry is used when appsettings set no cart.expiryMinutes
+const defaultExpiry = 24 * time.Hour
+
+// Catalog looks up live books kept by books package. Carts keep book ids and quantities only,
+// title, price and available copies of their books are read from it every time
+type Catalog interface {
+	// FindBookOffers leaves out books which are not found, called in a unit of work it keeps their stock
+	// from changing until the work is done
+	FindBookOffers(ctx context.Context, ids []int) (map[int]books.Offer, error)
+}
+
+// expiry is how long a cart is kept after the last request to it
+func expiry() time.Duration {
+	minutes := config.GetAppsettings().Cart.ExpiryMinutes
+	if minutes <= 0 {
+		return defaultExpiry
+	}
+	return time.Duration(minutes * int(time.Minute))
+}
+
+// pathCartID parses cart id path parameter, carts are named by uuids so that nobody finds a cart of someone else
+func pathCartID(r *http.Request) (string, *database.APIError) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return "", &database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept uuid values as {id} path parameter",
+		}
+	}
+	return id.String(), nil
+}
+
+// pathBookID parses bookId path parameter
+func pathBookID(r *http.Request) (int, *database.APIError) {
+	id, err := strconv.Atoi(r.PathValue("bookId"))
+	if err != nil {
+		return 0, &database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: "only accept integer values as {bookId} path parameter",
+		}
+	}
+	return id, nil
+}
+
+func decodeLine(r *http.Request) (lineRequestBody, *database.APIError) {
+	var req lineRequestBody
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, &database.APIError{
+			Message: "invalid request model",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if err := req.validate(); err != nil {
+		return req, &database.APIError{
+			Message: err.Error(),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	return req, nil
+}
+
+type API struct {
+	repo  ICartRepo
+	books Catalog
+	// inTx runs a unit of work of repo and books calls, see database.Storage
+	inTx func(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// New picks repository of the opened storage, books price carts and check their stock
+func New(books Catalog) API {
+	var repo ICartRepo
+	storage := database.Get()
+	switch s := storage.(type) {
+	case *database.PostgresStorage:
+		repo = &CartRepo{pool: s.Pool}
+	case *database.SQLiteStorage:
+		repo = &SQLiteRepo{db: s.DB}
+	default:
+		repo = NewMemoryRepo()
+	}
+
+	return API{
+		repo:  repo,
+		books: books,
+		inTx:  storage.InTx,
+	}
+}
+
+// checkBook makes sure quantity of the book can be sold right now
+func (api API) checkBook(ctx context.Context, bookID, quantity int) error {
+	offers, err := api.books.FindBookOffers(ctx, []int{bookID})
+	if err != nil {
+		return err
+	}
+	offer, ok := offers[bookID]
+	if !ok {
+		return bookNotFound(bookID)
+	}
+	if offer.Price == nil {
+		return database.ConflictErr{Message: fmt.Sprintf("book with id %d has no price and can't be sold", bookID)}
+	}
+	if quantity > offer.Available {
+		return database.ConflictErr{Message: fmt.Sprintf("not enough copies of book with id %d, %d available", bookID, offer.Available)}
+	}
+	return nil
+}
+
+// toDto prices lines of the cart with current prices of their books and sums them by currency,
+// books of every line are looked up at once
+func (api API) toDto(ctx context.Context, c cartEntity) (cartDTO, error) {
+	ids := make([]int, 0, len(c.Lines))
+	for _, l := range c.Lines {
+		ids = append(ids, l.BookID)
+	}
+	offers, err := api.books.FindBookOffers(ctx, ids)
+	if err != nil {
+		return cartDTO{}, err
+	}
+
+	dto := cartDTO{
+		ID:        c.ID,
+		Lines:     make([]lineDTO, 0, len(c.Lines)),
+		Totals:    make([]priceDTO, 0),
+		CreatedAt: c.CreatedAt,
+		ExpiresAt: c.ExpiresAt,
+	}
+	totals := make([]money.Money, 0)
+
+	for _, l := range c.Lines {
+		line := lineDTO{BookID: l.BookID, Quantity: l.Quantity}
+		offer, ok := offers[l.BookID]
+		if ok {
+			line.Title, line.Available = offer.Title, offer.Available
+		}
+		if ok && offer.Price != nil {
+			total := money.Money{Amount: offer.Price.Amount * int64(l.Quantity), Currency: offer.Price.Currency}
+			line.UnitPrice, line.LineTotal = newPriceDTO(*offer.Price), newPriceDTO(total)
+			totals = addTotal(totals, total)
+		}
+		dto.Lines = append(dto.Lines, line)
+	}
+	for _, t := range totals {
+		dto.Totals = append(dto.Totals, *newPriceDTO(t))
+	}
+
+	return dto, nil
+}
+
+// addTotal adds amount to the total of its currency, currencies stay in order they first appear in
+func addTotal(totals []money.Money, amount money.Money) []money.Money {
+	for i, t := range totals {
+		if t.Currency == amount.Currency {
+			totals[i].Amount += amount.Amount
+			return totals
+		}
+	}
+	return append(totals, amount)
+}
+
+// AddCart creates an empty cart
+//
+//	@Summary		Create cart
+//	@Description	creates an empty cart, it expires after cart.expiryMinutes without a request to it
+//	@Tags			cart
+//	@Produce		json
+//	@Success		201	{object}	cartDTO
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Router			/api/carts [post]
+func (api API) AddCart(w http.ResponseWriter, r *http.Request) {
+	id := uuid.NewString()
+	if err := api.repo.AddCart(r.Context(), id, time.Now().Add(expiry())); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	c, err := api.repo.GetCart(r.Context(), id, time.Now().Add(expiry()))
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto, err := api.toDto(r.Context(), c)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	json, _ := json.Marshal(dto)
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// GetCart returns a cart with totals
+//
+//	@Summary		Get cart
+//	@Description	lists lines of a cart in book id order with current price and available copies of their books,
+//	@Description	totals sum lines by currency. Lines of removed books or books without price are left out of totals
+//	@Tags			cart
+//	@Produce		json
+//	@Param			id	path		string	true	"Cart ID"
+//	@Success		200	{object}	cartDTO
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/carts/{id} [get]
+func (api API) GetCart(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathCartID(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	c, err := api.repo.GetCart(r.Context(), id, time.Now().Add(expiry()))
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto, err := api.toDto(r.Context(), c)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	json, _ := json.Marshal(dto)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// SetLine puts copies of a book in a cart
+//
+//	@Summary		Set cart line
+//	@Description	sets quantity of a book in a cart, the book must have a price and enough available copies
+//	@Tags			cart
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path	string			true	"Cart ID"
+//	@Param			bookId	path	int				true	"Book ID"
+//	@Param			line	body	lineRequestBody	true	"request body"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Failure		409	{object}	database.APIError
+//	@Router			/api/carts/{id}/lines/{bookId} [put]
+func (api API) SetLine(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathCartID(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+	bookID, apiErr := pathBookID(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	req, apiErr := decodeLine(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	// stock is checked in the transaction of the write, it can't change in between
+	err := api.inTx(r.Context(), func(ctx context.Context) error {
+		if err := api.checkBook(ctx, bookID, *req.Quantity); err != nil {
+			return err
+		}
+		return api.repo.SetLine(ctx, id, bookID, *req.Quantity, time.Now().Add(expiry()))
+	})
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveLine takes a book out of a cart
+//
+//	@Summary		Remove cart line
+//	@Description	removes every copy of a book from a cart
+//	@Tags			cart
+//	@Produce		json
+//	@Param			id		path	string	true	"Cart ID"
+//	@Param			bookId	path	int		true	"Book ID"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/carts/{id}/lines/{bookId} [delete]
+func (api API) RemoveLine(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathCartID(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+	bookID, apiErr := pathBookID(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	if err := api.repo.RemoveLine(r.Context(), id, bookID, time.Now().Add(expiry())); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveCart deletes a cart
+//
+//	@Summary		Remove cart
+//	@Description	removes a cart with its lines
+//	@Tags			cart
+//	@Produce		json
+//	@Param			id	path	string	true	"Cart ID"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/carts/{id} [delete]
+func (api API) RemoveCart(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathCartID(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	if err := api.repo.RemoveCart(r.Context(), id); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeExpiredPeriodically removes expired carts, first purge runs right away and then every interval until ctx is done.
+// Expired carts can't be read anyway, purge only frees their storage
+func (api API) PurgeExpiredPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := api.repo.PurgeCarts(ctx, time.Now())
+		if err != nil {
+			logger.Error(fmt.Sprintf("ERROR purging carts -> %s", err.Error()))
+		} else if purged > 0 {
+			logger.Info(fmt.Sprintf("purged %d expired carts", purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
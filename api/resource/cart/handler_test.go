@@ -0,0 +1,248 @@
+package cart
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/money"
+	"booksapi/api/resource/books"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeBook struct {
+	title     string
+	price     *money.Money
+	available int
+}
+
+// fakeCatalog is Catalog with books of fixed price and stock, it counts lookups
+// and those made outside of a unit of work of fakeTx
+type fakeCatalog struct {
+	books   map[int]fakeBook
+	lookups int
+	outside int
+}
+
+func (c *fakeCatalog) FindBookOffers(ctx context.Context, ids []int) (map[int]books.Offer, error) {
+	c.lookups++
+	if ctx.Value(fakeTxKey{}) == nil {
+		c.outside++
+	}
+	offers := map[int]books.Offer{}
+	for _, id := range ids {
+		if b, ok := c.books[id]; ok {
+			offers[id] = books.Offer{Title: b.title, Price: b.price, Available: b.available}
+		}
+	}
+	return offers, nil
+}
+
+type fakeTxKey struct{}
+
+// fakeTx marks ctx of the unit of work, so catalog can tell lookups made inside of it
+func fakeTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, fakeTxKey{}, true))
+}
+
+func price(amount int64, currency string) *money.Money {
+	m, _ := money.New(amount, currency)
+	return &m
+}
+
+func TestCartAPI(t *testing.T) {
+	catalog := &fakeCatalog{books: map[int]fakeBook{
+		1: {title: "The Hobbit", price: price(2000, "EUR"), available: 5},
+		2: {title: "Good Omens", price: price(999, "EUR"), available: 1},
+		3: {title: "Solaris", available: 3},
+		4: {title: "Dune", price: price(1250, "USD"), available: 3},
+	}}
+	repo := NewMemoryRepo()
+	api := API{repo: repo, books: catalog, inTx: fakeTx}
+
+	id := "0b9d6a52-5a4c-4f47-9d1c-2f6c2a1b6e0d"
+	repo.AddCart(context.Background(), id, time.Now().Add(time.Hour))
+	lines := "/api/carts/" + id + "/lines/"
+
+	tcases := []struct {
+		method       string
+		url          string
+		pathValues   map[string]string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		data         string
+		headerStatus int
+	}{
+		{
+			method:       "PUT",
+			url:          lines + "1",
+			pathValues:   map[string]string{"id": id, "bookId": "1"},
+			body:         `{"quantity":2}`,
+			handler:      api.SetLine,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "PUT",
+			url:          lines + "2",
+			pathValues:   map[string]string{"id": id, "bookId": "2"},
+			body:         `{"quantity":2}`,
+			handler:      api.SetLine,
+			data:         database.APIError{Status: http.StatusConflict, Message: "not enough copies of book with id 2, 1 available"}.Error(),
+			headerStatus: http.StatusConflict,
+		},
+		{
+			method:       "PUT",
+			url:          lines + "2",
+			pathValues:   map[string]string{"id": id, "bookId": "2"},
+			body:         `{"quantity":1}`,
+			handler:      api.SetLine,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "PUT",
+			url:          lines + "4",
+			pathValues:   map[string]string{"id": id, "bookId": "4"},
+			body:         `{"quantity":2}`,
+			handler:      api.SetLine,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "PUT",
+			url:          lines + "3",
+			pathValues:   map[string]string{"id": id, "bookId": "3"},
+			body:         `{"quantity":1}`,
+			handler:      api.SetLine,
+			data:         database.APIError{Status: http.StatusConflict, Message: "book with id 3 has no price and can't be sold"}.Error(),
+			headerStatus: http.StatusConflict,
+		},
+		{
+			method:       "PUT",
+			url:          lines + "42",
+			pathValues:   map[string]string{"id": id, "bookId": "42"},
+			body:         `{"quantity":1}`,
+			handler:      api.SetLine,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "book with id 42 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+		{
+			method:       "PUT",
+			url:          lines + "1",
+			pathValues:   map[string]string{"id": id, "bookId": "1"},
+			body:         `{"quantity":0}`,
+			handler:      api.SetLine,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "quantity must be between 1 and 100"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "PUT",
+			url:          lines + "1",
+			pathValues:   map[string]string{"id": id, "bookId": "1"},
+			body:         `{"quantity":1,"price":10}`,
+			handler:      api.SetLine,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "invalid request model"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "PUT",
+			url:          "/api/carts/42/lines/1",
+			pathValues:   map[string]string{"id": "42", "bookId": "1"},
+			body:         `{"quantity":1}`,
+			handler:      api.SetLine,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "only accept uuid values as {id} path parameter"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "PUT",
+			url:          "/api/carts/5f0c3d7e-3b7a-4a8e-9f53-8f4a3c2b1d00/lines/1",
+			pathValues:   map[string]string{"id": "5f0c3d7e-3b7a-4a8e-9f53-8f4a3c2b1d00", "bookId": "1"},
+			body:         `{"quantity":1}`,
+			handler:      api.SetLine,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "cart with id 5f0c3d7e-3b7a-4a8e-9f53-8f4a3c2b1d00 not found or expired"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+		{
+			method:       "DELETE",
+			url:          lines + "3",
+			pathValues:   map[string]string{"id": id, "bookId": "3"},
+			handler:      api.RemoveLine,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "book with id 3 is not in the cart"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range tcases {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		for name, value := range tc.pathValues {
+			rq.SetPathValue(name, value)
+		}
+		tc.handler(w, rq)
+
+		if got := w.Body.String(); tc.data != got {
+			t.Errorf("%s %s failed\nexpected %v\ngot %v", tc.method, tc.url, tc.data, got)
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("%s %s response header failed\nexpected %v\ngot  %v", tc.method, tc.url, tc.headerStatus, w.Code)
+		}
+	}
+
+	if catalog.outside != 0 {
+		t.Errorf("PUT %s must check stock in the unit of work of the write\ngot %d lookups outside of it", lines, catalog.outside)
+	}
+
+	getCart := func() (cartDTO, int) {
+		var c cartDTO
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest("GET", "/api/carts/"+id, nil)
+		rq.SetPathValue("id", id)
+		api.GetCart(w, rq)
+		json.Unmarshal(w.Body.Bytes(), &c)
+		return c, w.Code
+	}
+
+	// totals follow current prices and sum lines by currency
+	catalog.books[2] = fakeBook{title: "Good Omens", price: price(1099, "EUR"), available: 0}
+	lookups := catalog.lookups
+	c, code := getCart()
+	if catalog.lookups-lookups != 1 {
+		t.Errorf("GET /api/carts/%s must look up books of every line at once\ngot %d lookups", id, catalog.lookups-lookups)
+	}
+	expectedTotals := []priceDTO{{Amount: "50.99", Currency: "EUR"}, {Amount: "25.00", Currency: "USD"}}
+	if code != http.StatusOK || c.ID != id || len(c.Lines) != 3 || !slices.Equal(c.Totals, expectedTotals) {
+		t.Fatalf("GET /api/carts/%s failed\ngot %d %+v", id, code, c)
+	}
+	if l := c.Lines[1]; l.BookID != 2 || l.Title != "Good Omens" || *l.UnitPrice != (priceDTO{Amount: "10.99", Currency: "EUR"}) ||
+		l.Available != 0 {
+		t.Errorf("GET /api/carts/%s must show current price and stock of a line\ngot %+v", id, l)
+	}
+
+	// a removed book stays in the cart without price
+	delete(catalog.books, 4)
+	c, _ = getCart()
+	if l := c.Lines[2]; l.BookID != 4 || l.Title != "" || l.UnitPrice != nil || len(c.Totals) != 1 {
+		t.Errorf("GET /api/carts/%s must leave removed book out of totals\ngot %+v", id, c)
+	}
+
+	w := httptest.NewRecorder()
+	rq := httptest.NewRequest("DELETE", "/api/carts/"+id, nil)
+	rq.SetPathValue("id", id)
+	api.RemoveCart(w, rq)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("DELETE /api/carts/%s failed\ngot %d %s", id, w.Code, w.Body.String())
+	}
+	if _, code := getCart(); code != http.StatusNotFound {
+		t.Errorf("GET /api/carts/%s must not find removed cart\ngot %d", id, code)
+	}
+
+	w = httptest.NewRecorder()
+	api.AddCart(w, httptest.NewRequest("POST", "/api/carts", nil))
+	var created cartDTO
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated ||
+		len(created.Lines) != 0 || len(created.Totals) != 0 || !created.ExpiresAt.After(time.Now()) {
+		t.Errorf("POST /api/carts failed\ngot %d %s", w.Code, w.Body.String())
+	}
+}
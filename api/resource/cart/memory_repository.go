@@ -0,0 +1,180 @@
+package cart
+
+import (
+	"booksapi/api/database"
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryRepo keeps carts in a map, it is meant for demos and local runs without postgres.
+// It has no books to check, SetLine trusts the caller which looked the book up in Catalog already
+type MemoryRepo struct {
+	mu    sync.Mutex
+	carts map[string]memoryCart
+}
+
+// memoryCart holds quantities by book id
+type memoryCart struct {
+	lines     map[int]int
+	createdAt time.Time
+	expiresAt time.Time
+}
+
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		carts: map[string]memoryCart{},
+	}
+}
+
+// save copies carts for a unit of work, the copy is put back when the unit of work fails
+func (repo *MemoryRepo) save() func() {
+	repo.mu.Lock()
+	carts := make(map[string]memoryCart, len(repo.carts))
+	for id, c := range repo.carts {
+		c.lines = maps.Clone(c.lines)
+		carts[id] = c
+	}
+	repo.mu.Unlock()
+
+	return func() {
+		repo.mu.Lock()
+		defer repo.mu.Unlock()
+		repo.carts = carts
+	}
+}
+
+// touch returns the cart which has not expired yet and keeps it for expiresAt, caller holds the lock
+func (repo *MemoryRepo) touch(id string, expiresAt time.Time) (memoryCart, error) {
+	c, ok := repo.carts[id]
+	if !ok || !c.expiresAt.After(time.Now()) {
+		return c, cartNotFound(id)
+	}
+	c.expiresAt = expiresAt.UTC()
+	repo.carts[id] = c
+	return c, nil
+}
+
+func (repo *MemoryRepo) AddCart(ctx context.Context, id string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.carts[id] = memoryCart{lines: map[int]int{}, createdAt: time.Now().UTC(), expiresAt: expiresAt.UTC()}
+
+	return nil
+}
+
+func (repo *MemoryRepo) GetCart(ctx context.Context, id string, expiresAt time.Time) (cartEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return cartEntity{}, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	c, err := repo.touch(id, expiresAt)
+	if err != nil {
+		return cartEntity{}, err
+	}
+
+	cart := cartEntity{ID: id, Lines: make([]lineEntity, 0, len(c.lines)), CreatedAt: c.createdAt, ExpiresAt: c.expiresAt}
+	for bookID, quantity := range c.lines {
+		cart.Lines = append(cart.Lines, lineEntity{BookID: bookID, Quantity: quantity})
+	}
+	slices.SortFunc(cart.Lines, func(a, b lineEntity) int {
+		return cmp.Compare(a.BookID, b.BookID)
+	})
+
+	return cart, nil
+}
+
+func (repo *MemoryRepo) SetLine(ctx context.Context, id string, bookID, quantity int, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	c, err := repo.touch(id, expiresAt)
+	if err != nil {
+		return err
+	}
+	c.lines[bookID] = quantity
+
+	return nil
+}
+
+func (repo *MemoryRepo) RemoveLine(ctx context.Context, id string, bookID int, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	c, err := repo.touch(id, expiresAt)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.lines[bookID]; !ok {
+		return lineNotFound(bookID)
+	}
+	delete(c.lines, bookID)
+
+	return nil
+}
+
+func (repo *MemoryRepo) RemoveCart(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if c, ok := repo.carts[id]; !ok || !c.expiresAt.After(time.Now()) {
+		return cartNotFound(id)
+	}
+	delete(repo.carts, id)
+
+	return nil
+}
+
+func (repo *MemoryRepo) PurgeCarts(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	purged := 0
+	for id, c := range repo.carts {
+		if c.expiresAt.Before(before) {
+			delete(repo.carts, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,66 @@
+package cart
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/money"
+	"fmt"
+	"time"
+)
+
+// lineRequestBody sets quantity of a book in a cart
+type lineRequestBody struct {
+	Quantity *int `json:"quantity" example:"2"`
+}
+
+// validate checks quantity is positive, a line is removed instead of being set to 0
+func (b *lineRequestBody) validate() error {
+	if b.Quantity == nil || *b.Quantity < 1 || *b.Quantity > maxQuantity {
+		return database.BadRequestErr{Message: fmt.Sprintf("quantity must be between 1 and %d", maxQuantity)}
+	}
+	return nil
+}
+
+// cartEntity is a cart as it is kept, lines hold book ids and quantities only
+type cartEntity struct {
+	ID        string
+	Lines     []lineEntity
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// lineEntity is quantity of a book in a cart
+type lineEntity struct {
+	BookID   int
+	Quantity int
+}
+
+type cartDTO struct {
+	ID    string    `json:"id" example:"0b9d6a52-5a4c-4f47-9d1c-2f6c2a1b6e0d"`
+	Lines []lineDTO `json:"lines"`
+	// Totals sum line totals by currency, lines of books without price are left out
+	Totals    []priceDTO `json:"totals"`
+	CreatedAt time.Time  `json:"createdAt"`
+	// ExpiresAt moves on with every request to the cart
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// lineDTO is a line with title, price and stock its book has right now. Title is empty
+// and available 0 once the book is removed
+type lineDTO struct {
+	BookID    int       `json:"bookId"`
+	Title     string    `json:"title"`
+	Quantity  int       `json:"quantity"`
+	UnitPrice *priceDTO `json:"unitPrice,omitempty"`
+	LineTotal *priceDTO `json:"lineTotal,omitempty"`
+	Available int       `json:"available"`
+}
+
+// priceDTO writes an amount like books write prices, {"amount":"19.99","currency":"EUR"}
+type priceDTO struct {
+	Amount   string `json:"amount" example:"19.99"`
+	Currency string `json:"currency" example:"EUR"`
+}
+
+func newPriceDTO(m money.Money) *priceDTO {
+	return &priceDTO{Amount: m.Decimal(), Currency: m.Currency}
+}
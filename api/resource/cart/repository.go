@@ -0,0 +1,188 @@
+package cart
+
+import (
+	"booksapi/api/database"
+	"booksapi/logger"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ICartRepo keeps carts and their lines. Reads and changes of a cart see it only until it expires
+// and keep it for another expiresAt, which every call takes as the new expiry
+type ICartRepo interface {
+	AddCart(ctx context.Context, id string, expiresAt time.Time) error
+	// GetCart returns the cart with lines in book id order
+	GetCart(ctx context.Context, id string, expiresAt time.Time) (cartEntity, error)
+	// SetLine puts quantity of the live book in the cart, replacing quantity the book had there.
+	// Price and stock of the book are left to the caller, which checks them in the transaction of ctx
+	SetLine(ctx context.Context, id string, bookID, quantity int, expiresAt time.Time) error
+	// RemoveLine fails with NotFoundErr when the book is not in the cart
+	RemoveLine(ctx context.Context, id string, bookID int, expiresAt time.Time) error
+	RemoveCart(ctx context.Context, id string) error
+	// PurgeCarts removes carts which expired before given time and returns how many were removed
+	PurgeCarts(ctx context.Context, before time.Time) (int, error)
+}
+
+func cartNotFound(id string) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("cart with id %s not found or expired", id)}
+}
+
+func bookNotFound(id int) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("book with id %d not found", id)}
+}
+
+func lineNotFound(bookID int) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("book with id %d is not in the cart", bookID)}
+}
+
+// CartRepo keeps carts in postgres
+type CartRepo struct {
+	pool *pgxpool.Pool
+}
+
+// touch keeps a cart which has not expired yet for expiresAt
+func (repo *CartRepo) touch(ctx context.Context, tx pgx.Tx, id string, expiresAt time.Time) error {
+	tag, err := tx.Exec(ctx, `UPDATE public.carts SET expires_at = @expires_at WHERE id = @id AND expires_at > now()`,
+		pgx.NamedArgs{"id": id, "expires_at": expiresAt})
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return cartNotFound(id)
+	}
+	return nil
+}
+
+func (repo *CartRepo) AddCart(ctx context.Context, id string, expiresAt time.Time) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := database.PgxConn(ctx, repo.pool).Exec(ctx, `INSERT INTO public.carts (id, expires_at) VALUES (@id, @expires_at)`,
+		pgx.NamedArgs{"id": id, "expires_at": expiresAt})
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+
+	return nil
+}
+
+func (repo *CartRepo) GetCart(ctx context.Context, id string, expiresAt time.Time) (cartEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var c cartEntity
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		if err := repo.touch(ctx, tx, id, expiresAt); err != nil {
+			return err
+		}
+
+		err := tx.QueryRow(ctx, `SELECT id, created_at, expires_at FROM public.carts WHERE id = @id`,
+			pgx.NamedArgs{"id": id}).Scan(&c.ID, &c.CreatedAt, &c.ExpiresAt)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(ctx, `SELECT book_id, quantity FROM public.cart_lines WHERE cart_id = @id ORDER BY book_id`,
+			pgx.NamedArgs{"id": id})
+		if err != nil {
+			return err
+		}
+		c.Lines, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (lineEntity, error) {
+			var l lineEntity
+			err := row.Scan(&l.BookID, &l.Quantity)
+			return l, err
+		})
+		return err
+	})
+
+	return c, database.TxErr(ctx, err)
+}
+
+func (repo *CartRepo) SetLine(ctx context.Context, id string, bookID, quantity int, expiresAt time.Time) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		if err := repo.touch(ctx, tx, id, expiresAt); err != nil {
+			return err
+		}
+
+		var found bool
+		err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM public.books WHERE id = @id AND deleted_at IS NULL)`,
+			pgx.NamedArgs{"id": bookID}).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return bookNotFound(bookID)
+		}
+
+		_, err = tx.Exec(ctx, `INSERT INTO public.cart_lines (cart_id, book_id, quantity)
+                                VALUES (@id, @book_id, @quantity)
+                                ON CONFLICT (cart_id, book_id) DO UPDATE SET quantity = EXCLUDED.quantity`,
+			pgx.NamedArgs{"id": id, "book_id": bookID, "quantity": quantity})
+		return err
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *CartRepo) RemoveLine(ctx context.Context, id string, bookID int, expiresAt time.Time) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		if err := repo.touch(ctx, tx, id, expiresAt); err != nil {
+			return err
+		}
+
+		tag, err := tx.Exec(ctx, `DELETE FROM public.cart_lines WHERE cart_id = @id AND book_id = @book_id`,
+			pgx.NamedArgs{"id": id, "book_id": bookID})
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return lineNotFound(bookID)
+		}
+		return nil
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *CartRepo) RemoveCart(ctx context.Context, id string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	// lines go with the cart
+	tag, err := database.PgxConn(ctx, repo.pool).Exec(ctx, `DELETE FROM public.carts WHERE id = @id AND expires_at > now()`,
+		pgx.NamedArgs{"id": id})
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return cartNotFound(id)
+	}
+
+	return nil
+}
+
+func (repo *CartRepo) PurgeCarts(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	tag, err := database.PgxConn(ctx, repo.pool).Exec(ctx, `DELETE FROM public.carts WHERE expires_at < @before`,
+		pgx.NamedArgs{"before": before})
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, database.RepoErr(ctx, err)
+	}
+
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,127 @@
+package cart
+
+import (
+	"booksapi/api/database"
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// repository tests run the same scenarios against every ICartRepo implementation
+// which does not need an external server
+
+var ctx = context.Background()
+
+func newSQLiteStorage(t *testing.T) *database.SQLiteStorage {
+	s, err := database.OpenSQLite(filepath.Join(t.TempDir(), "books.db"))
+	if err != nil {
+		t.Fatalf("could not open sqlite database %s", err.Error())
+	}
+	t.Cleanup(s.Close)
+
+	if err := database.MigrateUp(ctx, s); err != nil {
+		t.Fatalf("could not migrate sqlite database %s", err.Error())
+	}
+
+	return s
+}
+
+// testRepos have live books 1 and 2 and trashed book 3 in sqlite, memory repo has no books
+func testRepos(t *testing.T) map[string]ICartRepo {
+	s := newSQLiteStorage(t)
+	for _, title := range []string{"The Hobbit", "Good Omens", "Dune"} {
+		if _, err := s.DB.Exec(`INSERT INTO books (title, author) VALUES (?, '')`, title); err != nil {
+			t.Fatalf("could not insert book %s", err.Error())
+		}
+	}
+	s.DB.Exec(`UPDATE books SET deleted_at = '2024-01-01 00:00:00.000' WHERE id = 3`)
+
+	return map[string]ICartRepo{
+		"memory": NewMemoryRepo(),
+		"sqlite": &SQLiteRepo{db: s.DB},
+	}
+}
+
+func lineBooks(lines []lineEntity) []int {
+	ids := make([]int, 0, len(lines))
+	for _, l := range lines {
+		ids = append(ids, l.BookID)
+	}
+	return ids
+}
+
+func TestRepoCart(t *testing.T) {
+	var nf database.NotFoundErr
+
+	for name, repo := range testRepos(t) {
+		id := uuid.NewString()
+		later := time.Now().Add(time.Hour)
+		if err := repo.AddCart(ctx, id, later); err != nil {
+			t.Fatalf("%s AddCart failed\nunexpected error %s", name, err.Error())
+		}
+
+		if err := repo.SetLine(ctx, id, 2, 1, later); err != nil {
+			t.Fatalf("%s SetLine failed\nunexpected error %s", name, err.Error())
+		}
+		repo.SetLine(ctx, id, 1, 3, later)
+		repo.SetLine(ctx, id, 2, 2, later)
+		c, err := repo.GetCart(ctx, id, later)
+		if err != nil {
+			t.Fatalf("%s GetCart failed\nunexpected error %s", name, err.Error())
+		}
+		if !slices.Equal(lineBooks(c.Lines), []int{1, 2}) || c.Lines[0].Quantity != 3 || c.Lines[1].Quantity != 2 {
+			t.Errorf("%s GetCart must list lines in book id order with their last quantity\ngot %+v", name, c.Lines)
+		}
+		// sqlite keeps milliseconds only
+		if c.ID != id || c.CreatedAt.IsZero() || c.ExpiresAt.Sub(later).Abs() >= time.Millisecond {
+			t.Errorf("%s GetCart failed\ngot %+v", name, c)
+		}
+
+		if name == "sqlite" {
+			if err := repo.SetLine(ctx, id, 3, 1, later); !errors.As(err, &nf) {
+				t.Errorf("%s SetLine expected NotFoundErr for trashed book\ngot %v", name, err)
+			}
+		}
+
+		if err := repo.RemoveLine(ctx, id, 1, later); err != nil {
+			t.Errorf("%s RemoveLine failed\nunexpected error %s", name, err.Error())
+		}
+		if err := repo.RemoveLine(ctx, id, 1, later); !errors.As(err, &nf) {
+			t.Errorf("%s RemoveLine expected NotFoundErr for book not in the cart\ngot %v", name, err)
+		}
+		if c, _ := repo.GetCart(ctx, id, later); !slices.Equal(lineBooks(c.Lines), []int{2}) {
+			t.Errorf("%s RemoveLine must remove the line\ngot %+v", name, c.Lines)
+		}
+
+		// a request keeps the cart for another expiresAt only while it has not expired
+		if _, err := repo.GetCart(ctx, id, time.Now().Add(-time.Second)); err != nil {
+			t.Fatalf("%s GetCart failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := repo.GetCart(ctx, id, later); !errors.As(err, &nf) {
+			t.Errorf("%s GetCart expected NotFoundErr for expired cart\ngot %v", name, err)
+		}
+		if err := repo.SetLine(ctx, id, 1, 1, later); !errors.As(err, &nf) {
+			t.Errorf("%s SetLine expected NotFoundErr for expired cart\ngot %v", name, err)
+		}
+		if err := repo.RemoveCart(ctx, id); !errors.As(err, &nf) {
+			t.Errorf("%s RemoveCart expected NotFoundErr for expired cart\ngot %v", name, err)
+		}
+
+		other := uuid.NewString()
+		repo.AddCart(ctx, other, later)
+		if purged, err := repo.PurgeCarts(ctx, time.Now()); err != nil || purged != 1 {
+			t.Errorf("%s PurgeCarts must remove expired carts only\ngot %d, %v", name, purged, err)
+		}
+		if err := repo.RemoveCart(ctx, other); err != nil {
+			t.Errorf("%s RemoveCart failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := repo.GetCart(ctx, other, later); !errors.As(err, &nf) {
+			t.Errorf("%s GetCart expected NotFoundErr for removed cart\ngot %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package cart
+
+import (
+	"booksapi/api/database"
+	"booksapi/logger"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SQLiteRepo keeps carts in sqlite database
+type SQLiteRepo struct {
+	db *sql.DB
+}
+
+// sqlite has no timestamp type, expires_at is stored as UTC text in sqliteTimeFormat
+// so that it compares in chronological order and is scanned back into time.Time
+const (
+	sqliteTimeFormat = "2006-01-02 15:04:05.000"
+	sqliteNow        = `strftime('%Y-%m-%d %H:%M:%f', 'now')`
+)
+
+// touch keeps a cart which has not expired yet for expiresAt
+func (repo *SQLiteRepo) touch(ctx context.Context, tx *sql.Tx, id string, expiresAt time.Time) error {
+	res, err := tx.ExecContext(ctx, `UPDATE carts SET expires_at = @expires_at WHERE id = @id AND expires_at > `+sqliteNow,
+		sql.Named("id", id), sql.Named("expires_at", expiresAt.UTC().Format(sqliteTimeFormat)))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return cartNotFound(id)
+	}
+	return nil
+}
+
+func (repo *SQLiteRepo) AddCart(ctx context.Context, id string, expiresAt time.Time) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := database.SQLConn(ctx, repo.db).ExecContext(ctx, `INSERT INTO carts (id, expires_at) VALUES (@id, @expires_at)`,
+		sql.Named("id", id), sql.Named("expires_at", expiresAt.UTC().Format(sqliteTimeFormat)))
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+
+	return nil
+}
+
+func (repo *SQLiteRepo) GetCart(ctx context.Context, id string, expiresAt time.Time) (cartEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var c cartEntity
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		if err := repo.touch(ctx, tx, id, expiresAt); err != nil {
+			return err
+		}
+
+		err := tx.QueryRowContext(ctx, `SELECT id, created_at, expires_at FROM carts WHERE id = @id`,
+			sql.Named("id", id)).Scan(&c.ID, &c.CreatedAt, &c.ExpiresAt)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `SELECT book_id, quantity FROM cart_lines WHERE cart_id = @id ORDER BY book_id`,
+			sql.Named("id", id))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		c.Lines = make([]lineEntity, 0)
+		for rows.Next() {
+			var l lineEntity
+			if err := rows.Scan(&l.BookID, &l.Quantity); err != nil {
+				return err
+			}
+			c.Lines = append(c.Lines, l)
+		}
+		return rows.Err()
+	})
+
+	return c, database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) SetLine(ctx context.Context, id string, bookID, quantity int, expiresAt time.Time) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	// transaction holds the database lock, so the book can't be purged between the check and insert
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		if err := repo.touch(ctx, tx, id, expiresAt); err != nil {
+			return err
+		}
+
+		var found bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM books WHERE id = @id AND deleted_at IS NULL)`,
+			sql.Named("id", bookID)).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return bookNotFound(bookID)
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO cart_lines (cart_id, book_id, quantity)
+                                      VALUES (@id, @book_id, @quantity)
+                                      ON CONFLICT (cart_id, book_id) DO UPDATE SET quantity = excluded.quantity`,
+			sql.Named("id", id), sql.Named("book_id", bookID), sql.Named("quantity", quantity))
+		return err
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) RemoveLine(ctx context.Context, id string, bookID int, expiresAt time.Time) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		if err := repo.touch(ctx, tx, id, expiresAt); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM cart_lines WHERE cart_id = @id AND book_id = @book_id`,
+			sql.Named("id", id), sql.Named("book_id", bookID))
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return lineNotFound(bookID)
+		}
+		return nil
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) RemoveCart(ctx context.Context, id string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	// lines go with the cart
+	res, err := database.SQLConn(ctx, repo.db).ExecContext(ctx, `DELETE FROM carts WHERE id = @id AND expires_at > `+sqliteNow,
+		sql.Named("id", id))
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return cartNotFound(id)
+	}
+
+	return nil
+}
+
+func (repo *SQLiteRepo) PurgeCarts(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := database.SQLConn(ctx, repo.db).ExecContext(ctx, `DELETE FROM carts WHERE expires_at < @before`,
+		sql.Named("before", before.UTC().Format(sqliteTimeFormat)))
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, database.RepoErr(ctx, err)
+	}
+
+	purged, _ := res.RowsAffected()
+	return int(purged), nil
+}
@@ -0,0 +1,320 @@
+package genres
+
+import (
+	"booksapi/api/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+func invalidParamErr(name string) database.APIError {
+	return database.APIError{
+		Status:  http.StatusBadRequest,
+		Message: fmt.Sprintf("invalid value for %s query parameter", name),
+	}
+}
+
+// pathID parses integer path parameter of given name
+func pathID(r *http.Request, name string) (int, *database.APIError) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		return 0, &database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("only accept integer values as {%s} path parameter", name),
+		}
+	}
+	return id, nil
+}
+
+// parsePaging reads limit and offset query parameters, any other parameter is refused
+func parsePaging(values url.Values) (pageQuery, *database.APIError) {
+	q := pageQuery{
+		Limit: defaultPageLimit,
+	}
+
+	for name := range values {
+		if name != "limit" && name != "offset" {
+			return q, &database.APIError{
+				Status:  http.StatusBadRequest,
+				Message: fmt.Sprintf("unknown query parameter %s", name),
+			}
+		}
+	}
+
+	if values.Has("limit") {
+		limit, err := strconv.Atoi(values.Get("limit"))
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			e := invalidParamErr("limit")
+			return q, &e
+		}
+		q.Limit = limit
+	}
+
+	if values.Has("offset") {
+		offset, err := strconv.Atoi(values.Get("offset"))
+		if err != nil || offset < 0 {
+			e := invalidParamErr("offset")
+			return q, &e
+		}
+		q.Offset = offset
+	}
+
+	return q, nil
+}
+
+func decodeGenre(r *http.Request, isNew bool) (genreRequestBody, *database.APIError) {
+	var req genreRequestBody
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, &database.APIError{
+			Message: "invalid request model",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if err := req.validate(isNew); err != nil {
+		return req, &database.APIError{
+			Message: err.Error(),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	return req, nil
+}
+
+type API struct {
+	repo IGenresRepo
+}
+
+// New picks repository of the opened storage
+func New() API {
+	var repo IGenresRepo
+	switch s := database.Get().(type) {
+	case *database.PostgresStorage:
+		repo = &GenresRepo{pool: s.Pool}
+	case *database.SQLiteStorage:
+		repo = &SQLiteRepo{db: s.DB}
+	default:
+		repo = NewMemoryRepo()
+	}
+
+	return API{
+		repo: repo,
+	}
+}
+
+// UseBooks gives memory repo books kept by books package so that renamed genres reach them.
+// Books package looks genres up in turn, so books can't be passed to New
+func (api API) UseBooks(books BookRenamer) {
+	if memory, ok := api.repo.(*MemoryRepo); ok {
+		memory.books = books
+	}
+}
+
+// GenreNames maps id of every genre to its name for packages which link to genres but can't join
+// genres table, e.g. memory repository of books. Genres which can't be read are left out
+func (api API) GenreNames(ctx context.Context) map[int]string {
+	names, err := api.repo.GenreNames(ctx)
+	if err != nil {
+		return map[int]string{}
+	}
+	return names
+}
+
+// Subgenres returns ids of the genre of given name and of every genre below it,
+// see GenreNames for whom it is meant
+func (api API) Subgenres(ctx context.Context, name string) []int {
+	ids, err := api.repo.Subgenres(ctx, name)
+	if err != nil {
+		return []int{}
+	}
+	return ids
+}
+
+// GetGenres returns a page of genres
+//
+//	@Summary		Get genres
+//	@Description	lists genres of every level ordered by name, parentId tells where a genre belongs in the tree
+//	@Tags			genres
+//	@Produce		json
+//	@Param			limit	query		int	false	"page size, 20 by default and 100 at most"
+//	@Param			offset	query		int	false	"number of genres to skip"
+//	@Success		200		{object}	genresPageDTO
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Router			/api/genres [get]
+func (api API) GetGenres(w http.ResponseWriter, r *http.Request) {
+	q, apiErr := parsePaging(r.URL.Query())
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	page, err := api.repo.GetGenres(r.Context(), q)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto := genresPageDTO{
+		Genres: make([]genreDTO, 0, len(page.Genres)),
+		Total:  page.Total,
+		Limit:  q.Limit,
+		Offset: q.Offset,
+	}
+	for _, g := range page.Genres {
+		dto.Genres = append(dto.Genres, g.ToDto())
+	}
+
+	json, _ := json.Marshal(dto)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// GetGenre returns a genre by id
+//
+//	@Summary		Get genre by id
+//	@Description	get genre
+//	@Tags			genres
+//	@Produce		json
+//	@Param			id	path		int	true	"Genre ID"
+//	@Success		200	{object}	genreDTO
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/genres/{id} [get]
+func (api API) GetGenre(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	genre, err := api.repo.GetGenreById(r.Context(), id)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	json, _ := json.Marshal(genre.ToDto())
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// AddGenre adds new genre
+//
+//	@Summary		Add new genre
+//	@Description	adds genre below parentId or at top level, names are unique regardless of case
+//	@Tags			genres
+//	@Accept			json
+//	@Produce		json
+//	@Param			genre	body		genreRequestBody	true	"request body"
+//	@Success		201		{object}	ActionResponse
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Failure		409		{object}	database.APIError
+//	@Router			/api/genres [post]
+func (api API) AddGenre(w http.ResponseWriter, r *http.Request) {
+	req, apiErr := decodeGenre(r, true)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	id, err := api.repo.AddGenre(r.Context(), req.applyTo(genreEntity{}))
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	j, _ := json.Marshal(ActionResponse{ResourceId: id})
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, string(j[:]))
+}
+
+// UpdateGenre renames or moves a genre
+//
+//	@Summary		Update genre
+//	@Description	renames genre or moves it below another one, parentId null moves it to top level.
+//	@Description	Books of the genre take the new name
+//	@Tags			genres
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path	int					true	"Genre ID"
+//	@Param			genre	body	genreRequestBody	true	"request body"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Failure		409	{object}	database.APIError
+//	@Router			/api/genres/{id} [patch]
+func (api API) UpdateGenre(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	req, apiErr := decodeGenre(r, false)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	if err := api.repo.UpdateGenre(r.Context(), id, req); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveGenre deletes a genre without subgenres and books
+//
+//	@Summary		Remove genre
+//	@Description	removes genre, its subgenres and books have to be moved first
+//	@Tags			genres
+//	@Produce		json
+//	@Param			id	path	int	true	"Genre ID"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Failure		409	{object}	database.APIError
+//	@Router			/api/genres/{id} [delete]
+func (api API) RemoveGenre(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	if err := api.repo.RemoveGenre(r.Context(), id); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,152 @@
+package genres
+
+import (
+	"booksapi/api/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGenresAPI(t *testing.T) {
+	api := API{repo: NewMemoryRepo()}
+
+	tcases := []struct {
+		method       string
+		url          string
+		pathValues   map[string]string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		data         string
+		headerStatus int
+	}{
+		{
+			method:       "POST",
+			url:          "/api/genres",
+			body:         `{"name":"  Science   Fiction "}`,
+			handler:      api.AddGenre,
+			data:         `{"resourceId":1}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "POST",
+			url:          "/api/genres",
+			body:         `{"name":"Space Opera","parentId":1}`,
+			handler:      api.AddGenre,
+			data:         `{"resourceId":2}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "POST",
+			url:          "/api/genres",
+			body:         `{"name":"science fiction"}`,
+			handler:      api.AddGenre,
+			data:         database.APIError{Status: http.StatusConflict, Message: `genre named "science fiction" already exists`}.Error(),
+			headerStatus: http.StatusConflict,
+		},
+		{
+			method:       "POST",
+			url:          "/api/genres",
+			body:         `{"name":"Cyberpunk","parentId":42}`,
+			handler:      api.AddGenre,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "parent genre with id 42 not found"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/genres",
+			body:         `{"parentId":1}`,
+			handler:      api.AddGenre,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "name is required, won't save the data"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "GET",
+			url:          "/api/genres/2",
+			pathValues:   map[string]string{"id": "2"},
+			handler:      api.GetGenre,
+			data:         `{"id":2,"name":"Space Opera","parentId":1}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/genres/1",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"parentId":2}`,
+			handler:      api.UpdateGenre,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "genre with id 1 can't be moved below itself"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/genres/1",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{}`,
+			handler:      api.UpdateGenre,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "nothing to change, set name or parentId"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "DELETE",
+			url:          "/api/genres/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.RemoveGenre,
+			data:         database.APIError{Status: http.StatusConflict, Message: "genre with id 1 still has subgenres or books, trashed books included"}.Error(),
+			headerStatus: http.StatusConflict,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/genres/2",
+			pathValues:   map[string]string{"id": "2"},
+			body:         `{"parentId":null}`,
+			handler:      api.UpdateGenre,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/genres?limit=1&offset=1",
+			handler:      api.GetGenres,
+			data:         `{"genres":[{"id":2,"name":"Space Opera","parentId":null}],"total":2,"limit":1,"offset":1}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/genres?name=fantasy",
+			handler:      api.GetGenres,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "unknown query parameter name"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "DELETE",
+			url:          "/api/genres/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.RemoveGenre,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/genres/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.GetGenre,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "genre with id 1 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+	}
+
+	// cases run in order, each one sees changes of those before it
+	for _, tc := range tcases {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		for name, value := range tc.pathValues {
+			rq.SetPathValue(name, value)
+		}
+		tc.handler(w, rq)
+
+		if tc.data != w.Body.String() {
+			t.Errorf("%s %s failed\nexpected %v\ngot %v", tc.method, tc.url, tc.data, w.Body.String())
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("%s %s response header failed\nexpected %v\ngot  %v", tc.method, tc.url, tc.headerStatus, w.Code)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package genres
+
+import (
+	"booksapi/api/database"
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BookRenamer renames genre of books kept by books package, memory repo has no books table to update
+type BookRenamer interface {
+	// RenameGenre sets name of the genre on its books, trashed books included
+	RenameGenre(ctx context.Context, genreID int, name string)
+}
+
+// MemoryRepo keeps genres in a map, it is meant for demos and local runs without postgres.
+// Memory repo of books looks genres up instead of linking to them, so unlike databases
+// genres are removed regardless of books
+type MemoryRepo struct {
+	mu     sync.RWMutex
+	genres map[int]genreEntity
+	lastID int
+	// books are renamed with their genre, renames don't reach books while it is nil
+	books BookRenamer
+}
+
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		genres: map[int]genreEntity{},
+	}
+}
+
+// save copies genres for a unit of work, the copy is put back when the unit of work fails
+func (repo *MemoryRepo) save() func() {
+	repo.mu.RLock()
+	genres, lastID := maps.Clone(repo.genres), repo.lastID
+	repo.mu.RUnlock()
+
+	return func() {
+		repo.mu.Lock()
+		defer repo.mu.Unlock()
+		repo.genres, repo.lastID = genres, lastID
+	}
+}
+
+// findByName returns id of the genre with the same name regardless of case, caller holds the lock
+func (repo *MemoryRepo) findByName(name string) (int, bool) {
+	for id, g := range repo.genres {
+		if strings.EqualFold(g.Name, name) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// isAncestor tells whether genre id is parentID or one of its ancestors, caller holds the lock
+func (repo *MemoryRepo) isAncestor(id, parentID int) bool {
+	for current := &parentID; current != nil; current = repo.genres[*current].ParentID {
+		if *current == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *MemoryRepo) GetGenres(ctx context.Context, q pageQuery) (genresPage, error) {
+	if err := ctx.Err(); err != nil {
+		return genresPage{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	page := genresPage{
+		Genres: make([]genreEntity, 0),
+		Total:  len(repo.genres),
+	}
+
+	genres := make([]genreEntity, 0, len(repo.genres))
+	for _, g := range repo.genres {
+		genres = append(genres, g)
+	}
+	sort.Slice(genres, func(i, j int) bool {
+		ni, nj := strings.ToLower(genres[i].Name), strings.ToLower(genres[j].Name)
+		if ni != nj {
+			return ni < nj
+		}
+		return genres[i].ID < genres[j].ID
+	})
+
+	start := min(q.Offset, len(genres))
+	end := min(start+q.Limit, len(genres))
+	page.Genres = append(page.Genres, genres[start:end]...)
+
+	return page, nil
+}
+
+func (repo *MemoryRepo) GetGenreById(ctx context.Context, id int) (genreEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return genreEntity{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	g, ok := repo.genres[id]
+	if !ok {
+		return genreEntity{}, genreNotFound(id)
+	}
+
+	return g, nil
+}
+
+func (repo *MemoryRepo) AddGenre(ctx context.Context, g genreEntity) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, taken := repo.findByName(g.Name); taken {
+		return 0, nameTaken(g.Name)
+	}
+	if g.ParentID != nil {
+		if _, ok := repo.genres[*g.ParentID]; !ok {
+			return 0, parentNotFound(*g.ParentID)
+		}
+	}
+
+	repo.lastID++
+	g.ID = repo.lastID
+	repo.genres[g.ID] = g
+
+	return g.ID, nil
+}
+
+func (repo *MemoryRepo) UpdateGenre(ctx context.Context, id int, b genreRequestBody) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	existing, updated, err := repo.update(id, b)
+	if err != nil {
+		return err
+	}
+
+	// books are renamed without the lock, books repo looks genres up while holding its own
+	if updated.Name != existing.Name && repo.books != nil {
+		repo.books.RenameGenre(ctx, id, updated.Name)
+	}
+
+	return nil
+}
+
+// update applies b to genre id and returns the genre before and after
+func (repo *MemoryRepo) update(id int, b genreRequestBody) (genreEntity, genreEntity, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing, ok := repo.genres[id]
+	if !ok {
+		return existing, existing, genreNotFound(id)
+	}
+	updated := b.applyTo(existing)
+
+	if other, taken := repo.findByName(updated.Name); taken && other != id {
+		return existing, existing, nameTaken(updated.Name)
+	}
+	if updated.ParentID != nil {
+		if _, ok := repo.genres[*updated.ParentID]; !ok {
+			return existing, existing, parentNotFound(*updated.ParentID)
+		}
+		if repo.isAncestor(id, *updated.ParentID) {
+			return existing, existing, genreCycle(id)
+		}
+	}
+
+	repo.genres[id] = updated
+
+	return existing, updated, nil
+}
+
+func (repo *MemoryRepo) RemoveGenre(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.genres[id]; !ok {
+		return genreNotFound(id)
+	}
+	for _, g := range repo.genres {
+		if g.ParentID != nil && *g.ParentID == id {
+			return genreInUse(id)
+		}
+	}
+
+	delete(repo.genres, id)
+
+	return nil
+}
+
+func (repo *MemoryRepo) GenreNames(ctx context.Context) (map[int]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	names := make(map[int]string, len(repo.genres))
+	for id, g := range repo.genres {
+		names[id] = g.Name
+	}
+
+	return names, nil
+}
+
+func (repo *MemoryRepo) Subgenres(ctx context.Context, name string) ([]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	ids := make([]int, 0)
+	root, ok := repo.findByName(name)
+	if !ok {
+		return ids, nil
+	}
+
+	// the tree is walked level by level, like the recursive query of databases does
+	level := map[int]bool{root: true}
+	for len(level) > 0 {
+		next := map[int]bool{}
+		for id := range level {
+			ids = append(ids, id)
+		}
+		for id, g := range repo.genres {
+			if g.ParentID != nil && level[*g.ParentID] {
+				next[id] = true
+			}
+		}
+		level = next
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
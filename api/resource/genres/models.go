@@ -0,0 +1,97 @@
+package genres
+
+import (
+	"booksapi/api/database"
+	"encoding/json"
+	"strings"
+)
+
+// nullableID tells a field set to null apart from a field left out of request body
+type nullableID struct {
+	Set   bool
+	Value *int
+}
+
+// UnmarshalJSON is called for null as well, absent field leaves Set false
+func (n *nullableID) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	return json.Unmarshal(data, &n.Value)
+}
+
+// genreRequestBody adds or changes a genre, parentId null makes the genre a top level one.
+// Fields left out of PATCH request keep their values
+type genreRequestBody struct {
+	Name     *string    `json:"name"`
+	ParentID nullableID `json:"parentId" swaggertype:"integer"`
+}
+
+// validate trims the name and checks fields which are set, name is required for new genres
+func (b *genreRequestBody) validate(isNew bool) error {
+	if isNew && b.Name == nil {
+		return database.BadRequestErr{Message: "name is required, won't save the data"}
+	}
+	if !isNew && b.Name == nil && !b.ParentID.Set {
+		return database.BadRequestErr{Message: "nothing to change, set name or parentId"}
+	}
+	if b.Name != nil {
+		name := strings.Join(strings.Fields(*b.Name), " ")
+		if name == "" {
+			return database.BadRequestErr{Message: "name can't be empty"}
+		}
+		b.Name = &name
+	}
+	return nil
+}
+
+// applyTo returns copy of g with every field set in request body replaced
+func (b genreRequestBody) applyTo(g genreEntity) genreEntity {
+	if b.Name != nil {
+		g.Name = *b.Name
+	}
+	if b.ParentID.Set {
+		g.ParentID = b.ParentID.Value
+	}
+	return g
+}
+
+// genreEntity is a node of genre taxonomy, ParentID is nil for top level genres
+type genreEntity struct {
+	ID       int
+	Name     string
+	ParentID *int
+}
+
+func (g genreEntity) ToDto() genreDTO {
+	return genreDTO{
+		ID:       g.ID,
+		Name:     g.Name,
+		ParentID: g.ParentID,
+	}
+}
+
+type genreDTO struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	ParentID *int   `json:"parentId"`
+}
+
+type pageQuery struct {
+	Limit  int
+	Offset int
+}
+
+type genresPage struct {
+	Genres []genreEntity
+	Total  int
+}
+
+type genresPageDTO struct {
+	Genres []genreDTO `json:"genres"`
+	Total  int        `json:"total"`
+	Limit  int        `json:"limit"`
+	Offset int        `json:"offset"`
+}
+
+type ActionResponse struct {
+	ResourceId int `json:"resourceId"`
+}
@@ -0,0 +1,286 @@
+package genres
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/router/middlewares"
+	"booksapi/logger"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type IGenresRepo interface {
+	// GetGenres lists genres ordered by name
+	GetGenres(context.Context, pageQuery) (genresPage, error)
+	GetGenreById(context.Context, int) (genreEntity, error)
+	// AddGenre and UpdateGenre fail with ConflictErr when another genre has the same name regardless of case
+	// and with BadRequestErr when parent genre does not exist
+	AddGenre(ctx context.Context, g genreEntity) (int, error)
+	// UpdateGenre renames or moves the genre, books of the genre take the new name.
+	// Moving the genre below itself fails with BadRequestErr
+	UpdateGenre(ctx context.Context, id int, b genreRequestBody) error
+	// RemoveGenre fails with ConflictErr while the genre has subgenres or books, trashed books included
+	RemoveGenre(ctx context.Context, id int) error
+	// GenreNames maps id of every genre to its name
+	GenreNames(ctx context.Context) (map[int]string, error)
+	// Subgenres returns ids of the genre of given name, case insensitive, and of every genre below it
+	Subgenres(ctx context.Context, name string) ([]int, error)
+}
+
+func genreNotFound(id int) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("genre with id %d not found", id)}
+}
+
+func parentNotFound(id int) database.BadRequestErr {
+	return database.BadRequestErr{Message: fmt.Sprintf("parent genre with id %d not found", id)}
+}
+
+func nameTaken(name string) database.ConflictErr {
+	return database.ConflictErr{Message: fmt.Sprintf("genre named %q already exists", name)}
+}
+
+func genreCycle(id int) database.BadRequestErr {
+	return database.BadRequestErr{Message: fmt.Sprintf("genre with id %d can't be moved below itself", id)}
+}
+
+func genreInUse(id int) database.ConflictErr {
+	return database.ConflictErr{Message: fmt.Sprintf("genre with id %d still has subgenres or books, trashed books included", id)}
+}
+
+// tree queries are shared with SQLiteRepo, so genres table is not schema qualified
+
+// isAncestorQuery tells whether genre @id is @parent_id or one of its ancestors
+const isAncestorQuery = `WITH RECURSIVE ancestors (id, parent_id) AS (
+                             SELECT id, parent_id FROM genres WHERE id = @parent_id
+                             UNION ALL
+                             SELECT g.id, g.parent_id FROM genres g JOIN ancestors a ON g.id = a.parent_id
+                         )
+                         SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = @id)`
+
+// subgenresQuery selects ids of the genre named @name and of every genre below it
+const subgenresQuery = `WITH RECURSIVE subgenres (id) AS (
+                            SELECT id FROM genres WHERE lower(name) = lower(@name)
+                            UNION ALL
+                            SELECT g.id FROM genres g JOIN subgenres s ON g.parent_id = s.id
+                        )
+                        SELECT id FROM subgenres ORDER BY id`
+
+// GenresRepo keeps genres in postgres
+type GenresRepo struct {
+	pool *pgxpool.Pool
+}
+
+func (repo *GenresRepo) GetGenres(ctx context.Context, q pageQuery) (genresPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := genresPage{
+		Genres: make([]genreEntity, 0),
+	}
+
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT count(*) FROM public.genres`).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	query := `SELECT id, name, parent_id FROM public.genres ORDER BY lower(name), id LIMIT @limit OFFSET @offset`
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, pgx.NamedArgs{"limit": q.Limit, "offset": q.Offset})
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	genres, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (genreEntity, error) {
+		var g genreEntity
+		err := row.Scan(&g.ID, &g.Name, &g.ParentID)
+		return g, err
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	page.Genres = genres
+
+	return page, nil
+}
+
+func (repo *GenresRepo) GetGenreById(ctx context.Context, id int) (genreEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var g genreEntity
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT id, name, parent_id FROM public.genres WHERE id = @id`,
+		pgx.NamedArgs{"id": id}).Scan(&g.ID, &g.Name, &g.ParentID)
+	if err != nil {
+		logger.Error(err.Error())
+		if errors.Is(err, pgx.ErrNoRows) {
+			return g, genreNotFound(id)
+		}
+		return g, database.RepoErr(ctx, err)
+	}
+
+	return g, nil
+}
+
+func (repo *GenresRepo) AddGenre(ctx context.Context, g genreEntity) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO public.genres (name, parent_id) VALUES (@name, @parent_id) RETURNING id`
+	args := pgx.NamedArgs{
+		"name":      g.Name,
+		"parent_id": g.ParentID,
+	}
+
+	var id int
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, query, args).Scan(&id)
+	if err != nil {
+		logger.Error(err.Error())
+		switch {
+		case database.IsUniqueViolation(err):
+			return 0, nameTaken(g.Name)
+		case database.IsForeignKeyViolation(err):
+			return 0, parentNotFound(*g.ParentID)
+		}
+		return 0, database.RepoErr(ctx, err)
+	}
+
+	return id, nil
+}
+
+func (repo *GenresRepo) UpdateGenre(ctx context.Context, id int, b genreRequestBody) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunPgxTx(ctx, repo.pool, func(ctx context.Context, tx pgx.Tx) error {
+		// changes of genres run one at a time, two moves checked for cycles side by side could make one
+		_, err := tx.Exec(ctx, `LOCK TABLE public.genres IN SHARE ROW EXCLUSIVE MODE`)
+		if err != nil {
+			return err
+		}
+
+		var existing genreEntity
+		err = tx.QueryRow(ctx, `SELECT id, name, parent_id FROM public.genres WHERE id = @id`,
+			pgx.NamedArgs{"id": id}).Scan(&existing.ID, &existing.Name, &existing.ParentID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return genreNotFound(id)
+		}
+		if err != nil {
+			return err
+		}
+		updated := b.applyTo(existing)
+
+		if updated.ParentID != nil {
+			var cycle bool
+			err := tx.QueryRow(ctx, isAncestorQuery, pgx.NamedArgs{"id": id, "parent_id": *updated.ParentID}).
+				Scan(&cycle)
+			if err != nil {
+				return err
+			}
+			if cycle {
+				return genreCycle(id)
+			}
+		}
+
+		args := pgx.NamedArgs{
+			"id":        id,
+			"name":      updated.Name,
+			"parent_id": updated.ParentID,
+		}
+		_, err = tx.Exec(ctx, `UPDATE public.genres SET name = @name, parent_id = @parent_id WHERE id = @id`, args)
+		switch {
+		case database.IsUniqueViolation(err):
+			return nameTaken(updated.Name)
+		case database.IsForeignKeyViolation(err):
+			return parentNotFound(*updated.ParentID)
+		case err != nil:
+			return err
+		}
+
+		// books keep the name next to the link, see linkGenre in books package. Every renamed book
+		// gets an audit entry like a book update, self join gives back the name it had before
+		args["actor"] = middlewares.AuditActor(ctx)
+		args["request_id"] = middlewares.RequestIDFromContext(ctx)
+		_, err = tx.Exec(ctx, `WITH renamed AS (
+		                           UPDATE public.books b SET genre = @name, version = b.version + 1
+		                           FROM public.books old
+		                           WHERE old.id = b.id AND b.genre_id = @id AND b.genre <> @name
+		                           RETURNING b.id, old.genre
+		                       )
+		                       INSERT INTO public.book_audit (book_id, action, changes, actor, request_id)
+		                       SELECT id, 'update', jsonb_build_object('genre', jsonb_build_object('before', genre, 'after', @name::text)),
+		                              @actor, @request_id
+		                       FROM renamed`, args)
+		return err
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *GenresRepo) RemoveGenre(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	// subgenres and books restrict deleting genres they link to
+	tag, err := database.PgxConn(ctx, repo.pool).Exec(ctx, `DELETE FROM public.genres WHERE id = @id`, pgx.NamedArgs{"id": id})
+	if err != nil {
+		logger.Error(err.Error())
+		if database.IsForeignKeyViolation(err) {
+			return genreInUse(id)
+		}
+		return database.RepoErr(ctx, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return genreNotFound(id)
+	}
+
+	return nil
+}
+
+func (repo *GenresRepo) GenreNames(ctx context.Context) (map[int]string, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	names := map[int]string{}
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, `SELECT id, name FROM public.genres`)
+	if err != nil {
+		logger.Error(err.Error())
+		return names, database.RepoErr(ctx, err)
+	}
+
+	var id int
+	var name string
+	_, err = pgx.ForEachRow(rows, []any{&id, &name}, func() error {
+		names[id] = name
+		return nil
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return names, database.RepoErr(ctx, err)
+	}
+
+	return names, nil
+}
+
+func (repo *GenresRepo) Subgenres(ctx context.Context, name string) ([]int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, subgenresQuery, pgx.NamedArgs{"name": name})
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	return ids, nil
+}
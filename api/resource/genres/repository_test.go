@@ -0,0 +1,245 @@
+package genres
+
+import (
+	"booksapi/api/database"
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// repository tests run the same scenarios against every IGenresRepo implementation
+// which does not need an external server
+
+var ctx = context.Background()
+
+func newSQLiteStorage(t *testing.T) *database.SQLiteStorage {
+	s, err := database.OpenSQLite(filepath.Join(t.TempDir(), "books.db"))
+	if err != nil {
+		t.Fatalf("could not open sqlite database %s", err.Error())
+	}
+	t.Cleanup(s.Close)
+
+	if err := database.MigrateUp(ctx, s); err != nil {
+		t.Fatalf("could not migrate sqlite database %s", err.Error())
+	}
+
+	return s
+}
+
+func testRepos(t *testing.T) map[string]IGenresRepo {
+	return map[string]IGenresRepo{
+		"memory": NewMemoryRepo(),
+		"sqlite": &SQLiteRepo{db: newSQLiteStorage(t).DB},
+	}
+}
+
+func intptr(x int) *int {
+	return &x
+}
+
+func strptr(s string) *string {
+	return &s
+}
+
+// addTree adds Fiction > Fantasy > High Fantasy, Fiction > Science Fiction and Poetry with ids in that order
+func addTree(t *testing.T, name string, repo IGenresRepo) {
+	genres := []genreEntity{
+		{Name: "Fiction"},
+		{Name: "Fantasy", ParentID: intptr(1)},
+		{Name: "High Fantasy", ParentID: intptr(2)},
+		{Name: "Science Fiction", ParentID: intptr(1)},
+		{Name: "Poetry"},
+	}
+	for i, g := range genres {
+		if id, err := repo.AddGenre(ctx, g); err != nil || id != i+1 {
+			t.Fatalf("%s AddGenre failed\nexpected id %d\ngot %v, %v", name, i+1, id, err)
+		}
+	}
+}
+
+func genreNames(genres []genreEntity) []string {
+	names := make([]string, 0, len(genres))
+	for _, g := range genres {
+		names = append(names, g.Name)
+	}
+	return names
+}
+
+func TestRepoGenres(t *testing.T) {
+	for name, repo := range testRepos(t) {
+		addTree(t, name, repo)
+
+		var conflict database.ConflictErr
+		var br database.BadRequestErr
+		var nf database.NotFoundErr
+		if _, err := repo.AddGenre(ctx, genreEntity{Name: "FANTASY"}); !errors.As(err, &conflict) {
+			t.Errorf("%s AddGenre expected ConflictErr for name of another case\ngot %v", name, err)
+		}
+		if _, err := repo.AddGenre(ctx, genreEntity{Name: "Horror", ParentID: intptr(42)}); !errors.As(err, &br) {
+			t.Errorf("%s AddGenre expected BadRequestErr for unknown parent\ngot %v", name, err)
+		}
+
+		page, err := repo.GetGenres(ctx, pageQuery{Limit: 3, Offset: 1})
+		expected := []string{"Fiction", "High Fantasy", "Poetry"}
+		if err != nil || page.Total != 5 || !slices.Equal(genreNames(page.Genres), expected) {
+			t.Errorf("%s GetGenres failed\nexpected %v of 5\ngot %v of %v, %v",
+				name, expected, genreNames(page.Genres), page.Total, err)
+		}
+
+		if g, err := repo.GetGenreById(ctx, 3); err != nil || g.Name != "High Fantasy" || *g.ParentID != 2 {
+			t.Errorf("%s GetGenreById failed\ngot %+v, %v", name, g, err)
+		}
+		if _, err := repo.GetGenreById(ctx, 42); !errors.As(err, &nf) {
+			t.Errorf("%s GetGenreById expected NotFoundErr\ngot %v", name, err)
+		}
+
+		if ids, err := repo.Subgenres(ctx, "fiction"); err != nil || !slices.Equal(ids, []int{1, 2, 3, 4}) {
+			t.Errorf("%s Subgenres failed\nexpected [1 2 3 4]\ngot %v, %v", name, ids, err)
+		}
+		if ids, err := repo.Subgenres(ctx, "horror"); err != nil || len(ids) != 0 {
+			t.Errorf("%s Subgenres expected no genres for unknown name\ngot %v, %v", name, ids, err)
+		}
+
+		// Fiction can't go below High Fantasy which is below Fiction itself
+		if err := repo.UpdateGenre(ctx, 1, genreRequestBody{ParentID: nullableID{Set: true, Value: intptr(3)}}); !errors.As(err, &br) {
+			t.Errorf("%s UpdateGenre expected BadRequestErr for a cycle\ngot %v", name, err)
+		}
+		if err := repo.UpdateGenre(ctx, 2, genreRequestBody{ParentID: nullableID{Set: true, Value: intptr(2)}}); !errors.As(err, &br) {
+			t.Errorf("%s UpdateGenre expected BadRequestErr for genre below itself\ngot %v", name, err)
+		}
+		if err := repo.UpdateGenre(ctx, 2, genreRequestBody{Name: strptr("poetry")}); !errors.As(err, &conflict) {
+			t.Errorf("%s UpdateGenre expected ConflictErr for taken name\ngot %v", name, err)
+		}
+		if err := repo.UpdateGenre(ctx, 42, genreRequestBody{Name: strptr("Horror")}); !errors.As(err, &nf) {
+			t.Errorf("%s UpdateGenre expected NotFoundErr\ngot %v", name, err)
+		}
+
+		// renamed Fantasy keeps its parent, then it moves to top level with its subgenre
+		if err := repo.UpdateGenre(ctx, 2, genreRequestBody{Name: strptr("Fantasy Fiction")}); err != nil {
+			t.Errorf("%s UpdateGenre failed\nunexpected error %s", name, err.Error())
+		}
+		if err := repo.UpdateGenre(ctx, 2, genreRequestBody{ParentID: nullableID{Set: true}}); err != nil {
+			t.Errorf("%s UpdateGenre failed\nunexpected error %s", name, err.Error())
+		}
+		if g, _ := repo.GetGenreById(ctx, 2); g.Name != "Fantasy Fiction" || g.ParentID != nil {
+			t.Errorf("%s UpdateGenre failed\ngot %+v", name, g)
+		}
+		if ids, _ := repo.Subgenres(ctx, "FICTION"); !slices.Equal(ids, []int{1, 4}) {
+			t.Errorf("%s Subgenres after move failed\nexpected [1 4]\ngot %v", name, ids)
+		}
+
+		if err := repo.RemoveGenre(ctx, 2); !errors.As(err, &conflict) {
+			t.Errorf("%s RemoveGenre expected ConflictErr while subgenres exist\ngot %v", name, err)
+		}
+		if err := repo.RemoveGenre(ctx, 3); err != nil {
+			t.Errorf("%s RemoveGenre failed\nunexpected error %s", name, err.Error())
+		}
+		if err := repo.RemoveGenre(ctx, 3); !errors.As(err, &nf) {
+			t.Errorf("%s RemoveGenre expected NotFoundErr\ngot %v", name, err)
+		}
+		if names, _ := repo.GenreNames(ctx); len(names) != 4 || names[2] != "Fantasy Fiction" {
+			t.Errorf("%s GenreNames failed\ngot %v", name, names)
+		}
+	}
+}
+
+func TestRepoGenreBooks(t *testing.T) {
+	s := newSQLiteStorage(t)
+	repo := &SQLiteRepo{db: s.DB}
+	addTree(t, "sqlite", repo)
+
+	_, err := s.DB.Exec(`INSERT INTO books (title, author, genre, genre_id) VALUES
+	                     ('The Hobbit', 'JRR Tolkien', 'Fantasy', 2),
+	                     ('Dune', 'Frank Herbert', 'Science Fiction', 4)`)
+	if err != nil {
+		t.Fatalf("could not insert books %s", err.Error())
+	}
+
+	if err := repo.UpdateGenre(ctx, 2, genreRequestBody{Name: strptr("Fantasy Fiction")}); err != nil {
+		t.Fatalf("UpdateGenre failed\nunexpected error %s", err.Error())
+	}
+	var genre string
+	var version int
+	s.DB.QueryRow(`SELECT genre, version FROM books WHERE id = 1`).Scan(&genre, &version)
+	if genre != "Fantasy Fiction" || version != 2 {
+		t.Errorf("UpdateGenre must rename genre of its books\ngot %q version %d", genre, version)
+	}
+	var bookID int
+	var action, changes, actor string
+	err = s.DB.QueryRow(`SELECT book_id, action, changes, actor FROM book_audit`).Scan(&bookID, &action, &changes, &actor)
+	if err != nil || bookID != 1 || action != "update" || actor != "system" ||
+		changes != `{"genre":{"before":"Fantasy","after":"Fantasy Fiction"}}` {
+		t.Errorf("UpdateGenre must audit renamed books\ngot %d %s %s %s, %v", bookID, action, changes, actor, err)
+	}
+
+	var conflict database.ConflictErr
+	if err := repo.RemoveGenre(ctx, 4); !errors.As(err, &conflict) {
+		t.Errorf("RemoveGenre expected ConflictErr while books are linked\ngot %v", err)
+	}
+}
+
+// migrateDownTo reverts every applied migration newer than version
+func migrateDownTo(t *testing.T, s *database.SQLiteStorage, version int) {
+	status, err := database.GetMigrationStatus(ctx, s)
+	if err != nil {
+		t.Fatalf("could not read migration status %s", err.Error())
+	}
+	steps := 0
+	for _, m := range status {
+		if m.Version > version && m.AppliedAt != nil {
+			steps++
+		}
+	}
+	if err := database.MigrateDown(ctx, s, steps); err != nil {
+		t.Fatalf("could not revert migrations %s", err.Error())
+	}
+}
+
+func TestGenresMigration(t *testing.T) {
+	s := newSQLiteStorage(t)
+	// 0006 is the last migration before genres
+	migrateDownTo(t, s, 6)
+	for _, genre := range []string{"fantasy", "Science Fiction", " Fantasy", "", "science fiction"} {
+		if _, err := s.DB.Exec(`INSERT INTO books (title, author, genre) VALUES ('title', 'author', ?)`, genre); err != nil {
+			t.Fatalf("could not insert book %s", err.Error())
+		}
+	}
+	if err := database.MigrateUp(ctx, s); err != nil {
+		t.Fatalf("could not migrate sqlite database %s", err.Error())
+	}
+
+	repo := &SQLiteRepo{db: s.DB}
+	page, err := repo.GetGenres(ctx, pageQuery{Limit: 10})
+	expected := []string{"fantasy", "Science Fiction"}
+	if err != nil || !slices.Equal(genreNames(page.Genres), expected) {
+		t.Fatalf("genres migration failed\nexpected %v\ngot %v, %v", expected, genreNames(page.Genres), err)
+	}
+
+	rows, err := s.DB.Query(`SELECT genre, genre_id FROM books ORDER BY id`)
+	if err != nil {
+		t.Fatalf("could not read books %s", err.Error())
+	}
+	defer rows.Close()
+
+	expectedBooks := []struct {
+		genre   string
+		genreID *int
+	}{
+		{"fantasy", intptr(1)},
+		{"Science Fiction", intptr(2)},
+		{"fantasy", intptr(1)},
+		{"", nil},
+		{"Science Fiction", intptr(2)},
+	}
+	for i := 0; rows.Next(); i++ {
+		var genre string
+		var genreID *int
+		rows.Scan(&genre, &genreID)
+		e := expectedBooks[i]
+		if genre != e.genre || (genreID == nil) != (e.genreID == nil) || genreID != nil && *genreID != *e.genreID {
+			t.Errorf("genres migration failed for book %d\nexpected %q %v\ngot %q %v", i+1, e.genre, e.genreID, genre, genreID)
+		}
+	}
+}
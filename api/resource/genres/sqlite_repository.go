@@ -0,0 +1,262 @@
+package genres
+
+import (
+	"booksapi/api/database"
+	"booksapi/api/router/middlewares"
+	"booksapi/logger"
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// SQLiteRepo keeps genres in sqlite database
+type SQLiteRepo struct {
+	db *sql.DB
+}
+
+// genreExists tells whether genre of given id exists
+func genreExists(ctx context.Context, tx *sql.Tx, id int) (bool, error) {
+	var found bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM genres WHERE id = @id)`, sql.Named("id", id)).
+		Scan(&found)
+	return found, err
+}
+
+func (repo *SQLiteRepo) GetGenres(ctx context.Context, q pageQuery) (genresPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := genresPage{
+		Genres: make([]genreEntity, 0),
+	}
+
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT count(*) FROM genres`).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	query := `SELECT id, name, parent_id FROM genres ORDER BY lower(name), id LIMIT @limit OFFSET @offset`
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, sql.Named("limit", q.Limit), sql.Named("offset", q.Offset))
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g genreEntity
+		if err := rows.Scan(&g.ID, &g.Name, &g.ParentID); err != nil {
+			logger.Error(err.Error())
+			return page, database.RepoErr(ctx, err)
+		}
+		page.Genres = append(page.Genres, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *SQLiteRepo) GetGenreById(ctx context.Context, id int) (genreEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var g genreEntity
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT id, name, parent_id FROM genres WHERE id = @id`,
+		sql.Named("id", id)).Scan(&g.ID, &g.Name, &g.ParentID)
+	if err != nil {
+		logger.Error(err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return g, genreNotFound(id)
+		}
+		return g, database.RepoErr(ctx, err)
+	}
+
+	return g, nil
+}
+
+func (repo *SQLiteRepo) AddGenre(ctx context.Context, g genreEntity) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		if g.ParentID != nil {
+			found, err := genreExists(ctx, tx, *g.ParentID)
+			if err != nil {
+				return err
+			}
+			if !found {
+				return parentNotFound(*g.ParentID)
+			}
+		}
+
+		err := tx.QueryRowContext(ctx, `INSERT INTO genres (name, parent_id) VALUES (@name, @parent_id) RETURNING id`,
+			sql.Named("name", g.Name), sql.Named("parent_id", g.ParentID)).Scan(&id)
+		if database.IsSQLiteUniqueViolation(err) {
+			return nameTaken(g.Name)
+		}
+		return err
+	})
+
+	return id, database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) UpdateGenre(ctx context.Context, id int, b genreRequestBody) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	// transaction holds the database lock, so the tree can't change between the checks and update
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		var existing genreEntity
+		err := tx.QueryRowContext(ctx, `SELECT id, name, parent_id FROM genres WHERE id = @id`,
+			sql.Named("id", id)).Scan(&existing.ID, &existing.Name, &existing.ParentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return genreNotFound(id)
+		}
+		if err != nil {
+			return err
+		}
+		updated := b.applyTo(existing)
+
+		if updated.ParentID != nil {
+			found, err := genreExists(ctx, tx, *updated.ParentID)
+			if err != nil {
+				return err
+			}
+			if !found {
+				return parentNotFound(*updated.ParentID)
+			}
+
+			var cycle bool
+			err = tx.QueryRowContext(ctx, isAncestorQuery,
+				sql.Named("id", id), sql.Named("parent_id", *updated.ParentID)).Scan(&cycle)
+			if err != nil {
+				return err
+			}
+			if cycle {
+				return genreCycle(id)
+			}
+		}
+
+		args := []any{sql.Named("id", id), sql.Named("name", updated.Name), sql.Named("parent_id", updated.ParentID)}
+		_, err = tx.ExecContext(ctx, `UPDATE genres SET name = @name, parent_id = @parent_id WHERE id = @id`, args...)
+		if database.IsSQLiteUniqueViolation(err) {
+			return nameTaken(updated.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+		// books keep the name next to the link, see linkGenre in books package.
+		// Every renamed book gets an audit entry like a book update, it is written while books have the old name
+		audit := append(args, sql.Named("actor", middlewares.AuditActor(ctx)),
+			sql.Named("request_id", middlewares.RequestIDFromContext(ctx)))
+		_, err = tx.ExecContext(ctx, `INSERT INTO book_audit (book_id, action, changes, actor, request_id)
+		                              SELECT id, 'update', json_object('genre', json_object('before', genre, 'after', @name)),
+		                                     @actor, @request_id
+		                              FROM books WHERE genre_id = @id AND genre <> @name
+		                              ORDER BY id`, audit...)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE books SET genre = @name, version = version + 1
+		                              WHERE genre_id = @id AND genre <> @name`, args...)
+		return err
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) RemoveGenre(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	// transaction holds the database lock, so nothing can link to the genre between the check and delete
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		var linked bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM genres WHERE parent_id = @id)
+		                                    OR EXISTS (SELECT 1 FROM books WHERE genre_id = @id)`,
+			sql.Named("id", id)).Scan(&linked)
+		if err != nil {
+			return err
+		}
+		if linked {
+			return genreInUse(id)
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM genres WHERE id = @id`, sql.Named("id", id))
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return genreNotFound(id)
+		}
+		return nil
+	})
+
+	return database.TxErr(ctx, err)
+}
+
+func (repo *SQLiteRepo) GenreNames(ctx context.Context) (map[int]string, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	names := map[int]string{}
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, `SELECT id, name FROM genres`)
+	if err != nil {
+		logger.Error(err.Error())
+		return names, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			logger.Error(err.Error())
+			return names, database.RepoErr(ctx, err)
+		}
+		names[id] = name
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return names, database.RepoErr(ctx, err)
+	}
+
+	return names, nil
+}
+
+func (repo *SQLiteRepo) Subgenres(ctx context.Context, name string) ([]int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, subgenresQuery, sql.Named("name", name))
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			logger.Error(err.Error())
+			return nil, database.RepoErr(ctx, err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return nil, database.RepoErr(ctx, err)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,301 @@
+package publishers
+
+import (
+	"booksapi/api/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+func invalidParamErr(name string) database.APIError {
+	return database.APIError{
+		Status:  http.StatusBadRequest,
+		Message: fmt.Sprintf("invalid value for %s query parameter", name),
+	}
+}
+
+// pathID parses integer path parameter of given name
+func pathID(r *http.Request, name string) (int, *database.APIError) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		return 0, &database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("only accept integer values as {%s} path parameter", name),
+		}
+	}
+	return id, nil
+}
+
+// parsePaging reads limit and offset query parameters, any other parameter is refused
+func parsePaging(values url.Values) (pageQuery, *database.APIError) {
+	q := pageQuery{
+		Limit: defaultPageLimit,
+	}
+
+	for name := range values {
+		if name != "limit" && name != "offset" {
+			return q, &database.APIError{
+				Status:  http.StatusBadRequest,
+				Message: fmt.Sprintf("unknown query parameter %s", name),
+			}
+		}
+	}
+
+	if values.Has("limit") {
+		limit, err := strconv.Atoi(values.Get("limit"))
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			e := invalidParamErr("limit")
+			return q, &e
+		}
+		q.Limit = limit
+	}
+
+	if values.Has("offset") {
+		offset, err := strconv.Atoi(values.Get("offset"))
+		if err != nil || offset < 0 {
+			e := invalidParamErr("offset")
+			return q, &e
+		}
+		q.Offset = offset
+	}
+
+	return q, nil
+}
+
+func decodePublisher(r *http.Request) (publisherRequestBody, *database.APIError) {
+	var req publisherRequestBody
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, &database.APIError{
+			Message: "invalid request model",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if err := req.validate(); err != nil {
+		return req, &database.APIError{
+			Message: err.Error(),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	return req, nil
+}
+
+type API struct {
+	repo IPublishersRepo
+}
+
+// New picks repository of the opened storage
+func New() API {
+	var repo IPublishersRepo
+	switch s := database.Get().(type) {
+	case *database.PostgresStorage:
+		repo = &PublishersRepo{pool: s.Pool}
+	case *database.SQLiteStorage:
+		repo = &SQLiteRepo{db: s.DB}
+	default:
+		repo = NewMemoryRepo()
+	}
+
+	return API{
+		repo: repo,
+	}
+}
+
+// FindPublisher looks a publisher up for packages which link to publishers but can't join
+// publishers table, e.g. memory repository of books
+func (api API) FindPublisher(ctx context.Context, id int) (name string, ok bool) {
+	p, err := api.repo.GetPublisherById(ctx, id)
+	if err != nil {
+		return "", false
+	}
+	return p.Name, true
+}
+
+// GetPublishers returns a page of publishers
+//
+//	@Summary		Get publishers
+//	@Description	lists publishers ordered by name
+//	@Tags			publishers
+//	@Produce		json
+//	@Param			limit	query		int	false	"page size, 20 by default and 100 at most"
+//	@Param			offset	query		int	false	"number of publishers to skip"
+//	@Success		200		{object}	publishersPageDTO
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Router			/api/publishers [get]
+func (api API) GetPublishers(w http.ResponseWriter, r *http.Request) {
+	q, apiErr := parsePaging(r.URL.Query())
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	page, err := api.repo.GetPublishers(r.Context(), q)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto := publishersPageDTO{
+		Publishers: make([]publisherDTO, 0, len(page.Publishers)),
+		Total:      page.Total,
+		Limit:      q.Limit,
+		Offset:     q.Offset,
+	}
+	for _, p := range page.Publishers {
+		dto.Publishers = append(dto.Publishers, p.ToDto())
+	}
+
+	json, _ := json.Marshal(dto)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// GetPublisher returns a publisher by id
+//
+//	@Summary		Get publisher by id
+//	@Description	get publisher
+//	@Tags			publishers
+//	@Produce		json
+//	@Param			id	path		int	true	"Publisher ID"
+//	@Success		200	{object}	publisherDTO
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/publishers/{id} [get]
+func (api API) GetPublisher(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	publisher, err := api.repo.GetPublisherById(r.Context(), id)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	json, _ := json.Marshal(publisher.ToDto())
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// AddPublisher adds new publisher
+//
+//	@Summary		Add new publisher
+//	@Description	adds publisher, names are unique regardless of case
+//	@Tags			publishers
+//	@Accept			json
+//	@Produce		json
+//	@Param			publisher	body		publisherRequestBody	true	"request body"
+//	@Success		201			{object}	ActionResponse
+//	@Failure		500			{object}	database.APIError
+//	@Failure		503			{object}	database.APIError
+//	@Failure		400			{object}	database.APIError
+//	@Failure		409			{object}	database.APIError
+//	@Router			/api/publishers [post]
+func (api API) AddPublisher(w http.ResponseWriter, r *http.Request) {
+	req, apiErr := decodePublisher(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	id, err := api.repo.AddPublisher(r.Context(), *req.Name)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	j, _ := json.Marshal(ActionResponse{ResourceId: id})
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, string(j[:]))
+}
+
+// UpdatePublisher renames a publisher
+//
+//	@Summary		Update publisher
+//	@Description	renames publisher
+//	@Tags			publishers
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path	int						true	"Publisher ID"
+//	@Param			publisher	body	publisherRequestBody	true	"request body"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Failure		409	{object}	database.APIError
+//	@Router			/api/publishers/{id} [patch]
+func (api API) UpdatePublisher(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	req, apiErr := decodePublisher(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	if err := api.repo.UpdatePublisher(r.Context(), id, *req.Name); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemovePublisher deletes a publisher without editions
+//
+//	@Summary		Remove publisher
+//	@Description	removes publisher, its editions have to be removed or moved to another publisher first
+//	@Tags			publishers
+//	@Produce		json
+//	@Param			id	path	int	true	"Publisher ID"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Failure		409	{object}	database.APIError
+//	@Router			/api/publishers/{id} [delete]
+func (api API) RemovePublisher(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	if err := api.repo.RemovePublisher(r.Context(), id); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
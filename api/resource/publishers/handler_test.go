@@ -0,0 +1,126 @@
+package publishers
+
+import (
+	"booksapi/api/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPublishersAPI(t *testing.T) {
+	api := API{repo: NewMemoryRepo()}
+
+	tcases := []struct {
+		method       string
+		url          string
+		pathValues   map[string]string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		data         string
+		headerStatus int
+	}{
+		{
+			method:       "POST",
+			url:          "/api/publishers",
+			body:         `{"name":"  Faber   and Faber "}`,
+			handler:      api.AddPublisher,
+			data:         `{"resourceId":1}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "POST",
+			url:          "/api/publishers",
+			body:         `{"name":"faber and faber"}`,
+			handler:      api.AddPublisher,
+			data:         database.APIError{Status: http.StatusConflict, Message: `publisher named "faber and faber" already exists`}.Error(),
+			headerStatus: http.StatusConflict,
+		},
+		{
+			method:       "POST",
+			url:          "/api/publishers",
+			body:         `{"name":""}`,
+			handler:      api.AddPublisher,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "name is required, won't save the data"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "POST",
+			url:          "/api/publishers",
+			body:         `{"name":"Gollancz","country":"UK"}`,
+			handler:      api.AddPublisher,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "invalid request model"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "GET",
+			url:          "/api/publishers/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.GetPublisher,
+			data:         `{"id":1,"name":"Faber and Faber"}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "PATCH",
+			url:          "/api/publishers/1",
+			pathValues:   map[string]string{"id": "1"},
+			body:         `{"name":"Faber Ltd"}`,
+			handler:      api.UpdatePublisher,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/publishers?limit=5",
+			handler:      api.GetPublishers,
+			data:         `{"publishers":[{"id":1,"name":"Faber Ltd"}],"total":1,"limit":5,"offset":0}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/publishers?name=penguin",
+			handler:      api.GetPublishers,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "unknown query parameter name"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "DELETE",
+			url:          "/api/publishers/x",
+			pathValues:   map[string]string{"id": "x"},
+			handler:      api.RemovePublisher,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "only accept integer values as {id} path parameter"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "DELETE",
+			url:          "/api/publishers/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.RemovePublisher,
+			headerStatus: http.StatusNoContent,
+		},
+		{
+			method:       "GET",
+			url:          "/api/publishers/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.GetPublisher,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "publisher with id 1 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+	}
+
+	// cases run in order, each one sees changes of those before it
+	for _, tc := range tcases {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		for name, value := range tc.pathValues {
+			rq.SetPathValue(name, value)
+		}
+		tc.handler(w, rq)
+
+		if tc.data != w.Body.String() {
+			t.Errorf("%s %s failed\nexpected %v\ngot %v", tc.method, tc.url, tc.data, w.Body.String())
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("%s %s response header failed\nexpected %v\ngot  %v", tc.method, tc.url, tc.headerStatus, w.Code)
+		}
+	}
+}
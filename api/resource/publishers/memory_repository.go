@@ -0,0 +1,155 @@
+package publishers
+
+import (
+	"booksapi/api/database"
+	"context"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryRepo keeps publishers in a map, it is meant for demos and local runs without postgres.
+// Memory repo of books looks publishers up instead of linking to them, so unlike databases
+// publishers are removed regardless of editions
+type MemoryRepo struct {
+	mu         sync.RWMutex
+	publishers map[int]publisherEntity
+	lastID     int
+}
+
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		publishers: map[int]publisherEntity{},
+	}
+}
+
+// save copies publishers for a unit of work, the copy is put back when the unit of work fails
+func (repo *MemoryRepo) save() func() {
+	repo.mu.RLock()
+	publishers, lastID := maps.Clone(repo.publishers), repo.lastID
+	repo.mu.RUnlock()
+
+	return func() {
+		repo.mu.Lock()
+		defer repo.mu.Unlock()
+		repo.publishers, repo.lastID = publishers, lastID
+	}
+}
+
+// nameTakenBy tells whether a publisher other than id has the name regardless of case, caller holds the lock
+func (repo *MemoryRepo) nameTakenBy(name string, id int) bool {
+	for _, p := range repo.publishers {
+		if p.ID != id && strings.EqualFold(p.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *MemoryRepo) GetPublishers(ctx context.Context, q pageQuery) (publishersPage, error) {
+	if err := ctx.Err(); err != nil {
+		return publishersPage{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	page := publishersPage{
+		Publishers: make([]publisherEntity, 0),
+		Total:      len(repo.publishers),
+	}
+
+	publishers := make([]publisherEntity, 0, len(repo.publishers))
+	for _, p := range repo.publishers {
+		publishers = append(publishers, p)
+	}
+	sort.Slice(publishers, func(i, j int) bool {
+		ni, nj := strings.ToLower(publishers[i].Name), strings.ToLower(publishers[j].Name)
+		if ni != nj {
+			return ni < nj
+		}
+		return publishers[i].ID < publishers[j].ID
+	})
+
+	start := min(q.Offset, len(publishers))
+	end := min(start+q.Limit, len(publishers))
+	page.Publishers = append(page.Publishers, publishers[start:end]...)
+
+	return page, nil
+}
+
+func (repo *MemoryRepo) GetPublisherById(ctx context.Context, id int) (publisherEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return publisherEntity{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	p, ok := repo.publishers[id]
+	if !ok {
+		return publisherEntity{}, publisherNotFound(id)
+	}
+
+	return p, nil
+}
+
+func (repo *MemoryRepo) AddPublisher(ctx context.Context, name string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.nameTakenBy(name, 0) {
+		return 0, nameTaken(name)
+	}
+
+	repo.lastID++
+	repo.publishers[repo.lastID] = publisherEntity{ID: repo.lastID, Name: name}
+
+	return repo.lastID, nil
+}
+
+func (repo *MemoryRepo) UpdatePublisher(ctx context.Context, id int, name string) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.publishers[id]; !ok {
+		return publisherNotFound(id)
+	}
+	if repo.nameTakenBy(name, id) {
+		return nameTaken(name)
+	}
+	repo.publishers[id] = publisherEntity{ID: id, Name: name}
+
+	return nil
+}
+
+func (repo *MemoryRepo) RemovePublisher(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.publishers[id]; !ok {
+		return publisherNotFound(id)
+	}
+	delete(repo.publishers, id)
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package publishers
+
+import (
+	"booksapi/api/database"
+	"strings"
+)
+
+type publisherRequestBody struct {
+	Name *string `json:"name"`
+}
+
+// validate trims the name and checks it is set
+func (b *publisherRequestBody) validate() error {
+	if b.Name == nil || strings.TrimSpace(*b.Name) == "" {
+		return database.BadRequestErr{Message: "name is required, won't save the data"}
+	}
+	name := strings.Join(strings.Fields(*b.Name), " ")
+	b.Name = &name
+	return nil
+}
+
+type publisherEntity struct {
+	ID   int
+	Name string
+}
+
+func (p publisherEntity) ToDto() publisherDTO {
+	return publisherDTO{
+		ID:   p.ID,
+		Name: p.Name,
+	}
+}
+
+type publisherDTO struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type pageQuery struct {
+	Limit  int
+	Offset int
+}
+
+type publishersPage struct {
+	Publishers []publisherEntity
+	Total      int
+}
+
+type publishersPageDTO struct {
+	Publishers []publisherDTO `json:"publishers"`
+	Total      int            `json:"total"`
+	Limit      int            `json:"limit"`
+	Offset     int            `json:"offset"`
+}
+
+type ActionResponse struct {
+	ResourceId int `json:"resourceId"`
+}
@@ -0,0 +1,151 @@
+package publishers
+
+import (
+	"booksapi/api/database"
+	"booksapi/logger"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type IPublishersRepo interface {
+	// GetPublishers lists publishers ordered by name
+	GetPublishers(context.Context, pageQuery) (publishersPage, error)
+	GetPublisherById(context.Context, int) (publisherEntity, error)
+	// AddPublisher and UpdatePublisher fail with ConflictErr when another publisher has the same name regardless of case
+	AddPublisher(ctx context.Context, name string) (int, error)
+	UpdatePublisher(ctx context.Context, id int, name string) error
+	// RemovePublisher fails with ConflictErr while editions are published by the publisher, trashed books included
+	RemovePublisher(ctx context.Context, id int) error
+}
+
+func publisherNotFound(id int) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("publisher with id %d not found", id)}
+}
+
+func nameTaken(name string) database.ConflictErr {
+	return database.ConflictErr{Message: fmt.Sprintf("publisher named %q already exists", name)}
+}
+
+func publisherInUse(id int) database.ConflictErr {
+	return database.ConflictErr{Message: fmt.Sprintf("publisher with id %d still has editions, trashed books included", id)}
+}
+
+// PublishersRepo keeps publishers in postgres
+type PublishersRepo struct {
+	pool *pgxpool.Pool
+}
+
+func (repo *PublishersRepo) GetPublishers(ctx context.Context, q pageQuery) (publishersPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := publishersPage{
+		Publishers: make([]publisherEntity, 0),
+	}
+
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT count(*) FROM public.publishers`).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	query := `SELECT id, name FROM public.publishers ORDER BY lower(name), id LIMIT @limit OFFSET @offset`
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, pgx.NamedArgs{"limit": q.Limit, "offset": q.Offset})
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	publishers, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (publisherEntity, error) {
+		var p publisherEntity
+		err := row.Scan(&p.ID, &p.Name)
+		return p, err
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	page.Publishers = publishers
+
+	return page, nil
+}
+
+func (repo *PublishersRepo) GetPublisherById(ctx context.Context, id int) (publisherEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var p publisherEntity
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT id, name FROM public.publishers WHERE id = @id`,
+		pgx.NamedArgs{"id": id}).Scan(&p.ID, &p.Name)
+	if err != nil {
+		logger.Error(err.Error())
+		if errors.Is(err, pgx.ErrNoRows) {
+			return p, publisherNotFound(id)
+		}
+		return p, database.RepoErr(ctx, err)
+	}
+
+	return p, nil
+}
+
+func (repo *PublishersRepo) AddPublisher(ctx context.Context, name string) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `INSERT INTO public.publishers (name) VALUES (@name) RETURNING id`,
+		pgx.NamedArgs{"name": name}).Scan(&id)
+	if err != nil {
+		logger.Error(err.Error())
+		if database.IsUniqueViolation(err) {
+			return 0, nameTaken(name)
+		}
+		return 0, database.RepoErr(ctx, err)
+	}
+
+	return id, nil
+}
+
+func (repo *PublishersRepo) UpdatePublisher(ctx context.Context, id int, name string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	tag, err := database.PgxConn(ctx, repo.pool).Exec(ctx, `UPDATE public.publishers SET name = @name WHERE id = @id`,
+		pgx.NamedArgs{"id": id, "name": name})
+	if err != nil {
+		logger.Error(err.Error())
+		if database.IsUniqueViolation(err) {
+			return nameTaken(name)
+		}
+		return database.RepoErr(ctx, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return publisherNotFound(id)
+	}
+
+	return nil
+}
+
+func (repo *PublishersRepo) RemovePublisher(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	// editions restrict deleting publishers they link to
+	tag, err := database.PgxConn(ctx, repo.pool).Exec(ctx, `DELETE FROM public.publishers WHERE id = @id`, pgx.NamedArgs{"id": id})
+	if err != nil {
+		logger.Error(err.Error())
+		if database.IsForeignKeyViolation(err) {
+			return publisherInUse(id)
+		}
+		return database.RepoErr(ctx, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return publisherNotFound(id)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package publishers
+
+import (
+	"booksapi/api/database"
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// repository tests run the same scenarios against every IPublishersRepo implementation
+// which does not need an external server
+
+var ctx = context.Background()
+
+func newSQLiteStorage(t *testing.T) *database.SQLiteStorage {
+	s, err := database.OpenSQLite(filepath.Join(t.TempDir(), "books.db"))
+	if err != nil {
+		t.Fatalf("could not open sqlite database %s", err.Error())
+	}
+	t.Cleanup(s.Close)
+
+	if err := database.MigrateUp(ctx, s); err != nil {
+		t.Fatalf("could not migrate sqlite database %s", err.Error())
+	}
+
+	return s
+}
+
+func testRepos(t *testing.T) map[string]IPublishersRepo {
+	return map[string]IPublishersRepo{
+		"memory": NewMemoryRepo(),
+		"sqlite": &SQLiteRepo{db: newSQLiteStorage(t).DB},
+	}
+}
+
+func publisherNames(publishers []publisherEntity) []string {
+	names := make([]string, 0, len(publishers))
+	for _, p := range publishers {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func TestRepoPublishers(t *testing.T) {
+	for name, repo := range testRepos(t) {
+		for i, n := range []string{"Penguin", "Allen & Unwin", "Gollancz"} {
+			if id, err := repo.AddPublisher(ctx, n); err != nil || id != i+1 {
+				t.Fatalf("%s AddPublisher failed\nexpected id %d\ngot %v, %v", name, i+1, id, err)
+			}
+		}
+
+		var conflict database.ConflictErr
+		var nf database.NotFoundErr
+		if _, err := repo.AddPublisher(ctx, "PENGUIN"); !errors.As(err, &conflict) {
+			t.Errorf("%s AddPublisher expected ConflictErr for name of another case\ngot %v", name, err)
+		}
+
+		page, err := repo.GetPublishers(ctx, pageQuery{Limit: 2, Offset: 1})
+		expected := []string{"Gollancz", "Penguin"}
+		if err != nil || page.Total != 3 || !slices.Equal(publisherNames(page.Publishers), expected) {
+			t.Errorf("%s GetPublishers failed\nexpected %v of 3\ngot %v of %v, %v",
+				name, expected, publisherNames(page.Publishers), page.Total, err)
+		}
+
+		if err := repo.UpdatePublisher(ctx, 1, "Penguin Books"); err != nil {
+			t.Errorf("%s UpdatePublisher failed\nunexpected error %s", name, err.Error())
+		}
+		if p, err := repo.GetPublisherById(ctx, 1); err != nil || p.Name != "Penguin Books" {
+			t.Errorf("%s GetPublisherById failed\ngot %+v, %v", name, p, err)
+		}
+		if err := repo.UpdatePublisher(ctx, 1, "gollancz"); !errors.As(err, &conflict) {
+			t.Errorf("%s UpdatePublisher expected ConflictErr for taken name\ngot %v", name, err)
+		}
+		if err := repo.UpdatePublisher(ctx, 42, "Tor"); !errors.As(err, &nf) {
+			t.Errorf("%s UpdatePublisher expected NotFoundErr\ngot %v", name, err)
+		}
+
+		if err := repo.RemovePublisher(ctx, 3); err != nil {
+			t.Errorf("%s RemovePublisher failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := repo.GetPublisherById(ctx, 3); !errors.As(err, &nf) {
+			t.Errorf("%s GetPublisherById expected NotFoundErr\ngot %v", name, err)
+		}
+		if err := repo.RemovePublisher(ctx, 3); !errors.As(err, &nf) {
+			t.Errorf("%s RemovePublisher expected NotFoundErr\ngot %v", name, err)
+		}
+	}
+}
+
+func TestRepoPublisherEditions(t *testing.T) {
+	s := newSQLiteStorage(t)
+	repo := &SQLiteRepo{db: s.DB}
+	if _, err := repo.AddPublisher(ctx, "Allen & Unwin"); err != nil {
+		t.Fatalf("AddPublisher failed\nunexpected error %s", err.Error())
+	}
+
+	_, err := s.DB.Exec(`INSERT INTO books (title, author) VALUES ('The Hobbit', 'JRR Tolkien');
+	                     INSERT INTO editions (book_id, publisher_id, format) VALUES (1, 1, 'hardcover')`)
+	if err != nil {
+		t.Fatalf("could not insert edition %s", err.Error())
+	}
+
+	var conflict database.ConflictErr
+	if err := repo.RemovePublisher(ctx, 1); !errors.As(err, &conflict) {
+		t.Errorf("RemovePublisher expected ConflictErr while editions are linked\ngot %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package publishers
+
+import (
+	"booksapi/api/database"
+	"booksapi/logger"
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// SQLiteRepo keeps publishers in sqlite database
+type SQLiteRepo struct {
+	db *sql.DB
+}
+
+func (repo *SQLiteRepo) GetPublishers(ctx context.Context, q pageQuery) (publishersPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := publishersPage{
+		Publishers: make([]publisherEntity, 0),
+	}
+
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT count(*) FROM publishers`).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	query := `SELECT id, name FROM publishers ORDER BY lower(name), id LIMIT @limit OFFSET @offset`
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, sql.Named("limit", q.Limit), sql.Named("offset", q.Offset))
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p publisherEntity
+		if err := rows.Scan(&p.ID, &p.Name); err != nil {
+			logger.Error(err.Error())
+			return page, database.RepoErr(ctx, err)
+		}
+		page.Publishers = append(page.Publishers, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *SQLiteRepo) GetPublisherById(ctx context.Context, id int) (publisherEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var p publisherEntity
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT id, name FROM publishers WHERE id = @id`,
+		sql.Named("id", id)).Scan(&p.ID, &p.Name)
+	if err != nil {
+		logger.Error(err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return p, publisherNotFound(id)
+		}
+		return p, database.RepoErr(ctx, err)
+	}
+
+	return p, nil
+}
+
+func (repo *SQLiteRepo) AddPublisher(ctx context.Context, name string) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `INSERT INTO publishers (name) VALUES (@name) RETURNING id`,
+		sql.Named("name", name)).Scan(&id)
+	if err != nil {
+		logger.Error(err.Error())
+		if database.IsSQLiteUniqueViolation(err) {
+			return 0, nameTaken(name)
+		}
+		return 0, database.RepoErr(ctx, err)
+	}
+
+	return id, nil
+}
+
+func (repo *SQLiteRepo) UpdatePublisher(ctx context.Context, id int, name string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := database.SQLConn(ctx, repo.db).ExecContext(ctx, `UPDATE publishers SET name = @name WHERE id = @id`,
+		sql.Named("id", id), sql.Named("name", name))
+	if err != nil {
+		logger.Error(err.Error())
+		if database.IsSQLiteUniqueViolation(err) {
+			return nameTaken(name)
+		}
+		return database.RepoErr(ctx, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return publisherNotFound(id)
+	}
+
+	return nil
+}
+
+func (repo *SQLiteRepo) RemovePublisher(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	// transaction holds the database lock, so no edition can link to the publisher between the check and delete
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		var linked bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM editions WHERE publisher_id = @id)`,
+			sql.Named("id", id)).Scan(&linked)
+		if err != nil {
+			return err
+		}
+		if linked {
+			return publisherInUse(id)
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM publishers WHERE id = @id`, sql.Named("id", id))
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return publisherNotFound(id)
+		}
+		return nil
+	})
+
+	return database.TxErr(ctx, err)
+}
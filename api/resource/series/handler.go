@@ -0,0 +1,305 @@
+package series
+
+import (
+	"booksapi/api/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+func invalidParamErr(name string) database.APIError {
+	return database.APIError{
+		Status:  http.StatusBadRequest,
+		Message: fmt.Sprintf("invalid value for %s query parameter", name),
+	}
+}
+
+// pathID parses integer path parameter of given name
+func pathID(r *http.Request, name string) (int, *database.APIError) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		return 0, &database.APIError{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("only accept integer values as {%s} path parameter", name),
+		}
+	}
+	return id, nil
+}
+
+// parseSeriesQuery reads limit and offset query parameters, any other parameter is refused
+func parseSeriesQuery(values url.Values) (seriesQuery, *database.APIError) {
+	q := seriesQuery{
+		Limit: defaultPageLimit,
+	}
+
+	for name := range values {
+		if name != "limit" && name != "offset" {
+			return q, &database.APIError{
+				Status:  http.StatusBadRequest,
+				Message: fmt.Sprintf("unknown query parameter %s", name),
+			}
+		}
+	}
+
+	if values.Has("limit") {
+		limit, err := strconv.Atoi(values.Get("limit"))
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			e := invalidParamErr("limit")
+			return q, &e
+		}
+		q.Limit = limit
+	}
+
+	if values.Has("offset") {
+		offset, err := strconv.Atoi(values.Get("offset"))
+		if err != nil || offset < 0 {
+			e := invalidParamErr("offset")
+			return q, &e
+		}
+		q.Offset = offset
+	}
+
+	return q, nil
+}
+
+func decodeSeries(r *http.Request) (seriesRequestBody, *database.APIError) {
+	var req seriesRequestBody
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, &database.APIError{
+			Message: "invalid request model",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if err := req.validate(); err != nil {
+		return req, &database.APIError{
+			Message: err.Error(),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	return req, nil
+}
+
+type API struct {
+	repo ISeriesRepo
+}
+
+// New picks repository of the opened storage, books are needed by memory repo only
+func New(books BookFinder) API {
+	var repo ISeriesRepo
+	switch s := database.Get().(type) {
+	case *database.PostgresStorage:
+		repo = &SeriesRepo{pool: s.Pool}
+	case *database.SQLiteStorage:
+		repo = &SQLiteRepo{db: s.DB}
+	default:
+		repo = NewMemoryRepo(books)
+	}
+
+	return API{
+		repo: repo,
+	}
+}
+
+// FindSeries looks a series up for packages which link to series but can't join
+// series table, e.g. memory repository of books
+func (api API) FindSeries(ctx context.Context, id int) (name string, ok bool) {
+	s, err := api.repo.GetSeriesById(ctx, id)
+	if err != nil {
+		return "", false
+	}
+	return s.Name, true
+}
+
+// GetSeriesList returns a page of series
+//
+//	@Summary		Lists series
+//	@Description	lists series ordered by name
+//	@Tags			series
+//	@Produce		json
+//	@Param			limit	query		int	false	"page size, 20 by default, 100 at most"
+//	@Param			offset	query		int	false	"number of series to skip"
+//	@Success		200		{object}	seriesPageDTO
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Router			/api/series [get]
+func (api API) GetSeriesList(w http.ResponseWriter, r *http.Request) {
+	q, apiErr := parseSeriesQuery(r.URL.Query())
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	page, err := api.repo.GetSeriesList(r.Context(), q)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto := seriesPageDTO{
+		Items: make([]seriesDTO, 0, len(page.Series)),
+		Total: page.Total,
+	}
+	for _, s := range page.Series {
+		dto.Items = append(dto.Items, s.ToDto())
+	}
+
+	json, _ := json.Marshal(dto)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// AddSeries adds new series
+//
+//	@Summary		Add series
+//	@Description	adds series, books join it with seriesId and seriesPosition
+//	@Tags			series
+//	@Accept			json
+//	@Produce		json
+//	@Param			series	body		seriesRequestBody	true	"request body"
+//	@Success		201		{object}	ActionResponse
+//	@Failure		500		{object}	database.APIError
+//	@Failure		503		{object}	database.APIError
+//	@Failure		400		{object}	database.APIError
+//	@Router			/api/series [post]
+func (api API) AddSeries(w http.ResponseWriter, r *http.Request) {
+	req, apiErr := decodeSeries(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	id, err := api.repo.AddSeries(r.Context(), *req.Name)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	j, _ := json.Marshal(ActionResponse{ResourceId: id})
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, string(j[:]))
+}
+
+// GetSeries returns a series with its books
+//
+//	@Summary		Get series by id
+//	@Description	get series with its books in reading order, books without seriesPosition come last and trashed books are left out
+//	@Tags			series
+//	@Produce		json
+//	@Param			id	path		int	true	"Series ID"
+//	@Success		200	{object}	seriesBooksDTO
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/series/{id} [get]
+func (api API) GetSeries(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	series, err := api.repo.GetSeriesById(r.Context(), id)
+	if err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	dto := seriesBooksDTO{
+		seriesDTO: series.ToDto(),
+		Books:     make([]bookSummaryDTO, 0, len(series.Books)),
+	}
+	for _, b := range series.Books {
+		dto.Books = append(dto.Books, b.ToDto())
+	}
+
+	json, _ := json.Marshal(dto)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(json[:]))
+}
+
+// UpdateSeries renames a series
+//
+//	@Summary		Update series
+//	@Description	renames series
+//	@Tags			series
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path	int					true	"Series ID"
+//	@Param			series	body	seriesRequestBody	true	"request body"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Router			/api/series/{id} [patch]
+func (api API) UpdateSeries(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	req, apiErr := decodeSeries(r)
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	if err := api.repo.UpdateSeries(r.Context(), id, *req.Name); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveSeries deletes a series
+//
+//	@Summary		Remove series
+//	@Description	removes series which has no books, books in trash included
+//	@Tags			series
+//	@Produce		json
+//	@Param			id	path	int	true	"Series ID"
+//	@Success		204
+//	@Failure		500	{object}	database.APIError
+//	@Failure		503	{object}	database.APIError
+//	@Failure		400	{object}	database.APIError
+//	@Failure		404	{object}	database.APIError
+//	@Failure		409	{object}	database.APIError
+//	@Router			/api/series/{id} [delete]
+func (api API) RemoveSeries(w http.ResponseWriter, r *http.Request) {
+	id, apiErr := pathID(r, "id")
+	if apiErr != nil {
+		database.WriteAPIErr(*apiErr, w)
+		return
+	}
+
+	if err := api.repo.RemoveSeries(r.Context(), id); err != nil {
+		code := database.ErrStatus(err)
+		database.WriteErr(err, code, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
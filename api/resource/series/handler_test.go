@@ -0,0 +1,98 @@
+package series
+
+import (
+	"booksapi/api/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSeriesAPI(t *testing.T) {
+	api := API{repo: NewMemoryRepo(fakeBooks{
+		titles: map[int]string{1: "The Fellowship of the Ring", 2: "The Two Towers"},
+		order:  []int{1, 2},
+	})}
+
+	tcases := []struct {
+		method       string
+		url          string
+		pathValues   map[string]string
+		body         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		data         string
+		headerStatus int
+	}{
+		{
+			method:       "POST",
+			url:          "/api/series",
+			body:         `{"name":"  The Lord  of the Rings "}`,
+			handler:      api.AddSeries,
+			data:         `{"resourceId":1}`,
+			headerStatus: http.StatusCreated,
+		},
+		{
+			method:       "POST",
+			url:          "/api/series",
+			body:         `{"name":" "}`,
+			handler:      api.AddSeries,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "name is required, won't save the data"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "GET",
+			url:          "/api/series/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.GetSeries,
+			data:         `{"id":1,"name":"The Lord of the Rings","books":[{"id":1,"title":"The Fellowship of the Ring","releaseYear":null},{"id":2,"title":"The Two Towers","releaseYear":null}]}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "GET",
+			url:          "/api/series?sort=name",
+			handler:      api.GetSeriesList,
+			data:         database.APIError{Status: http.StatusBadRequest, Message: "unknown query parameter sort"}.Error(),
+			headerStatus: http.StatusBadRequest,
+		},
+		{
+			method:       "GET",
+			url:          "/api/series",
+			handler:      api.GetSeriesList,
+			data:         `{"items":[{"id":1,"name":"The Lord of the Rings"}],"total":1}`,
+			headerStatus: http.StatusOK,
+		},
+		{
+			method:       "DELETE",
+			url:          "/api/series/1",
+			pathValues:   map[string]string{"id": "1"},
+			handler:      api.RemoveSeries,
+			data:         database.APIError{Status: http.StatusConflict, Message: "series with id 1 still has books, trashed books included"}.Error(),
+			headerStatus: http.StatusConflict,
+		},
+		{
+			method:       "GET",
+			url:          "/api/series/2",
+			pathValues:   map[string]string{"id": "2"},
+			handler:      api.GetSeries,
+			data:         database.APIError{Status: http.StatusNotFound, Message: "series with id 2 not found"}.Error(),
+			headerStatus: http.StatusNotFound,
+		},
+	}
+
+	// cases run in order, each one sees changes of those before it
+	for _, tc := range tcases {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		for name, value := range tc.pathValues {
+			rq.SetPathValue(name, value)
+		}
+		tc.handler(w, rq)
+
+		if tc.data != w.Body.String() {
+			t.Errorf("%s %s failed\nexpected %v\ngot %v", tc.method, tc.url, tc.data, w.Body.String())
+		}
+		if tc.headerStatus != w.Code {
+			t.Errorf("%s %s response header failed\nexpected %v\ngot  %v", tc.method, tc.url, tc.headerStatus, w.Code)
+		}
+	}
+}
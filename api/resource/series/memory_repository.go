@@ -0,0 +1,150 @@
+package series
+
+import (
+	"booksapi/api/database"
+	"context"
+	"maps"
+	"sort"
+	"sync"
+)
+
+// BookFinder looks up books kept by books package, memory repo has no books table to join.
+// Books tell which series they belong to, so the series asks them for its books
+type BookFinder interface {
+	FindBook(ctx context.Context, id int) (title string, releaseYear *int, ok bool)
+	// SeriesBookIDs returns ids of live books of the series in reading order,
+	// inUse tells whether any book, trashed one included, belongs to the series
+	SeriesBookIDs(ctx context.Context, seriesID int) (ids []int, inUse bool)
+}
+
+// MemoryRepo keeps series in a map, it is meant for demos and local runs without postgres
+type MemoryRepo struct {
+	mu     sync.RWMutex
+	series map[int]seriesEntity
+	lastID int
+	books  BookFinder
+}
+
+func NewMemoryRepo(books BookFinder) *MemoryRepo {
+	return &MemoryRepo{
+		series: map[int]seriesEntity{},
+		books:  books,
+	}
+}
+
+// save copies series for a unit of work, the copy is put back when the unit of work fails
+func (repo *MemoryRepo) save() func() {
+	repo.mu.RLock()
+	series, lastID := maps.Clone(repo.series), repo.lastID
+	repo.mu.RUnlock()
+
+	return func() {
+		repo.mu.Lock()
+		defer repo.mu.Unlock()
+		repo.series, repo.lastID = series, lastID
+	}
+}
+
+func (repo *MemoryRepo) GetSeriesList(ctx context.Context, q seriesQuery) (seriesPage, error) {
+	if err := ctx.Err(); err != nil {
+		return seriesPage{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	all := make([]seriesEntity, 0, len(repo.series))
+	for _, s := range repo.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Name != all[j].Name {
+			return all[i].Name < all[j].Name
+		}
+		return all[i].ID < all[j].ID
+	})
+
+	start := min(q.Offset, len(all))
+	end := min(start+q.Limit, len(all))
+	return seriesPage{Series: all[start:end], Total: len(all)}, nil
+}
+
+func (repo *MemoryRepo) GetSeriesById(ctx context.Context, id int) (seriesEntity, error) {
+	if err := ctx.Err(); err != nil {
+		return seriesEntity{}, database.RepoErr(ctx, err)
+	}
+
+	repo.mu.RLock()
+	s, ok := repo.series[id]
+	repo.mu.RUnlock()
+	if !ok {
+		return s, seriesNotFound(id)
+	}
+
+	s.Books = make([]bookSummary, 0)
+	ids, _ := repo.books.SeriesBookIDs(ctx, id)
+	for _, bookID := range ids {
+		if title, releaseYear, ok := repo.books.FindBook(ctx, bookID); ok {
+			s.Books = append(s.Books, bookSummary{ID: bookID, Title: title, ReleaseYear: releaseYear})
+		}
+	}
+
+	return s, nil
+}
+
+func (repo *MemoryRepo) AddSeries(ctx context.Context, name string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.lastID++
+	repo.series[repo.lastID] = seriesEntity{ID: repo.lastID, Name: name}
+
+	return repo.lastID, nil
+}
+
+func (repo *MemoryRepo) UpdateSeries(ctx context.Context, id int, name string) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	s, ok := repo.series[id]
+	if !ok {
+		return seriesNotFound(id)
+	}
+	s.Name = name
+	repo.series[id] = s
+
+	return nil
+}
+
+func (repo *MemoryRepo) RemoveSeries(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return database.RepoErr(ctx, err)
+	}
+	ctx, done := database.MemoryWrite(ctx, repo, repo.save)
+	defer done()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.series[id]; !ok {
+		return seriesNotFound(id)
+	}
+	if _, inUse := repo.books.SeriesBookIDs(ctx, id); inUse {
+		return seriesInUse(id)
+	}
+	delete(repo.series, id)
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package series
+
+import (
+	"booksapi/api/database"
+	"strings"
+)
+
+// seriesRequestBody adds or renames a series
+type seriesRequestBody struct {
+	Name *string `json:"name"`
+}
+
+// validate trims the name and checks it is set
+func (b *seriesRequestBody) validate() error {
+	if b.Name == nil || strings.TrimSpace(*b.Name) == "" {
+		return database.BadRequestErr{Message: "name is required, won't save the data"}
+	}
+	name := strings.Join(strings.Fields(*b.Name), " ")
+	b.Name = &name
+	return nil
+}
+
+// seriesEntity is a series of books, Books are its live books in reading order
+// and are read for a single series only
+type seriesEntity struct {
+	ID    int
+	Name  string
+	Books []bookSummary
+}
+
+func (s seriesEntity) ToDto() seriesDTO {
+	return seriesDTO{
+		ID:   s.ID,
+		Name: s.Name,
+	}
+}
+
+type seriesDTO struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type seriesBooksDTO struct {
+	seriesDTO
+	Books []bookSummaryDTO `json:"books"`
+}
+
+// bookSummary is a live book of a series
+type bookSummary struct {
+	ID          int
+	Title       string
+	ReleaseYear *int
+}
+
+func (b bookSummary) ToDto() bookSummaryDTO {
+	return bookSummaryDTO{
+		ID:          b.ID,
+		Title:       b.Title,
+		ReleaseYear: b.ReleaseYear,
+	}
+}
+
+type bookSummaryDTO struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	ReleaseYear *int   `json:"releaseYear"`
+}
+
+// seriesQuery selects a page of series ordered by name
+type seriesQuery struct {
+	Limit  int
+	Offset int
+}
+
+type seriesPage struct {
+	Series []seriesEntity
+	Total  int
+}
+
+type seriesPageDTO struct {
+	Items []seriesDTO `json:"items"`
+	Total int         `json:"total"`
+}
+
+type ActionResponse struct {
+	ResourceId int `json:"resourceId"`
+}
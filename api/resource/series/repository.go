@@ -0,0 +1,158 @@
+package series
+
+import (
+	"booksapi/api/database"
+	"booksapi/logger"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ISeriesRepo interface {
+	// GetSeriesList lists series ordered by name
+	GetSeriesList(ctx context.Context, q seriesQuery) (seriesPage, error)
+	// GetSeriesById finds series with its live books in reading order, books without position come last
+	GetSeriesById(ctx context.Context, id int) (seriesEntity, error)
+	AddSeries(ctx context.Context, name string) (int, error)
+	UpdateSeries(ctx context.Context, id int, name string) error
+	// RemoveSeries fails with ConflictErr while any book, trashed one included, belongs to the series
+	RemoveSeries(ctx context.Context, id int) error
+}
+
+func seriesNotFound(id int) database.NotFoundErr {
+	return database.NotFoundErr{Message: fmt.Sprintf("series with id %d not found", id)}
+}
+
+func seriesInUse(id int) database.ConflictErr {
+	return database.ConflictErr{Message: fmt.Sprintf("series with id %d still has books, trashed books included", id)}
+}
+
+// SeriesRepo keeps series in postgres, books join them by series_id
+type SeriesRepo struct {
+	pool *pgxpool.Pool
+}
+
+func (repo *SeriesRepo) GetSeriesList(ctx context.Context, q seriesQuery) (seriesPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := seriesPage{
+		Series: make([]seriesEntity, 0),
+	}
+
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT count(*) FROM public.series`).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, `SELECT id, name FROM public.series ORDER BY name, id LIMIT @limit OFFSET @offset`,
+		pgx.NamedArgs{"limit": q.Limit, "offset": q.Offset})
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	page.Series, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (seriesEntity, error) {
+		var s seriesEntity
+		err := row.Scan(&s.ID, &s.Name)
+		return s, err
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *SeriesRepo) GetSeriesById(ctx context.Context, id int) (seriesEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var s seriesEntity
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `SELECT id, name FROM public.series WHERE id = @id`,
+		pgx.NamedArgs{"id": id}).Scan(&s.ID, &s.Name)
+	if err != nil {
+		logger.Error(err.Error())
+		if errors.Is(err, pgx.ErrNoRows) {
+			return s, seriesNotFound(id)
+		}
+		return s, database.RepoErr(ctx, err)
+	}
+
+	query := `SELECT id, title, release_year FROM public.books
+              WHERE series_id = @id AND deleted_at IS NULL
+              ORDER BY series_position NULLS LAST, id`
+	rows, err := database.PgxConn(ctx, repo.pool).Query(ctx, query, pgx.NamedArgs{"id": id})
+	if err != nil {
+		logger.Error(err.Error())
+		return s, database.RepoErr(ctx, err)
+	}
+
+	s.Books, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (bookSummary, error) {
+		var b bookSummary
+		err := row.Scan(&b.ID, &b.Title, &b.ReleaseYear)
+		return b, err
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return s, database.RepoErr(ctx, err)
+	}
+
+	return s, nil
+}
+
+func (repo *SeriesRepo) AddSeries(ctx context.Context, name string) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := database.PgxConn(ctx, repo.pool).QueryRow(ctx, `INSERT INTO public.series (name) VALUES (@name) RETURNING id`,
+		pgx.NamedArgs{"name": name}).Scan(&id)
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, database.RepoErr(ctx, err)
+	}
+
+	return id, nil
+}
+
+func (repo *SeriesRepo) UpdateSeries(ctx context.Context, id int, name string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	tag, err := database.PgxConn(ctx, repo.pool).Exec(ctx, `UPDATE public.series SET name = @name WHERE id = @id`,
+		pgx.NamedArgs{"id": id, "name": name})
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return seriesNotFound(id)
+	}
+
+	return nil
+}
+
+func (repo *SeriesRepo) RemoveSeries(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	tag, err := database.PgxConn(ctx, repo.pool).Exec(ctx, `DELETE FROM public.series WHERE id = @id`, pgx.NamedArgs{"id": id})
+	if database.IsForeignKeyViolation(err) {
+		return seriesInUse(id)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return seriesNotFound(id)
+	}
+
+	return nil
+}
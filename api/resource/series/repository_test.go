@@ -0,0 +1,143 @@
+package series
+
+import (
+	"booksapi/api/database"
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// repository tests run the same scenarios against every ISeriesRepo implementation
+// which does not need an external server
+
+var ctx = context.Background()
+
+// fakeBooks is BookFinder of memory repo, books belong to series 1
+type fakeBooks struct {
+	titles map[int]string
+	// order holds ids of live books of series 1 in reading order
+	order []int
+	// trashed tells that series 1 has a trashed book
+	trashed bool
+}
+
+func (b fakeBooks) FindBook(_ context.Context, id int) (string, *int, bool) {
+	title, ok := b.titles[id]
+	return title, nil, ok
+}
+
+func (b fakeBooks) SeriesBookIDs(_ context.Context, seriesID int) ([]int, bool) {
+	if seriesID != 1 {
+		return nil, false
+	}
+	return b.order, len(b.order) > 0 || b.trashed
+}
+
+func newSQLiteStorage(t *testing.T) *database.SQLiteStorage {
+	s, err := database.OpenSQLite(filepath.Join(t.TempDir(), "books.db"))
+	if err != nil {
+		t.Fatalf("could not open sqlite database %s", err.Error())
+	}
+	t.Cleanup(s.Close)
+
+	if err := database.MigrateUp(ctx, s); err != nil {
+		t.Fatalf("could not migrate sqlite database %s", err.Error())
+	}
+
+	return s
+}
+
+// testRepos have series 1 with The Two Towers without position, The Fellowship of the Ring
+// at position 1 and The Return of the King trashed, series 2 has no books
+func testRepos(t *testing.T) map[string]ISeriesRepo {
+	s := newSQLiteStorage(t)
+	for _, name := range []string{"The Lord of the Rings", "Dune Chronicles"} {
+		if _, err := s.DB.Exec(`INSERT INTO series (name) VALUES (?)`, name); err != nil {
+			t.Fatalf("could not insert series %s", err.Error())
+		}
+	}
+	books := []struct {
+		title    string
+		position any
+		trashed  bool
+	}{
+		{title: "The Two Towers"},
+		{title: "The Fellowship of the Ring", position: 1},
+		{title: "The Return of the King", position: 3, trashed: true},
+	}
+	for _, b := range books {
+		if _, err := s.DB.Exec(`INSERT INTO books (title, author, series_id, series_position) VALUES (?, 'JRR Tolkien', 1, ?)`,
+			b.title, b.position); err != nil {
+			t.Fatalf("could not insert book %s", err.Error())
+		}
+	}
+	s.DB.Exec(`UPDATE books SET deleted_at = '2024-01-01 00:00:00.000' WHERE id = 3`)
+
+	memory := NewMemoryRepo(fakeBooks{
+		titles:  map[int]string{1: "The Two Towers", 2: "The Fellowship of the Ring"},
+		order:   []int{2, 1},
+		trashed: true,
+	})
+	for _, name := range []string{"The Lord of the Rings", "Dune Chronicles"} {
+		if _, err := memory.AddSeries(ctx, name); err != nil {
+			t.Fatalf("AddSeries failed\nunexpected error %s", err.Error())
+		}
+	}
+
+	return map[string]ISeriesRepo{
+		"memory": memory,
+		"sqlite": &SQLiteRepo{db: s.DB},
+	}
+}
+
+func bookIDs(books []bookSummary) []int {
+	ids := make([]int, 0, len(books))
+	for _, b := range books {
+		ids = append(ids, b.ID)
+	}
+	return ids
+}
+
+func TestRepoSeries(t *testing.T) {
+	for name, repo := range testRepos(t) {
+		var conflict database.ConflictErr
+		var nf database.NotFoundErr
+
+		// books without position come last, trashed books are left out
+		s, err := repo.GetSeriesById(ctx, 1)
+		if err != nil || s.Name != "The Lord of the Rings" || !slices.Equal(bookIDs(s.Books), []int{2, 1}) {
+			t.Errorf("%s GetSeriesById failed\nexpected books [2 1]\ngot %+v, %v", name, s, err)
+		}
+		if s.Books[0].Title != "The Fellowship of the Ring" {
+			t.Errorf("%s GetSeriesById must return titles of books\ngot %+v", name, s.Books)
+		}
+
+		page, err := repo.GetSeriesList(ctx, seriesQuery{Limit: 1})
+		if err != nil || page.Total != 2 || len(page.Series) != 1 || page.Series[0].ID != 2 {
+			t.Errorf("%s GetSeriesList failed\nexpected series 2 of 2\ngot %+v, %v", name, page, err)
+		}
+
+		if err := repo.UpdateSeries(ctx, 2, "Dune Saga"); err != nil {
+			t.Errorf("%s UpdateSeries failed\nunexpected error %s", name, err.Error())
+		}
+		if s, _ := repo.GetSeriesById(ctx, 2); s.Name != "Dune Saga" {
+			t.Errorf("%s UpdateSeries must rename the series\ngot %+v", name, s)
+		}
+		if err := repo.UpdateSeries(ctx, 99, "Foundation"); !errors.As(err, &nf) {
+			t.Errorf("%s UpdateSeries expected NotFoundErr\ngot %v", name, err)
+		}
+
+		// trashed book keeps the series from removal
+		if err := repo.RemoveSeries(ctx, 1); !errors.As(err, &conflict) {
+			t.Errorf("%s RemoveSeries expected ConflictErr for series with books\ngot %v", name, err)
+		}
+		if err := repo.RemoveSeries(ctx, 2); err != nil {
+			t.Errorf("%s RemoveSeries failed\nunexpected error %s", name, err.Error())
+		}
+		if _, err := repo.GetSeriesById(ctx, 2); !errors.As(err, &nf) {
+			t.Errorf("%s GetSeriesById expected NotFoundErr for removed series\ngot %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package series
+
+import (
+	"booksapi/api/database"
+	"booksapi/logger"
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// SQLiteRepo keeps series in sqlite database
+type SQLiteRepo struct {
+	db *sql.DB
+}
+
+func (repo *SQLiteRepo) GetSeriesList(ctx context.Context, q seriesQuery) (seriesPage, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	page := seriesPage{
+		Series: make([]seriesEntity, 0),
+	}
+
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT count(*) FROM series`).Scan(&page.Total)
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, `SELECT id, name FROM series ORDER BY name, id LIMIT @limit OFFSET @offset`,
+		sql.Named("limit", q.Limit), sql.Named("offset", q.Offset))
+	if err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s seriesEntity
+		if err := rows.Scan(&s.ID, &s.Name); err != nil {
+			logger.Error(err.Error())
+			return page, database.RepoErr(ctx, err)
+		}
+		page.Series = append(page.Series, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return page, database.RepoErr(ctx, err)
+	}
+
+	return page, nil
+}
+
+func (repo *SQLiteRepo) GetSeriesById(ctx context.Context, id int) (seriesEntity, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var s seriesEntity
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `SELECT id, name FROM series WHERE id = @id`, sql.Named("id", id)).
+		Scan(&s.ID, &s.Name)
+	if err != nil {
+		logger.Error(err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return s, seriesNotFound(id)
+		}
+		return s, database.RepoErr(ctx, err)
+	}
+
+	query := `SELECT id, title, release_year FROM books
+              WHERE series_id = @id AND deleted_at IS NULL
+              ORDER BY series_position IS NULL, series_position, id`
+	rows, err := database.SQLConn(ctx, repo.db).QueryContext(ctx, query, sql.Named("id", id))
+	if err != nil {
+		logger.Error(err.Error())
+		return s, database.RepoErr(ctx, err)
+	}
+	defer rows.Close()
+
+	s.Books = make([]bookSummary, 0)
+	for rows.Next() {
+		var b bookSummary
+		if err := rows.Scan(&b.ID, &b.Title, &b.ReleaseYear); err != nil {
+			logger.Error(err.Error())
+			return s, database.RepoErr(ctx, err)
+		}
+		s.Books = append(s.Books, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(err.Error())
+		return s, database.RepoErr(ctx, err)
+	}
+
+	return s, nil
+}
+
+func (repo *SQLiteRepo) AddSeries(ctx context.Context, name string) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := database.SQLConn(ctx, repo.db).QueryRowContext(ctx, `INSERT INTO series (name) VALUES (@name) RETURNING id`,
+		sql.Named("name", name)).Scan(&id)
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, database.RepoErr(ctx, err)
+	}
+
+	return id, nil
+}
+
+func (repo *SQLiteRepo) UpdateSeries(ctx context.Context, id int, name string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := database.SQLConn(ctx, repo.db).ExecContext(ctx, `UPDATE series SET name = @name WHERE id = @id`,
+		sql.Named("id", id), sql.Named("name", name))
+	if err != nil {
+		logger.Error(err.Error())
+		return database.RepoErr(ctx, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return seriesNotFound(id)
+	}
+
+	return nil
+}
+
+func (repo *SQLiteRepo) RemoveSeries(ctx context.Context, id int) error {
+	ctx, cancel := database.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := database.RunSQLTx(ctx, repo.db, func(ctx context.Context, tx *sql.Tx) error {
+		var used bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM books WHERE series_id = @id)`, sql.Named("id", id)).
+			Scan(&used)
+		if err != nil {
+			return err
+		}
+		if used {
+			return seriesInUse(id)
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM series WHERE id = @id`, sql.Named("id", id))
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return seriesNotFound(id)
+		}
+		return nil
+	})
+
+	return database.TxErr(ctx, err)
+}
@@ -52,6 +52,12 @@ func (api API) HandleHealth(w http.ResponseWriter, r *http.Request) {
 		{
 			Name: func() string {
 				db := config.GetAppsettings().Database
+				switch database.Driver() {
+				case database.DriverMemory:
+					return database.DriverMemory
+				case database.DriverSQLite:
+					return database.DriverSQLite
+				}
 				return db.Db
 			}(),
 			HealthStatus: func() struct {
@@ -75,6 +81,12 @@ func (api API) HandleHealth(w http.ResponseWriter, r *http.Request) {
 			}(),
 			Address: func() string {
 				db := config.GetAppsettings().Database
+				switch database.Driver() {
+				case database.DriverMemory:
+					return "in-process"
+				case database.DriverSQLite:
+					return db.Path
+				}
 				return fmt.Sprintf("%s:%d/%s", db.Host, db.Port, db.Db)
 			}(),
 		},
@@ -2,12 +2,48 @@ package middlewares
 
 import (
 	"booksapi/api/router"
+	"booksapi/config"
 	"booksapi/logger"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/google/uuid"
 )
 
+type contextKey string
+
+const (
+	requestIDKey contextKey = "requestID"
+	actorKey     contextKey = "actor"
+)
+
+// RequestIDFromContext returns id RequestID middleware gave to the request, empty string outside of one
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// ActorFromContext returns name Actor middleware stored for the request, empty string outside of one
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey).(string)
+	return actor
+}
+
+// SystemActor is recorded as actor of changes made outside of a request
+const SystemActor = "system"
+
+// AuditActor returns actor to record for a change made with ctx, SystemActor outside of a request
+func AuditActor(ctx context.Context) string {
+	if actor := ActorFromContext(ctx); actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
 func ContentTypeJSON(next http.Handler) http.Handler {
 	const (
 		HeaderKeyContentType       = "Content-Type"
@@ -29,7 +65,31 @@ func RequestID(next http.Handler) http.Handler {
 		xRequestID := uuid.NewString()
 		w.Header().Set(XRequestIDKey, xRequestID)
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), requestIDKey, xRequestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Actor stores who makes the request in its context, api has no authentication
+// so the name is taken from X-Actor header as is
+func Actor(next http.Handler) http.Handler {
+	const (
+		XActorKey    = "X-Actor"
+		maxActorLen  = 200
+		defaultActor = "anonymous"
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := strings.TrimSpace(r.Header.Get(XActorKey))
+		if actor == "" {
+			actor = defaultActor
+		}
+		if len(actor) > maxActorLen {
+			actor = actor[:maxActorLen]
+		}
+
+		ctx := context.WithValue(r.Context(), actorKey, actor)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
@@ -44,3 +104,35 @@ func LogRequestResponse(next http.Handler) http.Handler {
 		next.ServeHTTP(rww, r)
 	})
 }
+
+// AdminOnly lets through requests carrying admin api key from appsettings in X-Admin-Key header,
+// every request is refused when no key is configured
+func AdminOnly(next http.Handler) http.Handler {
+	const (
+		XAdminKey = "X-Admin-Key"
+	)
+
+	forbid := func(w http.ResponseWriter, message string) {
+		body, _ := json.Marshal(struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		}{http.StatusForbidden, message})
+
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, string(body))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := config.GetAppsettings().Admin.ApiKey
+		if key == "" {
+			forbid(w, "admin endpoints are disabled")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(XAdminKey)), []byte(key)) != 1 {
+			forbid(w, "valid admin key is required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -10,21 +10,27 @@ type ResponseWriterWrapper struct {
 	W          *http.ResponseWriter
 	Body       *bytes.Buffer
 	StatusCode *int
+	// SkipBody is set by handlers which stream big responses, see SkipBodyCapture
+	SkipBody *bool
 }
 
 func NewResponseWriterWrapper(w http.ResponseWriter) ResponseWriterWrapper {
 	var buf bytes.Buffer
 	var statusCode = 200
+	var skipBody = false
 	return ResponseWriterWrapper{
 		W:          &w,
 		Body:       &buf,
 		StatusCode: &statusCode,
+		SkipBody:   &skipBody,
 	}
 }
 
 // overwrites Write() function
 func (rww ResponseWriterWrapper) Write(buf []byte) (int, error) {
-	rww.Body.Write(buf)
+	if !*rww.SkipBody {
+		rww.Body.Write(buf)
+	}
 	return (*rww.W).Write(buf)
 }
 
@@ -38,3 +44,24 @@ func (rww ResponseWriterWrapper) WriteHeader(statusCode int) {
 	(*rww.StatusCode) = statusCode
 	(*rww.W).WriteHeader(statusCode)
 }
+
+// Unwrap lets http.ResponseController reach flushing and deadlines of the wrapped writer
+func (rww ResponseWriterWrapper) Unwrap() http.ResponseWriter {
+	return *rww.W
+}
+
+// SkipBodyCapture stops logging middleware from keeping a copy of response body written to w,
+// streaming handlers call it before writing. It does nothing when w is not wrapped for logging
+func SkipBodyCapture(w http.ResponseWriter) {
+	for {
+		switch v := w.(type) {
+		case ResponseWriterWrapper:
+			*v.SkipBody = true
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return
+		}
+	}
+}
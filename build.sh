@@ -1,4 +1,4 @@
 #!/bin/sh
 
-swag init -d cmd/api/,api/resource/system/,api/resource/books/
+swag init -d cmd/api/,api/resource/system/,api/resource/books/,api/resource/authors/,api/resource/genres/,api/resource/publishers/,api/resource/series/,api/resource/cart/,api/database/
 go build -C ./cmd/api/ -v -o ../../main -ldflags "-X main.compileDate=`date +%Y/%m/%d:%H:%M.%S`"
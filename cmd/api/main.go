@@ -2,7 +2,12 @@ package main
 
 import (
 	"booksapi/api/database"
+	"booksapi/api/resource/authors"
 	"booksapi/api/resource/books"
+	"booksapi/api/resource/cart"
+	"booksapi/api/resource/genres"
+	"booksapi/api/resource/publishers"
+	"booksapi/api/resource/series"
 	"booksapi/api/resource/system"
 	"booksapi/api/router"
 	"booksapi/config"
@@ -10,8 +15,10 @@ import (
 
 	"booksapi/api/router/middlewares"
 	"booksapi/docs"
+	"context"
 	"fmt"
 	"net/http"
+	"os"
 	"time"
 
 	httpSwagger "github.com/swaggo/http-swagger"
@@ -24,6 +31,19 @@ func main() {
 	logger.Init()
 	database.Init()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		code := runMigrate(os.Args[2:])
+		database.Close()
+		os.Exit(code)
+	}
+
+	if database.Driver() != database.DriverMemory && config.GetAppsettings().Database.MigrateOnStartup {
+		if err := database.MigrateUp(context.Background(), database.Get()); err != nil {
+			logger.Error(fmt.Sprintf("ERROR applying migrations -> %s", err.Error()))
+			os.Exit(1)
+		}
+	}
+
 	logger.Info("APPLICATION HAS STARTED")
 
 	docs.SwaggerInfo.Title = "Books store API"
@@ -32,6 +52,40 @@ func main() {
 
 	conf := config.GetAppsettings().Config
 
+	genresApi := genres.New()
+	publishersApi := publishers.New()
+	booksApi := books.New(genresApi, publishersApi)
+	genresApi.UseBooks(booksApi)
+	stockApi := booksApi.Stock()
+	pricesApi := booksApi.Prices()
+	reviewsApi := booksApi.Reviews()
+	seriesApi := series.New(booksApi)
+	booksApi.UseSeries(seriesApi)
+	authorsApi := authors.New(booksApi)
+	booksApi.UseAuthors(authorsApi)
+	cartApi := cart.New(booksApi)
+
+	if trash := config.GetAppsettings().Trash; trash.RetentionDays > 0 {
+		interval := time.Duration(trash.PurgeInterval * int(time.Minute))
+		if interval <= 0 {
+			interval = time.Hour
+		}
+		retention := time.Duration(trash.RetentionDays * 24 * int(time.Hour))
+		go booksApi.PurgeTrashPeriodically(context.Background(), retention, interval)
+	}
+
+	scheduleInterval := time.Duration(config.GetAppsettings().Prices.ScheduleInterval * int(time.Second))
+	if scheduleInterval <= 0 {
+		scheduleInterval = time.Minute
+	}
+	go pricesApi.ApplyScheduledPricesPeriodically(context.Background(), scheduleInterval)
+
+	cartPurgeInterval := time.Duration(config.GetAppsettings().Cart.PurgeInterval * int(time.Minute))
+	if cartPurgeInterval <= 0 {
+		cartPurgeInterval = time.Hour
+	}
+	go cartApi.PurgeExpiredPeriodically(context.Background(), cartPurgeInterval)
+
 	router := router.CreateAndSetup(func(this *router.CustomMux) *router.CustomMux {
 		this.Use(middlewares.ContentTypeJSON)
 
@@ -48,14 +102,86 @@ func main() {
 
 		this.AddGroup("/api/", func(ng *router.Group) {
 			ng.Use(middlewares.RequestID)
+			ng.Use(middlewares.Actor)
 			ng.Use(middlewares.LogRequestResponse)
 
-			booksApi := books.New()
-
 			ng.HandleRouteFunc("GET /books", func(w http.ResponseWriter, r *http.Request) {
 				booksApi.GetBooks(w, r)
 			})
 
+			ng.HandleRouteFunc("GET /books/search", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.SearchBooks(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /books/trash", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.GetTrash(w, r)
+			})
+
+			ng.HandleRoute("DELETE /books/trash", middlewares.AdminOnly(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					booksApi.PurgeTrash(w, r)
+				})))
+
+			ng.HandleRouteFunc("POST /books/{id}/restore", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.RestoreBook(w, r)
+			})
+
+			ng.HandleRouteFunc("POST /books/import", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.ImportBooks(w, r)
+			})
+
+			ng.HandleRouteFunc("POST /books/batch", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.BatchBooks(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /books/export", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.ExportBooks(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /books/{id}/history", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.GetBookHistory(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /books/{id}/editions", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.GetEditions(w, r)
+			})
+
+			ng.HandleRouteFunc("POST /books/{id}/editions", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.AddEdition(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /books/{id}/stock", func(w http.ResponseWriter, r *http.Request) {
+				stockApi.GetStock(w, r)
+			})
+
+			ng.HandleRouteFunc("POST /books/{id}/stock/adjust", func(w http.ResponseWriter, r *http.Request) {
+				stockApi.AdjustStock(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /books/{id}/stock/movements", func(w http.ResponseWriter, r *http.Request) {
+				stockApi.GetStockMovements(w, r)
+			})
+
+			ng.HandleRouteFunc("POST /books/{id}/stock/reservations", func(w http.ResponseWriter, r *http.Request) {
+				stockApi.ReserveStock(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /books/{id}/prices", func(w http.ResponseWriter, r *http.Request) {
+				pricesApi.GetPrices(w, r)
+			})
+
+			ng.HandleRouteFunc("POST /books/{id}/prices", func(w http.ResponseWriter, r *http.Request) {
+				pricesApi.SchedulePrice(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /books/{id}/reviews", func(w http.ResponseWriter, r *http.Request) {
+				reviewsApi.GetBookReviews(w, r)
+			})
+
+			ng.HandleRouteFunc("POST /books/{id}/reviews", func(w http.ResponseWriter, r *http.Request) {
+				reviewsApi.AddReview(w, r)
+			})
+
 			ng.HandleRouteFunc("GET /books/{id}", func(w http.ResponseWriter, r *http.Request) {
 				booksApi.GetBook(w, r)
 			})
@@ -72,6 +198,156 @@ func main() {
 				booksApi.UpdateBook(w, r)
 			})
 
+			ng.HandleRouteFunc("POST /carts", func(w http.ResponseWriter, r *http.Request) {
+				cartApi.AddCart(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /carts/{id}", func(w http.ResponseWriter, r *http.Request) {
+				cartApi.GetCart(w, r)
+			})
+
+			ng.HandleRouteFunc("DELETE /carts/{id}", func(w http.ResponseWriter, r *http.Request) {
+				cartApi.RemoveCart(w, r)
+			})
+
+			ng.HandleRouteFunc("PUT /carts/{id}/lines/{bookId}", func(w http.ResponseWriter, r *http.Request) {
+				cartApi.SetLine(w, r)
+			})
+
+			ng.HandleRouteFunc("DELETE /carts/{id}/lines/{bookId}", func(w http.ResponseWriter, r *http.Request) {
+				cartApi.RemoveLine(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /authors", func(w http.ResponseWriter, r *http.Request) {
+				authorsApi.GetAuthors(w, r)
+			})
+
+			ng.HandleRouteFunc("POST /authors", func(w http.ResponseWriter, r *http.Request) {
+				authorsApi.AddAuthor(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /authors/{id}", func(w http.ResponseWriter, r *http.Request) {
+				authorsApi.GetAuthor(w, r)
+			})
+
+			ng.HandleRouteFunc("PATCH /authors/{id}", func(w http.ResponseWriter, r *http.Request) {
+				authorsApi.UpdateAuthor(w, r)
+			})
+
+			ng.HandleRouteFunc("DELETE /authors/{id}", func(w http.ResponseWriter, r *http.Request) {
+				authorsApi.RemoveAuthor(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /authors/{id}/books", func(w http.ResponseWriter, r *http.Request) {
+				authorsApi.GetAuthorBooks(w, r)
+			})
+
+			ng.HandleRouteFunc("PUT /authors/{id}/books/{bookId}", func(w http.ResponseWriter, r *http.Request) {
+				authorsApi.LinkBook(w, r)
+			})
+
+			ng.HandleRouteFunc("DELETE /authors/{id}/books/{bookId}", func(w http.ResponseWriter, r *http.Request) {
+				authorsApi.UnlinkBook(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /genres", func(w http.ResponseWriter, r *http.Request) {
+				genresApi.GetGenres(w, r)
+			})
+
+			ng.HandleRouteFunc("POST /genres", func(w http.ResponseWriter, r *http.Request) {
+				genresApi.AddGenre(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /genres/{id}", func(w http.ResponseWriter, r *http.Request) {
+				genresApi.GetGenre(w, r)
+			})
+
+			ng.HandleRouteFunc("PATCH /genres/{id}", func(w http.ResponseWriter, r *http.Request) {
+				genresApi.UpdateGenre(w, r)
+			})
+
+			ng.HandleRouteFunc("DELETE /genres/{id}", func(w http.ResponseWriter, r *http.Request) {
+				genresApi.RemoveGenre(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /publishers", func(w http.ResponseWriter, r *http.Request) {
+				publishersApi.GetPublishers(w, r)
+			})
+
+			ng.HandleRouteFunc("POST /publishers", func(w http.ResponseWriter, r *http.Request) {
+				publishersApi.AddPublisher(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /publishers/{id}", func(w http.ResponseWriter, r *http.Request) {
+				publishersApi.GetPublisher(w, r)
+			})
+
+			ng.HandleRouteFunc("PATCH /publishers/{id}", func(w http.ResponseWriter, r *http.Request) {
+				publishersApi.UpdatePublisher(w, r)
+			})
+
+			ng.HandleRouteFunc("DELETE /publishers/{id}", func(w http.ResponseWriter, r *http.Request) {
+				publishersApi.RemovePublisher(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /editions/{id}", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.GetEdition(w, r)
+			})
+
+			ng.HandleRouteFunc("PATCH /editions/{id}", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.UpdateEdition(w, r)
+			})
+
+			ng.HandleRouteFunc("DELETE /editions/{id}", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.RemoveEdition(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /series", func(w http.ResponseWriter, r *http.Request) {
+				seriesApi.GetSeriesList(w, r)
+			})
+
+			ng.HandleRouteFunc("POST /series", func(w http.ResponseWriter, r *http.Request) {
+				seriesApi.AddSeries(w, r)
+			})
+
+			ng.HandleRouteFunc("GET /series/{id}", func(w http.ResponseWriter, r *http.Request) {
+				seriesApi.GetSeries(w, r)
+			})
+
+			ng.HandleRouteFunc("PATCH /series/{id}", func(w http.ResponseWriter, r *http.Request) {
+				seriesApi.UpdateSeries(w, r)
+			})
+
+			ng.HandleRouteFunc("DELETE /series/{id}", func(w http.ResponseWriter, r *http.Request) {
+				seriesApi.RemoveSeries(w, r)
+			})
+
+			ng.HandleRouteFunc("DELETE /reservations/{id}", func(w http.ResponseWriter, r *http.Request) {
+				stockApi.ReleaseReservation(w, r)
+			})
+
+			ng.HandleRoute("GET /reviews", middlewares.AdminOnly(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					reviewsApi.GetReviews(w, r)
+				})))
+
+			ng.HandleRoute("PATCH /reviews/{id}", middlewares.AdminOnly(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					reviewsApi.ModerateReview(w, r)
+				})))
+
+		})
+
+		// isbn lookup has a group of its own, GET /books/isbn/{isbn} would conflict with GET /books/{id}/history
+		// inside /api/ group. Prefix of this group is longer, so it takes the requests before /api/ does
+		this.AddGroup("/api/books/isbn/", func(ng *router.Group) {
+			ng.Use(middlewares.RequestID)
+			ng.Use(middlewares.Actor)
+			ng.Use(middlewares.LogRequestResponse)
+
+			ng.HandleRouteFunc("GET /{isbn}", func(w http.ResponseWriter, r *http.Request) {
+				booksApi.GetBookByISBN(w, r)
+			})
 		})
 
 		this.HandleFunc("GET /swagger/*", httpSwagger.Handler(
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"booksapi/api/database"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const migrateUsage = `usage: main migrate <command>
+
+commands:
+  up           apply all pending migrations
+  down [n]     revert n most recently applied migrations, 1 by default
+  status       list migrations and when they were applied`
+
+// runMigrate handles "migrate" subcommand and returns process exit code
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(migrateUsage)
+		return 2
+	}
+
+	ctx := context.Background()
+	var err error
+
+	switch args[0] {
+	case "up":
+		err = database.MigrateUp(ctx, database.Get())
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				fmt.Println(migrateUsage)
+				return 2
+			}
+		}
+		err = database.MigrateDown(ctx, database.Get(), steps)
+	case "status":
+		var statuses []database.MigrationStatus
+		statuses, err = database.GetMigrationStatus(ctx, database.Get())
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-40s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		fmt.Println(migrateUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate %s failed: %s\n", args[0], err.Error())
+		return 1
+	}
+	return 0
+}
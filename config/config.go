@@ -9,6 +9,12 @@ type Appsettings struct {
 	Config   Config
 	Logging  Logging
 	Database Database
+	Admin    Admin
+	Trash    Trash
+	Stock    Stock
+	Money    Money
+	Prices   Prices
+	Cart     Cart
 }
 
 type Config struct {
@@ -23,11 +29,56 @@ type Logging struct {
 }
 
 type Database struct {
-	User string
-	Pass string
-	Host string
-	Db   string
-	Port uint16
+	Driver           string
+	User             string
+	Pass             string
+	Host             string
+	Db               string
+	Port             uint16
+	Path             string
+	MigrateOnStartup bool
+	QueryTimeout     int
+}
+
+// Admin endpoints are disabled while ApiKey is empty
+type Admin struct {
+	ApiKey string
+}
+
+// Trash configures purging of soft deleted books,
+// books are kept forever when RetentionDays is 0
+type Trash struct {
+	RetentionDays int
+	// PurgeInterval is number of minutes between purge runs
+	PurgeInterval int
+}
+
+// Stock configures inventory, ReservationMinutes is how long a reservation holds copies,
+// 15 minutes when it is not set
+type Stock struct {
+	ReservationMinutes int
+}
+
+// Money configures prices. IntegerPrices is compatibility mode for clients from the time prices
+// were integers: prices without currency are taken in DefaultCurrency, EUR when it is not set,
+// and prices in whole units of DefaultCurrency are written as integers instead of amount and currency
+type Money struct {
+	DefaultCurrency string
+	IntegerPrices   bool
+}
+
+// Prices configures the scheduler of prices, ScheduleInterval is number of seconds between its runs,
+// 60 when it is not set
+type Prices struct {
+	ScheduleInterval int
+}
+
+// Cart configures carts, ExpiryMinutes is how long a cart is kept after the last request to it,
+// a day when it is not set
+type Cart struct {
+	ExpiryMinutes int
+	// PurgeInterval is number of minutes between runs removing expired carts, 60 when it is not set
+	PurgeInterval int
 }
 
 var appsettings Appsettings
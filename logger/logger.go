@@ -36,20 +36,28 @@ func Init() {
 	}))
 }
 
+// get falls back to default logger when Init was not called, e.g. in tests
+func get() *slog.Logger {
+	if lgr == nil {
+		return slog.Default()
+	}
+	return lgr
+}
+
 func Info(msg string) {
-	lgr.Info(msg)
+	get().Info(msg)
 }
 
 func Error(msg string) {
-	lgr.Error(msg)
+	get().Error(msg)
 }
 
 func Warn(msg string) {
-	lgr.Warn(msg)
+	get().Warn(msg)
 }
 
 func Debug(msg string) {
-	lgr.Debug(msg)
+	get().Debug(msg)
 }
 
 type requestResponseLog struct {
@@ -90,7 +98,11 @@ func getResponseLog(rww router.ResponseWriterWrapper) responseLog {
 	var result responseLog
 
 	var buf bytes.Buffer
-	buf.WriteString(rww.Body.String())
+	if *rww.SkipBody {
+		buf.WriteString("<not captured>")
+	} else {
+		buf.WriteString(rww.Body.String())
+	}
 
 	result.Header = (*rww.W).Header()
 	result.Body = buf.String()